@@ -15,7 +15,9 @@ const (
 	ErrorCodeUserNotValid = 101 // 用户非法
 	ErrorCodeFail         = 200 // 失败
 	ErrorCodeDbFail       = 201 // DB请求失败
+	ErrorCodeClusterBusy  = 202 // 集群正在执行其他操作
 	ErrorCodeExecFail     = 203 // 执行失败
+	ErrorCodeConflict     = 204 // 配置已被其他请求修改，需要重新查询后再更新
 	ErrorCodeTryAgain     = 300 // 失败，需要重试
 	ErrorCodeUnknown      = 999 // 未知失败
 )
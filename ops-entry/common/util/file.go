@@ -44,6 +44,27 @@ func CreatePath(path string) error {
 	return nil
 }
 
+// WriteTempFile 将content写入只允许当前用户读写的临时文件并返回文件路径，使用完毕后由调用方删除
+func WriteTempFile(pattern string, content []byte) (string, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	name := f.Name()
+	err = f.Chmod(constValue.PrivateFileMode)
+	if err == nil {
+		_, err = f.Write(content)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name)
+		return "", err
+	}
+	return name, nil
+}
+
 func ParseLabels(labelData string) (map[string]string, error) {
 	var labels map[string]string
 	if err := json.Unmarshal([]byte(labelData), &labels); err != nil {
@@ -74,9 +95,8 @@ func GetSaveFilename(labelData string, clusterID string) (string, error) {
 		if err != nil {
 			return "", err
 		}
-
-		for key, value := range labels {
-			filename = filename + "-" + key + "-" + value
+		if hash := LabelsHash(labels); hash != "" {
+			filename = filename + "-" + hash
 		}
 	}
 
@@ -8,10 +8,16 @@
 package util
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"ops-entry/constValue"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 // IsValidResourceName 检查Kubernetes资源名称是否合法
@@ -32,3 +38,34 @@ func IsValidResourceName(name string) bool {
 	}
 	return true
 }
+
+// ValidateLabels 检查labels能否作为Kubernetes的labels保存
+func ValidateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid label key %q: %s", key, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid label value %q: %s", value, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// LabelsHash 按key排序后计算labels的哈希，与map的遍历顺序无关，labels为空时返回空字符串
+func LabelsHash(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s=%s\n", key, labels[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:constValue.LabelsHashLength]
+}
@@ -0,0 +1,27 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package constValue
+
+// 生成集群配置时使用的默认值
+const (
+	DefaultKubernetesVersion    = "v1.29.1"
+	DefaultKubernetesApiVersion = "v1beta3"
+	DefaultImageRegistry        = "registry.k8s.io"
+	DefaultPauseImage           = "pause:3.9"
+	DefaultServiceSubnet        = "10.96.0.0/16"
+	DefaultPodSubnet            = "10.244.0.0/16"
+	DefaultNetworkPlugin        = "calico"
+	DefaultApiserverPort        = 6443
+	DefaultMasterNameFormat     = "k8s-master%02d" // 未指定名称的节点按序号命名，从01开始
+	DefaultWorkerNameFormat     = "k8s-worker%02d"
+)
@@ -7,6 +7,8 @@
  */
 package constValue
 
+import "time"
+
 type OperatorType int
 
 const (
@@ -24,3 +26,80 @@ const (
 )
 
 const NameSpace = "kubemate"
+
+const (
+	LabelType      = "kubemate.openeuler.org/type"
+	LabelClusterId = "kubemate.openeuler.org/cluster-id"
+	LabelHash      = "kubemate.openeuler.org/labels-hash"
+	LabelManagedBy = "kubemate.openeuler.org/managed-by"
+	LabelNameHash  = "kubemate.openeuler.org/name-hash" // 多版本对象和secret历史版本所属名称的哈希，按名称查询版本时作为label selector
+)
+
+// ManagedByOperator operator保存的集群配置的LabelManagedBy，与上传的集群配置区分
+const ManagedByOperator = "operator"
+
+// 集群配置的不同变体以labels区分，名称为<cluster_id>-clusterconfig-<labels哈希>
+const (
+	ClusterConfigType = "clusterconfig"
+	KubeconfigType    = "kubeconfig"
+	LabelsHashLength  = 10
+)
+
+// kubeconfig、集群配置列表的排序和分页
+const (
+	ConfigSortByName         = "name"
+	ConfigSortByCreationTime = "creation_time"
+	ConfigSortByUpdateTime   = "update_time"
+	ConfigOrderAsc           = "asc"
+	ConfigOrderDesc          = "desc"
+	ConfigListPageSize       = 20
+	ConfigListMaxPageSize    = 100
+)
+
+// secret的历史版本，名称为kubemate-secretrevision-<名称>-v-N
+const (
+	SecretRevisionType     = "secret-revision"
+	AnnotationRevisionOf   = "kubemate.openeuler.org/revision-of"
+	AnnotationRevision     = "kubemate.openeuler.org/revision"
+	AnnotationChangeCause  = "kubemate.openeuler.org/change-cause"
+	ChangeCauseCreate      = "create"
+	ChangeCauseUpdate      = "update"
+	ChangeCauseRollbackFmt = "rollback to %d"
+	ChangeCauseMigrate     = "migrate" // 旧版本保存的配置迁移后记录的版本
+	RevisionCreateRetries  = 10        // 并发创建版本时版本号已被占用的重试次数
+)
+
+// AnnotationActive 被标记为active的历史版本不会被保留策略删除，回滚时标记回滚到的版本
+const AnnotationActive = "kubemate.openeuler.org/active"
+
+// 多版本configMap、cr和secret历史版本的保留策略，可通过环境变量覆盖
+const (
+	RetentionMaxRevisions    = 20                  // 每个名称最多保留的版本数
+	RetentionMaxAge          = 90 * 24 * time.Hour // 版本最长保留时间
+	RetentionGCInterval      = time.Hour           // 定期清理的间隔
+	RetentionMaxRevisionsEnv = "KUBEMATE_RETENTION_MAX_REVISIONS"
+	RetentionMaxAgeEnv       = "KUBEMATE_RETENTION_MAX_AGE"
+)
+
+// kubeconfig和集群配置的信封加密，未配置密钥时不加密
+const (
+	EncryptionProviderEnv   = "KUBEMATE_ENCRYPTION_PROVIDER" // 主密钥提供者，默认为本地密钥文件
+	EncryptionConfigEnv     = "KUBEMATE_ENCRYPTION_CONFIG"   // 提供者的配置，本地密钥文件为文件路径
+	EncryptionProviderFile  = "file"
+	EncryptionKeyFile       = "/etc/kubemate/encryption.key"
+	EncryptionValuePrefix   = "kubemate:enc:v1:" // 加密后的数据以该前缀开头，没有该前缀的为未加密的数据
+	EncryptionDataKeyLength = 32                 // 数据密钥长度，AES-256
+)
+
+// PrivateFileMode 传给nkd的集群配置临时文件包含凭据，只允许当前用户读写
+const PrivateFileMode = 0600
+
+// 配置的存储后端，启动时选择；本地目录存储不需要管理集群，用于单机部署
+const (
+	StorageBackendEnv        = "KUBEMATE_STORAGE_BACKEND"
+	StorageDirEnv            = "KUBEMATE_STORAGE_DIR"
+	StorageBackendKubernetes = "kubernetes"
+	StorageBackendLocal      = "local"
+	StorageDir               = "/var/lib/kubemate"
+	PrivateDirMode           = 0700
+)
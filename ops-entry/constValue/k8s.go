@@ -7,9 +7,65 @@
  */
 package constValue
 
+import "time"
+
 const (
 	KubeConfig           = "/.kube/config" //kubectl config view
 	DefaultNameSpace     = "default"       //kubectl config view
 	DefaultCrVersion     = "v1"
 	DefaultCrUpdateField = "spec"
 )
+
+// KubeMateCluster CRD，上传的集群配置转换为该类型的CR
+const (
+	KubeMateGroup            = "kubemate.openeuler.org"
+	KubeMateVersion          = "v1"
+	KubeMateClusterKind      = "KubeMateCluster"
+	KubeMateClusterListKind  = "KubeMateClusterList"
+	KubeMateClusterResource  = "kubemateclusters"
+	KubeMateClusterSingular  = "kubematecluster"
+	KubeMateClusterShortName = "kmc"
+	KubeMateClusterCRDName   = KubeMateClusterResource + "." + KubeMateGroup
+	CRDEstablishTimeout      = 30 * time.Second
+	CRDEstablishPollInterval = time.Second
+)
+
+// operator模式，监听KubeMateCluster并调用nkd使集群与spec一致
+const (
+	KubeMateClusterFinalizer = KubeMateGroup + "/nkd-destroy" // 删除CR时先调用nkd destroy
+	OperatorWorkers          = 2
+	OperatorResyncPeriod     = 10 * time.Minute
+	OperatorRequeueInterval  = 10 * time.Second     // 任务执行期间检查任务状态的间隔
+	OpenStackPasswordKey     = "openstack_password" // spec.credentials_secret引用的secret中OpenStack密码的key
+)
+
+// NameSpace下Secret、ConfigMap和KubeMate CR的informer缓存
+const (
+	CacheResyncPeriod = 10 * time.Minute
+	CacheSyncTimeout  = time.Minute // 超时后不使用缓存，直接访问API server
+)
+
+// OSUpgrade CRD，在被管理集群的节点上执行universal_os_upgrader
+const (
+	OSUpgradeKind      = "OSUpgrade"
+	OSUpgradeListKind  = "OSUpgradeList"
+	OSUpgradeResource  = "osupgrades"
+	OSUpgradeSingular  = "osupgrade"
+	OSUpgradeShortName = "osu"
+	OSUpgradeCRDName   = OSUpgradeResource + "." + KubeMateGroup
+)
+
+// 执行升级的Job，通过nsenter在节点上执行UniversalOS，配置文件复制到节点的OSUpgradeConfigDir
+const (
+	LabelOSUpgrade          = KubeMateGroup + "/osupgrade"
+	LabelOSUpgradeUID       = KubeMateGroup + "/osupgrade-uid"  // 创建Job的OSUpgrade的UID，区分删除后重新创建的同名CR
+	OSUpgradeFinalizer      = KubeMateGroup + "/osupgrade-jobs" // 删除CR时先删除被管理集群中的Job
+	AnnotationOSUpgradeNode = KubeMateGroup + "/osupgrade-node"
+	OSUpgradeJobNameSpace   = "kube-system"
+	OSUpgradeImage          = "busybox:1.36"
+	OSUpgradeCommand        = "UniversalOS Upgrade"
+	OSUpgradeConfigDir      = "/opt/kubemate/config"
+	OSUpgradeBackupFile     = "backup.yaml"
+	OSUpgradeUpgradeFile    = "upgrade.yaml"
+	OSUpgradeJobTTL         = int32(24 * time.Hour / time.Second) // 保留已结束的Job用于查看日志
+)
@@ -12,4 +12,74 @@
 
 package constValue
 
+import "time"
+
 const NkdPath = "/usr/bin/nkd"
+
+const NkdPathEnv = "KUBEMATE_NKD_PATH" // 指定nkd二进制路径的环境变量
+
+const (
+	NkdVerbDeploy  = "deploy"
+	NkdVerbDestroy = "destroy"
+	NkdVerbExtend  = "extend"
+	NkdVerbShrink  = "shrink"
+	NkdVerbUpgrade = "upgrade"
+)
+
+const (
+	NkdWorkerNum    = 4   // 同时执行的nkd进程数
+	NkdJobQueueSize = 100 // 等待执行的nkd任务数上限
+)
+
+// 已结束的nkd任务在内存中保留的时间，执行记录已保存时之后从执行记录中查询
+const (
+	NkdJobTTL        = 10 * time.Minute
+	NkdUnsavedJobTTL = 24 * time.Hour // 未连接管理集群或保存执行记录失败
+)
+
+// nkd任务的默认超时时间，可通过环境变量KUBEMATE_NKD_<VERB>_TIMEOUT覆盖，如KUBEMATE_NKD_DEPLOY_TIMEOUT=90m
+const (
+	NkdDeployTimeout  = 2 * time.Hour
+	NkdDestroyTimeout = 30 * time.Minute
+	NkdExtendTimeout  = time.Hour
+	NkdShrinkTimeout  = time.Hour
+	NkdUpgradeTimeout = 2 * time.Hour
+	NkdDefaultTimeout = time.Hour
+)
+
+const NkdTimeoutEnvFormat = "KUBEMATE_NKD_%s_TIMEOUT"
+
+const (
+	NodeDrainTimeout      = 10 * time.Minute // 缩容时等待节点上pod驱逐完成的时间
+	NodeDrainPollInterval = 2 * time.Second
+)
+
+const NkdKillGracePeriod = 30 * time.Second // 取消任务时先发送SIGTERM，超过该时间仍未退出则发送SIGKILL
+
+const NkdLeaseDuration = 60 // 集群操作锁的租期（秒），任务执行期间每1/3租期续约一次
+
+const (
+	AnnotationRequestId = "kubemate.openeuler.org/request-id"
+	AnnotationJobId     = "kubemate.openeuler.org/job-id"
+	AnnotationVerb      = "kubemate.openeuler.org/verb"
+)
+
+// nkd执行记录以多版本configMap保存在kubemate命名空间
+const (
+	NkdHistoryType        = "nkd-history"
+	NkdHistoryName        = "nkd-history-"
+	NkdHistoryKey         = "record"
+	NkdHistoryOutputLimit = 32 * 1024 // 执行记录中保留的输出长度，超出部分只保留末尾，完整日志另外保存
+	NkdHistoryPageSize    = 20
+	NkdHistoryMaxPageSize = 100
+	NkdHistoryMaxRecords  = 100 // 每个集群保留的执行记录数，不使用配置历史版本的保留策略
+)
+
+// 已结束的nkd任务的完整日志，每个任务保存为一组多版本configMap，版本号为分块序号，随执行记录一起删除
+const (
+	NkdLogType      = "nkd-log"
+	NkdLogName      = "nkd-log-"
+	NkdLogKey       = "log"
+	NkdLogChunkSize = 512 * 1024 // 每个configMap保存的日志长度，configMap不能超过1MiB
+	LabelJobId      = "kubemate.openeuler.org/job-id"
+)
@@ -8,9 +8,11 @@
 package constValue
 
 const (
-	Prefix      = NameSpace + "-"
-	VersionMark = "v-"
-	ConfigMap   = "configmap-"
-	SECRET      = "secret-"
-	Cr          = "cr"
+	Prefix         = NameSpace + "-"
+	VersionMark    = "v-"
+	ConfigMap      = "configmap-"
+	SECRET         = "secret-"
+	SecretRevision = "secretrevision-" // secret历史版本的前缀
+	LEASE          = "lease-"
+	Cr             = "cr-"
 )
@@ -13,6 +13,7 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"ops-entry/common/util"
 	"ops-entry/constValue"
@@ -107,6 +108,11 @@ func ClusterconfigFileUploadHandler(gc *gin.Context) {
 		logrus.Errorf(c.P()+"UploadClusterConfigFile failed: %s", err.Error())
 		result.Code = util.ErrorCodeFail
 		result.Msg = err.Error()
+		var configErr *service.ClusterConfigError
+		if errors.As(err, &configErr) {
+			result.Code = util.ErrorCodeInvalidParam
+			result.Violations = configErr.Violations()
+		}
 		gc.JSON(http.StatusOK, result)
 		return
 	}
@@ -115,6 +121,62 @@ func ClusterconfigFileUploadHandler(gc *gin.Context) {
 	return
 }
 
+// ClusterconfigFileGenerateHandler 生成集群配置文件
+//
+//	@Summary		Generate a cluster config file
+//	@Description	Generate a NKD cluster config file from a JSON document, unset fields are filled with defaults, the file is stored the same way as an uploaded one
+//	@Tags			集群配置文件
+//	@Accept			application/json
+//	@Produce		json
+//	@Param			generate	body		proto.ClusterConfigGenerateParam	true	"The cluster config"
+//	@Success		200			{object}	proto.ClusterConfigGenerateResult
+//	@Router			/clusterconfig/generate [POST]
+func ClusterconfigFileGenerateHandler(gc *gin.Context) {
+	requestId := gc.GetHeader("Request-Id")
+	c := util.CreateContext(requestId)
+	if len(requestId) == 0 {
+		gc.Request.Header.Set("Request-Id", c.RequestId)
+	}
+	var result proto.ClusterConfigGenerateResult
+	result.Code = 0
+	result.Msg = "success"
+	result.RequestId = c.RequestId
+
+	param := new(proto.ClusterConfigGenerateParam)
+	if err := gc.ShouldBindJSON(param); err != nil {
+		logrus.Errorf(c.P()+"Invalid params: %s", err.Error())
+		result.Code = util.ErrorCodeInvalidParam
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	if !util.IsValidResourceName(param.ClusterId) {
+		logrus.Errorf(c.P() + "Invalid params:Empty ClusterId or is not a valid resource")
+		result.Code = util.ErrorCodeInvalidParam
+		result.Msg = "Invalid params:Empty ClusterId or is not a valid resource"
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	content, err := service.GenerateClusterConfigFile(c, param)
+	if err != nil {
+		logrus.Errorf(c.P()+"GenerateClusterConfigFile failed: %s", err.Error())
+		result.Code = util.ErrorCodeFail
+		result.Msg = err.Error()
+		var configErr *service.ClusterConfigError
+		if errors.As(err, &configErr) {
+			result.Code = util.ErrorCodeInvalidParam
+			result.Violations = configErr.Violations()
+		}
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	result.Data = string(content)
+	gc.JSON(http.StatusOK, result)
+}
+
 // ClusterconfigFileDeleteHandler 删除集群配置文件
 //
 //	@Summary		Delete a cluster config file
@@ -157,6 +219,7 @@ func ClusterconfigFileDeleteHandler(gc *gin.Context) {
 // @Param 		cluster_id 	path 		string true "k8s cluster ID"
 // @Param       labels      query   	string  false "The JSON string containing labels to filter the files to delete. Optional."
 // @Success		200			{object}	proto.FileResult
+// @Header		200			{string}	ETag	"Version of the cluster config, pass as If-Match to /clusterconfig/update"
 // @Router /clusterconfig/{cluster_id} [GET]
 func ClusterconfigFileQueryHandler(gc *gin.Context) {
 	var (
@@ -183,8 +246,14 @@ func ClusterconfigFileQueryHandler(gc *gin.Context) {
 		return
 	}
 
-	clusterConfigInfo.Name = secret.Name
-	clusterConfigInfo.Data = string(secret.Data[constValue.Clusterconfig])
+	if secret != nil {
+		// 与之前的返回值保持一致，返回secret的完整名称kubemate-secret-<name>
+		clusterConfigInfo.Name = constValue.Prefix + constValue.SECRET + secret.Name
+		clusterConfigInfo.Data = secret.Data[constValue.Clusterconfig]
+		if secret.ResourceVersion != "" {
+			gc.Header("ETag", configETag(secret.ResourceVersion))
+		}
+	}
 	gc.JSON(http.StatusOK, clusterConfigInfo)
 
 	return
@@ -200,6 +269,7 @@ func ClusterconfigFileQueryHandler(gc *gin.Context) {
 // @Param		file		formData	file	true	"The cluster config file to upload"
 // @Param		cluster_id	formData	string	true	"k8s name"
 // @Param 		labels	    formData    string  false	"The JSON string containing labels for the uploaded file"
+// @Param		If-Match	header		string	false	"ETag returned by GET /clusterconfig/{cluster_id}, the update is rejected with code 204 if the config has been modified"
 // @Success		200			{object}	proto.FileResult
 // @Router		/clusterconfig/update 	[PUT]
 func ClusterconfigFileUpdateHandler(gc *gin.Context) {
@@ -259,11 +329,20 @@ func ClusterconfigFileUpdateHandler(gc *gin.Context) {
 		return
 	}
 
+	param.IfMatch = parseIfMatch(gc.GetHeader("If-Match"))
 	err = service.UpdateClusterConfigFile(c, param)
 	if err != nil {
 		logrus.Errorf(c.P()+"UploadClusterConfigFile failed: %s", err.Error())
 		result.Code = util.ErrorCodeFail
+		if k8serrors.IsConflict(err) {
+			result.Code = util.ErrorCodeConflict
+		}
 		result.Msg = err.Error()
+		var configErr *service.ClusterConfigError
+		if errors.As(err, &configErr) {
+			result.Code = util.ErrorCodeInvalidParam
+			result.Violations = configErr.Violations()
+		}
 		gc.JSON(http.StatusOK, result)
 		return
 	}
@@ -271,3 +350,40 @@ func ClusterconfigFileUpdateHandler(gc *gin.Context) {
 	gc.JSON(http.StatusOK, result)
 	return
 }
+
+// ClusterconfigStatusHandler 查询集群配置对应的KubeMateCluster的status
+//
+//	@Summary		Query the status of a cluster
+//	@Description	Query the phase, conditions and last operation recorded in the KubeMateCluster of a cluster config. Use the labels {"kubemate.openeuler.org/managed-by":"operator"} to query a cluster managed by the operator
+//	@Tags			集群配置文件
+//	@Produce		json
+//	@Param			cluster_id	path		string	true	"k8s cluster ID"
+//	@Param			labels		query		string	false	"The JSON string containing labels of the cluster config"
+//	@Success		200			{object}	proto.KubeMateClusterStatusResult
+//	@Router			/clusterconfig/{cluster_id}/status [GET]
+func ClusterconfigStatusHandler(gc *gin.Context) {
+	requestId := gc.GetHeader("Request-Id")
+	c := util.CreateContext(requestId)
+	if len(requestId) == 0 {
+		gc.Request.Header.Set("Request-Id", c.RequestId)
+	}
+	var result proto.KubeMateClusterStatusResult
+	result.Code = 0
+	result.Msg = "success"
+	result.RequestId = c.RequestId
+
+	status, err := service.QueryClusterStatus(c, gc.Param("cluster_id"), gc.Query("labels"))
+	if err != nil {
+		logrus.Errorf(c.P()+"query cluster status failed: %s", err.Error())
+		result.Code = util.ErrorCodeFail
+		if k8serrors.IsNotFound(err) {
+			result.Code = util.ErrorCodeInvalidParam
+		}
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	result.Data = status
+	gc.JSON(http.StatusOK, result)
+}
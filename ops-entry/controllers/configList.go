@@ -0,0 +1,100 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/proto"
+	"ops-entry/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// KubeconfigListHandler 查询全部集群的kubeconfig
+//
+//	@Summary		List kubeconfig files
+//	@Description	List the kubeconfig of every cluster with revision count and size, filter by label selector
+//	@Tags			kubeconfig文件
+//	@Produce		json
+//	@Param			label_selector	query		string	false	"Kubernetes label selector on the labels of the uploaded file"
+//	@Param			sort_by			query		string	false	"name, creation_time or update_time, default name"
+//	@Param			order			query		string	false	"asc or desc, default asc"
+//	@Param			limit			query		int		false	"Max number of items to return, default 20"
+//	@Param			continue		query		string	false	"The continue token returned by the previous page"
+//	@Success		200				{object}	proto.ConfigListResult
+//	@Router			/kubeconfig		[GET]
+func KubeconfigListHandler(gc *gin.Context) {
+	configListHandler(gc, service.ListKubeconfigs)
+}
+
+// ClusterconfigListHandler 查询全部集群配置
+//
+//	@Summary		List cluster config files
+//	@Description	List the cluster configs of every cluster with labels, revision count and size, filter by label selector
+//	@Tags			集群配置文件
+//	@Produce		json
+//	@Param			label_selector	query		string	false	"Kubernetes label selector on the labels of the uploaded file"
+//	@Param			sort_by			query		string	false	"name, creation_time or update_time, default name"
+//	@Param			order			query		string	false	"asc or desc, default asc"
+//	@Param			limit			query		int		false	"Max number of items to return, default 20"
+//	@Param			continue		query		string	false	"The continue token returned by the previous page"
+//	@Success		200				{object}	proto.ConfigListResult
+//	@Router			/clusterconfig	[GET]
+func ClusterconfigListHandler(gc *gin.Context) {
+	configListHandler(gc, service.ListClusterConfigs)
+}
+
+func configListHandler(gc *gin.Context, list func(util.Context, *proto.ConfigListParam) (*proto.ConfigList, error)) {
+	requestId := gc.GetHeader("Request-Id")
+	c := util.CreateContext(requestId)
+	if len(requestId) == 0 {
+		gc.Request.Header.Set("Request-Id", c.RequestId)
+	}
+	var result proto.ConfigListResult
+	result.Code = 0
+	result.Msg = "success"
+	result.RequestId = c.RequestId
+
+	var param proto.ConfigListParam
+	if err := gc.ShouldBindQuery(&param); err != nil || param.Limit < 0 {
+		logrus.Errorf(c.P()+"Invalid param: %v", err)
+		result.Code = util.ErrorCodeInvalidParam
+		result.Msg = "Invalid param: limit must be a non-negative integer"
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+	if param.Limit == 0 {
+		param.Limit = constValue.ConfigListPageSize
+	}
+	if param.Limit > constValue.ConfigListMaxPageSize {
+		param.Limit = constValue.ConfigListMaxPageSize
+	}
+
+	data, err := list(c, &param)
+	if err != nil {
+		logrus.Errorf(c.P()+"list configs failed: %s", err.Error())
+		result.Code = util.ErrorCodeDbFail
+		if errors.Is(err, service.ErrInvalidListParam) {
+			result.Code = util.ErrorCodeInvalidParam
+		}
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	result.Data = data
+	gc.JSON(http.StatusOK, result)
+}
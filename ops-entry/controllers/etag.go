@@ -0,0 +1,28 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package controllers
+
+import "strings"
+
+// configETag 根据配置的resourceVersion生成ETag
+func configETag(resourceVersion string) string {
+	return `"` + resourceVersion + `"`
+}
+
+// parseIfMatch 解析If-Match请求头中的ETag，返回对应的resourceVersion，为空或*时不检查版本
+func parseIfMatch(header string) string {
+	value := strings.TrimSpace(header)
+	if value == "" || value == "*" {
+		return ""
+	}
+	return strings.Trim(strings.TrimPrefix(value, "W/"), `"`)
+}
@@ -127,6 +127,7 @@ func KubeconfigFileDeleteHandler(gc *gin.Context) {
 // @Tags 		kubeconfig文件
 // @Param 		cluster_id 	path 		string true "k8s cluster ID"
 // @Success		204			"No Content - Indicates successful get"
+// @Header		200			{string}	ETag	"Version of the kubeconfig, pass as If-Match to /kubeconfig/update"
 // @Router 		/kubeconfig/{cluster_id} [GET]
 func KubeconfigFileQueryHandler(gc *gin.Context) {
 	var (
@@ -150,8 +151,14 @@ func KubeconfigFileQueryHandler(gc *gin.Context) {
 		gc.JSON(http.StatusOK, result)
 		return
 	}
-	kubeconfigInfo.Name = secret.Name
-	kubeconfigInfo.Data = string(secret.Data[constValue.Kubeconfig])
+	if secret != nil {
+		// 与之前的返回值保持一致，返回secret的完整名称kubemate-secret-<name>
+		kubeconfigInfo.Name = constValue.Prefix + constValue.SECRET + secret.Name
+		kubeconfigInfo.Data = secret.Data[constValue.Kubeconfig]
+		if secret.ResourceVersion != "" {
+			gc.Header("ETag", configETag(secret.ResourceVersion))
+		}
+	}
 	gc.JSON(http.StatusOK, kubeconfigInfo)
 
 	return
@@ -166,6 +173,7 @@ func KubeconfigFileQueryHandler(gc *gin.Context) {
 //	@Produce		json
 //	@Param			file		formData	file	true	"The kubeconfig file to upload"
 //	@Param			cluster_id	formData	string	true	"k8s name"
+//	@Param			If-Match	header		string	false	"ETag returned by GET /kubeconfig/{cluster_id}, the update is rejected with code 204 if the kubeconfig has been modified"
 //	@Success		200			{object}	proto.FileResult "Successful file update"
 //	@Router			/kubeconfig/update [PUT]
 func KubeconfigFileUpdateHandler(gc *gin.Context) {
@@ -225,10 +233,14 @@ func KubeconfigFileUpdateHandler(gc *gin.Context) {
 		return
 	}
 
+	param.IfMatch = parseIfMatch(gc.GetHeader("If-Match"))
 	err = service.UpdateKubeconfigFile(c, param)
 	if err != nil {
 		logrus.Errorf(c.P()+"UploadFile failed: %s", err.Error())
 		result.Code = util.ErrorCodeFail
+		if k8serrors.IsConflict(err) {
+			result.Code = util.ErrorCodeConflict
+		}
 		result.Msg = err.Error()
 		gc.JSON(http.StatusOK, result)
 		return
@@ -14,11 +14,14 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"ops-entry/common/util"
 	"ops-entry/constValue"
 	"ops-entry/proto"
-	"os/exec"
+	"ops-entry/service"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -28,7 +31,7 @@ import (
 // NKDDeployHandler
 //
 //	@Summary		Deploy a kubernetes cluster
-//	@Description	Deploy a kubernetes cluster
+//	@Description	Submit a job to deploy a kubernetes cluster, query it by the returned job_id
 //	@Tags			Use NKD to manage a kubernetes cluster
 //	@Accept			application/json
 //	@Produce		json
@@ -56,10 +59,10 @@ func NKDDeployHandler(gc *gin.Context) {
 		return
 	}
 
-	if requestBody.ClusterID == "" {
-		logrus.Errorf(c.P() + "Invalid param: clusterID is missing")
+	if requestBody.ClusterID == "" || !util.IsValidResourceName(requestBody.ClusterID) {
+		logrus.Errorf(c.P() + "Invalid param: clusterID is missing or is not a valid resource")
 		result.Code = util.ErrorCodeInvalidParam
-		result.Msg = "Invalid param: clusterID is missing"
+		result.Msg = "Invalid param: clusterID is missing or is not a valid resource"
 		gc.JSON(http.StatusOK, result)
 		return
 	}
@@ -67,31 +70,46 @@ func NKDDeployHandler(gc *gin.Context) {
 	byteParam, _ := json.Marshal(requestBody)
 	logrus.Infof(c.P()+"FileUploadHandler param: %s", string(byteParam))
 
-	dst, err := util.GetSaveFilename(requestBody.Labels, requestBody.ClusterID)
-	if err != nil {
-		logrus.Errorf(c.P()+"Failed to get cluster config file: %s", err.Error())
+	if _, err := service.QueryClusterConfigFile(c, requestBody.ClusterID, requestBody.Labels); err != nil {
+		logrus.Errorf(c.P()+"Failed to get cluster config: %s", err.Error())
+		result.Code = util.ErrorCodeFail
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
 		return
 	}
 
-	cmd := exec.Command(constValue.NkdPath, "deploy", "-f", dst)
-	output, err := cmd.CombinedOutput()
+	job, err := service.SubmitNKDJob(c, service.NKDJobParam{
+		Verb:      constValue.NkdVerbDeploy,
+		ClusterID: requestBody.ClusterID,
+		Labels:    requestBody.Labels,
+		Requester: gc.ClientIP(),
+	})
+	var repeated *service.RepeatRequestError
+	if errors.As(err, &repeated) {
+		// 相同Request-Id的请求已被其他副本受理或已执行结束，返回原任务
+		logrus.Infof(c.P()+"%s", err.Error())
+		result.JobId = repeated.JobId
+		result.Msg = "Cluster deploy job submitted"
+		gc.JSON(http.StatusOK, result)
+		return
+	}
 	if err != nil {
-		logrus.Errorf(c.P()+"Failed to deploy cluster: %s, output: %s", err.Error(), string(output))
-		result.Code = util.ErrorCodeExecFail
+		logrus.Errorf(c.P()+"Failed to submit deploy job: %s", err.Error())
+		result.Code = nkdSubmitErrorCode(err)
 		result.Msg = err.Error()
 		gc.JSON(http.StatusOK, result)
 		return
 	}
-	logrus.Infof(c.P()+"Cluster deployed successfully, output: %s", string(output))
-	result.Msg = "Cluster deployed successfully"
 
+	result.JobId = job.Id
+	result.Msg = "Cluster deploy job submitted"
 	gc.JSON(http.StatusOK, result)
 }
 
 // NKDDeleteHandler
 //
 //	@Summary		Destroy a kubernetes cluster
-//	@Description	Destroy a kubernetes cluster
+//	@Description	Submit a job to destroy a kubernetes cluster, query it by the returned job_id
 //	@Tags			Use NKD to manage a kubernetes cluster
 //	@Accept			application/json
 //	@Produce		json
@@ -118,7 +136,7 @@ func NKDDeleteHandler(gc *gin.Context) {
 		return
 	}
 
-	if requestBody.ClusterID == "" {
+	if requestBody.ClusterID == "" || !util.IsValidResourceName(requestBody.ClusterID) {
 		logrus.Errorf(c.P() + "Invalid param")
 		result.Code = util.ErrorCodeInvalidParam
 		result.Msg = "Invalid param"
@@ -126,25 +144,37 @@ func NKDDeleteHandler(gc *gin.Context) {
 		return
 	}
 
-	cmd := exec.Command(constValue.NkdPath, "destroy", "--cluster-id", requestBody.ClusterID)
-	output, err := cmd.CombinedOutput()
+	job, err := service.SubmitNKDJob(c, service.NKDJobParam{
+		Verb:      constValue.NkdVerbDestroy,
+		ClusterID: requestBody.ClusterID,
+		Requester: gc.ClientIP(),
+	})
+	var repeated *service.RepeatRequestError
+	if errors.As(err, &repeated) {
+		// 相同Request-Id的请求已被其他副本受理或已执行结束，返回原任务
+		logrus.Infof(c.P()+"%s", err.Error())
+		result.JobId = repeated.JobId
+		result.Msg = "Cluster destroy job submitted"
+		gc.JSON(http.StatusOK, result)
+		return
+	}
 	if err != nil {
-		logrus.Errorf(c.P()+"Failed to destroy cluster: %s, output: %s", err.Error(), string(output))
-		result.Code = util.ErrorCodeExecFail
+		logrus.Errorf(c.P()+"Failed to submit destroy job: %s", err.Error())
+		result.Code = nkdSubmitErrorCode(err)
 		result.Msg = err.Error()
 		gc.JSON(http.StatusOK, result)
 		return
 	}
-	logrus.Infof(c.P()+"Cluster destroyed successfully, output: %s", string(output))
 
-	result.Msg = "Cluster destroyed successfully"
+	result.JobId = job.Id
+	result.Msg = "Cluster destroy job submitted"
 	gc.JSON(http.StatusOK, result)
 }
 
 // NKDExtendHandler
 //
 //	@Summary		Extend a kubernetes cluster
-//	@Description	Extend a kubernetes cluster
+//	@Description	Submit a job to extend a kubernetes cluster, query it by the returned job_id
 //	@Tags			Use NKD to manage a kubernetes cluster
 //	@Accept			application/json
 //	@Produce		json
@@ -171,7 +201,7 @@ func NKDExtendHandler(gc *gin.Context) {
 		return
 	}
 
-	if requestBody.ClusterID == "" {
+	if requestBody.ClusterID == "" || !util.IsValidResourceName(requestBody.ClusterID) {
 		logrus.Errorf(c.P() + "Invalid param")
 		result.Code = util.ErrorCodeInvalidParam
 		result.Msg = "Invalid param"
@@ -181,24 +211,482 @@ func NKDExtendHandler(gc *gin.Context) {
 
 	num, err := strconv.Atoi(requestBody.Num)
 	if err != nil || num <= 0 {
-		logrus.Errorf(c.P()+"Invalid num: %s", err.Error())
+		logrus.Errorf(c.P()+"Invalid num: %s", requestBody.Num)
 		result.Code = util.ErrorCodeInvalidParam
 		result.Msg = "Invalid num"
 		gc.JSON(http.StatusOK, result)
 		return
 	}
 
-	cmd := exec.Command(constValue.NkdPath, "extend", "--cluster-id", requestBody.ClusterID, "-n", requestBody.Num)
-	output, err := cmd.CombinedOutput()
+	job, err := service.SubmitNKDJob(c, service.NKDJobParam{
+		Verb:      constValue.NkdVerbExtend,
+		ClusterID: requestBody.ClusterID,
+		Requester: gc.ClientIP(),
+		Num:       num,
+	})
+	var repeated *service.RepeatRequestError
+	if errors.As(err, &repeated) {
+		// 相同Request-Id的请求已被其他副本受理或已执行结束，返回原任务
+		logrus.Infof(c.P()+"%s", err.Error())
+		result.JobId = repeated.JobId
+		result.Msg = "Cluster extend job submitted"
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+	if err != nil {
+		logrus.Errorf(c.P()+"Failed to submit extend job: %s", err.Error())
+		result.Code = nkdSubmitErrorCode(err)
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	result.JobId = job.Id
+	result.Msg = "Cluster extend job submitted"
+	gc.JSON(http.StatusOK, result)
+}
+
+// NKDShrinkHandler
+//
+//	@Summary		Shrink a kubernetes cluster
+//	@Description	Submit a job to drain and remove worker nodes from the cluster, query it by the returned job_id. The job then releases their machines through nkd and removes them from the cluster config
+//	@Tags			Use NKD to manage a kubernetes cluster
+//	@Accept			application/json
+//	@Produce		json
+//	@Param			shrink		body		proto.NKDShrinkParam	true	"Shrink a kubernetes cluster"
+//	@Success		200			{object}	proto.NKDResult
+//	@Router			/nkd/shrink [POST]
+func NKDShrinkHandler(gc *gin.Context) {
+	requestId := gc.GetHeader("Request-Id")
+	c := util.CreateContext(requestId)
+	if len(requestId) == 0 {
+		gc.Request.Header.Set("Request-Id", c.RequestId)
+	}
+	var result proto.NKDResult
+	result.Code = 0
+	result.Msg = "success"
+	result.RequestId = c.RequestId
+
+	var requestBody proto.NKDShrinkParam
+	if err := gc.ShouldBindJSON(&requestBody); err != nil {
+		logrus.Errorf(c.P()+"Invalid param: %s", err.Error())
+		result.Code = util.ErrorCodeFail
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	if requestBody.ClusterID == "" || !util.IsValidResourceName(requestBody.ClusterID) {
+		logrus.Errorf(c.P() + "Invalid param")
+		result.Code = util.ErrorCodeInvalidParam
+		result.Msg = "Invalid param"
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	if len(requestBody.Nodes) == 0 {
+		logrus.Errorf(c.P() + "Invalid param: no nodes")
+		result.Code = util.ErrorCodeInvalidParam
+		result.Msg = "Invalid nodes"
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+	for _, node := range requestBody.Nodes {
+		if !util.IsValidResourceName(node) {
+			logrus.Errorf(c.P()+"Invalid node: %s", node)
+			result.Code = util.ErrorCodeInvalidParam
+			result.Msg = "Invalid node: " + node
+			gc.JSON(http.StatusOK, result)
+			return
+		}
+	}
+
+	job, err := service.SubmitNKDJob(c, service.NKDJobParam{
+		Verb:      constValue.NkdVerbShrink,
+		ClusterID: requestBody.ClusterID,
+		Requester: gc.ClientIP(),
+		Nodes:     requestBody.Nodes,
+	})
+	var repeated *service.RepeatRequestError
+	if errors.As(err, &repeated) {
+		// 相同Request-Id的请求已被其他副本受理或已执行结束，返回原任务
+		logrus.Infof(c.P()+"%s", err.Error())
+		result.JobId = repeated.JobId
+		result.Msg = "Cluster shrink job submitted"
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+	if err != nil {
+		logrus.Errorf(c.P()+"Failed to submit shrink job: %s", err.Error())
+		result.Code = nkdSubmitErrorCode(err)
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	result.JobId = job.Id
+	result.Msg = "Cluster shrink job submitted"
+	gc.JSON(http.StatusOK, result)
+}
+
+// NKDUpgradeHandler
+//
+//	@Summary		Upgrade a kubernetes cluster
+//	@Description	Submit a job to upgrade the control plane and then the workers of a kubernetes cluster to the target version, query it by the returned job_id
+//	@Tags			Use NKD to manage a kubernetes cluster
+//	@Accept			application/json
+//	@Produce		json
+//	@Param			upgrade		body		proto.NKDUpgradeParam	true	"Upgrade a kubernetes cluster"
+//	@Success		200			{object}	proto.NKDResult
+//	@Router			/nkd/upgrade [POST]
+func NKDUpgradeHandler(gc *gin.Context) {
+	requestId := gc.GetHeader("Request-Id")
+	c := util.CreateContext(requestId)
+	if len(requestId) == 0 {
+		gc.Request.Header.Set("Request-Id", c.RequestId)
+	}
+	var result proto.NKDResult
+	result.Code = 0
+	result.Msg = "success"
+	result.RequestId = c.RequestId
+
+	var requestBody proto.NKDUpgradeParam
+	if err := gc.ShouldBindJSON(&requestBody); err != nil {
+		logrus.Errorf(c.P()+"Invalid param: %s", err.Error())
+		result.Code = util.ErrorCodeFail
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	if requestBody.ClusterID == "" || !util.IsValidResourceName(requestBody.ClusterID) {
+		logrus.Errorf(c.P() + "Invalid param")
+		result.Code = util.ErrorCodeInvalidParam
+		result.Msg = "Invalid param"
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	current, err := service.CheckNKDUpgrade(c, requestBody.ClusterID, requestBody.Labels, requestBody.Version)
+	if err != nil {
+		logrus.Errorf(c.P()+"Failed to check upgrade version: %s", err.Error())
+		result.Code = util.ErrorCodeFail
+		if errors.Is(err, service.ErrInvalidUpgradeVersion) {
+			result.Code = util.ErrorCodeInvalidParam
+		}
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+	logrus.Infof(c.P()+"upgrade cluster %s from %s to %s", requestBody.ClusterID, current, requestBody.Version)
+
+	job, err := service.SubmitNKDJob(c, service.NKDJobParam{
+		Verb:      constValue.NkdVerbUpgrade,
+		ClusterID: requestBody.ClusterID,
+		Labels:    requestBody.Labels,
+		Requester: gc.ClientIP(),
+		Version:   requestBody.Version,
+	})
+	var repeated *service.RepeatRequestError
+	if errors.As(err, &repeated) {
+		// 相同Request-Id的请求已被其他副本受理或已执行结束，返回原任务
+		logrus.Infof(c.P()+"%s", err.Error())
+		result.JobId = repeated.JobId
+		result.Msg = "Cluster upgrade job submitted"
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+	if err != nil {
+		logrus.Errorf(c.P()+"Failed to submit upgrade job: %s", err.Error())
+		result.Code = nkdSubmitErrorCode(err)
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	result.JobId = job.Id
+	result.Msg = "Cluster upgrade job submitted"
+	gc.JSON(http.StatusOK, result)
+}
+
+// NKDJobQueryHandler
+//
+//	@Summary		Query a nkd job
+//	@Description	Query the phase, start/end time, exit code and output of a nkd job
+//	@Tags			Use NKD to manage a kubernetes cluster
+//	@Produce		json
+//	@Param			id				path		string	true	"nkd job ID"
+//	@Success		200				{object}	proto.NKDJobResult
+//	@Router			/nkd/jobs/{id}	[GET]
+func NKDJobQueryHandler(gc *gin.Context) {
+	requestId := gc.GetHeader("Request-Id")
+	c := util.CreateContext(requestId)
+	if len(requestId) == 0 {
+		gc.Request.Header.Set("Request-Id", c.RequestId)
+	}
+	var result proto.NKDJobResult
+	result.Code = 0
+	result.Msg = "success"
+	result.RequestId = c.RequestId
+
+	jobId := gc.Param("id")
+	job, ok := service.GetNKDJob(jobId)
+	if ok {
+		result.Data = job.Info()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	// 其他副本正在执行的任务
+	if info, ok := service.GetLeasedNKDJob(c, jobId); ok {
+		result.Data = info
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	// ops-entry重启后内存中已没有该任务，从执行记录中查询
+	record, err := service.GetNKDHistory(c, jobId)
+	if err != nil || record == nil {
+		logrus.Errorf(c.P()+"nkd job not found: %s", jobId)
+		result.Code = util.ErrorCodeInvalidParam
+		result.Msg = "nkd job not found"
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	result.Data = &record.NKDJobInfo
+	gc.JSON(http.StatusOK, result)
+}
+
+// NKDJobLogHandler
+//
+//	@Summary		Read the output of a nkd job
+//	@Description	Read stdout/stderr of a nkd job line by line starting from offset.
+//	@Description	With follow=true the lines are streamed as server-sent events until the job ends,
+//	@Description	the id of each event is the offset to resume from (also accepted in the Last-Event-ID header).
+//	@Tags			Use NKD to manage a kubernetes cluster
+//	@Produce		json,text/event-stream
+//	@Param			id						path		string	true	"nkd job ID"
+//	@Param			follow					query		bool	false	"Stream new lines as server-sent events"
+//	@Param			offset					query		int		false	"Number of lines already read"
+//	@Success		200						{object}	proto.NKDJobLogResult
+//	@Router			/nkd/jobs/{id}/logs		[GET]
+func NKDJobLogHandler(gc *gin.Context) {
+	requestId := gc.GetHeader("Request-Id")
+	c := util.CreateContext(requestId)
+	if len(requestId) == 0 {
+		gc.Request.Header.Set("Request-Id", c.RequestId)
+	}
+	var result proto.NKDJobLogResult
+	result.Code = 0
+	result.Msg = "success"
+	result.RequestId = c.RequestId
+
+	var param proto.NKDJobLogParam
+	if err := gc.ShouldBindQuery(&param); err != nil {
+		logrus.Errorf(c.P()+"Invalid param: %s", err.Error())
+		result.Code = util.ErrorCodeInvalidParam
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+	// 断线重连时浏览器通过Last-Event-ID带回最后收到的偏移量
+	if lastEventId := gc.GetHeader("Last-Event-ID"); len(lastEventId) > 0 {
+		offset, err := strconv.Atoi(lastEventId)
+		if err == nil {
+			param.Offset = offset
+		}
+	}
+
+	jobId := gc.Param("id")
+	job, ok := service.GetNKDJob(jobId)
+	if !ok {
+		// 已从内存中删除的任务从执行记录中读取保存的完整日志
+		lines, record, err := service.FinishedNKDJobLog(c, jobId)
+		if err != nil || record == nil {
+			logrus.Errorf(c.P()+"nkd job not found: %s", jobId)
+			result.Code = util.ErrorCodeInvalidParam
+			result.Msg = "nkd job not found"
+			gc.JSON(http.StatusOK, result)
+			return
+		}
+		writeFinishedNKDJobLog(gc, result, param, lines, record)
+		return
+	}
+
+	if !param.Follow {
+		lines, _, finished := job.ReadLog(param.Offset)
+		result.Data = &proto.NKDJobLog{
+			JobId:    job.Id,
+			Lines:    lines,
+			Offset:   param.Offset + len(lines),
+			Finished: finished,
+		}
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	offset := param.Offset
+	gc.Header("Content-Type", "text/event-stream")
+	gc.Header("Cache-Control", "no-cache")
+	gc.Header("Connection", "keep-alive")
+	gc.Stream(func(w io.Writer) bool {
+		lines, wait, finished := job.ReadLog(offset)
+		for _, line := range lines {
+			offset++
+			fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", offset, line)
+		}
+		if len(lines) > 0 {
+			return true
+		}
+		if finished {
+			fmt.Fprintf(w, "id: %d\nevent: end\ndata: %s\n\n", offset, job.Info().Phase)
+			return false
+		}
+		select {
+		case <-wait:
+			return true
+		case <-gc.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// writeFinishedNKDJobLog 返回已结束任务的日志，follow时输出offset之后的日志和结束事件
+func writeFinishedNKDJobLog(gc *gin.Context, result proto.NKDJobLogResult, param proto.NKDJobLogParam, lines []string, record *proto.NKDHistoryRecord) {
+	offset := param.Offset
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(lines) {
+		offset = len(lines)
+	}
+	lines = lines[offset:]
+
+	if !param.Follow {
+		result.Data = &proto.NKDJobLog{
+			JobId:    record.JobId,
+			Lines:    lines,
+			Offset:   offset + len(lines),
+			Finished: true,
+		}
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	gc.Header("Content-Type", "text/event-stream")
+	gc.Header("Cache-Control", "no-cache")
+	gc.Header("Connection", "keep-alive")
+	gc.Stream(func(w io.Writer) bool {
+		for _, line := range lines {
+			offset++
+			fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", offset, line)
+		}
+		fmt.Fprintf(w, "id: %d\nevent: end\ndata: %s\n\n", offset, record.Phase)
+		return false
+	})
+}
+
+// nkdSubmitErrorCode 根据提交nkd任务失败的原因返回错误码
+func nkdSubmitErrorCode(err error) int {
+	switch {
+	case errors.Is(err, service.ErrRepeatRequest):
+		return util.ErrorCodeRepeatReq
+	case errors.Is(err, service.ErrClusterBusy):
+		return util.ErrorCodeClusterBusy
+	default:
+		return util.ErrorCodeTryAgain
+	}
+}
+
+// NKDJobCancelHandler
+//
+//	@Summary		Cancel a nkd job
+//	@Description	Cancel a pending or running nkd job, the nkd process group is terminated gracefully then forcefully
+//	@Tags			Use NKD to manage a kubernetes cluster
+//	@Produce		json
+//	@Param			id				path		string	true	"nkd job ID"
+//	@Success		200				{object}	proto.NKDJobResult
+//	@Router			/nkd/jobs/{id}	[DELETE]
+func NKDJobCancelHandler(gc *gin.Context) {
+	requestId := gc.GetHeader("Request-Id")
+	c := util.CreateContext(requestId)
+	if len(requestId) == 0 {
+		gc.Request.Header.Set("Request-Id", c.RequestId)
+	}
+	var result proto.NKDJobResult
+	result.Code = 0
+	result.Msg = "success"
+	result.RequestId = c.RequestId
+
+	jobId := gc.Param("id")
+	job, ok := service.GetNKDJob(jobId)
+	if !ok {
+		logrus.Errorf(c.P()+"nkd job not found: %s", jobId)
+		result.Code = util.ErrorCodeInvalidParam
+		result.Msg = "nkd job not found"
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	if err := job.Cancel(c); err != nil {
+		logrus.Errorf(c.P()+"Failed to cancel nkd job: %s", err.Error())
+		result.Code = util.ErrorCodeFail
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	result.Data = job.Info()
+	gc.JSON(http.StatusOK, result)
+}
+
+// NKDHistoryHandler
+//
+//	@Summary		List nkd operation history
+//	@Description	List finished nkd jobs persisted in the kubemate namespace, newest first
+//	@Tags			Use NKD to manage a kubernetes cluster
+//	@Produce		json
+//	@Param			cluster_id		query		string	false	"Only return records of the kubernetes cluster"
+//	@Param			limit			query		int		false	"Max number of records to return, default 20"
+//	@Param			offset			query		int		false	"Number of records to skip"
+//	@Success		200				{object}	proto.NKDHistoryResult
+//	@Router			/nkd/history	[GET]
+func NKDHistoryHandler(gc *gin.Context) {
+	requestId := gc.GetHeader("Request-Id")
+	c := util.CreateContext(requestId)
+	if len(requestId) == 0 {
+		gc.Request.Header.Set("Request-Id", c.RequestId)
+	}
+	var result proto.NKDHistoryResult
+	result.Code = 0
+	result.Msg = "success"
+	result.RequestId = c.RequestId
+
+	var param proto.NKDHistoryParam
+	if err := gc.ShouldBindQuery(&param); err != nil || param.Limit < 0 || param.Offset < 0 {
+		logrus.Errorf(c.P()+"Invalid param: %v", err)
+		result.Code = util.ErrorCodeInvalidParam
+		result.Msg = "Invalid param: limit and offset must be non-negative integers"
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+	if param.Limit == 0 {
+		param.Limit = constValue.NkdHistoryPageSize
+	}
+	if param.Limit > constValue.NkdHistoryMaxPageSize {
+		param.Limit = constValue.NkdHistoryMaxPageSize
+	}
+
+	list, err := service.ListNKDHistory(c, param.ClusterID, param.Limit, param.Offset)
 	if err != nil {
-		logrus.Errorf(c.P()+"Failed to extend cluster: %s, output: %s", err.Error(), string(output))
-		result.Code = util.ErrorCodeExecFail
+		logrus.Errorf(c.P()+"ListNKDHistory failed: %s", err.Error())
+		result.Code = util.ErrorCodeDbFail
 		result.Msg = err.Error()
 		gc.JSON(http.StatusOK, result)
 		return
 	}
-	logrus.Infof(c.P()+"Cluster extended successfully, output: %s", string(output))
 
-	result.Msg = "Cluster extended successfully"
+	result.Data = list
 	gc.JSON(http.StatusOK, result)
 }
@@ -0,0 +1,421 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/proto"
+	"ops-entry/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// ClusterconfigRevisionListHandler 查询集群配置的历史版本
+//
+//	@Summary		List revisions of a cluster config
+//	@Description	List the revisions recorded by every upload, update and rollback of a cluster config
+//	@Tags			集群配置文件
+//	@Produce		json
+//	@Param			cluster_id	path		string	true	"k8s cluster ID"
+//	@Param			labels		query		string	false	"The JSON string containing labels of the cluster config"
+//	@Success		200			{object}	proto.ConfigRevisionListResult
+//	@Router			/clusterconfig/{cluster_id}/revisions [GET]
+func ClusterconfigRevisionListHandler(gc *gin.Context) {
+	requestId := gc.GetHeader("Request-Id")
+	c := util.CreateContext(requestId)
+	if len(requestId) == 0 {
+		gc.Request.Header.Set("Request-Id", c.RequestId)
+	}
+	var result proto.ConfigRevisionListResult
+	result.Code = 0
+	result.Msg = "success"
+	result.RequestId = c.RequestId
+
+	revisions, err := service.ListClusterConfigRevisions(c, gc.Param("cluster_id"), gc.Query("labels"))
+	if err != nil {
+		logrus.Errorf(c.P()+"list revisions failed: %s", err.Error())
+		result.Code = util.ErrorCodeFail
+		if k8serrors.IsNotFound(err) || k8serrors.IsBadRequest(err) {
+			result.Code = util.ErrorCodeInvalidParam
+		}
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	result.Data = revisions
+	gc.JSON(http.StatusOK, result)
+}
+
+// ClusterconfigRevisionQueryHandler 查询集群配置的指定版本
+//
+//	@Summary		Query a revision of a cluster config
+//	@Description	Query the content of a cluster config revision
+//	@Tags			集群配置文件
+//	@Produce		json
+//	@Param			cluster_id	path		string	true	"k8s cluster ID"
+//	@Param			revision	path		int		true	"revision number"
+//	@Param			labels		query		string	false	"The JSON string containing labels of the cluster config"
+//	@Success		200			{object}	proto.ConfigRevisionResult
+//	@Router			/clusterconfig/{cluster_id}/revisions/{revision} [GET]
+func ClusterconfigRevisionQueryHandler(gc *gin.Context) {
+	requestId := gc.GetHeader("Request-Id")
+	c := util.CreateContext(requestId)
+	if len(requestId) == 0 {
+		gc.Request.Header.Set("Request-Id", c.RequestId)
+	}
+	var result proto.ConfigRevisionResult
+	result.Code = 0
+	result.Msg = "success"
+	result.RequestId = c.RequestId
+
+	revision, err := strconv.Atoi(gc.Param("revision"))
+	if err != nil || revision <= 0 {
+		logrus.Errorf(c.P()+"Invalid params: invalid revision %s", gc.Param("revision"))
+		result.Code = util.ErrorCodeInvalidParam
+		result.Msg = "Invalid params: invalid revision " + gc.Param("revision")
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	data, err := service.GetClusterConfigRevision(c, gc.Param("cluster_id"), gc.Query("labels"), revision)
+	if err != nil {
+		logrus.Errorf(c.P()+"query revision failed: %s", err.Error())
+		result.Code = util.ErrorCodeFail
+		if k8serrors.IsNotFound(err) || k8serrors.IsBadRequest(err) {
+			result.Code = util.ErrorCodeInvalidParam
+		}
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	result.Data = data
+	gc.JSON(http.StatusOK, result)
+}
+
+// ClusterconfigRollbackHandler 回滚集群配置
+//
+//	@Summary		Roll back a cluster config
+//	@Description	Restore a cluster config to an earlier revision, the rollback is recorded as a new revision
+//	@Tags			集群配置文件
+//	@Accept			application/json
+//	@Produce		json
+//	@Param			cluster_id	path		string						true	"k8s cluster ID"
+//	@Param			rollback	body		proto.ConfigRollbackParam	true	"The revision to roll back to"
+//	@Success		200			{object}	proto.ConfigRollbackResult
+//	@Router			/clusterconfig/{cluster_id}/rollback [POST]
+func ClusterconfigRollbackHandler(gc *gin.Context) {
+	requestId := gc.GetHeader("Request-Id")
+	c := util.CreateContext(requestId)
+	if len(requestId) == 0 {
+		gc.Request.Header.Set("Request-Id", c.RequestId)
+	}
+	var result proto.ConfigRollbackResult
+	result.Code = 0
+	result.Msg = "success"
+	result.RequestId = c.RequestId
+
+	var param proto.ConfigRollbackParam
+	if err := gc.ShouldBindJSON(&param); err != nil || param.Revision <= 0 {
+		logrus.Errorf(c.P()+"Invalid params: %v", err)
+		result.Code = util.ErrorCodeInvalidParam
+		result.Msg = "Invalid params: revision must be a positive number"
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	revision, err := service.RollbackClusterConfig(c, gc.Param("cluster_id"), param.Labels, param.Revision)
+	if err != nil {
+		logrus.Errorf(c.P()+"rollback failed: %s", err.Error())
+		result.Code = util.ErrorCodeFail
+		if k8serrors.IsNotFound(err) || k8serrors.IsBadRequest(err) {
+			result.Code = util.ErrorCodeInvalidParam
+		}
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	result.Revision = revision
+	gc.JSON(http.StatusOK, result)
+}
+
+// ClusterconfigDiffHandler 比较集群配置的两个版本
+//
+//	@Summary		Diff two revisions of a cluster config
+//	@Description	Compare two revisions of a cluster config as parsed YAML, credential values are redacted
+//	@Tags			集群配置文件
+//	@Produce		json
+//	@Param			cluster_id	path		string	true	"k8s cluster ID"
+//	@Param			from		query		int		true	"The old revision"
+//	@Param			to			query		int		false	"The new revision, defaults to the latest one"
+//	@Param			labels		query		string	false	"The JSON string containing labels of the cluster config"
+//	@Success		200			{object}	proto.ConfigDiffResult
+//	@Router			/clusterconfig/{cluster_id}/diff [GET]
+func ClusterconfigDiffHandler(gc *gin.Context) {
+	requestId := gc.GetHeader("Request-Id")
+	c := util.CreateContext(requestId)
+	if len(requestId) == 0 {
+		gc.Request.Header.Set("Request-Id", c.RequestId)
+	}
+	var result proto.ConfigDiffResult
+	result.Code = 0
+	result.Msg = "success"
+	result.RequestId = c.RequestId
+
+	from, err := strconv.Atoi(gc.Query("from"))
+	if err != nil || from <= 0 {
+		logrus.Errorf(c.P()+"Invalid params: invalid revision %s", gc.Query("from"))
+		result.Code = util.ErrorCodeInvalidParam
+		result.Msg = "Invalid params: invalid revision " + gc.Query("from")
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+	to := 0
+	if len(gc.Query("to")) > 0 {
+		to, err = strconv.Atoi(gc.Query("to"))
+		if err != nil || to <= 0 {
+			logrus.Errorf(c.P()+"Invalid params: invalid revision %s", gc.Query("to"))
+			result.Code = util.ErrorCodeInvalidParam
+			result.Msg = "Invalid params: invalid revision " + gc.Query("to")
+			gc.JSON(http.StatusOK, result)
+			return
+		}
+	}
+
+	diff, err := service.DiffClusterConfigRevisions(c, gc.Param("cluster_id"), gc.Query("labels"), from, to)
+	if err != nil {
+		logrus.Errorf(c.P()+"diff cluster config failed: %s", err.Error())
+		result.Code = util.ErrorCodeFail
+		if k8serrors.IsNotFound(err) || k8serrors.IsBadRequest(err) {
+			result.Code = util.ErrorCodeInvalidParam
+		}
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	result.Data = diff
+	gc.JSON(http.StatusOK, result)
+}
+
+// ClusterconfigCandidateDiffHandler 比较集群配置的历史版本与待上传的集群配置
+//
+//	@Summary		Diff a revision of a cluster config with a candidate file
+//	@Description	Compare a revision of a cluster config with a cluster config file before uploading it, credential values are redacted
+//	@Tags			集群配置文件
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Param			cluster_id	path		string	true	"k8s cluster ID"
+//	@Param			file		formData	file	true	"The candidate cluster config file"
+//	@Param			from		formData	int		true	"The revision to compare with"
+//	@Param			labels		formData	string	false	"The JSON string containing labels of the cluster config"
+//	@Success		200			{object}	proto.ConfigDiffResult
+//	@Router			/clusterconfig/{cluster_id}/diff [POST]
+func ClusterconfigCandidateDiffHandler(gc *gin.Context) {
+	requestId := gc.GetHeader("Request-Id")
+	c := util.CreateContext(requestId)
+	if len(requestId) == 0 {
+		gc.Request.Header.Set("Request-Id", c.RequestId)
+	}
+	var result proto.ConfigDiffResult
+	result.Code = 0
+	result.Msg = "success"
+	result.RequestId = c.RequestId
+
+	from, err := strconv.Atoi(gc.PostForm("from"))
+	if err != nil || from <= 0 {
+		logrus.Errorf(c.P()+"Invalid params: invalid revision %s", gc.PostForm("from"))
+		result.Code = util.ErrorCodeInvalidParam
+		result.Msg = "Invalid params: invalid revision " + gc.PostForm("from")
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	file, err := gc.FormFile("file")
+	if err != nil || file.Size > constValue.MaxFileSize {
+		logrus.Errorf(c.P()+"Invalid parameters: file is empty or too big: %v", err)
+		result.Code = util.ErrorCodeInvalidParam
+		result.Msg = "Invalid params: file is empty or too big"
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+	open, err := file.Open()
+	if err != nil {
+		logrus.Errorf(c.P()+"open file failed: %s", err.Error())
+		result.Code = util.ErrorCodeFail
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+	defer open.Close()
+	content, err := io.ReadAll(open)
+	if err != nil {
+		logrus.Errorf(c.P()+"read file failed: %s", err.Error())
+		result.Code = util.ErrorCodeFail
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	diff, err := service.DiffClusterConfigCandidate(c, gc.Param("cluster_id"), gc.PostForm("labels"), from, content)
+	if err != nil {
+		logrus.Errorf(c.P()+"diff cluster config failed: %s", err.Error())
+		result.Code = util.ErrorCodeFail
+		if k8serrors.IsNotFound(err) || k8serrors.IsBadRequest(err) {
+			result.Code = util.ErrorCodeInvalidParam
+		}
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	result.Data = diff
+	gc.JSON(http.StatusOK, result)
+}
+
+// KubeconfigRevisionListHandler 查询kubeconfig的历史版本
+//
+//	@Summary		List revisions of a kubeconfig
+//	@Description	List the revisions recorded by every upload, update and rollback of a kubeconfig
+//	@Tags			kubeconfig文件
+//	@Produce		json
+//	@Param			cluster_id	path		string	true	"k8s cluster ID"
+//	@Success		200			{object}	proto.ConfigRevisionListResult
+//	@Router			/kubeconfig/{cluster_id}/revisions [GET]
+func KubeconfigRevisionListHandler(gc *gin.Context) {
+	requestId := gc.GetHeader("Request-Id")
+	c := util.CreateContext(requestId)
+	if len(requestId) == 0 {
+		gc.Request.Header.Set("Request-Id", c.RequestId)
+	}
+	var result proto.ConfigRevisionListResult
+	result.Code = 0
+	result.Msg = "success"
+	result.RequestId = c.RequestId
+
+	revisions, err := service.ListKubeconfigRevisions(c, gc.Param("cluster_id"))
+	if err != nil {
+		logrus.Errorf(c.P()+"list revisions failed: %s", err.Error())
+		result.Code = util.ErrorCodeFail
+		if k8serrors.IsNotFound(err) || k8serrors.IsBadRequest(err) {
+			result.Code = util.ErrorCodeInvalidParam
+		}
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	result.Data = revisions
+	gc.JSON(http.StatusOK, result)
+}
+
+// KubeconfigRevisionQueryHandler 查询kubeconfig的指定版本
+//
+//	@Summary		Query a revision of a kubeconfig
+//	@Description	Query the content of a kubeconfig revision
+//	@Tags			kubeconfig文件
+//	@Produce		json
+//	@Param			cluster_id	path		string	true	"k8s cluster ID"
+//	@Param			revision	path		int		true	"revision number"
+//	@Success		200			{object}	proto.ConfigRevisionResult
+//	@Router			/kubeconfig/{cluster_id}/revisions/{revision} [GET]
+func KubeconfigRevisionQueryHandler(gc *gin.Context) {
+	requestId := gc.GetHeader("Request-Id")
+	c := util.CreateContext(requestId)
+	if len(requestId) == 0 {
+		gc.Request.Header.Set("Request-Id", c.RequestId)
+	}
+	var result proto.ConfigRevisionResult
+	result.Code = 0
+	result.Msg = "success"
+	result.RequestId = c.RequestId
+
+	revision, err := strconv.Atoi(gc.Param("revision"))
+	if err != nil || revision <= 0 {
+		logrus.Errorf(c.P()+"Invalid params: invalid revision %s", gc.Param("revision"))
+		result.Code = util.ErrorCodeInvalidParam
+		result.Msg = "Invalid params: invalid revision " + gc.Param("revision")
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	data, err := service.GetKubeconfigRevision(c, gc.Param("cluster_id"), revision)
+	if err != nil {
+		logrus.Errorf(c.P()+"query revision failed: %s", err.Error())
+		result.Code = util.ErrorCodeFail
+		if k8serrors.IsNotFound(err) || k8serrors.IsBadRequest(err) {
+			result.Code = util.ErrorCodeInvalidParam
+		}
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	result.Data = data
+	gc.JSON(http.StatusOK, result)
+}
+
+// KubeconfigRollbackHandler 回滚kubeconfig
+//
+//	@Summary		Roll back a kubeconfig
+//	@Description	Restore a kubeconfig to an earlier revision, the rollback is recorded as a new revision
+//	@Tags			kubeconfig文件
+//	@Accept			application/json
+//	@Produce		json
+//	@Param			cluster_id	path		string						true	"k8s cluster ID"
+//	@Param			rollback	body		proto.ConfigRollbackParam	true	"The revision to roll back to"
+//	@Success		200			{object}	proto.ConfigRollbackResult
+//	@Router			/kubeconfig/{cluster_id}/rollback [POST]
+func KubeconfigRollbackHandler(gc *gin.Context) {
+	requestId := gc.GetHeader("Request-Id")
+	c := util.CreateContext(requestId)
+	if len(requestId) == 0 {
+		gc.Request.Header.Set("Request-Id", c.RequestId)
+	}
+	var result proto.ConfigRollbackResult
+	result.Code = 0
+	result.Msg = "success"
+	result.RequestId = c.RequestId
+
+	var param proto.ConfigRollbackParam
+	if err := gc.ShouldBindJSON(&param); err != nil || param.Revision <= 0 {
+		logrus.Errorf(c.P()+"Invalid params: %v", err)
+		result.Code = util.ErrorCodeInvalidParam
+		result.Msg = "Invalid params: revision must be a positive number"
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	revision, err := service.RollbackKubeconfig(c, gc.Param("cluster_id"), param.Revision)
+	if err != nil {
+		logrus.Errorf(c.P()+"rollback failed: %s", err.Error())
+		result.Code = util.ErrorCodeFail
+		if k8serrors.IsNotFound(err) || k8serrors.IsBadRequest(err) {
+			result.Code = util.ErrorCodeInvalidParam
+		}
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	result.Revision = revision
+	gc.JSON(http.StatusOK, result)
+}
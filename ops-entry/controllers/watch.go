@@ -0,0 +1,90 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"ops-entry/common/util"
+	"ops-entry/proto"
+	"ops-entry/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// WatchClustersHandler
+//
+//	@Summary		Watch changes of kubeconfigs, cluster configs and clusters
+//	@Description	Stream added/updated/deleted events of kubeconfig secrets, cluster config secrets and KubeMateCluster resources as server-sent events.
+//	@Description	Without resource_version all existing objects are sent as added events first.
+//	@Description	The id of each event is the resource_version to resume from (also accepted in the Last-Event-ID header),
+//	@Description	when it has expired a reset event is sent and all existing objects are sent again.
+//	@Tags			Watch
+//	@Produce		text/event-stream
+//	@Param			resource_version	query		string	false	"The resource_version of the last received event"
+//	@Success		200					{object}	proto.WatchEvent
+//	@Router			/watch/clusters		[GET]
+func WatchClustersHandler(gc *gin.Context) {
+	requestId := gc.GetHeader("Request-Id")
+	c := util.CreateContext(requestId)
+	if len(requestId) == 0 {
+		gc.Request.Header.Set("Request-Id", c.RequestId)
+	}
+	var result proto.BaseResult
+	result.RequestId = c.RequestId
+
+	var param proto.WatchParam
+	if err := gc.ShouldBindQuery(&param); err != nil {
+		logrus.Errorf(c.P()+"Invalid param: %s", err.Error())
+		result.Code = util.ErrorCodeInvalidParam
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+	// 断线重连时浏览器通过Last-Event-ID带回最后收到的resource_version
+	if lastEventId := gc.GetHeader("Last-Event-ID"); len(lastEventId) > 0 {
+		param.ResourceVersion = lastEventId
+	}
+
+	events, err := service.WatchClusters(gc.Request.Context(), c, param.ResourceVersion)
+	if err != nil {
+		logrus.Errorf(c.P()+"watch clusters failed: %s", err.Error())
+		result.Code = util.ErrorCodeFail
+		if errors.Is(err, service.ErrInvalidResourceVersion) {
+			result.Code = util.ErrorCodeInvalidParam
+		}
+		result.Msg = err.Error()
+		gc.JSON(http.StatusOK, result)
+		return
+	}
+
+	gc.Header("Content-Type", "text/event-stream")
+	gc.Header("Cache-Control", "no-cache")
+	gc.Header("Connection", "keep-alive")
+	gc.Stream(func(w io.Writer) bool {
+		event, ok := <-events
+		if !ok {
+			return false
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			logrus.Errorf(c.P()+"marshal watch event failed: %s", err.Error())
+			return false
+		}
+		fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ResourceVersion, event.Type, data)
+		return event.Type != proto.WatchEventError
+	})
+}
@@ -27,8 +27,8 @@ import (
 )
 
 type K8sClientSet struct {
-	ClientSet        *kubernetes.Clientset
-	DynamicClientSet *dynamic.DynamicClient
+	ClientSet        kubernetes.Interface
+	DynamicClientSet dynamic.Interface
 }
 
 var KCS *K8sClientSet
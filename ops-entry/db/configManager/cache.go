@@ -0,0 +1,465 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package configManager
+
+import (
+	"context"
+	"errors"
+	"ops-entry/constValue"
+	"strconv"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// cacheIndexLabels 建立索引的label，label selector中精确匹配这些label时通过索引查询
+var cacheIndexLabels = []string{constValue.LabelType, constValue.LabelClusterId}
+
+// Cache nameSpace下Secret、ConfigMap和KubeMate CR的informer缓存
+type Cache struct {
+	nameSpace  string
+	secrets    cache.SharedIndexInformer
+	configMaps cache.SharedIndexInformer
+	resources  map[schema.GroupVersionResource]cache.SharedIndexInformer
+	stop       context.CancelFunc // 停止informer，ctx结束时informer同样停止
+	mu         sync.Mutex         // 串行化写入后的缓存更新，避免并发写入时较旧的结果覆盖较新的结果
+}
+
+/**
+* @Description: 启动nameSpace下的informer缓存，同步完成后返回使用缓存的clientSet：
+* nameSpace下Secret、ConfigMap和resources的Get、List从缓存读取，其余请求直接访问API server，
+* 写入成功后同时更新缓存，保证写入后立即读取可以读到写入的数据
+* @param resources 需要缓存的CR，CRD需要已安装
+* return
+*   @resp ctx结束或constValue.CacheSyncTimeout内没有同步完成时返回错误，此时应继续使用clients
+*
+ */
+
+func StartCache(ctx context.Context, clients *K8sClientSet, nameSpace string, resources ...schema.GroupVersionResource) (*K8sClientSet, error) {
+	if clients == nil {
+		return nil, errors.New("informer cache requires a management cluster")
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(clients.ClientSet, constValue.CacheResyncPeriod, informers.WithNamespace(nameSpace))
+	dynamicFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(clients.DynamicClientSet, constValue.CacheResyncPeriod, nameSpace, nil)
+	c := &Cache{
+		nameSpace:  nameSpace,
+		secrets:    factory.Core().V1().Secrets().Informer(),
+		configMaps: factory.Core().V1().ConfigMaps().Informer(),
+		resources:  make(map[schema.GroupVersionResource]cache.SharedIndexInformer, len(resources)),
+	}
+	synced := []cache.InformerSynced{c.secrets.HasSynced, c.configMaps.HasSynced}
+	for _, gvr := range resources {
+		c.resources[gvr] = dynamicFactory.ForResource(gvr).Informer()
+		synced = append(synced, c.resources[gvr].HasSynced)
+	}
+	for _, informer := range c.informers() {
+		if err := informer.AddIndexers(labelIndexers()); err != nil {
+			return nil, err
+		}
+	}
+
+	runCtx, stop := context.WithCancel(ctx)
+	c.stop = stop
+	factory.Start(runCtx.Done())
+	dynamicFactory.Start(runCtx.Done())
+	syncCtx, cancel := context.WithTimeout(runCtx, constValue.CacheSyncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(syncCtx.Done(), synced...) {
+		c.stop()
+		return nil, errors.New("wait for informer cache sync failed")
+	}
+	logrus.Infof("informer cache of namespace %s synced, %d custom resources", nameSpace, len(resources))
+	return &K8sClientSet{
+		ClientSet:        &cachedClientSet{Interface: clients.ClientSet, cache: c},
+		DynamicClientSet: &cachedDynamicClient{Interface: clients.DynamicClientSet, cache: c},
+	}, nil
+}
+
+func (c *Cache) informers() []cache.SharedIndexInformer {
+	informers := []cache.SharedIndexInformer{c.secrets, c.configMaps}
+	for _, informer := range c.resources {
+		informers = append(informers, informer)
+	}
+	return informers
+}
+
+func labelIndexers() cache.Indexers {
+	indexers := cache.Indexers{}
+	for _, key := range cacheIndexLabels {
+		key := key
+		indexers[key] = func(obj interface{}) ([]string, error) {
+			object, err := meta.Accessor(obj)
+			if err != nil {
+				return nil, err
+			}
+			if value, ok := object.GetLabels()[key]; ok {
+				return []string{value}, nil
+			}
+			return nil, nil
+		}
+	}
+	return indexers
+}
+
+// cachedGet 从缓存读取，不能使用缓存时返回false
+func (c *Cache) cachedGet(informer cache.SharedIndexInformer, resource schema.GroupResource, nameSpace, name string, opts metav1.GetOptions) (interface{}, bool, error) {
+	if nameSpace != c.nameSpace || opts.ResourceVersion != "" {
+		return nil, false, nil
+	}
+	obj, exists, err := informer.GetIndexer().GetByKey(nameSpace + "/" + name)
+	if err != nil {
+		return nil, true, err
+	}
+	if !exists {
+		return nil, true, apierrors.NewNotFound(resource, name)
+	}
+	return obj, true, nil
+}
+
+// cachedList 从缓存中查询匹配label selector的对象，selector精确匹配索引label时通过索引查询，不能使用缓存时返回false
+func (c *Cache) cachedList(informer cache.SharedIndexInformer, nameSpace string, opts metav1.ListOptions) ([]interface{}, bool, error) {
+	if nameSpace != c.nameSpace || opts.FieldSelector != "" || opts.ResourceVersion != "" || opts.Limit > 0 || opts.Continue != "" {
+		return nil, false, nil
+	}
+	selector, err := labels.Parse(opts.LabelSelector)
+	if err != nil {
+		return nil, true, apierrors.NewBadRequest(err.Error())
+	}
+
+	indexer := informer.GetIndexer()
+	var items []interface{}
+	indexed := false
+	for _, key := range cacheIndexLabels {
+		if value, ok := selector.RequiresExactMatch(key); ok {
+			if items, err = indexer.ByIndex(key, value); err != nil {
+				return nil, true, err
+			}
+			indexed = true
+			break
+		}
+	}
+	if !indexed {
+		items = indexer.List()
+	}
+
+	result := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		object, err := meta.Accessor(item)
+		if err != nil {
+			return nil, true, err
+		}
+		if selector.Matches(labels.Set(object.GetLabels())) {
+			result = append(result, item)
+		}
+	}
+	return result, true, nil
+}
+
+/**
+* @Description: 将写入成功后API server返回的对象更新到缓存，
+* informer可能已经同步了之后的修改，缓存中的对象resourceVersion相同或更新时不更新
+*
+ */
+
+func (c *Cache) written(informer cache.SharedIndexInformer, obj interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		logrus.Warnf("update informer cache failed: %v", err)
+		return
+	}
+	cached, exists, err := informer.GetIndexer().GetByKey(object.GetNamespace() + "/" + object.GetName())
+	if err == nil && exists {
+		if cachedObject, err := meta.Accessor(cached); err == nil && !newerVersion(object.GetResourceVersion(), cachedObject.GetResourceVersion()) {
+			return
+		}
+	}
+	if err := informer.GetIndexer().Update(obj); err != nil {
+		logrus.Warnf("update informer cache failed: %v", err)
+	}
+}
+
+// newerVersion resourceVersion不是数字时无法比较，视为较新的版本
+func newerVersion(version, cached string) bool {
+	current, err := strconv.ParseUint(version, 10, 64)
+	if err != nil {
+		return true
+	}
+	previous, err := strconv.ParseUint(cached, 10, 64)
+	if err != nil {
+		return true
+	}
+	return current > previous
+}
+
+// deleted 删除成功后从缓存中删除对象
+func (c *Cache) deleted(informer cache.SharedIndexInformer, nameSpace, name string) {
+	obj, exists, err := informer.GetIndexer().GetByKey(nameSpace + "/" + name)
+	if err != nil || !exists {
+		return
+	}
+	if err := informer.GetIndexer().Delete(obj); err != nil {
+		logrus.Warnf("delete from informer cache failed: %v", err)
+	}
+}
+
+// cachedClientSet 从缓存读取Secret和ConfigMap的clientSet
+type cachedClientSet struct {
+	kubernetes.Interface
+	cache *Cache
+}
+
+func (cs *cachedClientSet) CoreV1() corev1client.CoreV1Interface {
+	return &cachedCoreV1{CoreV1Interface: cs.Interface.CoreV1(), cache: cs.cache}
+}
+
+type cachedCoreV1 struct {
+	corev1client.CoreV1Interface
+	cache *Cache
+}
+
+func (c *cachedCoreV1) Secrets(nameSpace string) corev1client.SecretInterface {
+	return &cachedSecrets{SecretInterface: c.CoreV1Interface.Secrets(nameSpace), cache: c.cache, nameSpace: nameSpace}
+}
+
+func (c *cachedCoreV1) ConfigMaps(nameSpace string) corev1client.ConfigMapInterface {
+	return &cachedConfigMaps{ConfigMapInterface: c.CoreV1Interface.ConfigMaps(nameSpace), cache: c.cache, nameSpace: nameSpace}
+}
+
+type cachedSecrets struct {
+	corev1client.SecretInterface
+	cache     *Cache
+	nameSpace string
+}
+
+func (s *cachedSecrets) Get(ctx context.Context, name string, opts metav1.GetOptions) (*corev1.Secret, error) {
+	obj, ok, err := s.cache.cachedGet(s.cache.secrets, corev1.Resource("secrets"), s.nameSpace, name, opts)
+	if !ok {
+		return s.SecretInterface.Get(ctx, name, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*corev1.Secret).DeepCopy(), nil
+}
+
+func (s *cachedSecrets) List(ctx context.Context, opts metav1.ListOptions) (*corev1.SecretList, error) {
+	items, ok, err := s.cache.cachedList(s.cache.secrets, s.nameSpace, opts)
+	if !ok {
+		return s.SecretInterface.List(ctx, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := &corev1.SecretList{Items: make([]corev1.Secret, 0, len(items))}
+	list.ResourceVersion = s.cache.secrets.LastSyncResourceVersion()
+	for _, item := range items {
+		list.Items = append(list.Items, *item.(*corev1.Secret).DeepCopy())
+	}
+	return list, nil
+}
+
+func (s *cachedSecrets) Create(ctx context.Context, secret *corev1.Secret, opts metav1.CreateOptions) (*corev1.Secret, error) {
+	result, err := s.SecretInterface.Create(ctx, secret, opts)
+	if err == nil && s.nameSpace == s.cache.nameSpace && len(opts.DryRun) == 0 {
+		s.cache.written(s.cache.secrets, result.DeepCopy())
+	}
+	return result, err
+}
+
+func (s *cachedSecrets) Update(ctx context.Context, secret *corev1.Secret, opts metav1.UpdateOptions) (*corev1.Secret, error) {
+	result, err := s.SecretInterface.Update(ctx, secret, opts)
+	if err == nil && s.nameSpace == s.cache.nameSpace && len(opts.DryRun) == 0 {
+		s.cache.written(s.cache.secrets, result.DeepCopy())
+	}
+	return result, err
+}
+
+func (s *cachedSecrets) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	err := s.SecretInterface.Delete(ctx, name, opts)
+	if err == nil && s.nameSpace == s.cache.nameSpace && len(opts.DryRun) == 0 {
+		s.cache.deleted(s.cache.secrets, s.nameSpace, name)
+	}
+	return err
+}
+
+type cachedConfigMaps struct {
+	corev1client.ConfigMapInterface
+	cache     *Cache
+	nameSpace string
+}
+
+func (m *cachedConfigMaps) Get(ctx context.Context, name string, opts metav1.GetOptions) (*corev1.ConfigMap, error) {
+	obj, ok, err := m.cache.cachedGet(m.cache.configMaps, corev1.Resource("configmaps"), m.nameSpace, name, opts)
+	if !ok {
+		return m.ConfigMapInterface.Get(ctx, name, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*corev1.ConfigMap).DeepCopy(), nil
+}
+
+func (m *cachedConfigMaps) List(ctx context.Context, opts metav1.ListOptions) (*corev1.ConfigMapList, error) {
+	items, ok, err := m.cache.cachedList(m.cache.configMaps, m.nameSpace, opts)
+	if !ok {
+		return m.ConfigMapInterface.List(ctx, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := &corev1.ConfigMapList{Items: make([]corev1.ConfigMap, 0, len(items))}
+	list.ResourceVersion = m.cache.configMaps.LastSyncResourceVersion()
+	for _, item := range items {
+		list.Items = append(list.Items, *item.(*corev1.ConfigMap).DeepCopy())
+	}
+	return list, nil
+}
+
+func (m *cachedConfigMaps) Create(ctx context.Context, configMap *corev1.ConfigMap, opts metav1.CreateOptions) (*corev1.ConfigMap, error) {
+	result, err := m.ConfigMapInterface.Create(ctx, configMap, opts)
+	if err == nil && m.nameSpace == m.cache.nameSpace && len(opts.DryRun) == 0 {
+		m.cache.written(m.cache.configMaps, result.DeepCopy())
+	}
+	return result, err
+}
+
+func (m *cachedConfigMaps) Update(ctx context.Context, configMap *corev1.ConfigMap, opts metav1.UpdateOptions) (*corev1.ConfigMap, error) {
+	result, err := m.ConfigMapInterface.Update(ctx, configMap, opts)
+	if err == nil && m.nameSpace == m.cache.nameSpace && len(opts.DryRun) == 0 {
+		m.cache.written(m.cache.configMaps, result.DeepCopy())
+	}
+	return result, err
+}
+
+func (m *cachedConfigMaps) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	err := m.ConfigMapInterface.Delete(ctx, name, opts)
+	if err == nil && m.nameSpace == m.cache.nameSpace && len(opts.DryRun) == 0 {
+		m.cache.deleted(m.cache.configMaps, m.nameSpace, name)
+	}
+	return err
+}
+
+// cachedDynamicClient 从缓存读取已缓存CR的dynamic client
+type cachedDynamicClient struct {
+	dynamic.Interface
+	cache *Cache
+}
+
+func (d *cachedDynamicClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	resource := d.Interface.Resource(gvr)
+	informer, ok := d.cache.resources[gvr]
+	if !ok {
+		return resource
+	}
+	return &cachedResource{NamespaceableResourceInterface: resource, cache: d.cache, gvr: gvr, informer: informer}
+}
+
+type cachedResource struct {
+	dynamic.NamespaceableResourceInterface
+	cache    *Cache
+	gvr      schema.GroupVersionResource
+	informer cache.SharedIndexInformer
+}
+
+func (r *cachedResource) Namespace(nameSpace string) dynamic.ResourceInterface {
+	resource := r.NamespaceableResourceInterface.Namespace(nameSpace)
+	if nameSpace != r.cache.nameSpace {
+		return resource
+	}
+	return &cachedNamespacedResource{ResourceInterface: resource, cachedResource: r, nameSpace: nameSpace}
+}
+
+type cachedNamespacedResource struct {
+	dynamic.ResourceInterface
+	*cachedResource
+	nameSpace string
+}
+
+func (r *cachedNamespacedResource) Get(ctx context.Context, name string, opts metav1.GetOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if len(subresources) > 0 {
+		return r.ResourceInterface.Get(ctx, name, opts, subresources...)
+	}
+	obj, ok, err := r.cache.cachedGet(r.informer, r.gvr.GroupResource(), r.nameSpace, name, opts)
+	if !ok {
+		return r.ResourceInterface.Get(ctx, name, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*unstructured.Unstructured).DeepCopy(), nil
+}
+
+func (r *cachedNamespacedResource) List(ctx context.Context, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	items, ok, err := r.cache.cachedList(r.informer, r.nameSpace, opts)
+	if !ok {
+		return r.ResourceInterface.List(ctx, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := &unstructured.UnstructuredList{Object: map[string]interface{}{}, Items: make([]unstructured.Unstructured, 0, len(items))}
+	list.SetAPIVersion(r.gvr.GroupVersion().String())
+	list.SetResourceVersion(r.informer.LastSyncResourceVersion())
+	for _, item := range items {
+		list.Items = append(list.Items, *item.(*unstructured.Unstructured).DeepCopy())
+	}
+	return list, nil
+}
+
+func (r *cachedNamespacedResource) Create(ctx context.Context, obj *unstructured.Unstructured, opts metav1.CreateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	result, err := r.ResourceInterface.Create(ctx, obj, opts, subresources...)
+	r.written(result, err, len(subresources) == 0 && len(opts.DryRun) == 0)
+	return result, err
+}
+
+func (r *cachedNamespacedResource) Update(ctx context.Context, obj *unstructured.Unstructured, opts metav1.UpdateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	result, err := r.ResourceInterface.Update(ctx, obj, opts, subresources...)
+	r.written(result, err, len(opts.DryRun) == 0)
+	return result, err
+}
+
+func (r *cachedNamespacedResource) UpdateStatus(ctx context.Context, obj *unstructured.Unstructured, opts metav1.UpdateOptions) (*unstructured.Unstructured, error) {
+	result, err := r.ResourceInterface.UpdateStatus(ctx, obj, opts)
+	r.written(result, err, len(opts.DryRun) == 0)
+	return result, err
+}
+
+func (r *cachedNamespacedResource) Delete(ctx context.Context, name string, opts metav1.DeleteOptions, subresources ...string) error {
+	err := r.ResourceInterface.Delete(ctx, name, opts, subresources...)
+	if err == nil && len(subresources) == 0 && len(opts.DryRun) == 0 {
+		r.cache.deleted(r.informer, r.nameSpace, name)
+	}
+	return err
+}
+
+// written 更新和status子资源更新返回的都是完整的对象
+func (r *cachedNamespacedResource) written(result *unstructured.Unstructured, err error, cacheable bool) {
+	if err == nil && cacheable && result != nil {
+		r.cache.written(r.informer, result.DeepCopy())
+	}
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package configManager
+
+import (
+	"context"
+	"ops-entry/constValue"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gvr := schema.GroupVersionResource{Group: constValue.KubeMateGroup, Version: constValue.KubeMateVersion, Resource: constValue.KubeMateClusterResource}
+
+	secret := func(name, namespace, clusterID string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{
+			constValue.LabelType: constValue.ClusterConfigType, constValue.LabelClusterId: clusterID}}}
+	}
+	clientSet := k8sfake.NewSimpleClientset(secret("cluster1", constValue.NameSpace, "cluster1"),
+		secret("cluster2", constValue.NameSpace, "cluster2"), secret("other", "default", "cluster1"))
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: constValue.KubeMateClusterListKind})
+
+	clients, err := StartCache(ctx, &K8sClientSet{ClientSet: clientSet, DynamicClientSet: dynamicClient}, constValue.NameSpace, gvr)
+	assert.Nil(t, err)
+	clientSet.ClearActions()
+	secrets := clients.ClientSet.CoreV1().Secrets(constValue.NameSpace)
+
+	t.Run("read from cache", func(t *testing.T) {
+		got, err := secrets.Get(ctx, "cluster1", metav1.GetOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, "cluster1", got.Labels[constValue.LabelClusterId])
+		_, err = secrets.Get(ctx, "missing", metav1.GetOptions{})
+		assert.True(t, apierrors.IsNotFound(err))
+
+		list, err := secrets.List(ctx, metav1.ListOptions{LabelSelector: constValue.LabelClusterId + "=cluster1"})
+		assert.Nil(t, err)
+		assert.Len(t, list.Items, 1)
+		list, err = secrets.List(ctx, metav1.ListOptions{LabelSelector: constValue.LabelType + "=" + constValue.ClusterConfigType + "," + constValue.LabelClusterId + "!=cluster1"})
+		assert.Nil(t, err)
+		assert.Equal(t, "cluster2", list.Items[0].Name)
+		assert.Empty(t, clientSet.Actions())
+
+		// 修改返回的对象不影响缓存
+		got.Labels[constValue.LabelClusterId] = "changed"
+		got, _ = secrets.Get(ctx, "cluster1", metav1.GetOptions{})
+		assert.Equal(t, "cluster1", got.Labels[constValue.LabelClusterId])
+	})
+
+	t.Run("other namespace", func(t *testing.T) {
+		got, err := clients.ClientSet.CoreV1().Secrets("default").Get(ctx, "other", metav1.GetOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, "other", got.Name)
+		assert.True(t, clientSet.Actions()[0].Matches("get", "secrets"))
+	})
+
+	t.Run("write through", func(t *testing.T) {
+		_, err := secrets.Create(ctx, secret("cluster3", constValue.NameSpace, "cluster3"), metav1.CreateOptions{})
+		assert.Nil(t, err)
+		_, err = secrets.Get(ctx, "cluster3", metav1.GetOptions{})
+		assert.Nil(t, err)
+		assert.Nil(t, secrets.Delete(ctx, "cluster3", metav1.DeleteOptions{}))
+		_, err = secrets.Get(ctx, "cluster3", metav1.GetOptions{})
+		assert.True(t, apierrors.IsNotFound(err))
+
+		configMaps := clients.ClientSet.CoreV1().ConfigMaps(constValue.NameSpace)
+		_, err = configMaps.Create(ctx, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: constValue.NameSpace}}, metav1.CreateOptions{})
+		assert.Nil(t, err)
+		list, err := configMaps.List(ctx, metav1.ListOptions{})
+		assert.Nil(t, err)
+		assert.Len(t, list.Items, 1)
+	})
+
+	t.Run("custom resource", func(t *testing.T) {
+		crs := clients.DynamicClientSet.Resource(gvr).Namespace(constValue.NameSpace)
+		cr := &unstructured.Unstructured{}
+		cr.SetAPIVersion(gvr.GroupVersion().String())
+		cr.SetKind(constValue.KubeMateClusterKind)
+		cr.SetName("cluster1")
+		cr.SetLabels(map[string]string{constValue.LabelClusterId: "cluster1"})
+		_, err := crs.Create(ctx, cr, metav1.CreateOptions{})
+		assert.Nil(t, err)
+
+		dynamicClient.ClearActions()
+		got, err := crs.Get(ctx, "cluster1", metav1.GetOptions{})
+		assert.Nil(t, err)
+		assert.Nil(t, unstructured.SetNestedField(got.Object, "Ready", "status", "phase"))
+		_, err = crs.UpdateStatus(ctx, got, metav1.UpdateOptions{})
+		assert.Nil(t, err)
+
+		list, err := crs.List(ctx, metav1.ListOptions{LabelSelector: constValue.LabelClusterId + "=cluster1"})
+		assert.Nil(t, err)
+		assert.Len(t, list.Items, 1)
+		phase, _, _ := unstructured.NestedString(list.Items[0].Object, "status", "phase")
+		assert.Equal(t, "Ready", phase)
+		for _, action := range dynamicClient.Actions() {
+			_, read := action.(k8stesting.GetAction)
+			assert.False(t, read || action.GetVerb() == "list")
+		}
+	})
+}
+
+func TestCacheWrittenResourceVersion(t *testing.T) {
+	c := &Cache{nameSpace: constValue.NameSpace}
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &corev1.Secret{}, 0, cache.Indexers{})
+	secret := func(resourceVersion, data string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "cluster1", Namespace: constValue.NameSpace, ResourceVersion: resourceVersion},
+			StringData: map[string]string{"data": data}}
+	}
+	get := func() *corev1.Secret {
+		obj, exists, err := informer.GetIndexer().GetByKey(constValue.NameSpace + "/cluster1")
+		assert.Nil(t, err)
+		assert.True(t, exists)
+		return obj.(*corev1.Secret)
+	}
+
+	c.written(informer, secret("10", "v1"))
+	assert.Equal(t, "10", get().ResourceVersion)
+	// informer已同步较新的版本，较旧或相同版本的写入结果不覆盖缓存
+	c.written(informer, secret("12", "v2"))
+	c.written(informer, secret("11", "v1"))
+	assert.Equal(t, "12", get().ResourceVersion)
+	c.written(informer, secret("12", "stale"))
+	assert.Equal(t, "v2", get().StringData["data"])
+	c.written(informer, secret("13", "v3"))
+	assert.Equal(t, "v3", get().StringData["data"])
+}
@@ -11,7 +11,7 @@ import (
 	"context"
 	"fmt"
 	"ops-entry/constValue"
-	"strconv"
+	"sort"
 	"strings"
 
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
@@ -26,113 +26,123 @@ import (
 )
 
 type MapImpl struct {
-	NameSpace     string
-	ConfigMapName string
-	LabelData     map[string]string
+	NameSpace string
+	Clients   *configManager.K8sClientSet // 为空时使用configManager.KCS
+}
+
+func (m *MapImpl) clients() *configManager.K8sClientSet {
+	return clientsOf(m.Clients)
 }
 
 /**
-* @Description:
+* @Description: 多版本configMap存储，每个名称的版本为kubemate-configmap-<名称>-v-N
+* @param clients 为空时使用configManager.KCS
 * @param nameSpace命名空间，默认为default
-* @param 自定义的configMap的名称
-* @param labelData label数据列表必须要有一个标识来进行多版本管理
 * return
 *   @resp configMap实例
 *
  */
 
-func NewMapImpl(nameSpace, configMapName string, labelData map[string]string) *MapImpl {
+func NewMapImpl(clients *configManager.K8sClientSet, nameSpace string) *MapImpl {
 	if len(nameSpace) == 0 {
 		nameSpace = constValue.DefaultNameSpace
 	}
 	return &MapImpl{
-		NameSpace:     nameSpace,
-		ConfigMapName: configMapName,
-		LabelData:     labelData,
+		NameSpace: nameSpace,
+		Clients:   clients,
 	}
 }
 
+// 获取configmap的name
+func (m *MapImpl) getConfigMapName(name string, revision int) string {
+	return fmt.Sprintf("%s%s%s-%s%d", constValue.Prefix, constValue.ConfigMap, name, constValue.VersionMark, revision)
+}
+
 /**
-* @Description:
-* @param labelData  label数据
+* @Description: 名称对应的全部版本，按名称哈希label查询，不需要查询整个命名空间
 * return
-*   @resp opts
+*   @resp 按版本号升序排列的configMap
 *
  */
 
-func (m *MapImpl) GetListOptions(labelData map[string]string) metav1.ListOptions {
-	if len(labelData) < 1 {
-		return metav1.ListOptions{}
-	}
-
-	// 定义 LabelSelector 来筛选 ConfigMap
-	labelSelector := metav1.LabelSelector{
-		MatchLabels: labelData,
+func (m *MapImpl) revisions(ctx context.Context, name string) ([]corev1.ConfigMap, error) {
+	opts := labelListOptions(map[string]string{constValue.LabelNameHash: nameHash(name)})
+	list, err := m.clients().ClientSet.CoreV1().ConfigMaps(m.NameSpace).List(ctx, opts)
+	if err != nil {
+		return nil, err
 	}
-	return metav1.ListOptions{
-		LabelSelector: metav1.FormatLabelSelector(&labelSelector),
+	group := constValue.Prefix + constValue.ConfigMap + name
+	var items []corev1.ConfigMap
+	for _, item := range list.Items {
+		if itemGroup, _, ok := splitRevision(item.Name); ok && itemGroup == group {
+			items = append(items, item)
+		}
 	}
+	sort.Slice(items, func(i, j int) bool {
+		_, ri, _ := splitRevision(items[i].Name)
+		_, rj, _ := splitRevision(items[j].Name)
+		return ri < rj
+	})
+	return items, nil
 }
 
-// 获取configmap的name
-func (m *MapImpl) getConfigMapName(name string, revision int) string {
-	return fmt.Sprintf("%s%s%s_%s%d", constValue.Prefix, constValue.ConfigMap, name, constValue.VersionMark, revision)
+// Get 名称对应的最新版本
+func (m *MapImpl) Get(ctx context.Context, name string) (*configManager.ConfigObject, error) {
+	items, err := m.revisions(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) < 1 {
+		return nil, k8serrors.NewNotFound(corev1.Resource("configmaps"), name)
+	}
+	obj := configMapObject(&items[len(items)-1])
+	return &obj, nil
 }
 
-/**
-* @Description:
-* @param opts labels列表
-* return
-*   @resp  返回configMap 列表
-*
- */
-
-func (m *MapImpl) Get(ctx context.Context, opts metav1.ListOptions) (interface{}, error) {
-	fmt.Println(99999)
-	// 列出具有特定标签的 ConfigMap
-	return configManager.KCS.ClientSet.CoreV1().ConfigMaps(m.NameSpace).List(ctx, opts)
+// List 查询labels匹配的configMap，包含全部版本，按名称、版本号升序排列
+func (m *MapImpl) List(ctx context.Context, labels map[string]string) ([]configManager.ConfigObject, error) {
+	list, err := m.clients().ClientSet.CoreV1().ConfigMaps(m.NameSpace).List(ctx, labelListOptions(labels))
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]configManager.ConfigObject, 0, len(list.Items))
+	for i := range list.Items {
+		if _, _, ok := splitRevision(list.Items[i].Name); !ok ||
+			!strings.HasPrefix(list.Items[i].Name, constValue.Prefix+constValue.ConfigMap) {
+			continue
+		}
+		objects = append(objects, configMapObject(&list.Items[i]))
+	}
+	sortObjects(objects)
+	return objects, nil
 }
 
 /**
-* @Description: 添加或者修改labels
-* @param cmLabels待新增或者修改的labels
-* @param names存在就修改对应名称的configMap的labels，不存在就修改查询结果中全部的configMap
+* @Description: 添加或者修改labels，作用于名称的全部版本
 * return
 *   @resp  返回最后一个修改失败的错误的信息
 *    备注： 该修改不是原子性的可能存在部分修改成功，部分修改失败
  */
 
-func (m *MapImpl) AddLabels(ctx context.Context, opts metav1.UpdateOptions, cmLabels map[string]string, names ...string) error {
-	if len(cmLabels) < 1 {
+func (m *MapImpl) AddLabels(ctx context.Context, name string, labels map[string]string) error {
+	if len(labels) < 1 {
 		return errors.New("mapImpl Invalid empty cmLabels")
 	}
-
-	//获取当前列表信息
-	listOptions := m.GetListOptions(m.LabelData)
-	cms, err := m.Get(ctx, listOptions)
+	items, err := m.revisions(ctx, name)
 	if err != nil {
 		return err
 	}
-
-	configMapList, ok := cms.(*corev1.ConfigMapList)
-	if !ok {
-		return errors.New("add  labels configMap type trans failed")
+	if len(items) < 1 {
+		return k8serrors.NewNotFound(corev1.Resource("configmaps"), name)
 	}
 
-	//不存在
-	if len(configMapList.Items) < 1 {
-		return errors.New(" configMap Not Exists")
-	}
-
-	for _, cm := range configMapList.Items {
-		if len(names) > 0 && util.IsElementInSlice(names, cm.GetName()) || len(names) == 0 {
-			cm.Labels = util.MergeMap(cm.Labels, cmLabels)
-			// 使用客户端更新ConfigMap
-			_, err = configManager.KCS.ClientSet.CoreV1().ConfigMaps(m.NameSpace).Update(ctx, &cm, opts)
-			if err != nil {
-				logrus.Errorf("update configMap failed: [cm:%+v],[err:%v]", cm, err)
-				continue
-			}
+	for _, cm := range items {
+		cm.Labels = util.MergeMap(cm.Labels, labels)
+		// 使用客户端更新ConfigMap
+		_, err = m.clients().ClientSet.CoreV1().ConfigMaps(m.NameSpace).Update(ctx, &cm, metav1.UpdateOptions{})
+		if err != nil {
+			logrus.Errorf("update configMap failed: [cm:%s],[err:%v]", cm.Name, err)
+			continue
 		}
 	}
 	return err
@@ -140,175 +150,110 @@ func (m *MapImpl) AddLabels(ctx context.Context, opts metav1.UpdateOptions, cmLa
 
 /**
 * @Description:configmap 的创建，只能保存非加密信息,需要进行多版本管理
-* name_v1,name_v2 ... name_v10
-* @param opts CreateOptions列表
-* @param data 创建configMap时的map列表，可以为普通的key:value,也可为  文件名称：文件内容
+* name-v-1,name-v-2 ... name-v-N，创建后按保留策略清理旧版本
+* @param obj Data可以为普通的key:value,也可为  文件名称：文件内容
 * return
 *   @resp  返回configMap创建失败的具体信息
 *
  */
 
-func (m *MapImpl) Create(ctx context.Context, opts metav1.CreateOptions, data interface{}) error {
-	cmData, ok := data.(map[string]string)
-	if !ok {
-		return errors.New("mapImpl Invalid data")
-	}
-	if len(cmData) < 1 {
+func (m *MapImpl) Create(ctx context.Context, obj *configManager.ConfigObject) error {
+	if len(obj.Data) < 1 {
 		return errors.New("mapImpl Invalid empty data")
 	}
 
-	//获取当前列表信息
-	listOptions := m.GetListOptions(m.LabelData)
-	cms, err := m.Get(ctx, listOptions)
+	items, err := m.revisions(ctx, obj.Name)
 	if err != nil {
 		return err
 	}
-
-	configMapList, ok := cms.(*corev1.ConfigMapList)
-	if !ok {
-		return errors.New("Create  configMap type trans failed")
+	revision := 1
+	if len(items) > 0 {
+		_, revision, _ = splitRevision(items[len(items)-1].Name)
+		revision++
 	}
 
-	//初次创建
-	if len(configMapList.Items) < 1 {
-		name := m.getConfigMapName(m.ConfigMapName, 1)
-		return m.createConfigMap(ctx, opts, name, cmData)
-	}
-
-	// 获取当前最新revision Data字段信息
-	index := 0
-	for _, cm := range configMapList.Items {
-		idx := strings.LastIndex(cm.Name, constValue.VersionMark)
-		if idx < 0 {
-			logrus.Errorf("create [cm:%+v]", cm)
-			return errors.New("mapImpl Invalid data " + cm.Name)
-		}
-		revision, err := strconv.Atoi(cm.Name[idx+len(constValue.VersionMark):])
-		if err != nil {
-			return err
-		}
-		if revision > index {
-			index = revision
+	var created *corev1.ConfigMap
+	_, err = createRevision(revision, func(revision int) error {
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        m.getConfigMapName(obj.Name, revision),
+				Labels:      withNameLabel(obj.Labels, obj.Name),
+				Annotations: obj.Annotations,
+			},
+			Data: obj.Data,
 		}
-	}
-
-	name := m.getConfigMapName(m.ConfigMapName, index+1)
-	return m.createConfigMap(ctx, opts, name, cmData)
-}
-
-/**
-* @Description: 创建configMap 多版本区分标识为，configMap的名称不一样，但是label一样
-* @param name configMap的名称
-* @param data待保存的数据
-* @param
-* @param
-* return
-*   @resp
-*
- */
-
-func (m *MapImpl) createConfigMap(ctx context.Context, opts metav1.CreateOptions, name string, data map[string]string) error {
-	configMap := &corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:   name,
-			Labels: m.LabelData,
-		},
-		Data: data,
-	}
-
-	_, err := configManager.KCS.ClientSet.CoreV1().ConfigMaps(m.NameSpace).Create(ctx, configMap, opts)
-	if err != nil {
-		if k8serrors.IsAlreadyExists(err) {
-			logrus.Errorf("ConfigMap %s already exists\n", configMap)
-		} else {
+		var err error
+		created, err = m.clients().ClientSet.CoreV1().ConfigMaps(m.NameSpace).Create(ctx, configMap, metav1.CreateOptions{})
+		if err != nil && !k8serrors.IsAlreadyExists(err) {
 			logrus.Error("ConfigMap create err", err)
 		}
 		return err
+	})
+	if err != nil {
+		return err
+	}
+	policy := retentionOf(obj.Labels[constValue.LabelType], Retention)
+	if err := pruneConfigMaps(ctx, m.clients(), m.NameSpace, policy, append(items, *created)); err != nil {
+		logrus.Errorf("prune configMap %s failed: %v", obj.Name, err)
 	}
 	return nil
 }
 
-/**
-* @Description: 更新configMap列表,存在多版本时，需要全部进行修改
-* @param ctx
-* @param opts
-* return
-*   @resp 可能更新多个configMap,不能中断，只返回最后一个错误；日志文件中包含全部错误信息
-*
- */
-
-func (m *MapImpl) Update(ctx context.Context, opts metav1.UpdateOptions, data interface{}) error {
-	cmData, ok := data.(map[string]string)
-	if !ok {
-		return errors.New("mapImpl Update Invalid data")
-	}
-	if len(cmData) < 1 {
+// Update 替换最新版本的数据，Labels、Annotations为nil时保持不变
+func (m *MapImpl) Update(ctx context.Context, obj *configManager.ConfigObject) error {
+	if len(obj.Data) < 1 {
 		return errors.New("mapImpl Update Invalid empty data")
 	}
-	// 获取现有的ConfigMap对象
-	listOptions := m.GetListOptions(m.LabelData)
-	cms, err := m.Get(ctx, listOptions)
+	items, err := m.revisions(ctx, obj.Name)
 	if err != nil {
 		return err
 	}
-
-	configMapList, ok := cms.(*corev1.ConfigMapList)
-	if !ok {
-		return errors.New("update  configMap type trans failed")
+	if len(items) < 1 {
+		return k8serrors.NewNotFound(corev1.Resource("configmaps"), obj.Name)
 	}
-
-	if len(configMapList.Items) < 1 {
-		logrus.Errorf("get empty data [label:%+v]", m.LabelData)
-		return errors.New("update empty configMap failed")
+	latest := items[len(items)-1]
+	if err := checkResourceVersion(corev1.Resource("configmaps"), obj, latest.ResourceVersion); err != nil {
+		return err
 	}
-
-	for _, cm := range configMapList.Items {
-		cm.Data = util.MergeMap(cm.Data, cmData)
-		// 使用客户端更新ConfigMap
-		_, err = configManager.KCS.ClientSet.CoreV1().ConfigMaps(m.NameSpace).Update(ctx, &cm, opts)
-		if err != nil {
-			logrus.Errorf("update configMap failed: [cm:%+v],[err:%v]", cm, err)
-			continue
-		}
+	latest.Data = obj.Data
+	if obj.Labels != nil {
+		latest.Labels = withNameLabel(obj.Labels, obj.Name)
+	}
+	if obj.Annotations != nil {
+		latest.Annotations = obj.Annotations
+	}
+	_, err = m.clients().ClientSet.CoreV1().ConfigMaps(m.NameSpace).Update(ctx, &latest, metav1.UpdateOptions{})
+	if err != nil {
+		logrus.Errorf("update configMap failed: [cm:%s],[err:%v]", latest.Name, err)
 	}
-
 	return err
 }
 
 /**
-* @Description: 删除configMap列表
-* @param ctx
-* @param opts
+* @Description: 删除名称的全部版本
 * return
 *   @resp 可能删除多个configMap,不能中断，只返回最后一个错误；日志文件中包含全部错误信息
 *
  */
 
-func (m *MapImpl) Delete(ctx context.Context, opts metav1.DeleteOptions) error {
-	options := m.GetListOptions(m.LabelData)
-	list, err := m.Get(ctx, options)
+func (m *MapImpl) Delete(ctx context.Context, name string) error {
+	items, err := m.revisions(ctx, name)
 	if err != nil {
 		logrus.Errorf("empty configMaps data：[err:%+v]", err)
 		return err
 	}
-	configMapList, ok := list.(*corev1.ConfigMapList)
-	if !ok {
-		logrus.Error("type ConfigMap conversion failed")
-		return errors.New("type ConfigMap conversion failed")
-	}
-
-	if len(configMapList.Items) < 1 {
-		logrus.Error("empty configMaps data")
-		return errors.New("empty configMaps data")
+	if len(items) < 1 {
+		return k8serrors.NewNotFound(corev1.Resource("configmaps"), name)
 	}
 
 	// 遍历 ConfigMap 列表并执行删除操作
-	for _, cm := range configMapList.Items {
-		err := configManager.KCS.ClientSet.CoreV1().ConfigMaps(cm.Namespace).Delete(context.TODO(), cm.Name, opts)
-		if err != nil {
-			logrus.Errorf("delete configMap failed: [cm:%+v],[err:%v]", cm, err)
-			continue
+	var lastErr error
+	for _, cm := range items {
+		err := m.clients().ClientSet.CoreV1().ConfigMaps(m.NameSpace).Delete(ctx, cm.Name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			logrus.Errorf("delete configMap failed: [cm:%s],[err:%v]", cm.Name, err)
+			lastErr = err
 		}
 	}
-	return err
+	return lastErr
 }
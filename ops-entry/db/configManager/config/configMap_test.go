@@ -13,6 +13,7 @@ import (
 	"ops-entry/common/util"
 	"ops-entry/constValue"
 	"ops-entry/db"
+	"ops-entry/db/configManager"
 	"reflect"
 	"strconv"
 	"strings"
@@ -30,46 +31,40 @@ func TestMapImpl(t *testing.T) {
 	db.InitDb()
 	ctx := context.TODO()
 
-	configMap := NewMapImpl("test_np_config_map", "test_config_map", nil)
+	configMap := NewMapImpl(nil, "test_np_config_map")
+	configMapName := "test_config_map"
 	data := map[string]string{"name": "张三"}
 
 	//mock初始化
-	patches := gomonkey.ApplyMethod(reflect.TypeOf(configMap), "Get", func() (interface{}, error) {
+	patches := gomonkey.ApplyMethod(reflect.TypeOf(configMap), "List", func(_ *MapImpl, _ context.Context, _ map[string]string) ([]configManager.ConfigObject, error) {
 		fmt.Println("patches doing")
 		// 这里返回固定的数据或模拟的错误
-		return &corev1.ConfigMapList{
-			Items: []corev1.ConfigMap{
-				{
-					Data: map[string]string{"name": "lucy", "from": "china"},
-				},
-				{
-					Data: map[string]string{"name": "lily", "from": "china"},
-				},
+		return []configManager.ConfigObject{
+			{
+				Data: map[string]string{"name": "lucy", "from": "china"},
+			},
+			{
+				Data: map[string]string{"name": "lily", "from": "china"},
 			},
 		}, nil
 	})
 	defer patches.Reset() // 在测试结束后恢复原始函数
 
 	t.Run("create", func(t *testing.T) {
-		cms, err2 := configMap.Get(ctx, metav1.ListOptions{})
+		cms, err2 := configMap.List(ctx, nil)
 		if err2 != nil {
 			t.Error("get err", err2)
 			return
 		}
 
-		configMapList, ok := cms.(*corev1.ConfigMapList)
-
-		if !ok {
-			t.Error("type trans ", err2)
-			return
-		}
-		configMapList.Items = nil
+		cms = nil
 		//初次创建
-		if len(configMapList.Items) < 1 {
-			name := configMap.getConfigMapName(configMap.ConfigMapName, 1)
+		if len(cms) < 1 {
+			name := configMap.getConfigMapName(configMapName, 1)
 			t.Log("name-->", name)
-			configMap.createConfigMap(ctx, metav1.CreateOptions{}, name, data)
+			configMap.Create(ctx, &configManager.ConfigObject{Name: configMapName, Data: data})
 		}
+		configMapList := &corev1.ConfigMapList{}
 		configMapList.Items = []corev1.ConfigMap{
 			{
 				Data: map[string]string{"name": "lucy"},
@@ -102,35 +97,28 @@ func TestMapImpl(t *testing.T) {
 			}
 		}
 
-		name := configMap.getConfigMapName(configMap.ConfigMapName, index+1)
+		name := configMap.getConfigMapName(configMapName, index+1)
 		t.Log("name-->", name)
 		assert.Equal(t, "KCOD_CONFIG_MAP_test_config_map_V_5", name)
 
 	})
 
 	t.Run("update", func(t *testing.T) {
-		configMap.LabelData = map[string]string{"test_v1": "6666"}
 		data = map[string]string{"age": "44"}
-		cms, err := configMap.Get(ctx, metav1.ListOptions{})
+		cms, err := configMap.List(ctx, map[string]string{"test_v1": "6666"})
 		if err != nil {
 			t.Error(err)
 			return
 		}
 
-		configMapList, ok := cms.(*corev1.ConfigMapList)
-		if !ok {
-			t.Error("update  configMap type trans failed")
-			return
-		}
-
-		if len(configMapList.Items) < 1 {
+		if len(cms) < 1 {
 			t.Errorf("get empty data [label:%+v]", nil)
 			return
 		}
 
 		data = map[string]string{"name": "张三", "age": "44"}
 
-		for _, cm := range configMapList.Items {
+		for _, cm := range cms {
 			cm.Data = util.MergeMap(cm.Data, data)
 			t.Log(cm.Data)
 			assert.Equal(t, data, cm.Data)
@@ -144,8 +132,7 @@ func TestMapImpl(t *testing.T) {
 	})
 
 	t.Run("delete", func(t *testing.T) {
-		configMap.LabelData = map[string]string{"test_v1": "6666"}
-		err := configMap.Delete(ctx, metav1.DeleteOptions{})
+		err := configMap.Delete(ctx, configMapName)
 		if err != nil {
 			t.Error(err)
 			return
@@ -14,12 +14,14 @@ import (
 	"ops-entry/common/util"
 	"ops-entry/constValue"
 	"ops-entry/db/configManager"
-	"strconv"
+	"sort"
 	"strings"
 
 	"github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
@@ -29,23 +31,26 @@ type CrImpl struct {
 	Kind        string
 	Resource    string
 	NameSpace   string
-	CrName      string
-	LabelData   map[string]string
 	UpdateFiled string
+	Clients     *configManager.K8sClientSet // 为空时使用configManager.KCS
+}
+
+func (c *CrImpl) clients() *configManager.K8sClientSet {
+	return clientsOf(c.Clients)
 }
 
 /**
-* @Description: cr实例
+* @Description: 多版本cr存储，每个名称的版本为kubemate-cr-<名称>-v-N
+* @param clients 为空时使用configManager.KCS
 * @param gvk
 * @param gvr
-* @param crname cr的名字，作为cr的唯一标识
 * @param UpdateFiled 默认更新spec字段
 * return
 *   @resp
 *
  */
 
-func NewCrImpl(group, version, kind, resource, nameSpace, crName, updateFiled string, labelData map[string]string) *CrImpl {
+func NewCrImpl(clients *configManager.K8sClientSet, group, version, kind, resource, nameSpace, updateFiled string) *CrImpl {
 	if len(nameSpace) == 0 {
 		nameSpace = constValue.DefaultNameSpace
 	}
@@ -63,261 +68,284 @@ func NewCrImpl(group, version, kind, resource, nameSpace, crName, updateFiled st
 		Kind:        kind,
 		Resource:    resource,
 		NameSpace:   nameSpace,
-		CrName:      crName,
-		LabelData:   labelData,
 		UpdateFiled: updateFiled,
+		Clients:     clients,
 	}
 }
 
-func (c *CrImpl) GetListOptions(labels map[string]string) metav1.ListOptions {
-	if len(labels) < 1 {
-		return metav1.ListOptions{}
+func (c *CrImpl) gvr() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: c.Group, Version: c.Version, Resource: c.Resource}
+}
+
+// 获取cr的name
+func (c *CrImpl) getCrName(name string, revision int) string {
+	return fmt.Sprintf("%s%s%s-%s%d", constValue.Prefix, constValue.Cr, name, constValue.VersionMark, revision)
+}
+
+// revisions 名称对应的全部版本，按名称哈希label查询，按版本号升序排列
+func (c *CrImpl) revisions(ctx context.Context, name string) ([]unstructured.Unstructured, error) {
+	opts := labelListOptions(map[string]string{constValue.LabelNameHash: nameHash(name)})
+	list, err := c.clients().DynamicClientSet.Resource(c.gvr()).Namespace(c.NameSpace).List(ctx, opts)
+	if err != nil {
+		return nil, err
 	}
+	group := constValue.Prefix + constValue.Cr + name
+	var items []unstructured.Unstructured
+	for _, item := range list.Items {
+		if itemGroup, _, ok := splitRevision(item.GetName()); ok && itemGroup == group {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		_, ri, _ := splitRevision(items[i].GetName())
+		_, rj, _ := splitRevision(items[j].GetName())
+		return ri < rj
+	})
+	return items, nil
+}
 
-	// 定义 LabelSelector 来筛选 cr
-	labelSelector := metav1.LabelSelector{
-		MatchLabels: labels,
+// latest 名称的最新版本
+func (c *CrImpl) latest(ctx context.Context, name string) (*unstructured.Unstructured, error) {
+	items, err := c.revisions(ctx, name)
+	if err != nil {
+		return nil, err
 	}
-	return metav1.ListOptions{
-		LabelSelector: metav1.FormatLabelSelector(&labelSelector),
+	if len(items) < 1 {
+		return nil, k8serrors.NewNotFound(c.gvr().GroupResource(), name)
 	}
+	return &items[len(items)-1], nil
 }
 
-// 获取cr的name
-func (c *CrImpl) getCrName(name string, revision int) string {
-	return fmt.Sprintf("%s%s%s_%s%d", constValue.Prefix, constValue.Cr, name, constValue.VersionMark, revision)
+// Get 名称对应的最新版本，UpdateFiled中不是字符串的字段不包含在Data中
+func (c *CrImpl) Get(ctx context.Context, name string) (*configManager.ConfigObject, error) {
+	cr, err := c.latest(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	obj := c.crObject(cr)
+	return &obj, nil
 }
 
-func (c *CrImpl) Get(ctx context.Context, opts metav1.ListOptions) (interface{}, error) {
-	gvr := schema.GroupVersionResource{
-		Group:    c.Group,
-		Version:  c.Version,
-		Resource: c.Resource,
+// List 查询labels匹配的cr，包含全部版本，按名称、版本号升序排列
+func (c *CrImpl) List(ctx context.Context, labels map[string]string) ([]configManager.ConfigObject, error) {
+	list, err := c.clients().DynamicClientSet.Resource(c.gvr()).Namespace(c.NameSpace).List(ctx, labelListOptions(labels))
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]configManager.ConfigObject, 0, len(list.Items))
+	for i := range list.Items {
+		if _, _, ok := splitRevision(list.Items[i].GetName()); !ok ||
+			!strings.HasPrefix(list.Items[i].GetName(), constValue.Prefix+constValue.Cr) {
+			continue
+		}
+		objects = append(objects, c.crObject(&list.Items[i]))
 	}
+	sortObjects(objects)
+	return objects, nil
+}
 
-	// 获取自定义资源列表
-	return configManager.KCS.DynamicClientSet.Resource(gvr).Namespace(c.NameSpace).List(ctx, opts)
+// Create 创建新版本，obj.Data保存在UpdateFiled字段中
+func (c *CrImpl) Create(ctx context.Context, obj *configManager.ConfigObject) error {
+	fields := make(map[string]interface{}, len(obj.Data))
+	for k, val := range obj.Data {
+		fields[k] = val
+	}
+	return c.CreateFields(ctx, obj, fields)
 }
 
 /**
-* @Description: cr实例创建
-* @param opts CreateOptions
-* @param data需要设置的数据
-* @param 修改的默认列为spec
+* @Description: cr实例创建，每次创建一个新版本，创建后按保留策略清理旧版本
+* @param obj 名称、labels和annotations，不使用obj.Data
+* @param fields 设置到UpdateFiled下的结构化数据
 * return
 *   @resp
 *
  */
 
-func (c *CrImpl) Create(ctx context.Context, opts metav1.CreateOptions, data interface{}) error {
-	crData, ok := data.(map[string]string)
-	if !ok {
-		return errors.New("CrImpl Invalid data")
-	}
-	if len(crData) < 1 {
+func (c *CrImpl) CreateFields(ctx context.Context, obj *configManager.ConfigObject, fields map[string]interface{}) error {
+	if len(fields) < 1 {
 		return errors.New("CrImpl Invalid empty data")
 	}
-
-	listOptions := c.GetListOptions(c.LabelData)
-
-	gvr := schema.GroupVersionResource{
-		Group:    c.Group,
-		Version:  c.Version,
-		Resource: c.Resource,
-	}
-	gvk := schema.GroupVersionKind{
-		Group:   c.Group,
-		Version: c.Version,
-		Kind:    c.Kind,
-	}
-	// 获取自定义资源列表
-	list, err := c.Get(ctx, listOptions)
+	items, err := c.revisions(ctx, obj.Name)
 	if err != nil {
 		return err
 	}
-
-	crList, ok := list.(*unstructured.UnstructuredList)
-	if !ok {
-		return errors.New("Create  cr type trans failed")
-	}
-	if len(crList.Items) < 1 {
-		name := c.getCrName(c.CrName, 1)
-		return c.createCr(ctx, gvk, gvr, opts, name, crData)
-	}
-
-	// 获取当前最新revision Data字段信息
-	index := 0
-	for _, cr := range crList.Items {
-		idx := strings.LastIndex(cr.GetName(), constValue.VersionMark)
-		if idx < 0 {
-			logrus.Errorf("create [cm:%+v]", cr)
-			return errors.New("mapImpl Invalid data " + cr.GetName())
-		}
-		revision, err := strconv.Atoi(cr.GetName()[idx+len(constValue.VersionMark):])
-		if err != nil {
-			return err
-		}
-		if revision > index {
-			index = revision
+	revision := 1
+	if len(items) > 0 {
+		_, revision, _ = splitRevision(items[len(items)-1].GetName())
+		revision++
+	}
+
+	var created *unstructured.Unstructured
+	_, err = createRevision(revision, func(revision int) error {
+		// 创建CR实例
+		cr := &unstructured.Unstructured{}
+		cr.SetGroupVersionKind(schema.GroupVersionKind{Group: c.Group, Version: c.Version, Kind: c.Kind})
+		cr.SetNamespace(c.NameSpace)                      // 设置命名空间
+		cr.SetName(c.getCrName(obj.Name, revision))       // 设置CR名称
+		cr.SetLabels(withNameLabel(obj.Labels, obj.Name)) // 设置labels
+		cr.SetAnnotations(obj.Annotations)
+		// 设置CR的Spec字段
+		for k, val := range fields {
+			err := unstructured.SetNestedField(cr.Object, val, c.UpdateFiled, k)
+			if err != nil {
+				logrus.Errorf("cr set value failed [err:%v],[data:%+v]", err, fields)
+				return err
+			}
 		}
-	}
-	name := c.getCrName(c.CrName, index+1)
-	return c.createCr(ctx, gvk, gvr, opts, name, crData)
-}
 
-func (c *CrImpl) createCr(ctx context.Context, gvk schema.GroupVersionKind, gvr schema.GroupVersionResource, opts metav1.CreateOptions, name string, crData map[string]string) error {
-	// 创建CR实例
-	cr := &unstructured.Unstructured{}
-	cr.SetGroupVersionKind(gvk)
-	cr.SetNamespace(c.NameSpace) // 设置命名空间
-	cr.SetName(name)             // 设置CR名称
-	cr.SetLabels(c.LabelData)    // 设置labels
-	// 设置CR的Spec字段
-	for k, val := range crData {
-		err := unstructured.SetNestedField(cr.Object, val, c.UpdateFiled, k)
-		if err != nil {
-			logrus.Errorf("cr set value failed [err:%v],[data:%+v]", err, crData)
-			return err
+		// 创建CR
+		var err error
+		created, err = c.clients().DynamicClientSet.Resource(c.gvr()).Namespace(c.NameSpace).Create(ctx, cr, metav1.CreateOptions{})
+		if err != nil && !k8serrors.IsAlreadyExists(err) {
+			logrus.Errorf("cr create failed [err:%v]", err)
 		}
-	}
-
-	// 创建CR
-	_, err := configManager.KCS.DynamicClientSet.Resource(gvr).Namespace(c.NameSpace).Create(ctx, cr, opts)
+		return err
+	})
 	if err != nil {
-		logrus.Errorf("cr create failed [err:%v]", err)
 		return err
 	}
+	if err := pruneCrs(ctx, c.clients(), c.gvr(), c.NameSpace, Retention, append(items, *created)); err != nil {
+		logrus.Errorf("prune cr %s failed: %v", obj.Name, err)
+	}
 	return nil
 }
 
 /**
-* @Description: 添加或者修改labels
-* @param cmLabels待新增或者修改的labels
-* @param names存在就修改对应名称的cr的labels，不存在就修改查询结果中全部的cr
+* @Description: 添加或者修改labels，作用于名称的全部版本
 * return
 *   @resp  返回最后一个修改失败的错误的信息
 *    备注： 该修改不是原子性的可能存在部分修改成功，部分修改失败
  */
 
-func (c *CrImpl) AddLabels(ctx context.Context, opts metav1.UpdateOptions, cmLabels map[string]string, names ...string) error {
-	if len(cmLabels) < 1 {
+func (c *CrImpl) AddLabels(ctx context.Context, name string, labels map[string]string) error {
+	if len(labels) < 1 {
 		return errors.New("crImpl Invalid empty cmLabels")
 	}
-
-	//获取当前列表信息
-	listOptions := c.GetListOptions(c.LabelData)
-
-	gvr := schema.GroupVersionResource{
-		Group:    c.Group,
-		Version:  c.Version,
-		Resource: c.Resource,
-	}
-
-	// 获取自定义资源列表
-	list, err := c.Get(ctx, listOptions)
+	items, err := c.revisions(ctx, name)
 	if err != nil {
 		return err
 	}
-
-	listItem, ok := list.(*unstructured.UnstructuredList)
-	if !ok {
-		return errors.New("invalid data" + c.CrName)
-	}
-
-	if len(listItem.Items) < 1 {
-		logrus.Errorf("empty cr data [label:%+v]", c.LabelData)
-		return errors.New("empty cr data")
+	if len(items) < 1 {
+		return k8serrors.NewNotFound(c.gvr().GroupResource(), name)
 	}
 
-	for _, item := range listItem.Items {
-		if len(names) > 0 && util.IsElementInSlice(names, item.GetName()) || len(names) == 0 {
-			labels := util.MergeMap(item.GetLabels(), cmLabels)
-			item.SetLabels(labels)
-			_, err = configManager.KCS.DynamicClientSet.Resource(gvr).Namespace(c.NameSpace).Update(ctx, &item, opts)
-			return err
+	for _, item := range items {
+		item.SetLabels(util.MergeMap(item.GetLabels(), labels))
+		_, err = c.clients().DynamicClientSet.Resource(c.gvr()).Namespace(c.NameSpace).Update(ctx, &item, metav1.UpdateOptions{})
+		if err != nil {
+			logrus.Errorf("update cr failed: [cr:%s],[err:%v]", item.GetName(), err)
+			continue
 		}
 	}
 	return err
 }
 
-func (c *CrImpl) Update(ctx context.Context, opts metav1.UpdateOptions, data interface{}) error {
-	crData, ok := data.(map[string]string)
-	if !ok {
-		return errors.New("CrImpl Invalid data")
-	}
-	if len(crData) < 1 {
+// Update 替换最新版本UpdateFiled中的数据，Labels、Annotations为nil时保持不变
+func (c *CrImpl) Update(ctx context.Context, obj *configManager.ConfigObject) error {
+	if len(obj.Data) < 1 {
 		return errors.New("CrImpl Invalid empty data")
 	}
-
-	listOptions := c.GetListOptions(c.LabelData)
-
-	gvr := schema.GroupVersionResource{
-		Group:    c.Group,
-		Version:  c.Version,
-		Resource: c.Resource,
-	}
-
-	// 获取自定义资源列表
-	list, err := c.Get(ctx, listOptions)
+	latest, err := c.latest(ctx, obj.Name)
 	if err != nil {
 		return err
 	}
-
-	listItem, ok := list.(*unstructured.UnstructuredList)
-	if !ok {
-		return errors.New("invalid data" + c.CrName)
+	if err := checkResourceVersion(c.gvr().GroupResource(), obj, latest.GetResourceVersion()); err != nil {
+		return err
 	}
-
-	if len(listItem.Items) < 1 {
-		logrus.Errorf("empty cr data [label:%+v]", c.LabelData)
-		return errors.New("empty cr data")
+	if err := unstructured.SetNestedStringMap(latest.Object, obj.Data, c.UpdateFiled); err != nil {
+		logrus.Errorf("cr init value failed [err:%v],[data:%+v]", err, obj.Data)
+		return err
+	}
+	if obj.Labels != nil {
+		latest.SetLabels(withNameLabel(obj.Labels, obj.Name))
+	}
+	if obj.Annotations != nil {
+		latest.SetAnnotations(obj.Annotations)
 	}
+	_, err = c.clients().DynamicClientSet.Resource(c.gvr()).Namespace(c.NameSpace).Update(ctx, latest, metav1.UpdateOptions{})
+	return err
+}
 
-	for _, item := range listItem.Items {
-		for k, val := range crData {
-			err = unstructured.SetNestedField(item.Object, val, c.UpdateFiled, k)
-			if err != nil {
-				logrus.Errorf("cr init value failed [err:%v],[data:%+v]", err, crData)
-				return err
-			}
+/**
+* @Description: 通过status子资源更新名称最新版本cr的status，不修改UpdateFiled中的数据
+* @param status map[string]interface{}或可转换为unstructured的结构体指针，整体替换原有的status
+* return
+*   @resp 没有cr时返回k8serrors.IsNotFound可判断的错误
+*
+ */
+
+func (c *CrImpl) UpdateStatus(ctx context.Context, name string, status interface{}) error {
+	fields, ok := status.(map[string]interface{})
+	if !ok {
+		var err error
+		fields, err = runtime.DefaultUnstructuredConverter.ToUnstructured(status)
+		if err != nil {
+			return err
 		}
-		_, err = configManager.KCS.DynamicClientSet.Resource(gvr).Namespace(c.NameSpace).Update(ctx, &item, opts)
+	}
+
+	cr, err := c.latest(ctx, name)
+	if err != nil {
 		return err
+	}
+	cr.Object["status"] = fields
+	_, err = c.clients().DynamicClientSet.Resource(c.gvr()).Namespace(c.NameSpace).UpdateStatus(ctx, cr, metav1.UpdateOptions{})
+	if err != nil {
+		logrus.Errorf("cr update status failed [cr:%s],[err:%v]", cr.GetName(), err)
+	}
+	return err
+}
 
+// GetStatus 名称最新版本cr的status，没有status时返回nil
+func (c *CrImpl) GetStatus(ctx context.Context, name string) (map[string]interface{}, error) {
+	cr, err := c.latest(ctx, name)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	status, _, err := unstructured.NestedMap(cr.Object, "status")
+	return status, err
 }
 
-func (c *CrImpl) Delete(ctx context.Context, opts metav1.DeleteOptions) error {
-	gvr := schema.GroupVersionResource{
-		Group:    c.Group,
-		Version:  c.Version,
-		Resource: c.Resource,
-	}
-	options := c.GetListOptions(c.LabelData)
-	list, err := c.Get(ctx, options)
+/**
+* @Description: 删除名称的全部版本
+* return
+*   @resp 可能删除多个cr,不能中断，只返回最后一个错误；日志文件中包含全部错误信息
+*
+ */
+
+func (c *CrImpl) Delete(ctx context.Context, name string) error {
+	items, err := c.revisions(ctx, name)
 	if err != nil {
 		logrus.Errorf("empty cr data：[err:%+v]", err)
 		return err
 	}
-	listItem, ok := list.(*unstructured.UnstructuredList)
-	if !ok {
-		logrus.Errorf("empty cr data：[labels:%+v]", c.LabelData)
-		return errors.New("invalid data")
-	}
-
-	if len(listItem.Items) < 1 {
-		logrus.Errorf("empty cr data [label:%+v]", c.LabelData)
-		return errors.New("empty cr data")
+	if len(items) < 1 {
+		return k8serrors.NewNotFound(c.gvr().GroupResource(), name)
 	}
 
 	// 遍历 cr 列表并执行删除操作
-	for _, cr := range listItem.Items {
-		err := configManager.KCS.DynamicClientSet.Resource(gvr).Namespace(c.NameSpace).Delete(context.TODO(), cr.GetName(), opts)
-		if err != nil {
-			logrus.Errorf("delete cr failed: [cr:%+v],[err:%v]", cr, err)
-			continue
+	var lastErr error
+	for _, cr := range items {
+		err := c.clients().DynamicClientSet.Resource(c.gvr()).Namespace(c.NameSpace).Delete(ctx, cr.GetName(), metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			logrus.Errorf("delete cr failed: [cr:%s],[err:%v]", cr.GetName(), err)
+			lastErr = err
 		}
 	}
-	return err
+	return lastErr
+}
+
+func (c *CrImpl) crObject(cr *unstructured.Unstructured) configManager.ConfigObject {
+	group, revision, _ := splitRevision(cr.GetName())
+	data, _, _ := unstructured.NestedStringMap(cr.Object, c.UpdateFiled)
+	return configManager.ConfigObject{
+		Name:              strings.TrimPrefix(group, constValue.Prefix+constValue.Cr),
+		Revision:          revision,
+		Labels:            cr.GetLabels(),
+		Annotations:       cr.GetAnnotations(),
+		Data:              data,
+		CreationTimestamp: cr.GetCreationTimestamp().Time,
+		ResourceVersion:   cr.GetResourceVersion(),
+	}
 }
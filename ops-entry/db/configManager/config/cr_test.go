@@ -9,37 +9,36 @@ package config
 
 import (
 	"context"
+	"ops-entry/db/configManager"
 	"testing"
 
 	"github.com/agiledragon/gomonkey/v2"
 	"github.com/stretchr/testify/assert"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 )
 
 // 缺少gomokey mock和assert断言， gomonkey 不支持 x86/amd
 func TestCrImpl(t *testing.T) {
 	ctx := context.TODO()
 
-	cr := NewCrImpl("test.io", "v1", "buyer", "buyers", "test_cr", "test_cr_name", "", nil)
+	cr := NewCrImpl(nil, "test.io", "v1", "buyer", "buyers", "test_cr", "")
 	data := map[string]string{"name": "张三"}
 
 	//mock初始化
-	patches := gomonkey.ApplyFunc(cr.Get, func(ctx context.Context, opts metav1.ListOptions) (interface{}, error) {
+	patches := gomonkey.ApplyFunc(cr.Get, func(ctx context.Context, name string) (*configManager.ConfigObject, error) {
 		// 这里返回固定的数据或模拟的错误
-		return &unstructured.UnstructuredList{
-			Object: map[string]interface{}{},
-			Items: []unstructured.Unstructured{
-				{},
-				{},
-			},
-		}, nil
+		return &configManager.ConfigObject{Name: name}, nil
 	})
 	defer patches.Reset() // 在测试结束后恢复原始函数
 
 	t.Run("create", func(t *testing.T) {
-		cr.LabelData = map[string]string{"test_v1": "6666"}
-		err := cr.Create(ctx, metav1.CreateOptions{}, data)
+		labels := map[string]string{"test_v1": "6666"}
+		err := cr.Create(ctx, &configManager.ConfigObject{Name: "test_cr_name", Labels: labels, Data: data})
 		if err != nil {
 			t.Error(err)
 			return
@@ -50,9 +49,7 @@ func TestCrImpl(t *testing.T) {
 	})
 
 	t.Run("update", func(t *testing.T) {
-		cr.LabelData = map[string]string{"test_v1": "6666"}
-		data = map[string]string{"age": "44"}
-		err := cr.Update(ctx, metav1.UpdateOptions{}, map[string]string{"name": "张三"})
+		err := cr.Update(ctx, &configManager.ConfigObject{Name: "test_cr_name", Data: map[string]string{"name": "张三"}})
 		if err != nil {
 			t.Error(err)
 			return
@@ -61,9 +58,7 @@ func TestCrImpl(t *testing.T) {
 	})
 
 	t.Run("delete", func(t *testing.T) {
-		cr.LabelData = map[string]string{"test_v1": "6666"}
-		data = map[string]string{"age": "44"}
-		err := cr.Delete(ctx, metav1.DeleteOptions{})
+		err := cr.Delete(ctx, "test_cr_name")
 		if err != nil {
 			t.Error(err)
 			return
@@ -71,3 +66,36 @@ func TestCrImpl(t *testing.T) {
 		t.Log("success")
 	})
 }
+
+func TestCrImplStatus(t *testing.T) {
+	ctx := context.TODO()
+	cr := NewCrImpl(nil, "kubemate.openeuler.org", "v1", "Config", "configs", "kubemate", "")
+	gvr := schema.GroupVersionResource{Group: cr.Group, Version: cr.Version, Resource: cr.Resource}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{gvr: "ConfigList"})
+	cr.Clients = &configManager.K8sClientSet{DynamicClientSet: dynamicClient}
+
+	err := cr.UpdateStatus(ctx, "k8s-001", map[string]interface{}{"phase": "Ready"})
+	assert.True(t, k8serrors.IsNotFound(err))
+
+	labels := map[string]string{"cluster": "k8s-001"}
+	assert.Nil(t, cr.Create(ctx, &configManager.ConfigObject{Name: "k8s-001", Labels: labels, Data: map[string]string{"version": "v1.29.1"}}))
+	assert.Nil(t, cr.Create(ctx, &configManager.ConfigObject{Name: "k8s-001", Labels: labels, Data: map[string]string{"version": "v1.29.2"}}))
+	type status struct {
+		Phase              string `json:"phase"`
+		ObservedGeneration int64  `json:"observedGeneration"`
+	}
+	assert.Nil(t, cr.UpdateStatus(ctx, "k8s-001", &status{Phase: "Ready", ObservedGeneration: 1}))
+
+	current, err := cr.GetStatus(ctx, "k8s-001")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"phase": "Ready", "observedGeneration": int64(1)}, current)
+	// 只更新最新版本，spec不变
+	latest, err := dynamicClient.Resource(gvr).Namespace("kubemate").Get(ctx, "kubemate-cr-k8s-001-v-2", metav1.GetOptions{})
+	assert.Nil(t, err)
+	version, _, _ := unstructured.NestedString(latest.Object, "spec", "version")
+	assert.Equal(t, "v1.29.2", version)
+	first, err := dynamicClient.Resource(gvr).Namespace("kubemate").Get(ctx, "kubemate-cr-k8s-001-v-1", metav1.GetOptions{})
+	assert.Nil(t, err)
+	_, found, _ := unstructured.NestedMap(first.Object, "status")
+	assert.False(t, found)
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright (c) KylinSoft  Co., Ltd. 2024.All rights reserved.
+ * KubeMate licensed under the Mulan Permissive Software License, Version 2.
+ * See LICENSE file for more details.
+ * Author: liukuo <liukuo@kylinos.cn>
+ * Date: Thu Jul 25 16:18:53 2024 +0800
+ */
+package config
+
+import (
+	"context"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+
+	"github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+var crdResource = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// EnsureCRD 创建CRD，已存在时更新为crd的定义
+func EnsureCRD(ctx context.Context, clients *configManager.K8sClientSet, crd *unstructured.Unstructured) error {
+	client := clientsOf(clients).DynamicClientSet.Resource(crdResource)
+	current, err := client.Get(ctx, crd.GetName(), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = client.Create(ctx, crd, metav1.CreateOptions{})
+		if err == nil {
+			logrus.Infof("crd %s created", crd.GetName())
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	crd = crd.DeepCopy()
+	crd.SetResourceVersion(current.GetResourceVersion())
+	_, err = client.Update(ctx, crd, metav1.UpdateOptions{})
+	if err == nil {
+		logrus.Infof("crd %s updated", crd.GetName())
+	}
+	return err
+}
+
+// WaitCRDEstablished 等待CRD的Established条件为True，之后才能创建对应的CR
+func WaitCRDEstablished(ctx context.Context, clients *configManager.K8sClientSet, name string) error {
+	client := clientsOf(clients).DynamicClientSet.Resource(crdResource)
+	return wait.PollUntilContextTimeout(ctx, constValue.CRDEstablishPollInterval, constValue.CRDEstablishTimeout, true, func(ctx context.Context) (bool, error) {
+		crd, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+		for _, condition := range conditions {
+			c, ok := condition.(map[string]interface{})
+			if ok && c["type"] == "Established" && c["status"] == "True" {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+}
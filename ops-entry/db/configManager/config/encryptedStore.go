@@ -0,0 +1,241 @@
+/*
+ * Copyright (c) KylinSoft  Co., Ltd. 2024.All rights reserved.
+ * KubeMate licensed under the Mulan Permissive Software License, Version 2.
+ * See LICENSE file for more details.
+ * Author: liukuo <liukuo@kylinos.cn>
+ * Date: Thu Jul 25 16:18:53 2024 +0800
+ */
+package config
+
+import (
+	"context"
+	"ops-entry/db/configManager"
+	"ops-entry/db/configManager/encryption"
+
+	"github.com/sirupsen/logrus"
+)
+
+/**
+* @Description: 对指定数据字段加密的配置存储，写入时加密，读取时透明解密；
+* 已有的未加密数据可以正常读取，调用ReEncrypt后加密
+*
+ */
+
+type EncryptedStore struct {
+	configManager.RevisionStore
+	Envelope *encryption.Envelope
+	Keys     []string // 需要加密的数据字段
+}
+
+func NewEncryptedStore(store configManager.RevisionStore, envelope *encryption.Envelope, keys ...string) *EncryptedStore {
+	return &EncryptedStore{RevisionStore: store, Envelope: envelope, Keys: keys}
+}
+
+func (s *EncryptedStore) Get(ctx context.Context, name string) (*configManager.ConfigObject, error) {
+	obj, err := s.RevisionStore.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return obj, s.decrypt(ctx, obj)
+}
+
+func (s *EncryptedStore) List(ctx context.Context, labels map[string]string) ([]configManager.ConfigObject, error) {
+	objects, err := s.RevisionStore.List(ctx, labels)
+	if err != nil {
+		return nil, err
+	}
+	return objects, s.decryptAll(ctx, objects)
+}
+
+func (s *EncryptedStore) Create(ctx context.Context, obj *configManager.ConfigObject) error {
+	encrypted, err := s.encrypt(ctx, obj)
+	if err != nil {
+		return err
+	}
+	return s.RevisionStore.Create(ctx, encrypted)
+}
+
+func (s *EncryptedStore) Update(ctx context.Context, obj *configManager.ConfigObject) error {
+	encrypted, err := s.encrypt(ctx, obj)
+	if err != nil {
+		return err
+	}
+	return s.RevisionStore.Update(ctx, encrypted)
+}
+
+func (s *EncryptedStore) ListRevisions(ctx context.Context, name string) ([]configManager.ConfigObject, error) {
+	revisions, err := s.RevisionStore.ListRevisions(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return revisions, s.decryptAll(ctx, revisions)
+}
+
+func (s *EncryptedStore) GetRevision(ctx context.Context, name string, revision int) (*configManager.ConfigObject, error) {
+	obj, err := s.RevisionStore.GetRevision(ctx, name, revision)
+	if err != nil {
+		return nil, err
+	}
+	return obj, s.decrypt(ctx, obj)
+}
+
+func (s *EncryptedStore) UpdateRevision(ctx context.Context, obj *configManager.ConfigObject) error {
+	encrypted, err := s.encrypt(ctx, obj)
+	if err != nil {
+		return err
+	}
+	return s.RevisionStore.UpdateRevision(ctx, encrypted)
+}
+
+/**
+* @Description: 使用当前主密钥重新加密全部配置及其历史版本，包括未加密的配置，用于轮换主密钥
+* 只处理包含加密字段的对象，更新时携带读取到的ResourceVersion，期间被修改的对象返回Conflict错误
+* return
+*   @resp 重新加密的对象数，包括历史版本
+*
+ */
+
+func (s *EncryptedStore) ReEncrypt(ctx context.Context) (int, error) {
+	objects, err := s.RevisionStore.List(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for i := range objects {
+		obj := &objects[i]
+		if !s.hasKeys(obj) {
+			continue
+		}
+		updated, err := s.reEncrypt(ctx, obj, s.Update)
+		if err != nil {
+			return count, err
+		}
+		if updated {
+			count++
+		}
+
+		revisions, err := s.RevisionStore.ListRevisions(ctx, obj.Name)
+		if err != nil {
+			return count, err
+		}
+		for j := range revisions {
+			updated, err := s.reEncrypt(ctx, &revisions[j], s.UpdateRevision)
+			if err != nil {
+				return count, err
+			}
+			if updated {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+// reEncrypt 对象存在未加密、不是使用当前主密钥或按旧格式加密的字段时重新加密
+func (s *EncryptedStore) reEncrypt(ctx context.Context, obj *configManager.ConfigObject,
+	update func(context.Context, *configManager.ConfigObject) error) (bool, error) {
+	stale := s.stale(obj)
+	legacy, err := s.open(ctx, obj)
+	if err != nil {
+		return false, err
+	}
+	if !stale && !legacy {
+		return false, nil
+	}
+	// labels和annotations保持不变
+	obj.Labels, obj.Annotations = nil, nil
+	if err := update(ctx, obj); err != nil {
+		logrus.Errorf("re-encrypt config failed: [name:%s],[revision:%d],[err:%v]", obj.Name, obj.Revision, err)
+		return false, err
+	}
+	return true, nil
+}
+
+/**
+* @Description: 字段的附加认证数据，绑定配置名称和字段名，字段名即配置类型，如clusterconfig、kubeconfig，
+* 密文被复制到其他配置或字段时无法解密；历史版本的名称为所属配置的名称，与当前版本一致
+*
+ */
+
+func additionalData(name, key string) []byte {
+	return []byte(name + "/" + key)
+}
+
+// encrypt 返回加密后的副本，不修改obj
+func (s *EncryptedStore) encrypt(ctx context.Context, obj *configManager.ConfigObject) (*configManager.ConfigObject, error) {
+	encrypted := *obj
+	encrypted.Data = copyMap(obj.Data)
+	for _, key := range s.Keys {
+		value, ok := encrypted.Data[key]
+		if !ok || encryption.IsEncrypted(value) {
+			continue
+		}
+		ciphertext, err := s.Envelope.Encrypt(ctx, []byte(value), additionalData(obj.Name, key))
+		if err != nil {
+			return nil, err
+		}
+		encrypted.Data[key] = ciphertext
+	}
+	return &encrypted, nil
+}
+
+func (s *EncryptedStore) decrypt(ctx context.Context, obj *configManager.ConfigObject) error {
+	_, err := s.open(ctx, obj)
+	return err
+}
+
+/**
+* @Description: 解密对象的全部加密字段，旧版本只使用字段名作为附加认证数据，
+* 按当前格式解密失败时再按旧格式解密，ReEncrypt时改为当前格式
+* return
+*   @resp 存在按旧格式加密的字段时返回true
+*
+ */
+
+func (s *EncryptedStore) open(ctx context.Context, obj *configManager.ConfigObject) (bool, error) {
+	legacy := false
+	for key, value := range obj.Data {
+		if !encryption.IsEncrypted(value) {
+			continue
+		}
+		plaintext, err := s.Envelope.Decrypt(ctx, value, additionalData(obj.Name, key))
+		if err != nil {
+			var legacyErr error
+			if plaintext, legacyErr = s.Envelope.Decrypt(ctx, value, []byte(key)); legacyErr != nil {
+				logrus.Errorf("decrypt config failed: [name:%s],[key:%s],[err:%v]", obj.Name, key, err)
+				return legacy, err
+			}
+			legacy = true
+		}
+		obj.Data[key] = string(plaintext)
+	}
+	return legacy, nil
+}
+
+func (s *EncryptedStore) decryptAll(ctx context.Context, objects []configManager.ConfigObject) error {
+	for i := range objects {
+		if err := s.decrypt(ctx, &objects[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *EncryptedStore) hasKeys(obj *configManager.ConfigObject) bool {
+	for _, key := range s.Keys {
+		if _, ok := obj.Data[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// stale 存在未加密或不是使用当前主密钥加密的字段
+func (s *EncryptedStore) stale(obj *configManager.ConfigObject) bool {
+	for _, key := range s.Keys {
+		if value, ok := obj.Data[key]; ok && s.Envelope.Stale(value) {
+			return true
+		}
+	}
+	return false
+}
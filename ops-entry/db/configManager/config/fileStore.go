@@ -0,0 +1,422 @@
+/*
+ * Copyright (c) KylinSoft  Co., Ltd. 2024.All rights reserved.
+ * KubeMate licensed under the Mulan Permissive Software License, Version 2.
+ * See LICENSE file for more details.
+ * Author: liukuo <liukuo@kylinos.cn>
+ * Date: Thu Jul 25 16:18:53 2024 +0800
+ */
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var fileResource = schema.GroupResource{Resource: "files"}
+
+const (
+	fileStoreExt      = ".json"
+	fileStoreLockName = ".lock"
+)
+
+// fileEntry 一个名称对应的文件内容，包含当前数据和全部历史版本
+type fileEntry struct {
+	Object    configManager.ConfigObject   `json:"object"`
+	Revisions []configManager.ConfigObject `json:"revisions,omitempty"`
+	Version   int64                        `json:"version"` // 每次修改递增，作为对象和历史版本的ResourceVersion
+}
+
+/**
+* @Description: 本地目录中的配置存储，语义与SecretImpl一致，不需要管理集群
+* 每个名称保存为目录下的一个<name>.json文件，先写临时文件再rename保证写入的原子性；
+* 目录下的.lock文件用flock在多个进程之间加锁，文件权限为0600
+*
+ */
+
+type FileStore struct {
+	Dir string
+}
+
+// NewFileStore 创建本地目录存储，目录不存在时创建
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, constValue.PrivateDirMode); err != nil {
+		return nil, err
+	}
+	return &FileStore{Dir: dir}, nil
+}
+
+func (s *FileStore) Get(ctx context.Context, name string) (*configManager.ConfigObject, error) {
+	var obj configManager.ConfigObject
+	err := s.withLock(syscall.LOCK_SH, func() error {
+		entry, err := s.read(name)
+		if err != nil {
+			return err
+		}
+		obj = entry.Object
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+func (s *FileStore) List(ctx context.Context, selector map[string]string) ([]configManager.ConfigObject, error) {
+	objects := []configManager.ConfigObject{}
+	err := s.withLock(syscall.LOCK_SH, func() error {
+		files, err := os.ReadDir(s.Dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			name := strings.TrimSuffix(file.Name(), fileStoreExt)
+			if file.IsDir() || !strings.HasSuffix(file.Name(), fileStoreExt) || strings.HasPrefix(file.Name(), ".") {
+				continue
+			}
+			entry, err := s.read(name)
+			if err != nil {
+				return err
+			}
+			if labels.SelectorFromSet(selector).Matches(labels.Set(entry.Object.Labels)) {
+				objects = append(objects, entry.Object)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortObjects(objects)
+	return objects, nil
+}
+
+func (s *FileStore) Create(ctx context.Context, obj *configManager.ConfigObject) error {
+	if err := s.validName(obj.Name); err != nil {
+		return err
+	}
+	return s.withLock(syscall.LOCK_EX, func() error {
+		if _, err := os.Stat(s.path(obj.Name)); err == nil {
+			return k8serrors.NewAlreadyExists(fileResource, obj.Name)
+		}
+		entry := &fileEntry{Object: copyObject(*obj)}
+		entry.Object.Revision = 0
+		entry.Object.CreationTimestamp = time.Now()
+		entry.Object.ResourceVersion = entry.nextVersion()
+		return s.write(entry)
+	})
+}
+
+func (s *FileStore) Update(ctx context.Context, obj *configManager.ConfigObject) error {
+	return s.modify(obj.Name, func(entry *fileEntry) error {
+		if err := checkResourceVersion(fileResource, obj, entry.Object.ResourceVersion); err != nil {
+			return err
+		}
+		update := copyObject(*obj)
+		entry.Object.Data = update.Data
+		if update.Labels != nil {
+			entry.Object.Labels = update.Labels
+		}
+		if update.Annotations != nil {
+			entry.Object.Annotations = update.Annotations
+		}
+		entry.Object.ResourceVersion = entry.nextVersion()
+		return nil
+	})
+}
+
+func (s *FileStore) AddLabels(ctx context.Context, name string, labels map[string]string) error {
+	return s.modify(name, func(entry *fileEntry) error {
+		entry.Object.Labels = util.MergeMap(entry.Object.Labels, labels)
+		entry.Object.ResourceVersion = entry.nextVersion()
+		return nil
+	})
+}
+
+func (s *FileStore) Delete(ctx context.Context, name string) error {
+	if err := s.validName(name); err != nil {
+		return err
+	}
+	return s.withLock(syscall.LOCK_EX, func() error {
+		err := os.Remove(s.path(name))
+		if os.IsNotExist(err) {
+			return k8serrors.NewNotFound(fileResource, name)
+		}
+		return err
+	})
+}
+
+func (s *FileStore) CreateRevision(ctx context.Context, name string, cause string) (int, error) {
+	revision := 0
+	err := s.modify(name, func(entry *fileEntry) error {
+		revision = 1
+		if len(entry.Revisions) > 0 {
+			revision = entry.Revisions[len(entry.Revisions)-1].Revision + 1
+		}
+		entry.addRevision(revision, cause)
+		markActive(entry.Revisions, 0)
+		entry.prune(Retention)
+		return nil
+	})
+	return revision, err
+}
+
+func (s *FileStore) RevisionStats(ctx context.Context) (map[string]configManager.RevisionStat, error) {
+	stats := make(map[string]configManager.RevisionStat)
+	err := s.withLock(syscall.LOCK_SH, func() error {
+		files, err := os.ReadDir(s.Dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			name := strings.TrimSuffix(file.Name(), fileStoreExt)
+			if file.IsDir() || !strings.HasSuffix(file.Name(), fileStoreExt) || strings.HasPrefix(file.Name(), ".") {
+				continue
+			}
+			entry, err := s.read(name)
+			if err != nil {
+				return err
+			}
+			var stat configManager.RevisionStat
+			for _, revision := range entry.Revisions {
+				stat.Add(revision.CreationTimestamp)
+			}
+			stats[name] = stat
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (s *FileStore) ListRevisions(ctx context.Context, name string) ([]configManager.ConfigObject, error) {
+	revisions := []configManager.ConfigObject{}
+	err := s.withLock(syscall.LOCK_SH, func() error {
+		entry, err := s.read(name)
+		if err != nil {
+			return err
+		}
+		revisions = append(revisions, entry.Revisions...)
+		return nil
+	})
+	if k8serrors.IsNotFound(err) {
+		return revisions, nil
+	}
+	return revisions, err
+}
+
+func (s *FileStore) GetRevision(ctx context.Context, name string, revision int) (*configManager.ConfigObject, error) {
+	var obj *configManager.ConfigObject
+	err := s.withLock(syscall.LOCK_SH, func() error {
+		entry, err := s.read(name)
+		if err != nil {
+			return err
+		}
+		obj, err = entry.revision(revision)
+		return err
+	})
+	return obj, err
+}
+
+func (s *FileStore) Rollback(ctx context.Context, name string, revision int) (int, error) {
+	newRevision := 0
+	err := s.modify(name, func(entry *fileEntry) error {
+		obj, err := entry.revision(revision)
+		if err != nil {
+			return err
+		}
+		entry.Object.Data = obj.Data
+		entry.Object.ResourceVersion = entry.nextVersion()
+		newRevision = entry.Revisions[len(entry.Revisions)-1].Revision + 1
+		entry.addRevision(newRevision, fmt.Sprintf(constValue.ChangeCauseRollbackFmt, revision))
+		markActive(entry.Revisions, revision)
+		entry.prune(Retention)
+		return nil
+	})
+	return newRevision, err
+}
+
+func (s *FileStore) UpdateRevision(ctx context.Context, obj *configManager.ConfigObject) error {
+	return s.modify(obj.Name, func(entry *fileEntry) error {
+		for i := range entry.Revisions {
+			if entry.Revisions[i].Revision != obj.Revision {
+				continue
+			}
+			if err := checkResourceVersion(fileResource, obj, entry.Revisions[i].ResourceVersion); err != nil {
+				return err
+			}
+			entry.Revisions[i].Data = copyMap(obj.Data)
+			entry.Revisions[i].ResourceVersion = entry.nextVersion()
+			return nil
+		}
+		return k8serrors.NewNotFound(fileResource, fmt.Sprintf("%s-%s%d", obj.Name, constValue.VersionMark, obj.Revision))
+	})
+}
+
+// modify 在排它锁内读取、修改并写回名称对应的文件
+func (s *FileStore) modify(name string, fn func(entry *fileEntry) error) error {
+	if err := s.validName(name); err != nil {
+		return err
+	}
+	return s.withLock(syscall.LOCK_EX, func() error {
+		entry, err := s.read(name)
+		if err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+		return s.write(entry)
+	})
+}
+
+// withLock 持有目录的文件锁执行fn，how为syscall.LOCK_SH或syscall.LOCK_EX
+func (s *FileStore) withLock(how int, fn func() error) error {
+	lock, err := os.OpenFile(filepath.Join(s.Dir, fileStoreLockName), os.O_CREATE|os.O_RDWR, constValue.PrivateFileMode)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), how); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+	return fn()
+}
+
+func (s *FileStore) read(name string) (*fileEntry, error) {
+	if err := s.validName(name); err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(s.path(name))
+	if os.IsNotExist(err) {
+		return nil, k8serrors.NewNotFound(fileResource, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry fileEntry
+	if err := json.Unmarshal(content, &entry); err != nil {
+		logrus.Errorf("invalid config file %s: %v", s.path(name), err)
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// write 先写入同目录下的临时文件，同步到磁盘后rename，避免中断时留下不完整的文件
+func (s *FileStore) write(entry *fileEntry) error {
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.Dir, "."+entry.Object.Name+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(constValue.PrivateFileMode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(entry.Object.Name))
+}
+
+func (s *FileStore) path(name string) string {
+	return filepath.Join(s.Dir, name+fileStoreExt)
+}
+
+// validName 名称作为文件名使用，必须是合法的资源名称
+func (s *FileStore) validName(name string) error {
+	if !util.IsValidResourceName(name) {
+		return k8serrors.NewBadRequest("invalid config name " + strconv.Quote(name))
+	}
+	return nil
+}
+
+func (e *fileEntry) nextVersion() string {
+	e.Version++
+	return strconv.FormatInt(e.Version, 10)
+}
+
+// addRevision 将当前数据保存为历史版本
+func (e *fileEntry) addRevision(revision int, cause string) {
+	object := copyObject(e.Object)
+	object.Revision = revision
+	object.Labels = map[string]string{constValue.LabelType: constValue.SecretRevisionType}
+	object.Annotations = map[string]string{
+		constValue.AnnotationRevisionOf:  e.Object.Name,
+		constValue.AnnotationRevision:    strconv.Itoa(revision),
+		constValue.AnnotationChangeCause: cause,
+	}
+	object.CreationTimestamp = time.Now()
+	object.ResourceVersion = e.nextVersion()
+	e.Revisions = append(e.Revisions, object)
+}
+
+func (e *fileEntry) revision(revision int) (*configManager.ConfigObject, error) {
+	for i := range e.Revisions {
+		if e.Revisions[i].Revision == revision {
+			obj := e.Revisions[i]
+			return &obj, nil
+		}
+	}
+	return nil, k8serrors.NewNotFound(fileResource, fmt.Sprintf("%s-%s%d", e.Object.Name, constValue.VersionMark, revision))
+}
+
+// prune 按保留策略删除过期的历史版本
+func (e *fileEntry) prune(policy RetentionPolicy) {
+	objects := make([]revisionObject, 0, len(e.Revisions))
+	for _, revision := range e.Revisions {
+		objects = append(objects, revisionObject{
+			name:     strconv.Itoa(revision.Revision),
+			group:    e.Object.Name,
+			revision: revision.Revision,
+			created:  revision.CreationTimestamp,
+			active:   revision.Annotations[constValue.AnnotationActive] == "true",
+		})
+	}
+	expired := make(map[string]bool)
+	for _, name := range policy.expired(objects, time.Now()) {
+		expired[name] = true
+	}
+
+	revisions := e.Revisions[:0]
+	for _, revision := range e.Revisions {
+		if !expired[strconv.Itoa(revision.Revision)] {
+			revisions = append(revisions, revision)
+		}
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision < revisions[j].Revision
+	})
+	e.Revisions = revisions
+}
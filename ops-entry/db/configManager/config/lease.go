@@ -0,0 +1,172 @@
+/*
+ * Copyright (c) KylinSoft  Co., Ltd. 2024.All rights reserved.
+ * KubeMate licensed under the Mulan Permissive Software License, Version 2.
+ * See LICENSE file for more details.
+ * Author: liukuo <liukuo@kylinos.cn>
+ * Date: Thu Jul 25 16:18:53 2024 +0800
+ */
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type LeaseImpl struct {
+	NameSpace string
+	LeaseName string
+	Holder    string
+	Duration  int32
+}
+
+/**
+* @Description: lease实例，用于多副本之间的互斥
+* @param nameSpace命名空间
+* @param leaseName lease名称，同一个名称同一时刻只能被一个holder持有
+* @param holder 持有者标识
+* @param duration 租期（秒），超过租期未续约的lease可以被其他holder抢占
+* return
+*   @resp
+*
+ */
+
+func NewLeaseImpl(nameSpace, leaseName, holder string, duration int32) *LeaseImpl {
+	if len(nameSpace) == 0 {
+		nameSpace = constValue.DefaultNameSpace
+	}
+	return &LeaseImpl{
+		NameSpace: nameSpace,
+		LeaseName: getLeaseName(leaseName),
+		Holder:    holder,
+		Duration:  duration,
+	}
+}
+
+func getLeaseName(name string) string {
+	return fmt.Sprintf("%s%s%s", constValue.Prefix, constValue.LEASE, name)
+}
+
+func (l *LeaseImpl) Get(ctx context.Context, opts metav1.GetOptions) (*coordinationv1.Lease, error) {
+	return configManager.KCS.ClientSet.CoordinationV1().Leases(l.NameSpace).Get(ctx, l.LeaseName, opts)
+}
+
+/**
+* @Description: 获取lease
+* @param annotations 写入lease的附加信息，便于其他副本识别持有者
+* return
+*   @resp lease 当前的lease，获取失败时为其他holder持有的lease
+*   @resp acquired 是否获取成功
+*
+ */
+
+func (l *LeaseImpl) Acquire(ctx context.Context, annotations map[string]string) (*coordinationv1.Lease, bool, error) {
+	now := metav1.NewMicroTime(time.Now())
+	lease, err := l.Get(ctx, metav1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			logrus.Errorf("get lease failed: [name:%s],[err:%v]", l.LeaseName, err)
+			return nil, false, err
+		}
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        l.LeaseName,
+				Annotations: annotations,
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &l.Holder,
+				LeaseDurationSeconds: &l.Duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		lease, err = configManager.KCS.ClientSet.CoordinationV1().Leases(l.NameSpace).Create(ctx, lease, metav1.CreateOptions{})
+		if err != nil {
+			if k8serrors.IsAlreadyExists(err) {
+				// 其他副本抢先创建
+				current, getErr := l.Get(ctx, metav1.GetOptions{})
+				return current, false, getErr
+			}
+			logrus.Errorf("create lease failed: [name:%s],[err:%v]", l.LeaseName, err)
+			return nil, false, err
+		}
+		return lease, true, nil
+	}
+
+	if lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity != l.Holder && !LeaseExpired(lease) {
+		return lease, false, nil
+	}
+
+	newLease := lease.DeepCopy()
+	newLease.Annotations = annotations
+	newLease.Spec.HolderIdentity = &l.Holder
+	newLease.Spec.LeaseDurationSeconds = &l.Duration
+	newLease.Spec.AcquireTime = &now
+	newLease.Spec.RenewTime = &now
+	// 带resourceVersion更新，并发抢占时只有一个副本能成功
+	newLease, err = configManager.KCS.ClientSet.CoordinationV1().Leases(l.NameSpace).Update(ctx, newLease, metav1.UpdateOptions{})
+	if err != nil {
+		if k8serrors.IsConflict(err) {
+			current, getErr := l.Get(ctx, metav1.GetOptions{})
+			return current, false, getErr
+		}
+		logrus.Errorf("update lease failed: [name:%s],[err:%v]", l.LeaseName, err)
+		return nil, false, err
+	}
+	return newLease, true, nil
+}
+
+// Renew 续约，lease已被其他holder持有时返回错误
+func (l *LeaseImpl) Renew(ctx context.Context) error {
+	lease, err := l.Get(ctx, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != l.Holder {
+		return errors.New("lease is held by others: " + l.LeaseName)
+	}
+
+	now := metav1.NewMicroTime(time.Now())
+	lease.Spec.RenewTime = &now
+	_, err = configManager.KCS.ClientSet.CoordinationV1().Leases(l.NameSpace).Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// Release 释放lease，只删除自己持有的lease
+func (l *LeaseImpl) Release(ctx context.Context) error {
+	lease, err := l.Get(ctx, metav1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != l.Holder {
+		return nil
+	}
+
+	preconditions := metav1.Preconditions{ResourceVersion: &lease.ResourceVersion}
+	err = configManager.KCS.ClientSet.CoordinationV1().Leases(l.NameSpace).Delete(ctx, l.LeaseName, metav1.DeleteOptions{Preconditions: &preconditions})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		logrus.Errorf("delete lease failed: [name:%s],[err:%v]", l.LeaseName, err)
+		return err
+	}
+	return nil
+}
+
+// LeaseExpired 超过租期未续约，可以被其他holder抢占
+func LeaseExpired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expire := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return time.Now().After(expire)
+}
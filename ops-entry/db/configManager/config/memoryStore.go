@@ -0,0 +1,267 @@
+/*
+ * Copyright (c) KylinSoft  Co., Ltd. 2024.All rights reserved.
+ * KubeMate licensed under the Mulan Permissive Software License, Version 2.
+ * See LICENSE file for more details.
+ * Author: liukuo <liukuo@kylinos.cn>
+ * Date: Thu Jul 25 16:18:53 2024 +0800
+ */
+package config
+
+import (
+	"context"
+	"fmt"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var memoryResource = schema.GroupResource{Resource: "configs"}
+
+type memoryEntry struct {
+	object    configManager.ConfigObject
+	revisions []configManager.ConfigObject
+}
+
+// MemoryStore 内存中的配置存储，语义与SecretImpl一致，用于单元测试和未连接管理集群的场景
+type MemoryStore struct {
+	mu      sync.RWMutex
+	entries map[string]*memoryEntry
+	version int64 // 每次修改递增，作为对象的ResourceVersion
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*memoryEntry)}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, name string) (*configManager.ConfigObject, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.entries[name]
+	if !ok {
+		return nil, k8serrors.NewNotFound(memoryResource, name)
+	}
+	obj := copyObject(entry.object)
+	return &obj, nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, selector map[string]string) ([]configManager.ConfigObject, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	objects := make([]configManager.ConfigObject, 0, len(s.entries))
+	for _, entry := range s.entries {
+		if labels.SelectorFromSet(selector).Matches(labels.Set(entry.object.Labels)) {
+			objects = append(objects, copyObject(entry.object))
+		}
+	}
+	sortObjects(objects)
+	return objects, nil
+}
+
+func (s *MemoryStore) Create(ctx context.Context, obj *configManager.ConfigObject) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[obj.Name]; ok {
+		return k8serrors.NewAlreadyExists(memoryResource, obj.Name)
+	}
+	object := copyObject(*obj)
+	object.Revision = 0
+	object.CreationTimestamp = time.Now()
+	object.ResourceVersion = s.nextVersion()
+	s.entries[obj.Name] = &memoryEntry{object: object}
+	return nil
+}
+
+func (s *MemoryStore) Update(ctx context.Context, obj *configManager.ConfigObject) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[obj.Name]
+	if !ok {
+		return k8serrors.NewNotFound(memoryResource, obj.Name)
+	}
+	if err := checkResourceVersion(memoryResource, obj, entry.object.ResourceVersion); err != nil {
+		return err
+	}
+	update := copyObject(*obj)
+	entry.object.Data = update.Data
+	if update.Labels != nil {
+		entry.object.Labels = update.Labels
+	}
+	if update.Annotations != nil {
+		entry.object.Annotations = update.Annotations
+	}
+	entry.object.ResourceVersion = s.nextVersion()
+	return nil
+}
+
+func (s *MemoryStore) AddLabels(ctx context.Context, name string, labels map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[name]
+	if !ok {
+		return k8serrors.NewNotFound(memoryResource, name)
+	}
+	if entry.object.Labels == nil {
+		entry.object.Labels = make(map[string]string, len(labels))
+	}
+	for key, value := range labels {
+		entry.object.Labels[key] = value
+	}
+	entry.object.ResourceVersion = s.nextVersion()
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[name]; !ok {
+		return k8serrors.NewNotFound(memoryResource, name)
+	}
+	delete(s.entries, name)
+	return nil
+}
+
+func (s *MemoryStore) CreateRevision(ctx context.Context, name string, cause string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createRevision(name, cause, 0)
+}
+
+// createRevision 保存新的历史版本，并只将active指定的版本标记为active
+func (s *MemoryStore) createRevision(name string, cause string, active int) (int, error) {
+	entry, ok := s.entries[name]
+	if !ok {
+		return 0, k8serrors.NewNotFound(memoryResource, name)
+	}
+	revision := 1
+	if len(entry.revisions) > 0 {
+		revision = entry.revisions[len(entry.revisions)-1].Revision + 1
+	}
+	object := copyObject(entry.object)
+	object.Revision = revision
+	object.Labels = map[string]string{constValue.LabelType: constValue.SecretRevisionType}
+	object.Annotations = map[string]string{
+		constValue.AnnotationRevisionOf:  name,
+		constValue.AnnotationRevision:    strconv.Itoa(revision),
+		constValue.AnnotationChangeCause: cause,
+	}
+	object.CreationTimestamp = time.Now()
+	object.ResourceVersion = s.nextVersion()
+	entry.revisions = append(entry.revisions, object)
+	markActive(entry.revisions, active)
+	return revision, nil
+}
+
+func (s *MemoryStore) RevisionStats(ctx context.Context) (map[string]configManager.RevisionStat, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := make(map[string]configManager.RevisionStat, len(s.entries))
+	for name, entry := range s.entries {
+		var stat configManager.RevisionStat
+		for _, revision := range entry.revisions {
+			stat.Add(revision.CreationTimestamp)
+		}
+		stats[name] = stat
+	}
+	return stats, nil
+}
+
+func (s *MemoryStore) ListRevisions(ctx context.Context, name string) ([]configManager.ConfigObject, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.entries[name]
+	if !ok {
+		return []configManager.ConfigObject{}, nil
+	}
+	revisions := make([]configManager.ConfigObject, 0, len(entry.revisions))
+	for _, revision := range entry.revisions {
+		revisions = append(revisions, copyObject(revision))
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision < revisions[j].Revision
+	})
+	return revisions, nil
+}
+
+func (s *MemoryStore) GetRevision(ctx context.Context, name string, revision int) (*configManager.ConfigObject, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	object, ok := s.revision(name, revision)
+	if !ok {
+		return nil, k8serrors.NewNotFound(memoryResource, fmt.Sprintf("%s-%s%d", name, constValue.VersionMark, revision))
+	}
+	return &object, nil
+}
+
+func (s *MemoryStore) Rollback(ctx context.Context, name string, revision int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.revision(name, revision)
+	if !ok {
+		return 0, k8serrors.NewNotFound(memoryResource, fmt.Sprintf("%s-%s%d", name, constValue.VersionMark, revision))
+	}
+	s.entries[name].object.Data = object.Data
+	s.entries[name].object.ResourceVersion = s.nextVersion()
+	return s.createRevision(name, fmt.Sprintf(constValue.ChangeCauseRollbackFmt, revision), revision)
+}
+
+func (s *MemoryStore) UpdateRevision(ctx context.Context, obj *configManager.ConfigObject) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.entries[obj.Name]; ok {
+		for i := range entry.revisions {
+			if entry.revisions[i].Revision != obj.Revision {
+				continue
+			}
+			if err := checkResourceVersion(memoryResource, obj, entry.revisions[i].ResourceVersion); err != nil {
+				return err
+			}
+			entry.revisions[i].Data = copyMap(obj.Data)
+			entry.revisions[i].ResourceVersion = s.nextVersion()
+			return nil
+		}
+	}
+	return k8serrors.NewNotFound(memoryResource, fmt.Sprintf("%s-%s%d", obj.Name, constValue.VersionMark, obj.Revision))
+}
+
+func (s *MemoryStore) revision(name string, revision int) (configManager.ConfigObject, bool) {
+	entry, ok := s.entries[name]
+	if !ok {
+		return configManager.ConfigObject{}, false
+	}
+	for _, object := range entry.revisions {
+		if object.Revision == revision {
+			return copyObject(object), true
+		}
+	}
+	return configManager.ConfigObject{}, false
+}
+
+func (s *MemoryStore) nextVersion() string {
+	s.version++
+	return strconv.FormatInt(s.version, 10)
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	result := make(map[string]string, len(m))
+	for key, value := range m {
+		result[key] = value
+	}
+	return result
+}
+
+func copyObject(obj configManager.ConfigObject) configManager.ConfigObject {
+	obj.Labels = copyMap(obj.Labels)
+	obj.Annotations = copyMap(obj.Annotations)
+	obj.Data = copyMap(obj.Data)
+	return obj
+}
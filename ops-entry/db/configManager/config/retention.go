@@ -0,0 +1,300 @@
+/*
+ * Copyright (c) KylinSoft  Co., Ltd. 2024.All rights reserved.
+ * KubeMate licensed under the Mulan Permissive Software License, Version 2.
+ * See LICENSE file for more details.
+ * Author: liukuo <liukuo@kylinos.cn>
+ * Date: Thu Jul 25 16:18:53 2024 +0800
+ */
+package config
+
+import (
+	"context"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// RetentionPolicy 多版本对象的保留策略，值<=0时不限制
+type RetentionPolicy struct {
+	MaxRevisions int           // 每个名称最多保留的版本数
+	MaxAge       time.Duration // 版本最长保留时间
+}
+
+// Retention 当前使用的保留策略
+var Retention = LoadRetentionPolicy()
+
+// TypeRetention 不是配置历史版本的多版本configMap按类型使用单独的保留策略，如nkd执行记录；
+// nkd日志的各版本是同一日志的分块，不按版本删除
+var TypeRetention = map[string]RetentionPolicy{
+	constValue.NkdHistoryType: {MaxRevisions: constValue.NkdHistoryMaxRecords},
+	constValue.NkdLogType:     {},
+}
+
+// retentionOf 类型有单独的保留策略时使用该策略，否则使用policy
+func retentionOf(typ string, policy RetentionPolicy) RetentionPolicy {
+	if typePolicy, ok := TypeRetention[typ]; ok {
+		return typePolicy
+	}
+	return policy
+}
+
+/**
+* @Description: 读取保留策略，环境变量KUBEMATE_RETENTION_MAX_REVISIONS、KUBEMATE_RETENTION_MAX_AGE
+* 可覆盖默认值，如KUBEMATE_RETENTION_MAX_AGE=720h，设置为0表示不限制
+*
+ */
+
+func LoadRetentionPolicy() RetentionPolicy {
+	policy := RetentionPolicy{
+		MaxRevisions: constValue.RetentionMaxRevisions,
+		MaxAge:       constValue.RetentionMaxAge,
+	}
+	if value := os.Getenv(constValue.RetentionMaxRevisionsEnv); len(value) > 0 {
+		if num, err := strconv.Atoi(value); err == nil {
+			policy.MaxRevisions = num
+		} else {
+			logrus.Errorf("invalid %s=%s, use the default value", constValue.RetentionMaxRevisionsEnv, value)
+		}
+	}
+	if value := os.Getenv(constValue.RetentionMaxAgeEnv); len(value) > 0 {
+		if age, err := time.ParseDuration(value); err == nil {
+			policy.MaxAge = age
+		} else {
+			logrus.Errorf("invalid %s=%s, use the default value", constValue.RetentionMaxAgeEnv, value)
+		}
+	}
+	return policy
+}
+
+// revisionObject 参与保留策略计算的一个版本
+type revisionObject struct {
+	name     string
+	group    string // 同一组的对象是同一名称的不同版本
+	revision int
+	created  time.Time
+	active   bool
+}
+
+/**
+* @Description: 计算需要删除的版本
+* 每组中版本号最大的版本和标记为active的版本总是保留，回滚后生效的数据来自被标记为active的版本，
+* 其余版本超过MaxRevisions个或者超过MaxAge时删除，没有创建时间的版本不按时间删除
+* return
+*   @resp 需要删除的对象名称
+*
+ */
+
+func (p RetentionPolicy) expired(objects []revisionObject, now time.Time) []string {
+	groups := make(map[string][]revisionObject)
+	for _, obj := range objects {
+		groups[obj.group] = append(groups[obj.group], obj)
+	}
+
+	var names []string
+	for _, group := range groups {
+		sort.Slice(group, func(i, j int) bool {
+			return group[i].revision > group[j].revision
+		})
+		for i, obj := range group {
+			if i == 0 || obj.active {
+				continue
+			}
+			if p.MaxRevisions > 0 && i >= p.MaxRevisions || p.MaxAge > 0 && !obj.created.IsZero() && now.Sub(obj.created) > p.MaxAge {
+				names = append(names, obj.name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// splitRevision 解析<名称>-v-N形式的对象名称
+func splitRevision(name string) (string, int, bool) {
+	idx := strings.LastIndex(name, "-"+constValue.VersionMark)
+	if idx < 0 {
+		return "", 0, false
+	}
+	revision, err := strconv.Atoi(name[idx+len(constValue.VersionMark)+1:])
+	if err != nil || revision <= 0 {
+		return "", 0, false
+	}
+	return name[:idx], revision, true
+}
+
+func newRevisionObject(meta metav1.Object) (revisionObject, bool) {
+	group, revision, ok := splitRevision(meta.GetName())
+	if !ok {
+		return revisionObject{}, false
+	}
+	return revisionObject{
+		name:     meta.GetName(),
+		group:    group,
+		revision: revision,
+		created:  meta.GetCreationTimestamp().Time,
+		active:   meta.GetAnnotations()[constValue.AnnotationActive] == "true",
+	}, true
+}
+
+func configMapRevisions(items []corev1.ConfigMap) []revisionObject {
+	objects := make([]revisionObject, 0, len(items))
+	for i := range items {
+		if obj, ok := newRevisionObject(&items[i]); ok {
+			objects = append(objects, obj)
+		}
+	}
+	return objects
+}
+
+func crRevisions(items []unstructured.Unstructured) []revisionObject {
+	objects := make([]revisionObject, 0, len(items))
+	for i := range items {
+		if obj, ok := newRevisionObject(&items[i]); ok {
+			objects = append(objects, obj)
+		}
+	}
+	return objects
+}
+
+// secretRevisions secret的历史版本按所属的secret分组，当前生效的数据保存在secret中，不参与计算
+func secretRevisions(items []corev1.Secret) []revisionObject {
+	objects := make([]revisionObject, 0, len(items))
+	for i := range items {
+		obj, ok := newRevisionObject(&items[i])
+		if !ok {
+			continue
+		}
+		obj.group = items[i].Annotations[constValue.AnnotationRevisionOf]
+		objects = append(objects, obj)
+	}
+	return objects
+}
+
+// PruneRevisions 按保留策略删除过期的secret历史版本
+func (s *SecretImpl) PruneRevisions(ctx context.Context, name string, policy RetentionPolicy) error {
+	revisions, err := s.listRevisions(ctx, name)
+	if err != nil {
+		return err
+	}
+	return pruneSecrets(ctx, s.clients(), s.NameSpace, policy, revisions)
+}
+
+func pruneConfigMaps(ctx context.Context, kcs *configManager.K8sClientSet, nameSpace string, policy RetentionPolicy, items []corev1.ConfigMap) error {
+	var lastErr error
+	for _, name := range policy.expired(configMapRevisions(items), time.Now()) {
+		err := kcs.ClientSet.CoreV1().ConfigMaps(nameSpace).Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			logrus.Errorf("prune configMap failed: [name:%s],[err:%v]", name, err)
+			lastErr = err
+			continue
+		}
+		logrus.Infof("configMap pruned: [name:%s]", name)
+	}
+	return lastErr
+}
+
+func pruneCrs(ctx context.Context, kcs *configManager.K8sClientSet, gvr schema.GroupVersionResource, nameSpace string, policy RetentionPolicy, items []unstructured.Unstructured) error {
+	var lastErr error
+	for _, name := range policy.expired(crRevisions(items), time.Now()) {
+		err := kcs.DynamicClientSet.Resource(gvr).Namespace(nameSpace).Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			logrus.Errorf("prune cr failed: [name:%s],[err:%v]", name, err)
+			lastErr = err
+			continue
+		}
+		logrus.Infof("cr pruned: [name:%s]", name)
+	}
+	return lastErr
+}
+
+func pruneSecrets(ctx context.Context, kcs *configManager.K8sClientSet, nameSpace string, policy RetentionPolicy, items []corev1.Secret) error {
+	var lastErr error
+	for _, name := range policy.expired(secretRevisions(items), time.Now()) {
+		err := kcs.ClientSet.CoreV1().Secrets(nameSpace).Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			logrus.Errorf("prune secret revision failed: [name:%s],[err:%v]", name, err)
+			lastErr = err
+			continue
+		}
+		logrus.Infof("secret revision pruned: [name:%s]", name)
+	}
+	return lastErr
+}
+
+/**
+* @Description: 定期按保留策略清理命名空间下的多版本configMap、secret历史版本和cr
+* @param resources 需要清理的cr资源
+*
+ */
+
+func StartRevisionGC(ctx context.Context, nameSpace string, interval time.Duration, resources ...schema.GroupVersionResource) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			RunRevisionGC(ctx, nameSpace, Retention, resources...)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RunRevisionGC 执行一次清理
+func RunRevisionGC(ctx context.Context, nameSpace string, policy RetentionPolicy, resources ...schema.GroupVersionResource) {
+	kcs := configManager.KCS
+	configMaps, err := kcs.ClientSet.CoreV1().ConfigMaps(nameSpace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		logrus.Errorf("revision gc list configMaps failed: %v", err)
+	} else {
+		// 按类型分别使用各自的保留策略
+		items := make(map[string][]corev1.ConfigMap)
+		for _, item := range configMaps.Items {
+			if strings.HasPrefix(item.Name, constValue.Prefix+constValue.ConfigMap) {
+				typ := item.Labels[constValue.LabelType]
+				if _, ok := TypeRetention[typ]; !ok {
+					typ = ""
+				}
+				items[typ] = append(items[typ], item)
+			}
+		}
+		for typ := range items {
+			_ = pruneConfigMaps(ctx, kcs, nameSpace, retentionOf(typ, policy), items[typ])
+		}
+	}
+
+	opts := metav1.ListOptions{LabelSelector: constValue.LabelType + "=" + constValue.SecretRevisionType}
+	secrets, err := kcs.ClientSet.CoreV1().Secrets(nameSpace).List(ctx, opts)
+	if err != nil {
+		logrus.Errorf("revision gc list secret revisions failed: %v", err)
+	} else {
+		_ = pruneSecrets(ctx, kcs, nameSpace, policy, secrets.Items)
+	}
+
+	for _, gvr := range resources {
+		crs, err := kcs.DynamicClientSet.Resource(gvr).Namespace(nameSpace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			logrus.Errorf("revision gc list %s failed: %v", gvr.String(), err)
+			continue
+		}
+		var items []unstructured.Unstructured
+		for _, item := range crs.Items {
+			if strings.HasPrefix(item.GetName(), constValue.Prefix+constValue.Cr) {
+				items = append(items, item)
+			}
+		}
+		_ = pruneCrs(ctx, kcs, gvr, nameSpace, policy, items)
+	}
+}
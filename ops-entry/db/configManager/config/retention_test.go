@@ -0,0 +1,186 @@
+/*
+ * Copyright (c) KylinSoft  Co., Ltd. 2024.All rights reserved.
+ * KubeMate licensed under the Mulan Permissive Software License, Version 2.
+ * See LICENSE file for more details.
+ * Author: liukuo <liukuo@kylinos.cn>
+ * Date: Thu Jul 25 16:18:53 2024 +0800
+ */
+package config
+
+import (
+	"context"
+	"fmt"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRetentionPolicyExpired(t *testing.T) {
+	now := time.Now()
+	objects := []revisionObject{
+		{name: "a-v-1", group: "a", revision: 1, created: now.Add(-48 * time.Hour)},
+		{name: "a-v-2", group: "a", revision: 2, created: now.Add(-48 * time.Hour), active: true},
+		{name: "a-v-3", group: "a", revision: 3, created: now.Add(-2 * time.Hour)},
+		{name: "a-v-4", group: "a", revision: 4, created: now.Add(-time.Hour)},
+		{name: "b-v-1", group: "b", revision: 1, created: now.Add(-48 * time.Hour)},
+	}
+
+	// 按数量保留：最新的和active的版本不删除
+	policy := RetentionPolicy{MaxRevisions: 2}
+	assert.Equal(t, []string{"a-v-1"}, policy.expired(objects, now))
+
+	// 按时间保留：只剩一个版本时即使过期也保留
+	policy = RetentionPolicy{MaxAge: 24 * time.Hour}
+	assert.Equal(t, []string{"a-v-1"}, policy.expired(objects, now))
+
+	policy = RetentionPolicy{MaxRevisions: 1, MaxAge: 24 * time.Hour}
+	assert.Equal(t, []string{"a-v-1", "a-v-3"}, policy.expired(objects, now))
+
+	// 不限制
+	assert.Empty(t, RetentionPolicy{}.expired(objects, now))
+}
+
+func TestSplitRevision(t *testing.T) {
+	name, revision, ok := splitRevision("kubemate-configmap-k8s-v-1-v-12")
+	assert.True(t, ok)
+	assert.Equal(t, "kubemate-configmap-k8s-v-1", name)
+	assert.Equal(t, 12, revision)
+
+	_, _, ok = splitRevision("kubemate-configmap-k8s")
+	assert.False(t, ok)
+	_, _, ok = splitRevision("kubemate-configmap-k8s-v-x")
+	assert.False(t, ok)
+}
+
+func TestLoadRetentionPolicy(t *testing.T) {
+	t.Setenv(constValue.RetentionMaxRevisionsEnv, "5")
+	t.Setenv(constValue.RetentionMaxAgeEnv, "720h")
+	assert.Equal(t, RetentionPolicy{MaxRevisions: 5, MaxAge: 720 * time.Hour}, LoadRetentionPolicy())
+
+	t.Setenv(constValue.RetentionMaxRevisionsEnv, "invalid")
+	t.Setenv(constValue.RetentionMaxAgeEnv, "0")
+	assert.Equal(t, RetentionPolicy{MaxRevisions: constValue.RetentionMaxRevisions}, LoadRetentionPolicy())
+}
+
+func TestMapImplPrune(t *testing.T) {
+	origin, originPolicy := configManager.KCS, Retention
+	configManager.KCS = &configManager.K8sClientSet{ClientSet: fake.NewSimpleClientset()}
+	Retention = RetentionPolicy{MaxRevisions: 2}
+	defer func() { configManager.KCS, Retention = origin, originPolicy }()
+
+	ctx := context.TODO()
+	cm := NewMapImpl(nil, "kubemate")
+	labels := map[string]string{"cluster": "k8s-001"}
+	for i := 1; i <= 3; i++ {
+		assert.Nil(t, cm.Create(ctx, &configManager.ConfigObject{Name: "k8s-001", Labels: labels, Data: map[string]string{"data": fmt.Sprint(i)}}))
+	}
+	// 标记为active的版本不会被删除
+	active, err := configManager.KCS.ClientSet.CoreV1().ConfigMaps("kubemate").Get(ctx, "kubemate-configmap-k8s-001-v-2", metav1.GetOptions{})
+	assert.Nil(t, err)
+	active.Annotations = map[string]string{constValue.AnnotationActive: "true"}
+	_, err = configManager.KCS.ClientSet.CoreV1().ConfigMaps("kubemate").Update(ctx, active, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, cm.Create(ctx, &configManager.ConfigObject{Name: "k8s-001", Labels: labels, Data: map[string]string{"data": "4"}}))
+	// nkd执行记录使用单独的保留策略
+	historyLabels := map[string]string{constValue.LabelType: constValue.NkdHistoryType}
+	for i := 1; i <= 3; i++ {
+		assert.Nil(t, cm.Create(ctx, &configManager.ConfigObject{Name: "nkd-history-k8s-001", Labels: historyLabels, Data: map[string]string{"record": fmt.Sprint(i)}}))
+	}
+
+	list, err := configManager.KCS.ClientSet.CoreV1().ConfigMaps("kubemate").List(ctx, metav1.ListOptions{})
+	assert.Nil(t, err)
+	var names []string
+	for _, item := range list.Items {
+		names = append(names, item.Name)
+	}
+	assert.ElementsMatch(t, []string{"kubemate-configmap-k8s-001-v-2", "kubemate-configmap-k8s-001-v-3", "kubemate-configmap-k8s-001-v-4",
+		"kubemate-configmap-nkd-history-k8s-001-v-1", "kubemate-configmap-nkd-history-k8s-001-v-2", "kubemate-configmap-nkd-history-k8s-001-v-3"}, names)
+}
+
+func TestRunRevisionGC(t *testing.T) {
+	origin := configManager.KCS
+	configManager.KCS = &configManager.K8sClientSet{ClientSet: fake.NewSimpleClientset()}
+	defer func() { configManager.KCS = origin }()
+
+	ctx := context.TODO()
+	sr := NewSecretImpl(nil, "kubemate", corev1.SecretTypeOpaque)
+	name := "k8s-001-clusterconfig"
+	assert.Nil(t, sr.Create(ctx, &configManager.ConfigObject{Name: name, Data: map[string]string{"clusterconfig": "v1"}}))
+	for i := 0; i < 3; i++ {
+		_, err := sr.CreateRevision(ctx, name, constValue.ChangeCauseUpdate)
+		assert.Nil(t, err)
+	}
+
+	history := NewMapImpl(nil, "kubemate")
+	historyLabels := map[string]string{constValue.LabelType: constValue.NkdHistoryType}
+	for i := 1; i <= 3; i++ {
+		assert.Nil(t, history.Create(ctx, &configManager.ConfigObject{Name: "nkd-history-k8s-001", Labels: historyLabels, Data: map[string]string{"record": fmt.Sprint(i)}}))
+	}
+
+	RunRevisionGC(ctx, "kubemate", RetentionPolicy{MaxRevisions: 1})
+	revisions, err := sr.ListRevisions(ctx, name)
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 1)
+	assert.Equal(t, 3, revisions[0].Revision)
+
+	// nkd执行记录不按配置的保留策略删除
+	configMaps, err := configManager.KCS.ClientSet.CoreV1().ConfigMaps("kubemate").List(ctx, metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, configMaps.Items, 3)
+
+	// 当前生效的secret不受影响
+	_, err = sr.Get(ctx, name)
+	assert.Nil(t, err)
+}
+
+func TestRollbackKeepsActiveRevision(t *testing.T) {
+	origin, originPolicy := configManager.KCS, Retention
+	configManager.KCS = &configManager.K8sClientSet{ClientSet: fake.NewSimpleClientset()}
+	Retention = RetentionPolicy{MaxRevisions: 2}
+	defer func() { configManager.KCS, Retention = origin, originPolicy }()
+
+	ctx := context.TODO()
+	sr := NewSecretImpl(nil, "kubemate", corev1.SecretTypeOpaque)
+	name := "k8s-001-clusterconfig"
+	assert.Nil(t, sr.Create(ctx, &configManager.ConfigObject{Name: name, Data: map[string]string{"clusterconfig": "v1"}}))
+	_, err := sr.CreateRevision(ctx, name, constValue.ChangeCauseCreate)
+	assert.Nil(t, err)
+	for i := 2; i <= 3; i++ {
+		assert.Nil(t, sr.Update(ctx, &configManager.ConfigObject{Name: name, Data: map[string]string{"clusterconfig": fmt.Sprint("v", i)}}))
+		_, err = sr.CreateRevision(ctx, name, constValue.ChangeCauseUpdate)
+		assert.Nil(t, err)
+	}
+	revisions, err := sr.ListRevisions(ctx, name)
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 2)
+	assert.Equal(t, 2, revisions[0].Revision)
+
+	// 回滚到的版本被标记为active，清理时保留
+	revision, err := sr.Rollback(ctx, name, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, revision)
+	RunRevisionGC(ctx, "kubemate", RetentionPolicy{MaxRevisions: 1})
+	revisions, err = sr.ListRevisions(ctx, name)
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 2)
+	assert.Equal(t, 2, revisions[0].Revision)
+	assert.Equal(t, "true", revisions[0].Annotations[constValue.AnnotationActive])
+	assert.Equal(t, 4, revisions[1].Revision)
+
+	// 再次更新后清除标记
+	assert.Nil(t, sr.Update(ctx, &configManager.ConfigObject{Name: name, Data: map[string]string{"clusterconfig": "v5"}}))
+	_, err = sr.CreateRevision(ctx, name, constValue.ChangeCauseUpdate)
+	assert.Nil(t, err)
+	RunRevisionGC(ctx, "kubemate", RetentionPolicy{MaxRevisions: 1})
+	revisions, err = sr.ListRevisions(ctx, name)
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 1)
+	assert.Equal(t, 5, revisions[0].Revision)
+	assert.Empty(t, revisions[0].Annotations[constValue.AnnotationActive])
+}
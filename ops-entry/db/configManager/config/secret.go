@@ -14,6 +14,9 @@ import (
 	"ops-entry/common/util"
 	"ops-entry/constValue"
 	"ops-entry/db/configManager"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
@@ -23,23 +26,25 @@ import (
 
 type SecretImpl struct {
 	NameSpace  string
-	SecretName string
 	SecretType corev1.SecretType
-	LabelData  map[string]string
+	Clients    *configManager.K8sClientSet // 为空时使用configManager.KCS
+}
+
+func (s *SecretImpl) clients() *configManager.K8sClientSet {
+	return clientsOf(s.Clients)
 }
 
 /**
-* @Description: secret初始化
+* @Description: secret存储初始化，每个名称对应一个kubemate-secret-<名称>的secret，历史版本保存在单独的secret中
+* @param clients 为空时使用configManager.KCS
 * @param namespace命名空间
-* @param secretName secret 名称，多版本时，需要组装最大版本信息，
-* @param labelData 以label来保存多版本的secret信息
 * @param secretType 默认Opaque
 * return
 *   @resp
 *
  */
 
-func NewSecretImpl(nameSpace, secretName string, labelData map[string]string, secretType corev1.SecretType) *SecretImpl {
+func NewSecretImpl(clients *configManager.K8sClientSet, nameSpace string, secretType corev1.SecretType) *SecretImpl {
 	if len(nameSpace) == 0 {
 		nameSpace = constValue.DefaultNameSpace
 	}
@@ -50,9 +55,8 @@ func NewSecretImpl(nameSpace, secretName string, labelData map[string]string, se
 
 	return &SecretImpl{
 		NameSpace:  nameSpace,
-		SecretName: getSecretName(secretName),
-		LabelData:  labelData,
 		SecretType: secretType,
+		Clients:    clients,
 	}
 }
 
@@ -60,223 +64,375 @@ func getSecretName(name string) string {
 	return fmt.Sprintf("%s%s%s", constValue.Prefix, constValue.SECRET, name)
 }
 
-func (s *SecretImpl) GetListOptions(labelData map[string]string) metav1.ListOptions {
-	if len(labelData) < 1 {
-		return metav1.ListOptions{}
-	}
-	labelSelector := metav1.LabelSelector{
-		MatchLabels: labelData,
-	}
-	return metav1.ListOptions{
-		LabelSelector: metav1.FormatLabelSelector(&labelSelector),
-	}
+func (s *SecretImpl) get(ctx context.Context, name string) (*corev1.Secret, error) {
+	return s.clients().ClientSet.CoreV1().Secrets(s.NameSpace).Get(ctx, getSecretName(name), metav1.GetOptions{})
 }
 
-func (s *SecretImpl) Get(ctx context.Context, opts metav1.GetOptions) (*corev1.Secret, error) {
-	logrus.Info("s.SecretName-->", s.SecretName, s.NameSpace)
-	return configManager.KCS.ClientSet.CoreV1().Secrets(s.NameSpace).Get(ctx, s.SecretName, opts)
+func (s *SecretImpl) Get(ctx context.Context, name string) (*configManager.ConfigObject, error) {
+	secret, err := s.get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	obj := secretObject(secret)
+	return &obj, nil
 }
 
-func (s *SecretImpl) List(ctx context.Context, opts metav1.ListOptions) (interface{}, error) {
-	return configManager.KCS.ClientSet.CoreV1().Secrets(s.NameSpace).List(ctx, opts)
+// List 查询labels匹配的secret，不包含历史版本
+func (s *SecretImpl) List(ctx context.Context, labels map[string]string) ([]configManager.ConfigObject, error) {
+	list, err := s.clients().ClientSet.CoreV1().Secrets(s.NameSpace).List(ctx, labelListOptions(labels))
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]configManager.ConfigObject, 0, len(list.Items))
+	for i := range list.Items {
+		if !strings.HasPrefix(list.Items[i].Name, constValue.Prefix+constValue.SECRET) {
+			continue
+		}
+		objects = append(objects, secretObject(&list.Items[i]))
+	}
+	sortObjects(objects)
+	return objects, nil
 }
 
 /**
 * @Description: 创建secret ，以stringData形式进行存储
-* @param opts
-* @param data
 * return
-*   @resp
+*   @resp 已存在时返回k8serrors.IsAlreadyExists可判断的错误
 *
  */
 
-func (s *SecretImpl) Create(ctx context.Context, opts metav1.CreateOptions, data interface{}) error {
-	scData, ok := data.(map[string]string)
-	if !ok {
-		return errors.New("secretImpl Invalid data")
-	}
-	if len(scData) < 1 {
+func (s *SecretImpl) Create(ctx context.Context, obj *configManager.ConfigObject) error {
+	if len(obj.Data) < 1 {
 		return errors.New("secretImpl Invalid empty data")
 	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        getSecretName(obj.Name),
+			Labels:      obj.Labels,
+			Annotations: obj.Annotations,
+		},
+		StringData: obj.Data,
+		Type:       s.SecretType,
+	}
 
-	secret, err := s.Get(ctx, metav1.GetOptions{})
-	if err != nil && !k8serrors.IsNotFound(err) {
-		logrus.Errorf("Get secret error: %v", err)
-		return err
+	_, err := s.clients().ClientSet.CoreV1().Secrets(s.NameSpace).Create(ctx, secret, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		logrus.Errorf("secret create failed: [name:%s],[err:%v]", secret.Name, err)
 	}
+	return err
+}
 
-	if len(secret.Name) == 0 {
-		return s.createSecret(ctx, opts, s.SecretName, scData)
+// AddLabels 添加或者修改secret的labels
+func (s *SecretImpl) AddLabels(ctx context.Context, name string, labels map[string]string) error {
+	secret, err := s.get(ctx, name)
+	if err != nil {
+		return err
+	}
+	secret.Labels = util.MergeMap(secret.Labels, labels)
+	_, err = s.clients().ClientSet.CoreV1().Secrets(s.NameSpace).Update(ctx, secret, metav1.UpdateOptions{})
+	if err != nil {
+		logrus.Errorf("update secret labels failed: [name:%s],[err:%v]", secret.Name, err)
 	}
+	return err
+}
 
-	newSecret := secret.DeepCopy()
-	newSecret.StringData = scData
-	newSecret.Type = s.SecretType
-	newSecret.Labels = s.LabelData
+// Update 替换secret的数据，Labels、Annotations为nil时保持不变
+func (s *SecretImpl) Update(ctx context.Context, obj *configManager.ConfigObject) error {
+	if len(obj.Data) < 1 {
+		return errors.New("secretImpl Update Invalid empty data")
+	}
 
-	// 使用客户端更新Secrets
-	_, err = configManager.KCS.ClientSet.CoreV1().Secrets(secret.Namespace).Update(ctx, newSecret, metav1.UpdateOptions{})
+	secret, err := s.get(ctx, obj.Name)
 	if err != nil {
-		logrus.Errorf("update Secrets failed: [sc:%+v],[err:%v]", newSecret, err)
+		return err
+	}
+	if err := checkResourceVersion(corev1.Resource("secrets"), obj, secret.ResourceVersion); err != nil {
 		return err
 	}
 
-	return nil
-}
+	// 更新Secret的StringData字段
+	secret.Data = nil
+	secret.StringData = obj.Data
+	if obj.Labels != nil {
+		secret.Labels = obj.Labels
+	}
+	if obj.Annotations != nil {
+		secret.Annotations = obj.Annotations
+	}
 
-func (s *SecretImpl) createSecret(ctx context.Context, opts metav1.CreateOptions, name string, data map[string]string) error {
-	secretMap := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:   name,
-			Labels: s.LabelData,
-		},
-		StringData: data,
-		Type:       s.SecretType,
+	// 使用客户端更新Secrets
+	_, err = s.clients().ClientSet.CoreV1().Secrets(s.NameSpace).Update(ctx, secret, metav1.UpdateOptions{})
+	if err != nil {
+		logrus.Errorf("update Secrets failed: [secretName:%s],[err:%v]", secret.Name, err)
 	}
+	return err
+}
 
-	_, err := configManager.KCS.ClientSet.CoreV1().Secrets(s.NameSpace).Create(ctx, secretMap, opts)
+// Delete 删除secret及其全部历史版本
+func (s *SecretImpl) Delete(ctx context.Context, name string) error {
+	err := s.clients().ClientSet.CoreV1().Secrets(s.NameSpace).Delete(ctx, getSecretName(name), metav1.DeleteOptions{})
 	if err != nil {
-		if k8serrors.IsAlreadyExists(err) {
-			logrus.Errorf("secretMap %s already exists\n", secretMap)
-		} else {
-			logrus.Error("secretMap create err", err)
-		}
+		logrus.Errorf("delete Secret by name failed: [name:%s],[err:%+v]", getSecretName(name), err)
 		return err
 	}
+	logrus.Infof("Secret deleted successfully: [name:%s]", getSecretName(name))
+	s.deleteRevisions(ctx, name)
 	return nil
 }
 
+// revisionName 历史版本的名称，使用与secret不同的前缀，避免与名称以-v-N结尾的secret冲突
+func revisionName(name string, revision int) string {
+	return fmt.Sprintf("%s%s%s-%s%d", constValue.Prefix, constValue.SecretRevision, name, constValue.VersionMark, revision)
+}
+
+// SecretRevision 历史版本secret的版本号，不是历史版本时返回0
+func SecretRevision(secret *corev1.Secret) int {
+	revision, err := strconv.Atoi(secret.Annotations[constValue.AnnotationRevision])
+	if err != nil {
+		return 0
+	}
+	return revision
+}
+
 /**
-* @Description: 添加或者修改labels
-* @param cmLabels待新增或者修改的labels
-* @param names存在就修改对应名称的secret的labels，不存在就修改查询结果中全部的secret
+* @Description: 查询secret的历史版本，按名称哈希label查询，只返回属于该secret的版本
 * return
-*   @resp  返回最后一个修改失败的错误的信息
-*    备注： 该修改不是原子性的可能存在部分修改成功，部分修改失败
+*   @resp 按版本号升序排列的历史版本
+*
  */
 
-func (s *SecretImpl) AddLabels(ctx context.Context, opts metav1.UpdateOptions, cmLabels map[string]string, names ...string) error {
-	if len(cmLabels) < 1 {
-		return errors.New("secretImpl Invalid empty cmLabels")
-	}
-
-	//获取当前列表信息
-	listOptions := s.GetListOptions(s.LabelData)
-	cms, err := s.List(ctx, listOptions)
+func (s *SecretImpl) listRevisions(ctx context.Context, name string) ([]corev1.Secret, error) {
+	opts := labelListOptions(map[string]string{
+		constValue.LabelType:     constValue.SecretRevisionType,
+		constValue.LabelNameHash: nameHash(name),
+	})
+	list, err := s.clients().ClientSet.CoreV1().Secrets(s.NameSpace).List(ctx, opts)
 	if err != nil {
-		return err
+		logrus.Errorf("list secret revisions failed: [name:%s],[err:%v]", name, err)
+		return nil, err
 	}
 
-	secretList, ok := cms.(*corev1.SecretList)
-	if !ok {
-		return errors.New("add  labels Secret type trans failed")
+	revisions := make([]corev1.Secret, 0, len(list.Items))
+	for _, item := range list.Items {
+		if item.Annotations[constValue.AnnotationRevisionOf] == getSecretName(name) && SecretRevision(&item) > 0 {
+			revisions = append(revisions, item)
+		}
 	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return SecretRevision(&revisions[i]) < SecretRevision(&revisions[j])
+	})
+	return revisions, nil
+}
 
-	//不存在
-	if len(secretList.Items) < 1 {
-		return errors.New("secretList Not Exists")
+func (s *SecretImpl) ListRevisions(ctx context.Context, name string) ([]configManager.ConfigObject, error) {
+	revisions, err := s.listRevisions(ctx, name)
+	if err != nil {
+		return nil, err
 	}
-
-	for _, sr := range secretList.Items {
-		if len(names) > 0 && util.IsElementInSlice(names, sr.GetName()) || len(names) == 0 {
-			sr.Labels = util.MergeMap(sr.Labels, cmLabels)
-			// 使用客户端更新secret
-			_, err = configManager.KCS.ClientSet.CoreV1().Secrets(s.NameSpace).Update(ctx, &sr, opts)
-			if err != nil {
-				logrus.Errorf("update secret failed: [sr:%+v],[err:%v]", sr, err)
-				continue
-			}
-		}
+	objects := make([]configManager.ConfigObject, 0, len(revisions))
+	for i := range revisions {
+		objects = append(objects, secretObject(&revisions[i]))
 	}
-	return err
+	return objects, nil
 }
 
-func (s *SecretImpl) Update(ctx context.Context, opts metav1.UpdateOptions, data map[string]string) error {
-	if len(data) < 1 {
-		return errors.New("secretImpl Update Invalid empty data")
+func (s *SecretImpl) getRevision(ctx context.Context, name string, revision int) (*corev1.Secret, error) {
+	secret, err := s.clients().ClientSet.CoreV1().Secrets(s.NameSpace).Get(ctx, revisionName(name, revision), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if secret.Annotations[constValue.AnnotationRevisionOf] != getSecretName(name) {
+		return nil, k8serrors.NewNotFound(corev1.Resource("secrets"), revisionName(name, revision))
 	}
+	return secret, nil
+}
 
-	// 直接根据SecretName获取Secret对象
-	secret, err := configManager.KCS.ClientSet.CoreV1().Secrets(s.NameSpace).Get(ctx, s.SecretName, metav1.GetOptions{})
+// GetRevision 查询secret的指定版本
+func (s *SecretImpl) GetRevision(ctx context.Context, name string, revision int) (*configManager.ConfigObject, error) {
+	secret, err := s.getRevision(ctx, name, revision)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	obj := secretObject(secret)
+	return &obj, nil
+}
 
-	// 更新Secret的StringData字段
-	secret.StringData = data
+/**
+* @Description: 将secret当前的数据保存为一个新的历史版本，版本号从1开始递增，并发保存时各自使用不同的版本号
+* @param cause 产生该版本的原因，如create、update、rollback to N
+* return
+*   @resp 新版本的版本号
+*
+ */
 
-	// 使用客户端更新Secrets
-	_, err = configManager.KCS.ClientSet.CoreV1().Secrets(s.NameSpace).Update(ctx, secret, opts)
+func (s *SecretImpl) CreateRevision(ctx context.Context, name string, cause string) (int, error) {
+	return s.saveRevision(ctx, name, cause, 0)
+}
+
+// saveRevision 保存新的历史版本，只将active指定的版本标记为active，active为0时清除标记后再按保留策略清理
+func (s *SecretImpl) saveRevision(ctx context.Context, name string, cause string, active int) (int, error) {
+	secret, err := s.get(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	revisions, err := s.listRevisions(ctx, name)
 	if err != nil {
-		logrus.Errorf("update Secrets failed: [secretName:%s],[err:%v]", s.SecretName, err)
+		return 0, err
+	}
+	revision := 1
+	if len(revisions) > 0 {
+		revision = SecretRevision(&revisions[len(revisions)-1]) + 1
+	}
+
+	// apiserver会将stringData合并到data，这里同样合并，避免依赖apiserver的处理
+	data := make(map[string][]byte, len(secret.Data)+len(secret.StringData))
+	for key, value := range secret.Data {
+		data[key] = value
+	}
+	for key, value := range secret.StringData {
+		data[key] = []byte(value)
+	}
+
+	revision, err = createRevision(revision, func(revision int) error {
+		revisionSecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: revisionName(name, revision),
+				Labels: map[string]string{
+					constValue.LabelType:     constValue.SecretRevisionType,
+					constValue.LabelNameHash: nameHash(name),
+				},
+				Annotations: map[string]string{
+					constValue.AnnotationRevisionOf:  secret.Name,
+					constValue.AnnotationRevision:    strconv.Itoa(revision),
+					constValue.AnnotationChangeCause: cause,
+				},
+			},
+			Data: data,
+			Type: secret.Type,
+		}
+		_, err := s.clients().ClientSet.CoreV1().Secrets(s.NameSpace).Create(ctx, revisionSecret, metav1.CreateOptions{})
+		if err != nil && !k8serrors.IsAlreadyExists(err) {
+			logrus.Errorf("create secret revision failed: [name:%s],[err:%v]", revisionSecret.Name, err)
+		}
 		return err
+	})
+	if err != nil {
+		return 0, err
 	}
+	if err := s.markActive(ctx, name, active); err != nil {
+		logrus.Errorf("mark active revision of %s failed: %v", secret.Name, err)
+	}
+	if err := s.PruneRevisions(ctx, name, Retention); err != nil {
+		logrus.Errorf("prune secret revisions of %s failed: %v", secret.Name, err)
+	}
+	return revision, nil
+}
 
-	// // 获取现有的secret对象
-	// listOptions := s.GetListOptions(s.LabelData)
-	// scs, err := s.List(ctx, listOptions)
-	// if err != nil {
-	// 	return err
-	// }
-
-	// secretList, ok := scs.(*corev1.SecretList)
-	// if !ok {
-	// 	return errors.New("update  secret type trans failed")
-	// }
-
-	// if len(secretList.Items) < 1 {
-	// 	logrus.Errorf("get empty data [label:%+v]", s.LabelData)
-	// 	return errors.New("update empty secret failed")
-	// }
-
-	// for _, sc := range secretList.Items {
-	// 	sc.StringData = scData
-	// 	// 使用客户端更新Secrets
-	// 	_, err = configManager.KCS.ClientSet.CoreV1().Secrets(sc.Namespace).Update(ctx, &sc, opts)
-	// 	if err != nil {
-	// 		logrus.Errorf("update Secrets failed: [sc:%+v],[err:%v]", sc, err)
-	// 		continue
-	// 	}
-	// }
+/**
+* @Description: 将secret的数据回滚为指定版本，回滚后的数据同样记录为一个新的历史版本，
+* 回滚到的版本被标记为active，不会被保留策略删除，直到下一次创建或更新
+* return
+*   @resp 回滚后新版本的版本号
+*
+ */
 
-	return err
+func (s *SecretImpl) Rollback(ctx context.Context, name string, revision int) (int, error) {
+	revisionSecret, err := s.getRevision(ctx, name, revision)
+	if err != nil {
+		return 0, err
+	}
+	secret, err := s.get(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+
+	secret.Data = revisionSecret.Data
+	secret.StringData = nil
+	_, err = s.clients().ClientSet.CoreV1().Secrets(s.NameSpace).Update(ctx, secret, metav1.UpdateOptions{})
+	if err != nil {
+		logrus.Errorf("rollback secret failed: [name:%s],[revision:%d],[err:%v]", secret.Name, revision, err)
+		return 0, err
+	}
+	return s.saveRevision(ctx, name, fmt.Sprintf(constValue.ChangeCauseRollbackFmt, revision), revision)
 }
 
-func (s *SecretImpl) Delete(ctx context.Context, opts metav1.DeleteOptions) error {
-	err := configManager.KCS.ClientSet.CoreV1().Secrets(s.NameSpace).Delete(ctx, s.SecretName, opts)
+// markActive 只将指定的历史版本标记为active，active为0时清除全部标记
+func (s *SecretImpl) markActive(ctx context.Context, name string, active int) error {
+	revisions, err := s.listRevisions(ctx, name)
 	if err != nil {
-		logrus.Errorf("delete Secret by name failed: [name:%s],[err:%+v]", s.SecretName, err)
 		return err
 	}
-	logrus.Infof("Secret deleted successfully: [name:%s]", s.SecretName)
-
-	if len(s.LabelData) > 0 {
-		options := s.GetListOptions(s.LabelData)
-		list, err := s.List(ctx, options)
-		if err != nil {
-			logrus.Errorf("empty SecretImpl data:[err:%+v]", err)
-			return err
+	var lastErr error
+	for i := range revisions {
+		revision := &revisions[i]
+		_, marked := revision.Annotations[constValue.AnnotationActive]
+		if SecretRevision(revision) == active {
+			if marked {
+				continue
+			}
+			revision.Annotations[constValue.AnnotationActive] = "true"
+		} else if marked {
+			delete(revision.Annotations, constValue.AnnotationActive)
+		} else {
+			continue
 		}
-		secretList, ok := list.(*corev1.SecretList)
-		if !ok {
-			logrus.Error("type Secret conversion failed")
-			return errors.New("type Secret conversion failed")
+		if _, err := s.clients().ClientSet.CoreV1().Secrets(s.NameSpace).Update(ctx, revision, metav1.UpdateOptions{}); err != nil {
+			logrus.Errorf("update active annotation failed: [name:%s],[err:%v]", revision.Name, err)
+			lastErr = err
 		}
+	}
+	return lastErr
+}
 
-		if len(secretList.Items) < 1 {
-			logrus.Error("empty Secret data")
-			return errors.New("empty Secret data")
-		}
+// UpdateRevision 替换历史版本的数据，只用于重新加密
+func (s *SecretImpl) UpdateRevision(ctx context.Context, obj *configManager.ConfigObject) error {
+	secret, err := s.getRevision(ctx, obj.Name, obj.Revision)
+	if err != nil {
+		return err
+	}
+	if err := checkResourceVersion(corev1.Resource("secrets"), obj, secret.ResourceVersion); err != nil {
+		return err
+	}
+	secret.Data = make(map[string][]byte, len(obj.Data))
+	for key, value := range obj.Data {
+		secret.Data[key] = []byte(value)
+	}
+	secret.StringData = nil
+	_, err = s.clients().ClientSet.CoreV1().Secrets(s.NameSpace).Update(ctx, secret, metav1.UpdateOptions{})
+	return err
+}
 
-		// 遍历 secret 列表并执行删除操作
-		for _, sc := range secretList.Items {
-			err := configManager.KCS.ClientSet.CoreV1().Secrets(sc.Namespace).Delete(context.TODO(), sc.Name, opts)
-			if err != nil {
-				logrus.Errorf("delete Secrets failed: [cm:%+v],[err:%v]", sc, err)
-				continue
-			}
+// RevisionStats 一次查询全部历史版本secret，按所属的secret分组统计
+func (s *SecretImpl) RevisionStats(ctx context.Context) (map[string]configManager.RevisionStat, error) {
+	opts := labelListOptions(map[string]string{constValue.LabelType: constValue.SecretRevisionType})
+	list, err := s.clients().ClientSet.CoreV1().Secrets(s.NameSpace).List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]configManager.RevisionStat)
+	for i := range list.Items {
+		owner := list.Items[i].Annotations[constValue.AnnotationRevisionOf]
+		if len(owner) == 0 || SecretRevision(&list.Items[i]) <= 0 {
+			continue
 		}
+		name := strings.TrimPrefix(owner, constValue.Prefix+constValue.SECRET)
+		stat := stats[name]
+		stat.Add(list.Items[i].CreationTimestamp.Time)
+		stats[name] = stat
 	}
+	return stats, nil
+}
 
-	return nil
+// deleteRevisions 删除secret的全部历史版本
+func (s *SecretImpl) deleteRevisions(ctx context.Context, name string) {
+	revisions, err := s.listRevisions(ctx, name)
+	if err != nil {
+		return
+	}
+	for _, revision := range revisions {
+		err := s.clients().ClientSet.CoreV1().Secrets(s.NameSpace).Delete(ctx, revision.Name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			logrus.Errorf("delete secret revision failed: [name:%s],[err:%v]", revision.Name, err)
+		}
+	}
 }
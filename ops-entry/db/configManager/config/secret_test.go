@@ -9,35 +9,38 @@ package config
 
 import (
 	"context"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"sync"
 	"testing"
 
 	"github.com/agiledragon/gomonkey/v2"
+	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 // 缺少gomokey mock和assert断言
 func TestSecretImpl(t *testing.T) {
 	ctx := context.TODO()
 
-	sr := NewSecretImpl("test_np_secret", "test_secret", nil, corev1.SecretTypeOpaque)
+	sr := NewSecretImpl(nil, "test_np_secret", corev1.SecretTypeOpaque)
 	data := map[string]string{"user": "张三", "pwd": "123456"}
 
 	//mock初始化 go test -gcflags=all=-l
-	patches := gomonkey.ApplyFunc(sr.Get, func(ctx context.Context, opts metav1.ListOptions) (interface{}, error) {
+	patches := gomonkey.ApplyFunc(sr.Get, func(ctx context.Context, name string) (*configManager.ConfigObject, error) {
 		// 这里返回固定的数据或模拟的错误
-		return &corev1.SecretList{
-			Items: []corev1.Secret{
-				{},
-				{},
-			},
-		}, nil
+		return &configManager.ConfigObject{Name: name}, nil
 	})
 	defer patches.Reset() // 在测试结束后恢复原始函数
 
 	t.Run("create", func(t *testing.T) {
-		sr.LabelData = map[string]string{"test_v1": "6666"}
-		err := sr.Create(ctx, metav1.CreateOptions{}, data)
+		labels := map[string]string{"test_v1": "6666"}
+		err := sr.Create(ctx, &configManager.ConfigObject{Name: "test_secret", Labels: labels, Data: data})
 		if err != nil {
 			t.Error(err)
 			return
@@ -46,9 +49,7 @@ func TestSecretImpl(t *testing.T) {
 	})
 
 	t.Run("update", func(t *testing.T) {
-		sr.LabelData = map[string]string{"test_v1": "6666"}
-		data = map[string]string{"age": "44"}
-		err := sr.Update(ctx, metav1.UpdateOptions{}, map[string]string{"name": "张三"})
+		err := sr.Update(ctx, &configManager.ConfigObject{Name: "test_secret", Data: map[string]string{"name": "张三"}})
 		if err != nil {
 			t.Error(err)
 			return
@@ -57,9 +58,7 @@ func TestSecretImpl(t *testing.T) {
 	})
 
 	t.Run("delete", func(t *testing.T) {
-		sr.LabelData = map[string]string{"test_v1": "6666"}
-		data = map[string]string{"age": "44"}
-		err := sr.Delete(ctx, metav1.DeleteOptions{})
+		err := sr.Delete(ctx, "test_secret")
 		if err != nil {
 			t.Error(err)
 			return
@@ -67,3 +66,78 @@ func TestSecretImpl(t *testing.T) {
 		t.Log("success")
 	})
 }
+
+func TestSecretRevision(t *testing.T) {
+	ctx := context.TODO()
+	sr := NewSecretImpl(&configManager.K8sClientSet{ClientSet: fake.NewSimpleClientset()}, "kubemate", corev1.SecretTypeOpaque)
+	name := "k8s-001-clusterconfig"
+
+	assert.Nil(t, sr.Create(ctx, &configManager.ConfigObject{Name: name, Data: map[string]string{"clusterconfig": "v1"}}))
+	revision, err := sr.CreateRevision(ctx, name, constValue.ChangeCauseCreate)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, revision)
+
+	assert.Nil(t, sr.Update(ctx, &configManager.ConfigObject{Name: name, Data: map[string]string{"clusterconfig": "v2"}}))
+	revision, err = sr.CreateRevision(ctx, name, constValue.ChangeCauseUpdate)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, revision)
+
+	// 其他secret的历史版本不应被查询到
+	other := name + "-v-1"
+	assert.Nil(t, sr.Create(ctx, &configManager.ConfigObject{Name: other, Data: map[string]string{"clusterconfig": "other"}}))
+	_, err = sr.CreateRevision(ctx, other, constValue.ChangeCauseCreate)
+	assert.Nil(t, err)
+
+	revisions, err := sr.ListRevisions(ctx, name)
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 2)
+	assert.Equal(t, "v1", revisions[0].Data["clusterconfig"])
+	assert.Equal(t, constValue.ChangeCauseUpdate, revisions[1].Annotations[constValue.AnnotationChangeCause])
+
+	revision, err = sr.Rollback(ctx, name, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, revision)
+	secret, err := sr.Clients.ClientSet.CoreV1().Secrets("kubemate").Get(ctx, getSecretName(name), metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", string(secret.Data["clusterconfig"]))
+	latest, err := sr.GetRevision(ctx, name, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "rollback to 1", latest.Annotations[constValue.AnnotationChangeCause])
+
+	_, err = sr.Rollback(ctx, name, 10)
+	assert.True(t, k8serrors.IsNotFound(err))
+
+	assert.Nil(t, sr.Delete(ctx, name))
+	revisions, err = sr.ListRevisions(ctx, name)
+	assert.Nil(t, err)
+	assert.Empty(t, revisions)
+	revisions, err = sr.ListRevisions(ctx, other)
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 1)
+}
+
+func TestSecretRevisionConflict(t *testing.T) {
+	ctx := context.TODO()
+	clientSet := fake.NewSimpleClientset()
+	sr := NewSecretImpl(&configManager.K8sClientSet{ClientSet: clientSet}, "kubemate", corev1.SecretTypeOpaque)
+	name := "k8s-001-clusterconfig"
+	assert.Nil(t, sr.Create(ctx, &configManager.ConfigObject{Name: name, Data: map[string]string{"clusterconfig": "v1"}}))
+
+	// 缓存中的历史版本列表过期，总是从1开始尝试
+	clientSet.PrependReactor("list", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &corev1.SecretList{}, nil
+	})
+	var wg sync.WaitGroup
+	revisions := make([]int, 5)
+	for i := range revisions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			revision, err := sr.CreateRevision(ctx, name, constValue.ChangeCauseUpdate)
+			assert.Nil(t, err)
+			revisions[i] = revision
+		}(i)
+	}
+	wg.Wait()
+	assert.ElementsMatch(t, []int{1, 2, 3, 4, 5}, revisions)
+}
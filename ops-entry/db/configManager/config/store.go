@@ -0,0 +1,234 @@
+/*
+ * Copyright (c) KylinSoft  Co., Ltd. 2024.All rights reserved.
+ * KubeMate licensed under the Mulan Permissive Software License, Version 2.
+ * See LICENSE file for more details.
+ * Author: liukuo <liukuo@kylinos.cn>
+ * Date: Thu Jul 25 16:18:53 2024 +0800
+ */
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// clientsOf 未指定clientSet时使用configManager.KCS
+func clientsOf(clients *configManager.K8sClientSet) *configManager.K8sClientSet {
+	if clients != nil {
+		return clients
+	}
+	return configManager.KCS
+}
+
+func labelListOptions(labels map[string]string) metav1.ListOptions {
+	if len(labels) < 1 {
+		return metav1.ListOptions{}
+	}
+	return metav1.ListOptions{LabelSelector: metav1.FormatLabelSelector(&metav1.LabelSelector{MatchLabels: labels})}
+}
+
+/**
+* @Description: 检查Update的前置条件，obj指定的ResourceVersion与当前版本不一致时返回Conflict错误
+* 更新时同样携带该版本，由apiserver保证检查与更新之间没有其他修改
+*
+ */
+
+func checkResourceVersion(resource schema.GroupResource, obj *configManager.ConfigObject, current string) error {
+	if obj.ResourceVersion == "" || obj.ResourceVersion == current {
+		return nil
+	}
+	return k8serrors.NewConflict(resource, obj.Name,
+		fmt.Errorf("the object has been modified, resourceVersion %s is not the latest %s", obj.ResourceVersion, current))
+}
+
+/**
+* @Description: 从revision开始创建新版本，版本号已被占用时依次尝试下一个版本号
+* 起始版本号为查询到的最大版本号+1，并发创建或从缓存查询到过期的结果时会与已有的版本冲突
+* @param create 创建指定版本号的对象，版本已存在时返回k8serrors.IsAlreadyExists可判断的错误
+* return
+*   @resp 创建成功的版本号
+*
+ */
+
+func createRevision(revision int, create func(revision int) error) (int, error) {
+	for retries := 0; ; retries++ {
+		err := create(revision)
+		if err == nil {
+			return revision, nil
+		}
+		if !k8serrors.IsAlreadyExists(err) || retries >= constValue.RevisionCreateRetries {
+			return 0, err
+		}
+		revision++
+	}
+}
+
+// nameHash 名称的哈希，作为LabelNameHash的值，名称可能超过label值的长度限制
+func nameHash(name string) string {
+	hash := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(hash[:])[:constValue.LabelsHashLength]
+}
+
+// withNameLabel 在labels中增加名称哈希，不修改传入的labels
+func withNameLabel(labels map[string]string, name string) map[string]string {
+	result := make(map[string]string, len(labels)+1)
+	for key, value := range labels {
+		result[key] = value
+	}
+	result[constValue.LabelNameHash] = nameHash(name)
+	return result
+}
+
+// secretObject 转换secret，历史版本的名称为所属secret的名称
+func secretObject(secret *corev1.Secret) configManager.ConfigObject {
+	name := secret.Name
+	if owner, ok := secret.Annotations[constValue.AnnotationRevisionOf]; ok {
+		name = owner
+	}
+	data := make(map[string]string, len(secret.Data)+len(secret.StringData))
+	for key, value := range secret.Data {
+		data[key] = string(value)
+	}
+	for key, value := range secret.StringData {
+		data[key] = value
+	}
+	return configManager.ConfigObject{
+		Name:              strings.TrimPrefix(name, constValue.Prefix+constValue.SECRET),
+		Revision:          SecretRevision(secret),
+		Labels:            secret.Labels,
+		Annotations:       secret.Annotations,
+		Data:              data,
+		CreationTimestamp: secret.CreationTimestamp.Time,
+		ResourceVersion:   secret.ResourceVersion,
+	}
+}
+
+// configMapObject 转换多版本configMap，名称不含前缀和版本号
+func configMapObject(configMap *corev1.ConfigMap) configManager.ConfigObject {
+	group, revision, _ := splitRevision(configMap.Name)
+	return configManager.ConfigObject{
+		Name:              strings.TrimPrefix(group, constValue.Prefix+constValue.ConfigMap),
+		Revision:          revision,
+		Labels:            configMap.Labels,
+		Annotations:       configMap.Annotations,
+		Data:              configMap.Data,
+		CreationTimestamp: configMap.CreationTimestamp.Time,
+		ResourceVersion:   configMap.ResourceVersion,
+	}
+}
+
+/**
+* @Description: 为旧版本创建的多版本configMap、cr和secret历史版本增加LabelNameHash，
+* 按名称查询版本时只使用label selector，没有该label的版本不会被查询到，需要在启动时执行一次
+* @param resources 需要处理的cr资源，资源不存在时跳过
+* return
+*   @resp 增加label的对象数
+*
+ */
+
+func AddNameLabels(ctx context.Context, nameSpace string, resources ...schema.GroupVersionResource) (int, error) {
+	kcs := configManager.KCS
+	count := 0
+	opts := labelListOptions(map[string]string{constValue.LabelType: constValue.SecretRevisionType})
+	secrets, err := kcs.ClientSet.CoreV1().Secrets(nameSpace).List(ctx, opts)
+	if err != nil {
+		return count, err
+	}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		owner := strings.TrimPrefix(secret.Annotations[constValue.AnnotationRevisionOf], constValue.Prefix+constValue.SECRET)
+		if len(owner) == 0 || secret.Labels[constValue.LabelNameHash] != "" {
+			continue
+		}
+		secret.Labels = withNameLabel(secret.Labels, owner)
+		if _, err := kcs.ClientSet.CoreV1().Secrets(nameSpace).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	configMaps, err := kcs.ClientSet.CoreV1().ConfigMaps(nameSpace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return count, err
+	}
+	for i := range configMaps.Items {
+		configMap := &configMaps.Items[i]
+		group, _, ok := splitRevision(configMap.Name)
+		if !ok || !strings.HasPrefix(group, constValue.Prefix+constValue.ConfigMap) || configMap.Labels[constValue.LabelNameHash] != "" {
+			continue
+		}
+		configMap.Labels = withNameLabel(configMap.Labels, strings.TrimPrefix(group, constValue.Prefix+constValue.ConfigMap))
+		if _, err := kcs.ClientSet.CoreV1().ConfigMaps(nameSpace).Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	for _, gvr := range resources {
+		crs, err := kcs.DynamicClientSet.Resource(gvr).Namespace(nameSpace).List(ctx, metav1.ListOptions{})
+		if k8serrors.IsNotFound(err) {
+			logrus.Infof("%s is not installed, skip adding name labels", gvr.String())
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		for i := range crs.Items {
+			cr := &crs.Items[i]
+			group, _, ok := splitRevision(cr.GetName())
+			if !ok || !strings.HasPrefix(group, constValue.Prefix+constValue.Cr) || cr.GetLabels()[constValue.LabelNameHash] != "" {
+				continue
+			}
+			cr.SetLabels(withNameLabel(cr.GetLabels(), strings.TrimPrefix(group, constValue.Prefix+constValue.Cr)))
+			if _, err := kcs.DynamicClientSet.Resource(gvr).Namespace(nameSpace).Update(ctx, cr, metav1.UpdateOptions{}); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// markActive 只将指定版本标记为active，active为0时清除全部标记
+func markActive(revisions []configManager.ConfigObject, active int) {
+	for i := range revisions {
+		if revisions[i].Revision == active {
+			revisions[i].Annotations = copyMap(revisions[i].Annotations)
+			revisions[i].Annotations[constValue.AnnotationActive] = "true"
+		} else if _, ok := revisions[i].Annotations[constValue.AnnotationActive]; ok {
+			revisions[i].Annotations = copyMap(revisions[i].Annotations)
+			delete(revisions[i].Annotations, constValue.AnnotationActive)
+		}
+	}
+}
+
+// sortObjects 按名称、版本号升序排列
+func sortObjects(objects []configManager.ConfigObject) {
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].Name != objects[j].Name {
+			return objects[i].Name < objects[j].Name
+		}
+		return objects[i].Revision < objects[j].Revision
+	})
+}
+
+var (
+	_ configManager.RevisionStore = &SecretImpl{}
+	_ configManager.ConfigStore   = &MapImpl{}
+	_ configManager.ConfigStore   = &CrImpl{}
+	_ configManager.RevisionStore = &MemoryStore{}
+	_ configManager.RevisionStore = &EncryptedStore{}
+	_ configManager.RevisionStore = &FileStore{}
+)
@@ -0,0 +1,318 @@
+/*
+ * Copyright (c) KylinSoft  Co., Ltd. 2024.All rights reserved.
+ * KubeMate licensed under the Mulan Permissive Software License, Version 2.
+ * See LICENSE file for more details.
+ * Author: liukuo <liukuo@kylinos.cn>
+ * Date: Thu Jul 25 16:18:53 2024 +0800
+ */
+package config
+
+import (
+	"context"
+	"encoding/base64"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"ops-entry/db/configManager/encryption"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testRevisionStore SecretImpl、MemoryStore和FileStore需要满足相同的语义
+func testRevisionStore(t *testing.T, store configManager.RevisionStore) {
+	ctx := context.TODO()
+	_, err := store.Get(ctx, "k8s-001-clusterconfig")
+	assert.True(t, k8serrors.IsNotFound(err))
+
+	obj := &configManager.ConfigObject{
+		Name:   "k8s-001-clusterconfig",
+		Labels: map[string]string{"cluster": "k8s-001"},
+		Data:   map[string]string{"clusterconfig": "v1"},
+	}
+	assert.Nil(t, store.Create(ctx, obj))
+	assert.True(t, k8serrors.IsAlreadyExists(store.Create(ctx, obj)))
+	revision, err := store.CreateRevision(ctx, obj.Name, constValue.ChangeCauseCreate)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, revision)
+
+	assert.Nil(t, store.Update(ctx, &configManager.ConfigObject{Name: obj.Name, Data: map[string]string{"clusterconfig": "v2"}}))
+	revision, err = store.CreateRevision(ctx, obj.Name, constValue.ChangeCauseUpdate)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, revision)
+	assert.Nil(t, store.AddLabels(ctx, obj.Name, map[string]string{"env": "test"}))
+
+	got, err := store.Get(ctx, obj.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, obj.Name, got.Name)
+	assert.Equal(t, map[string]string{"clusterconfig": "v2"}, got.Data)
+	assert.Equal(t, map[string]string{"cluster": "k8s-001", "env": "test"}, got.Labels)
+
+	list, err := store.List(ctx, map[string]string{"env": "test"})
+	assert.Nil(t, err)
+	assert.Len(t, list, 1)
+	list, err = store.List(ctx, map[string]string{"env": "prod"})
+	assert.Nil(t, err)
+	assert.Empty(t, list)
+
+	revisions, err := store.ListRevisions(ctx, obj.Name)
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 2)
+	assert.Equal(t, obj.Name, revisions[0].Name)
+	assert.Equal(t, "v1", revisions[0].Data["clusterconfig"])
+
+	revision, err = store.Rollback(ctx, obj.Name, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, revision)
+	got, err = store.GetRevision(ctx, obj.Name, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", got.Data["clusterconfig"])
+	assert.Equal(t, "rollback to 1", got.Annotations[constValue.AnnotationChangeCause])
+	_, err = store.GetRevision(ctx, obj.Name, 4)
+	assert.True(t, k8serrors.IsNotFound(err))
+	stats, err := store.RevisionStats(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, stats[obj.Name].Count)
+
+	assert.Nil(t, store.Delete(ctx, obj.Name))
+	_, err = store.Get(ctx, obj.Name)
+	assert.True(t, k8serrors.IsNotFound(err))
+	assert.True(t, k8serrors.IsNotFound(store.Update(ctx, obj)))
+}
+
+func TestSecretImplRevisionStore(t *testing.T) {
+	clients := &configManager.K8sClientSet{ClientSet: fake.NewSimpleClientset()}
+	testRevisionStore(t, NewSecretImpl(clients, "kubemate", ""))
+}
+
+func TestMemoryStore(t *testing.T) {
+	testRevisionStore(t, NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "clusterconfig")
+	store, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	testRevisionStore(t, store)
+
+	ctx := context.TODO()
+	obj := &configManager.ConfigObject{Name: "k8s-001-kubeconfig", Data: map[string]string{"kubeconfig": "v1"}}
+	assert.Nil(t, store.Create(ctx, obj))
+	info, err := os.Stat(filepath.Join(dir, "k8s-001-kubeconfig.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(constValue.PrivateFileMode), info.Mode().Perm())
+
+	// 重新打开后数据仍然存在，过期的版本不能用于更新
+	reopened, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	got, err := reopened.Get(ctx, obj.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", got.Data["kubeconfig"])
+	assert.Nil(t, reopened.Update(ctx, &configManager.ConfigObject{Name: obj.Name, Data: map[string]string{"kubeconfig": "v2"}, ResourceVersion: got.ResourceVersion}))
+	err = store.Update(ctx, &configManager.ConfigObject{Name: obj.Name, Data: map[string]string{"kubeconfig": "v3"}, ResourceVersion: got.ResourceVersion})
+	assert.True(t, k8serrors.IsConflict(err))
+
+	// 没有遗留的临时文件
+	files, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	for _, file := range files {
+		assert.False(t, strings.HasSuffix(file.Name(), ".tmp"), file.Name())
+	}
+	assert.NotNil(t, store.Create(ctx, &configManager.ConfigObject{Name: "../k8s-001"}))
+}
+
+func testEnvelope(t *testing.T, ids ...string) *encryption.Envelope {
+	var keyFile []string
+	for _, id := range ids {
+		keyFile = append(keyFile, id+":"+base64.StdEncoding.EncodeToString([]byte(strings.Repeat(id, 32)[:32])))
+	}
+	provider, err := encryption.ParseKeyFile([]byte(strings.Join(keyFile, "\n")))
+	assert.Nil(t, err)
+	return encryption.NewEnvelope(provider)
+}
+
+func TestEncryptedStore(t *testing.T) {
+	testRevisionStore(t, NewEncryptedStore(NewMemoryStore(), testEnvelope(t, "k1"), "clusterconfig"))
+
+	ctx := context.TODO()
+	clients := &configManager.K8sClientSet{ClientSet: fake.NewSimpleClientset()}
+	inner := NewSecretImpl(clients, "kubemate", "")
+	// 启用加密前保存的数据
+	assert.Nil(t, inner.Create(ctx, &configManager.ConfigObject{Name: "k8s-001-clusterconfig", Data: map[string]string{"clusterconfig": "v1"}}))
+	_, err := inner.CreateRevision(ctx, "k8s-001-clusterconfig", constValue.ChangeCauseCreate)
+	assert.Nil(t, err)
+
+	store := NewEncryptedStore(inner, testEnvelope(t, "k1"), "clusterconfig")
+	got, err := store.Get(ctx, "k8s-001-clusterconfig")
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", got.Data["clusterconfig"])
+
+	count, err := store.ReEncrypt(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	raw, err := inner.GetRevision(ctx, "k8s-001-clusterconfig", 1)
+	assert.Nil(t, err)
+	assert.True(t, encryption.IsEncrypted(raw.Data["clusterconfig"]))
+
+	// 轮换密钥：新密钥在前，旧密钥只用于解密
+	rotated := NewEncryptedStore(inner, testEnvelope(t, "k2", "k1"), "clusterconfig")
+	got, err = rotated.GetRevision(ctx, "k8s-001-clusterconfig", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", got.Data["clusterconfig"])
+	count, err = rotated.ReEncrypt(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	count, err = rotated.ReEncrypt(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+
+	// 删除旧密钥后仍可读取
+	store = NewEncryptedStore(inner, testEnvelope(t, "k2"), "clusterconfig")
+	revisions, err := store.ListRevisions(ctx, "k8s-001-clusterconfig")
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", revisions[0].Data["clusterconfig"])
+	_, err = NewEncryptedStore(inner, testEnvelope(t, "k1"), "clusterconfig").Get(ctx, "k8s-001-clusterconfig")
+	assert.NotNil(t, err)
+}
+
+func TestEncryptedStoreAdditionalData(t *testing.T) {
+	ctx := context.TODO()
+	inner := NewMemoryStore()
+	envelope := testEnvelope(t, "k1")
+	store := NewEncryptedStore(inner, envelope, "clusterconfig")
+	assert.Nil(t, store.Create(ctx, &configManager.ConfigObject{Name: "k8s-001-clusterconfig", Data: map[string]string{"clusterconfig": "v1"}}))
+
+	// 密文复制到其他配置后无法解密
+	raw, err := inner.Get(ctx, "k8s-001-clusterconfig")
+	assert.Nil(t, err)
+	assert.Nil(t, inner.Create(ctx, &configManager.ConfigObject{Name: "k8s-002-clusterconfig", Data: raw.Data}))
+	_, err = store.Get(ctx, "k8s-002-clusterconfig")
+	assert.NotNil(t, err)
+	assert.Nil(t, inner.Delete(ctx, "k8s-002-clusterconfig"))
+
+	// 旧格式只使用字段名作为附加认证数据，可以读取，ReEncrypt后改为当前格式
+	legacy, err := envelope.Encrypt(ctx, []byte("v3"), []byte("clusterconfig"))
+	assert.Nil(t, err)
+	assert.Nil(t, inner.Create(ctx, &configManager.ConfigObject{Name: "k8s-003-clusterconfig", Data: map[string]string{"clusterconfig": legacy}}))
+	got, err := store.Get(ctx, "k8s-003-clusterconfig")
+	assert.Nil(t, err)
+	assert.Equal(t, "v3", got.Data["clusterconfig"])
+	count, err := store.ReEncrypt(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	raw, err = inner.Get(ctx, "k8s-003-clusterconfig")
+	assert.Nil(t, err)
+	_, err = envelope.Decrypt(ctx, raw.Data["clusterconfig"], []byte("clusterconfig"))
+	assert.NotNil(t, err)
+	count, err = store.ReEncrypt(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
+
+// testVersionedStore ConfigMap和CR的多版本语义
+func testVersionedStore(t *testing.T, store configManager.ConfigStore) {
+	ctx := context.TODO()
+	labels := map[string]string{constValue.LabelType: constValue.NkdHistoryType}
+	for _, data := range []string{"v1", "v2"} {
+		assert.Nil(t, store.Create(ctx, &configManager.ConfigObject{Name: "k8s-001", Labels: labels, Data: map[string]string{"record": data}}))
+	}
+	assert.Nil(t, store.Create(ctx, &configManager.ConfigObject{Name: "k8s-002", Labels: labels, Data: map[string]string{"record": "v1"}}))
+
+	got, err := store.Get(ctx, "k8s-001")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, got.Revision)
+	assert.Equal(t, "v2", got.Data["record"])
+
+	assert.Nil(t, store.Update(ctx, &configManager.ConfigObject{Name: "k8s-001", Data: map[string]string{"record": "v2-fixed"}}))
+	assert.Nil(t, store.AddLabels(ctx, "k8s-001", map[string]string{"env": "test"}))
+
+	list, err := store.List(ctx, map[string]string{"env": "test"})
+	assert.Nil(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, 1, list[0].Revision)
+	assert.Equal(t, "v2-fixed", list[1].Data["record"])
+
+	list, err = store.List(ctx, labels)
+	assert.Nil(t, err)
+	assert.Len(t, list, 3)
+
+	assert.Nil(t, store.Delete(ctx, "k8s-001"))
+	_, err = store.Get(ctx, "k8s-001")
+	assert.True(t, k8serrors.IsNotFound(err))
+	_, err = store.Get(ctx, "k8s-002")
+	assert.Nil(t, err)
+}
+
+func TestMapImplStore(t *testing.T) {
+	clients := &configManager.K8sClientSet{ClientSet: fake.NewSimpleClientset()}
+	testVersionedStore(t, NewMapImpl(clients, "kubemate"))
+}
+
+func TestCrImplStore(t *testing.T) {
+	store := NewCrImpl(nil, "kubemate.openeuler.org", "v1", "Config", "configs", "kubemate", "")
+	scheme := runtime.NewScheme()
+	gvrToListKind := map[schema.GroupVersionResource]string{store.gvr(): "ConfigList"}
+	store.Clients = &configManager.K8sClientSet{DynamicClientSet: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme, gvrToListKind)}
+	testVersionedStore(t, store)
+}
+
+func TestAddNameLabels(t *testing.T) {
+	origin := configManager.KCS
+	defer func() { configManager.KCS = origin }()
+	gvr := schema.GroupVersionResource{Group: "kubemate.openeuler.org", Version: "v1", Resource: "configs"}
+	configManager.KCS = &configManager.K8sClientSet{
+		ClientSet:        fake.NewSimpleClientset(),
+		DynamicClientSet: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{gvr: "ConfigList"}),
+	}
+
+	// 旧版本创建的对象没有名称哈希label
+	ctx := context.TODO()
+	kcs := configManager.KCS
+	_, err := kcs.ClientSet.CoreV1().ConfigMaps("kubemate").Create(ctx, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubemate-configmap-k8s-001-v-1"},
+		Data:       map[string]string{"record": "v1"},
+	}, metav1.CreateOptions{})
+	assert.Nil(t, err)
+	_, err = kcs.ClientSet.CoreV1().Secrets("kubemate").Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "kubemate-secretrevision-k8s-001-clusterconfig-v-1",
+			Labels:      map[string]string{constValue.LabelType: constValue.SecretRevisionType},
+			Annotations: map[string]string{constValue.AnnotationRevisionOf: "kubemate-secret-k8s-001-clusterconfig", constValue.AnnotationRevision: "1"},
+		},
+	}, metav1.CreateOptions{})
+	assert.Nil(t, err)
+	cr := &unstructured.Unstructured{}
+	cr.SetGroupVersionKind(schema.GroupVersionKind{Group: gvr.Group, Version: gvr.Version, Kind: "Config"})
+	cr.SetName("kubemate-cr-k8s-001-v-1")
+	_, err = kcs.DynamicClientSet.Resource(gvr).Namespace("kubemate").Create(ctx, cr, metav1.CreateOptions{})
+	assert.Nil(t, err)
+
+	_, err = NewMapImpl(nil, "kubemate").Get(ctx, "k8s-001")
+	assert.True(t, k8serrors.IsNotFound(err))
+
+	count, err := AddNameLabels(ctx, "kubemate", gvr)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+	got, err := NewMapImpl(nil, "kubemate").Get(ctx, "k8s-001")
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", got.Data["record"])
+	revisions, err := NewSecretImpl(nil, "kubemate", "").ListRevisions(ctx, "k8s-001-clusterconfig")
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 1)
+	_, err = NewCrImpl(nil, gvr.Group, gvr.Version, "Config", gvr.Resource, "kubemate", "").Get(ctx, "k8s-001")
+	assert.Nil(t, err)
+
+	count, err = AddNameLabels(ctx, "kubemate", gvr)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
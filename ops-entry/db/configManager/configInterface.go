@@ -9,15 +9,61 @@ package configManager
 
 import (
 	"context"
-
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"time"
 )
 
-type ConfigInterface interface {
-	GetListOptions(labels map[string]string) metav1.ListOptions
-	Get(ctx context.Context, opts metav1.ListOptions) (interface{}, error)
-	Create(ctx context.Context, opts metav1.CreateOptions, data interface{}) error
-	Update(ctx context.Context, opts metav1.UpdateOptions, data interface{}) error
-	AddLabels(ctx context.Context, opts metav1.UpdateOptions, cmLabels map[string]string, names ...string) error
-	Delete(ctx context.Context, opts metav1.DeleteOptions) error
+// ConfigObject 配置存储中的一条配置
+type ConfigObject struct {
+	Name              string            `json:"name"`               // 配置名称，不含kubemate-secret-等资源名称前缀
+	Revision          int               `json:"revision,omitempty"` // 版本号，ConfigMap和CR为多版本对象的版本，secret的历史版本为历史版本号，其余为0
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	Data              map[string]string `json:"data,omitempty"`
+	CreationTimestamp time.Time         `json:"creationTimestamp"`
+	ResourceVersion   string            `json:"resourceVersion,omitempty"` // Update时不为空则作为前置条件，与存储中的版本不一致时返回Conflict错误
+}
+
+/**
+* @Description: 统一的配置存储接口，由SecretImpl、MapImpl、CrImpl和内存、本地目录存储实现
+* 对象不存在时返回k8serrors.IsNotFound可判断的错误；
+* ConfigMap和CR为多版本存储，Create创建新版本，Get返回最新版本，List返回全部版本，
+* Update修改最新版本，AddLabels和Delete作用于全部版本；
+* Update指定ResourceVersion时，对象已被修改则返回k8serrors.IsConflict可判断的错误
+*
+ */
+
+type ConfigStore interface {
+	Get(ctx context.Context, name string) (*ConfigObject, error)
+	List(ctx context.Context, labels map[string]string) ([]ConfigObject, error)
+	Create(ctx context.Context, obj *ConfigObject) error
+	Update(ctx context.Context, obj *ConfigObject) error
+	AddLabels(ctx context.Context, name string, labels map[string]string) error
+	Delete(ctx context.Context, name string) error
+}
+
+// RevisionStore 支持历史版本和回滚的配置存储
+type RevisionStore interface {
+	ConfigStore
+	CreateRevision(ctx context.Context, name string, cause string) (int, error)
+	ListRevisions(ctx context.Context, name string) ([]ConfigObject, error)
+	GetRevision(ctx context.Context, name string, revision int) (*ConfigObject, error)
+	Rollback(ctx context.Context, name string, revision int) (int, error)
+	// UpdateRevision 替换obj.Revision指定的历史版本的数据，只用于重新加密等不改变内容的场景
+	UpdateRevision(ctx context.Context, obj *ConfigObject) error
+	// RevisionStats 一次查询全部配置的历史版本统计，key为配置名称，用于列表查询
+	RevisionStats(ctx context.Context) (map[string]RevisionStat, error)
+}
+
+// RevisionStat 一个配置的历史版本数和最新历史版本的创建时间
+type RevisionStat struct {
+	Count  int
+	Latest time.Time
+}
+
+// Add 统计一个历史版本
+func (s *RevisionStat) Add(created time.Time) {
+	s.Count++
+	if created.After(s.Latest) {
+		s.Latest = created
+	}
 }
@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) KylinSoft  Co., Ltd. 2024.All rights reserved.
+ * KubeMate licensed under the Mulan Permissive Software License, Version 2.
+ * See LICENSE file for more details.
+ * Author: liukuo <liukuo@kylinos.cn>
+ * Date: Thu Jul 25 16:18:53 2024 +0800
+ */
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"ops-entry/constValue"
+	"strings"
+	"sync"
+)
+
+/**
+* @Description: 主密钥提供者，与KMS插件的接口一致，只负责加解密数据密钥，
+* 数据本身使用随机生成的数据密钥以AES-GCM加密（信封加密）
+*
+ */
+
+type KeyProvider interface {
+	// KeyID 当前用于加密的主密钥id
+	KeyID() string
+	// WrapKey 使用当前主密钥加密数据密钥，返回使用的主密钥id
+	WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error)
+	// UnwrapKey 使用keyID对应的主密钥解密数据密钥
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// ProviderFactory 根据配置创建主密钥提供者
+type ProviderFactory func(config string) (KeyProvider, error)
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]ProviderFactory)
+)
+
+// RegisterProvider 注册主密钥提供者，KMS插件通过该方法接入
+func RegisterProvider(name string, factory ProviderFactory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[name] = factory
+}
+
+// NewProvider 创建已注册的主密钥提供者
+func NewProvider(name, config string) (KeyProvider, error) {
+	providersMu.RLock()
+	factory, ok := providers[name]
+	providersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption provider %q", name)
+	}
+	return factory(config)
+}
+
+// envelope 加密后保存的数据
+type envelope struct {
+	KeyID      string `json:"kid"`
+	WrappedKey []byte `json:"key"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"data"`
+}
+
+// Envelope 信封加密，每次加密生成新的数据密钥
+type Envelope struct {
+	Provider KeyProvider
+}
+
+func NewEnvelope(provider KeyProvider) *Envelope {
+	return &Envelope{Provider: provider}
+}
+
+/**
+* @Description: 加密数据
+* @param aad 附加认证数据，解密时必须一致，用于防止密文被挪用到其他字段
+* return
+*   @resp 以constValue.EncryptionValuePrefix开头的密文
+*
+ */
+
+func (e *Envelope) Encrypt(ctx context.Context, plaintext, aad []byte) (string, error) {
+	dataKey := make([]byte, constValue.EncryptionDataKeyLength)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	keyID, wrapped, err := e.Provider.WrapKey(ctx, dataKey)
+	if err != nil {
+		return "", fmt.Errorf("wrap data key failed: %v", err)
+	}
+
+	value, err := json.Marshal(envelope{
+		KeyID:      keyID,
+		WrappedKey: wrapped,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, aad),
+	})
+	if err != nil {
+		return "", err
+	}
+	return constValue.EncryptionValuePrefix + base64.StdEncoding.EncodeToString(value), nil
+}
+
+// Decrypt 解密Encrypt返回的密文，未加密的数据原样返回
+func (e *Envelope) Decrypt(ctx context.Context, value string, aad []byte) ([]byte, error) {
+	if !IsEncrypted(value) {
+		return []byte(value), nil
+	}
+	env, err := parseEnvelope(value)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := e.Provider.UnwrapKey(ctx, env.KeyID, env.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key with key %q failed: %v", env.KeyID, err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid encrypted value: bad nonce")
+	}
+	plaintext, err := aead.Open(nil, env.Nonce, env.Ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt failed: %v", err)
+	}
+	return plaintext, nil
+}
+
+// Stale 数据未加密或不是使用当前主密钥加密时返回true，轮换密钥时需要重新加密
+func (e *Envelope) Stale(value string) bool {
+	if !IsEncrypted(value) {
+		return true
+	}
+	env, err := parseEnvelope(value)
+	if err != nil {
+		return true
+	}
+	return env.KeyID != e.Provider.KeyID()
+}
+
+// IsEncrypted 数据是否为Encrypt返回的密文
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, constValue.EncryptionValuePrefix)
+}
+
+func parseEnvelope(value string) (*envelope, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, constValue.EncryptionValuePrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted value: %v", err)
+	}
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return nil, fmt.Errorf("invalid encrypted value: %v", err)
+	}
+	return &env, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) KylinSoft  Co., Ltd. 2024.All rights reserved.
+ * KubeMate licensed under the Mulan Permissive Software License, Version 2.
+ * See LICENSE file for more details.
+ * Author: liukuo <liukuo@kylinos.cn>
+ * Date: Thu Jul 25 16:18:53 2024 +0800
+ */
+package encryption
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testKey1 = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=" // 32字节
+	testKey2 = "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=" // 32字节
+)
+
+func TestEnvelope(t *testing.T) {
+	ctx := context.TODO()
+	provider, err := ParseKeyFile([]byte("# kubemate keys\nk1:" + testKey1 + "\n"))
+	assert.Nil(t, err)
+	envelope := NewEnvelope(provider)
+
+	value, err := envelope.Encrypt(ctx, []byte("password: secret"), []byte("clusterconfig"))
+	assert.Nil(t, err)
+	assert.True(t, IsEncrypted(value))
+	assert.NotContains(t, value, "secret")
+	assert.False(t, envelope.Stale(value))
+
+	// 每次加密使用不同的数据密钥
+	again, err := envelope.Encrypt(ctx, []byte("password: secret"), []byte("clusterconfig"))
+	assert.Nil(t, err)
+	assert.NotEqual(t, value, again)
+
+	plaintext, err := envelope.Decrypt(ctx, value, []byte("clusterconfig"))
+	assert.Nil(t, err)
+	assert.Equal(t, "password: secret", string(plaintext))
+	_, err = envelope.Decrypt(ctx, value, []byte("kubeconfig"))
+	assert.NotNil(t, err)
+
+	// 未加密的数据原样返回
+	plaintext, err = envelope.Decrypt(ctx, "plain", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "plain", string(plaintext))
+	assert.True(t, envelope.Stale("plain"))
+
+	// 轮换后旧密文仍可解密，但需要重新加密
+	rotated, err := ParseKeyFile([]byte("k2:" + testKey2 + "\nk1:" + testKey1))
+	assert.Nil(t, err)
+	plaintext, err = NewEnvelope(rotated).Decrypt(ctx, value, []byte("clusterconfig"))
+	assert.Nil(t, err)
+	assert.Equal(t, "password: secret", string(plaintext))
+	assert.True(t, NewEnvelope(rotated).Stale(value))
+
+	removed, err := ParseKeyFile([]byte("k2:" + testKey2))
+	assert.Nil(t, err)
+	_, err = NewEnvelope(removed).Decrypt(ctx, value, []byte("clusterconfig"))
+	assert.NotNil(t, err)
+}
+
+func TestParseKeyFile(t *testing.T) {
+	for _, content := range []string{
+		"",
+		"# comment only",
+		"k1",
+		":" + testKey1,
+		"k1:not-base64!",
+		"k1:c2hvcnQ=",
+		"k1:" + testKey1 + "\nk1:" + testKey2,
+	} {
+		_, err := ParseKeyFile([]byte(content))
+		assert.NotNil(t, err, content)
+	}
+
+	path := filepath.Join(t.TempDir(), "encryption.key")
+	assert.Nil(t, os.WriteFile(path, []byte("k1:"+testKey1), 0600))
+	provider, err := NewProvider("file", path)
+	assert.Nil(t, err)
+	assert.Equal(t, "k1", provider.KeyID())
+
+	_, err = NewProvider("file", filepath.Join(t.TempDir(), "missing"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = NewProvider("vault", "")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) KylinSoft  Co., Ltd. 2024.All rights reserved.
+ * KubeMate licensed under the Mulan Permissive Software License, Version 2.
+ * See LICENSE file for more details.
+ * Author: liukuo <liukuo@kylinos.cn>
+ * Date: Thu Jul 25 16:18:53 2024 +0800
+ */
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"ops-entry/constValue"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	RegisterProvider(constValue.EncryptionProviderFile, func(config string) (KeyProvider, error) {
+		if len(config) == 0 {
+			config = constValue.EncryptionKeyFile
+		}
+		return LoadKeyFile(config)
+	})
+}
+
+// localKey 本地密钥文件中的一个主密钥
+type localKey struct {
+	id  string
+	key []byte
+}
+
+/**
+* @Description: 本地密钥文件提供的主密钥，每行一个密钥，格式为<id>:<base64编码的16、24或32字节密钥>，
+* 空行和#开头的行被忽略；第一个密钥用于加密，其余密钥只用于解密。
+* 轮换密钥时在文件开头增加新密钥，重新加密全部数据后再删除旧密钥
+*
+ */
+
+type LocalKeyProvider struct {
+	keys []localKey
+}
+
+// LoadKeyFile 读取本地密钥文件
+func LoadKeyFile(path string) (*LocalKeyProvider, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		logrus.Warnf("encryption key file %s is accessible by other users, mode %s", path, info.Mode().Perm())
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeyFile(content)
+}
+
+// ParseKeyFile 解析密钥文件的内容
+func ParseKeyFile(content []byte) (*LocalKeyProvider, error) {
+	provider := &LocalKeyProvider{}
+	ids := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(text, ":")
+		id = strings.TrimSpace(id)
+		if !ok || len(id) == 0 {
+			return nil, fmt.Errorf("invalid key at line %d, expect <id>:<base64 key>", line)
+		}
+		if ids[id] {
+			return nil, fmt.Errorf("duplicate key id %q at line %d", id, line)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q at line %d: %v", id, line, err)
+		}
+		if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+			return nil, fmt.Errorf("invalid key %q at line %d: key must be 16, 24 or 32 bytes", id, line)
+		}
+		ids[id] = true
+		provider.keys = append(provider.keys, localKey{id: id, key: key})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(provider.keys) < 1 {
+		return nil, errors.New("no key found in encryption key file")
+	}
+	return provider, nil
+}
+
+func (p *LocalKeyProvider) KeyID() string {
+	return p.keys[0].id
+}
+
+func (p *LocalKeyProvider) WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	current := p.keys[0]
+	aead, err := newAEAD(current.key)
+	if err != nil {
+		return "", nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", nil, err
+	}
+	return current.id, aead.Seal(nonce, nonce, dataKey, []byte(current.id)), nil
+}
+
+func (p *LocalKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	for _, key := range p.keys {
+		if key.id != keyID {
+			continue
+		}
+		aead, err := newAEAD(key.key)
+		if err != nil {
+			return nil, err
+		}
+		if len(wrapped) < aead.NonceSize() {
+			return nil, errors.New("invalid wrapped key")
+		}
+		return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(keyID))
+	}
+	return nil, fmt.Errorf("key %q not found", keyID)
+}
@@ -7,12 +7,45 @@
  */
 package db
 
-import "ops-entry/db/configManager"
+import (
+	"fmt"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StorageBackend 启动时选择的存储后端，默认为kubernetes
+func StorageBackend() string {
+	if backend := os.Getenv(constValue.StorageBackendEnv); len(backend) > 0 {
+		return backend
+	}
+	return constValue.StorageBackendKubernetes
+}
+
+// StorageDir 本地目录存储的根目录
+func StorageDir() string {
+	if dir := os.Getenv(constValue.StorageDirEnv); len(dir) > 0 {
+		return dir
+	}
+	return constValue.StorageDir
+}
+
+/**
+* @Description: 初始化存储后端，kubernetes后端连接管理集群，
+* local后端只创建本地目录，不需要管理集群
+*
+ */
 
 func InitDb() error {
-	err := configManager.Init()
-	if err != nil {
-		return err
+	switch backend := StorageBackend(); backend {
+	case constValue.StorageBackendKubernetes:
+		return configManager.Init()
+	case constValue.StorageBackendLocal:
+		logrus.Infof("use local storage backend, dir[%s]", StorageDir())
+		return os.MkdirAll(StorageDir(), constValue.PrivateDirMode)
+	default:
+		return fmt.Errorf("unknown storage backend %q, expect %s or %s", backend, constValue.StorageBackendKubernetes, constValue.StorageBackendLocal)
 	}
-	return nil
 }
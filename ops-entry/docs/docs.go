@@ -24,6 +24,92 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/clusterconfig": {
+            "get": {
+                "description": "List the cluster configs of every cluster with labels, revision count and size, filter by label selector",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "集群配置文件"
+                ],
+                "summary": "List cluster config files",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Kubernetes label selector on the labels of the uploaded file",
+                        "name": "label_selector",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "name, creation_time or update_time, default name",
+                        "name": "sort_by",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "asc or desc, default asc",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Max number of items to return, default 20",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The continue token returned by the previous page",
+                        "name": "continue",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/proto.ConfigListResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/clusterconfig/generate": {
+            "post": {
+                "description": "Generate a NKD cluster config file from a JSON document, unset fields are filled with defaults, the file is stored the same way as an uploaded one",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "集群配置文件"
+                ],
+                "summary": "Generate a cluster config file",
+                "parameters": [
+                    {
+                        "description": "The cluster config",
+                        "name": "generate",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/proto.ClusterConfigGenerateParam"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/proto.ClusterConfigGenerateResult"
+                        }
+                    }
+                }
+            }
+        },
         "/clusterconfig/update": {
             "put": {
                 "description": "Update a file with optional description",
@@ -57,6 +143,12 @@ const docTemplate = `{
                         "description": "The JSON string containing labels for the uploaded file",
                         "name": "labels",
                         "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag returned by GET /clusterconfig/{cluster_id}, the update is rejected with code 204 if the config has been modified",
+                        "name": "If-Match",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -148,6 +240,12 @@ const docTemplate = `{
                         "description": "OK",
                         "schema": {
                             "$ref": "#/definitions/proto.FileResult"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Version of the cluster config, pass as If-Match to /clusterconfig/update"
+                            }
                         }
                     }
                 }
@@ -180,48 +278,55 @@ const docTemplate = `{
                 }
             }
         },
-        "/kubeconfig/update": {
-            "put": {
-                "description": "Update a file with optional description",
-                "consumes": [
-                    "multipart/form-data"
-                ],
+        "/clusterconfig/{cluster_id}/diff": {
+            "get": {
+                "description": "Compare two revisions of a cluster config as parsed YAML, credential values are redacted",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "kubeconfig文件"
+                    "集群配置文件"
                 ],
-                "summary": "Update a kubeconfig file",
+                "summary": "Diff two revisions of a cluster config",
                 "parameters": [
                     {
-                        "type": "file",
-                        "description": "The kubeconfig file to upload",
-                        "name": "file",
-                        "in": "formData",
+                        "type": "string",
+                        "description": "k8s cluster ID",
+                        "name": "cluster_id",
+                        "in": "path",
                         "required": true
                     },
                     {
-                        "type": "string",
-                        "description": "k8s name",
-                        "name": "cluster_id",
-                        "in": "formData",
+                        "type": "integer",
+                        "description": "The old revision",
+                        "name": "from",
+                        "in": "query",
                         "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The new revision, defaults to the latest one",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The JSON string containing labels of the cluster config",
+                        "name": "labels",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Successful file update",
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/proto.FileResult"
+                            "$ref": "#/definitions/proto.ConfigDiffResult"
                         }
                     }
                 }
-            }
-        },
-        "/kubeconfig/upload": {
+            },
             "post": {
-                "description": "Upload a file with optional description",
+                "description": "Compare a revision of a cluster config with a cluster config file before uploading it, credential values are redacted",
                 "consumes": [
                     "multipart/form-data"
                 ],
@@ -229,42 +334,58 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "kubeconfig文件"
+                    "集群配置文件"
                 ],
-                "summary": "Upload a kubeconfig file",
+                "summary": "Diff a revision of a cluster config with a candidate file",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "k8s cluster ID",
+                        "name": "cluster_id",
+                        "in": "path",
+                        "required": true
+                    },
                     {
                         "type": "file",
-                        "description": "The kubeconfig file to upload",
+                        "description": "The candidate cluster config file",
                         "name": "file",
                         "in": "formData",
                         "required": true
                     },
                     {
-                        "type": "string",
-                        "description": "k8s name",
-                        "name": "cluster_id",
+                        "type": "integer",
+                        "description": "The revision to compare with",
+                        "name": "from",
                         "in": "formData",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The JSON string containing labels of the cluster config",
+                        "name": "labels",
+                        "in": "formData"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Successful file upload",
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/proto.FileResult"
+                            "$ref": "#/definitions/proto.ConfigDiffResult"
                         }
                     }
                 }
             }
         },
-        "/kubeconfig/{cluster_id}": {
+        "/clusterconfig/{cluster_id}/revisions": {
             "get": {
-                "description": "Query a kubeconfig file by cluster ID",
+                "description": "List the revisions recorded by every upload, update and rollback of a cluster config",
+                "produces": [
+                    "application/json"
+                ],
                 "tags": [
-                    "kubeconfig文件"
+                    "集群配置文件"
                 ],
-                "summary": "Query a kubeconfig file",
+                "summary": "List revisions of a cluster config",
                 "parameters": [
                     {
                         "type": "string",
@@ -272,45 +393,69 @@ const docTemplate = `{
                         "name": "cluster_id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The JSON string containing labels of the cluster config",
+                        "name": "labels",
+                        "in": "query"
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content - Indicates successful get"
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/proto.ConfigRevisionListResult"
+                        }
                     }
                 }
-            },
-            "delete": {
-                "description": "Delete a file with optional description",
-                "consumes": [
-                    "application/json"
-                ],
+            }
+        },
+        "/clusterconfig/{cluster_id}/revisions/{revision}": {
+            "get": {
+                "description": "Query the content of a cluster config revision",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "kubeconfig文件"
+                    "集群配置文件"
                 ],
-                "summary": "Delete a kubeconfig file",
+                "summary": "Query a revision of a cluster config",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "k8s name",
+                        "description": "k8s cluster ID",
                         "name": "cluster_id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "revision number",
+                        "name": "revision",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The JSON string containing labels of the cluster config",
+                        "name": "labels",
+                        "in": "query"
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content - Indicates successful deletion"
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/proto.ConfigRevisionResult"
+                        }
                     }
                 }
             }
         },
-        "/nkd/deploy": {
+        "/clusterconfig/{cluster_id}/rollback": {
             "post": {
-                "description": "Deploy a kubernetes cluster",
+                "description": "Restore a cluster config to an earlier revision, the rollback is recorded as a new revision",
                 "consumes": [
                     "application/json"
                 ],
@@ -318,17 +463,24 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Use NKD to manage a kubernetes cluster"
+                    "集群配置文件"
                 ],
-                "summary": "Deploy a kubernetes cluster",
+                "summary": "Roll back a cluster config",
                 "parameters": [
                     {
-                        "description": "Deploy a kubernetes cluster",
-                        "name": "deploy",
+                        "type": "string",
+                        "description": "k8s cluster ID",
+                        "name": "cluster_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The revision to roll back to",
+                        "name": "rollback",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/proto.NKDDeployParam"
+                            "$ref": "#/definitions/proto.ConfigRollbackParam"
                         }
                     }
                 ],
@@ -336,88 +488,1324 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/proto.NKDResult"
+                            "$ref": "#/definitions/proto.ConfigRollbackResult"
                         }
                     }
                 }
             }
         },
-        "/nkd/destroy": {
-            "delete": {
-                "description": "Destroy a kubernetes cluster",
-                "consumes": [
-                    "application/json"
-                ],
+        "/clusterconfig/{cluster_id}/status": {
+            "get": {
+                "description": "Query the phase, conditions and last operation recorded in the KubeMateCluster of a cluster config. Use the labels {\"kubemate.openeuler.org/managed-by\":\"operator\"} to query a cluster managed by the operator",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Use NKD to manage a kubernetes cluster"
+                    "集群配置文件"
                 ],
-                "summary": "Destroy a kubernetes cluster",
+                "summary": "Query the status of a cluster",
                 "parameters": [
                     {
-                        "description": "Destroy a kubernetes cluster",
-                        "name": "destroy",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/proto.NKDDestroyParam"
-                        }
+                        "type": "string",
+                        "description": "k8s cluster ID",
+                        "name": "cluster_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The JSON string containing labels of the cluster config",
+                        "name": "labels",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/proto.NKDResult"
+                            "$ref": "#/definitions/proto.KubeMateClusterStatusResult"
                         }
                     }
                 }
             }
         },
-        "/nkd/extend": {
-            "post": {
-                "description": "Extend a kubernetes cluster",
-                "consumes": [
-                    "application/json"
-                ],
+        "/kubeconfig": {
+            "get": {
+                "description": "List the kubeconfig of every cluster with revision count and size, filter by label selector",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Use NKD to manage a kubernetes cluster"
+                    "kubeconfig文件"
                 ],
-                "summary": "Extend a kubernetes cluster",
+                "summary": "List kubeconfig files",
                 "parameters": [
                     {
-                        "description": "Extend a kubernetes cluster",
-                        "name": "extend",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/proto.NKDExtendParam"
-                        }
+                        "type": "string",
+                        "description": "Kubernetes label selector on the labels of the uploaded file",
+                        "name": "label_selector",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "name, creation_time or update_time, default name",
+                        "name": "sort_by",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "asc or desc, default asc",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Max number of items to return, default 20",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The continue token returned by the previous page",
+                        "name": "continue",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/proto.NKDResult"
+                            "$ref": "#/definitions/proto.ConfigListResult"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "proto.FileResult": {
-            "type": "object",
+        },
+        "/kubeconfig/update": {
+            "put": {
+                "description": "Update a file with optional description",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "kubeconfig文件"
+                ],
+                "summary": "Update a kubeconfig file",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "The kubeconfig file to upload",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "k8s name",
+                        "name": "cluster_id",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag returned by GET /kubeconfig/{cluster_id}, the update is rejected with code 204 if the kubeconfig has been modified",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successful file update",
+                        "schema": {
+                            "$ref": "#/definitions/proto.FileResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/kubeconfig/upload": {
+            "post": {
+                "description": "Upload a file with optional description",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "kubeconfig文件"
+                ],
+                "summary": "Upload a kubeconfig file",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "The kubeconfig file to upload",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "k8s name",
+                        "name": "cluster_id",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successful file upload",
+                        "schema": {
+                            "$ref": "#/definitions/proto.FileResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/kubeconfig/{cluster_id}": {
+            "get": {
+                "description": "Query a kubeconfig file by cluster ID",
+                "tags": [
+                    "kubeconfig文件"
+                ],
+                "summary": "Query a kubeconfig file",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "k8s cluster ID",
+                        "name": "cluster_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content - Indicates successful get"
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete a file with optional description",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "kubeconfig文件"
+                ],
+                "summary": "Delete a kubeconfig file",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "k8s name",
+                        "name": "cluster_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content - Indicates successful deletion"
+                    }
+                }
+            }
+        },
+        "/kubeconfig/{cluster_id}/revisions": {
+            "get": {
+                "description": "List the revisions recorded by every upload, update and rollback of a kubeconfig",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "kubeconfig文件"
+                ],
+                "summary": "List revisions of a kubeconfig",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "k8s cluster ID",
+                        "name": "cluster_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/proto.ConfigRevisionListResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/kubeconfig/{cluster_id}/revisions/{revision}": {
+            "get": {
+                "description": "Query the content of a kubeconfig revision",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "kubeconfig文件"
+                ],
+                "summary": "Query a revision of a kubeconfig",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "k8s cluster ID",
+                        "name": "cluster_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "revision number",
+                        "name": "revision",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/proto.ConfigRevisionResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/kubeconfig/{cluster_id}/rollback": {
+            "post": {
+                "description": "Restore a kubeconfig to an earlier revision, the rollback is recorded as a new revision",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "kubeconfig文件"
+                ],
+                "summary": "Roll back a kubeconfig",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "k8s cluster ID",
+                        "name": "cluster_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The revision to roll back to",
+                        "name": "rollback",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/proto.ConfigRollbackParam"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/proto.ConfigRollbackResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/nkd/deploy": {
+            "post": {
+                "description": "Submit a job to deploy a kubernetes cluster, query it by the returned job_id",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Use NKD to manage a kubernetes cluster"
+                ],
+                "summary": "Deploy a kubernetes cluster",
+                "parameters": [
+                    {
+                        "description": "Deploy a kubernetes cluster",
+                        "name": "deploy",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/proto.NKDDeployParam"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/proto.NKDResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/nkd/destroy": {
+            "delete": {
+                "description": "Submit a job to destroy a kubernetes cluster, query it by the returned job_id",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Use NKD to manage a kubernetes cluster"
+                ],
+                "summary": "Destroy a kubernetes cluster",
+                "parameters": [
+                    {
+                        "description": "Destroy a kubernetes cluster",
+                        "name": "destroy",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/proto.NKDDestroyParam"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/proto.NKDResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/nkd/extend": {
+            "post": {
+                "description": "Submit a job to extend a kubernetes cluster, query it by the returned job_id",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Use NKD to manage a kubernetes cluster"
+                ],
+                "summary": "Extend a kubernetes cluster",
+                "parameters": [
+                    {
+                        "description": "Extend a kubernetes cluster",
+                        "name": "extend",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/proto.NKDExtendParam"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/proto.NKDResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/nkd/history": {
+            "get": {
+                "description": "List finished nkd jobs persisted in the kubemate namespace, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Use NKD to manage a kubernetes cluster"
+                ],
+                "summary": "List nkd operation history",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only return records of the kubernetes cluster",
+                        "name": "cluster_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Max number of records to return, default 20",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of records to skip",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/proto.NKDHistoryResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/nkd/jobs/{id}": {
+            "get": {
+                "description": "Query the phase, start/end time, exit code and output of a nkd job",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Use NKD to manage a kubernetes cluster"
+                ],
+                "summary": "Query a nkd job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "nkd job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/proto.NKDJobResult"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Cancel a pending or running nkd job, the nkd process group is terminated gracefully then forcefully",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Use NKD to manage a kubernetes cluster"
+                ],
+                "summary": "Cancel a nkd job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "nkd job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/proto.NKDJobResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/nkd/jobs/{id}/logs": {
+            "get": {
+                "description": "Read stdout/stderr of a nkd job line by line starting from offset.\nWith follow=true the lines are streamed as server-sent events until the job ends,\nthe id of each event is the offset to resume from (also accepted in the Last-Event-ID header).",
+                "produces": [
+                    "application/json",
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "Use NKD to manage a kubernetes cluster"
+                ],
+                "summary": "Read the output of a nkd job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "nkd job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Stream new lines as server-sent events",
+                        "name": "follow",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of lines already read",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/proto.NKDJobLogResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/nkd/shrink": {
+            "post": {
+                "description": "Submit a job to drain and remove worker nodes from the cluster, query it by the returned job_id. The job then releases their machines through nkd and removes them from the cluster config",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Use NKD to manage a kubernetes cluster"
+                ],
+                "summary": "Shrink a kubernetes cluster",
+                "parameters": [
+                    {
+                        "description": "Shrink a kubernetes cluster",
+                        "name": "shrink",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/proto.NKDShrinkParam"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/proto.NKDResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/nkd/upgrade": {
+            "post": {
+                "description": "Submit a job to upgrade the control plane and then the workers of a kubernetes cluster to the target version, query it by the returned job_id",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Use NKD to manage a kubernetes cluster"
+                ],
+                "summary": "Upgrade a kubernetes cluster",
+                "parameters": [
+                    {
+                        "description": "Upgrade a kubernetes cluster",
+                        "name": "upgrade",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/proto.NKDUpgradeParam"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/proto.NKDResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/watch/clusters": {
+            "get": {
+                "description": "Stream added/updated/deleted events of kubeconfig secrets, cluster config secrets and KubeMateCluster resources as server-sent events.\nWithout resource_version all existing objects are sent as added events first.\nThe id of each event is the resource_version to resume from (also accepted in the Last-Event-ID header),\nwhen it has expired a reset event is sent and all existing objects are sent again.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "Watch"
+                ],
+                "summary": "Watch changes of kubeconfigs, cluster configs and clusters",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The resource_version of the last received event",
+                        "name": "resource_version",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/proto.WatchEvent"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "models.APIServerConfig": {
+            "type": "object"
+        },
+        "models.ClusterConfig": {
+            "type": "object",
+            "properties": {
+                "cluster_id": {
+                    "description": "ClusterID 是集群的名称",
+                    "type": "string"
+                },
+                "kubernetes": {
+                    "description": "Kubernetes 是集群级别的Kubernetes配置",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.KubernetesConfig"
+                        }
+                    ]
+                },
+                "master": {
+                    "description": "Master 包含了全部master节点",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.KubernetesMasterNode"
+                    }
+                },
+                "os": {
+                    "$ref": "#/definitions/models.Os"
+                },
+                "worker": {
+                    "description": "Worker 包含了全部worker节点",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.KubernetesWorkerNode"
+                    }
+                }
+            }
+        },
+        "models.ControlPlaneComponents": {
+            "type": "object",
+            "properties": {
+                "api_server": {
+                    "$ref": "#/definitions/models.APIServerConfig"
+                }
+            }
+        },
+        "models.KubernetesConfig": {
+            "type": "object",
+            "properties": {
+                "adminkubeconfig": {
+                    "type": "string"
+                },
+                "api_version": {
+                    "type": "string"
+                },
+                "apiserver_endpoint": {
+                    "type": "string"
+                },
+                "certificatekey": {
+                    "type": "string"
+                },
+                "controlPlaneComponents": {
+                    "description": "ControlPlaneComponents 包含了控制平面组件的配置（如API服务器、调度器等）",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.ControlPlaneComponents"
+                        }
+                    ]
+                },
+                "image_registry": {
+                    "type": "string"
+                },
+                "network": {
+                    "$ref": "#/definitions/models.KubernetesNetwork"
+                },
+                "pause_image": {
+                    "type": "string"
+                },
+                "token": {
+                    "type": "string"
+                },
+                "version": {
+                    "description": "Version 是Kubernetes的版本",
+                    "type": "string"
+                }
+            }
+        },
+        "models.KubernetesMasterNode": {
+            "type": "object",
+            "properties": {
+                "ip": {
+                    "description": "IP 是master节点的IP地址",
+                    "type": "string"
+                },
+                "kubernetesConfig": {
+                    "description": "KubernetesConfig 包含了Kubernetes相关的配置",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.KubernetesConfig"
+                        }
+                    ]
+                },
+                "libvirtConfig": {
+                    "description": "LibvirtConfig 包含了libvirt相关的配置",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.LibvirtConfig"
+                        }
+                    ]
+                },
+                "name": {
+                    "description": "Name 是master节点的名称",
+                    "type": "string"
+                },
+                "open_stack": {
+                    "description": "OpenStackConfig 包含了OpenStack相关的配置",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.OpenStackConfig"
+                        }
+                    ]
+                },
+                "port": {
+                    "description": "Port 是Kubernetes API服务的端口",
+                    "type": "integer"
+                }
+            }
+        },
+        "models.KubernetesNetwork": {
+            "type": "object",
+            "properties": {
+                "plugin": {
+                    "type": "string"
+                },
+                "pod_subnet": {
+                    "type": "string"
+                },
+                "service_subnet": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.KubernetesWorkerNode": {
+            "type": "object",
+            "properties": {
+                "ip": {
+                    "description": "IP 是Worker节点的IP地址",
+                    "type": "string"
+                },
+                "kubernetesConfig": {
+                    "description": "KubernetesConfig 包含了Kubernetes相关的配置",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.KubernetesConfig"
+                        }
+                    ]
+                },
+                "libvirtConfig": {
+                    "description": "LibvirtConfig 包含了libvirt相关的配置",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.LibvirtConfig"
+                        }
+                    ]
+                },
+                "name": {
+                    "description": "Name 是Worker节点的名称",
+                    "type": "string"
+                },
+                "open_stack": {
+                    "description": "OpenStackConfig 包含了OpenStack相关的配置",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.OpenStackConfig"
+                        }
+                    ]
+                }
+            }
+        },
+        "models.LibvirtConfig": {
+            "type": "object",
+            "properties": {
+                "cidr": {
+                    "type": "string"
+                },
+                "gateway": {
+                    "type": "string"
+                },
+                "networks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.LibvirtNetwork"
+                    }
+                },
+                "os_image": {
+                    "type": "string"
+                },
+                "storage_pools": {
+                    "description": "StoragePools 包含了libvirt管理的存储池列表",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.LibvirtStoragePool"
+                    }
+                },
+                "uri": {
+                    "description": "URI 是libvirt的URI，用于连接到libvirtd守护进程",
+                    "type": "string"
+                }
+            }
+        },
+        "models.LibvirtNetwork": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.LibvirtStoragePool": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.OpenStackConfig": {
+            "type": "object",
+            "properties": {
+                "auth_url": {
+                    "type": "string"
+                },
+                "availability_none": {
+                    "type": "string"
+                },
+                "external_network": {
+                    "type": "string"
+                },
+                "glance_name": {
+                    "type": "string"
+                },
+                "internal_network": {
+                    "type": "string"
+                },
+                "password": {
+                    "type": "string"
+                },
+                "region": {
+                    "type": "string"
+                },
+                "tenant_name": {
+                    "type": "string"
+                },
+                "user_name": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.Os": {
+            "type": "object",
+            "properties": {
+                "image": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "string"
+                }
+            }
+        },
+        "proto.ClusterConfigGenerateParam": {
+            "type": "object",
+            "required": [
+                "cluster_id"
+            ],
+            "properties": {
+                "cluster_id": {
+                    "type": "string",
+                    "example": "k8s-001"
+                },
+                "config": {
+                    "$ref": "#/definitions/models.ClusterConfig"
+                },
+                "labels": {
+                    "type": "string",
+                    "example": "{\"version\":\"v0.1\"}"
+                }
+            }
+        },
+        "proto.ClusterConfigGenerateResult": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "data": {
+                    "type": "string"
+                },
+                "msg": {
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                },
+                "violations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/proto.FieldViolation"
+                    }
+                }
+            }
+        },
+        "proto.ClusterPhase": {
+            "type": "string",
+            "enum": [
+                "Deploying",
+                "Extending",
+                "Ready",
+                "Destroying",
+                "Failed",
+                "Stored"
+            ],
+            "x-enum-comments": {
+                "ClusterStored": "上传集群配置时生成的记录，不会被operator处理"
+            },
+            "x-enum-varnames": [
+                "ClusterDeploying",
+                "ClusterExtending",
+                "ClusterReady",
+                "ClusterDestroying",
+                "ClusterFailed",
+                "ClusterStored"
+            ]
+        },
+        "proto.ConfigDiff": {
+            "type": "object",
+            "properties": {
+                "changes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/proto.ConfigDiffEntry"
+                    }
+                },
+                "from": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "to": {
+                    "type": "integer",
+                    "example": 2
+                }
+            }
+        },
+        "proto.ConfigDiffEntry": {
+            "type": "object",
+            "properties": {
+                "from": {},
+                "path": {
+                    "type": "string",
+                    "example": "master[0].ip"
+                },
+                "to": {},
+                "type": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/proto.ConfigDiffType"
+                        }
+                    ],
+                    "example": "changed"
+                }
+            }
+        },
+        "proto.ConfigDiffResult": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "data": {
+                    "$ref": "#/definitions/proto.ConfigDiff"
+                },
+                "msg": {
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "proto.ConfigDiffType": {
+            "type": "string",
+            "enum": [
+                "added",
+                "removed",
+                "changed"
+            ],
+            "x-enum-varnames": [
+                "ConfigDiffAdded",
+                "ConfigDiffRemoved",
+                "ConfigDiffChanged"
+            ]
+        },
+        "proto.ConfigList": {
+            "type": "object",
+            "properties": {
+                "continue": {
+                    "type": "string"
+                },
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/proto.ConfigSummary"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "proto.ConfigListResult": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "data": {
+                    "$ref": "#/definitions/proto.ConfigList"
+                },
+                "msg": {
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "proto.ConfigRevision": {
+            "type": "object",
+            "properties": {
+                "cause": {
+                    "type": "string",
+                    "example": "rollback to 1"
+                },
+                "creation_time": {
+                    "type": "string"
+                },
+                "data": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "k8s-001-clusterconfig"
+                },
+                "revision": {
+                    "type": "integer",
+                    "example": 3
+                }
+            }
+        },
+        "proto.ConfigRevisionListResult": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "data": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/proto.ConfigRevision"
+                    }
+                },
+                "msg": {
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "proto.ConfigRevisionResult": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "data": {
+                    "$ref": "#/definitions/proto.ConfigRevision"
+                },
+                "msg": {
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "proto.ConfigRollbackParam": {
+            "type": "object",
+            "required": [
+                "revision"
+            ],
+            "properties": {
+                "labels": {
+                    "type": "string",
+                    "example": "{\"version\":\"v0.1\"}"
+                },
+                "revision": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "proto.ConfigRollbackResult": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "msg": {
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                },
+                "revision": {
+                    "type": "integer",
+                    "example": 4
+                }
+            }
+        },
+        "proto.ConfigSummary": {
+            "type": "object",
+            "properties": {
+                "cluster_id": {
+                    "type": "string",
+                    "example": "k8s-001"
+                },
+                "creation_time": {
+                    "type": "string"
+                },
+                "labels": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "name": {
+                    "type": "string",
+                    "example": "k8s-001-clusterconfig"
+                },
+                "revisions": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "size": {
+                    "type": "integer",
+                    "example": 2048
+                },
+                "update_time": {
+                    "type": "string"
+                }
+            }
+        },
+        "proto.FieldViolation": {
+            "type": "object",
+            "properties": {
+                "detail": {
+                    "type": "string",
+                    "example": "Invalid value: \"10.0.1.1\": must be within cidr 192.168.1.0/24"
+                },
+                "field": {
+                    "type": "string",
+                    "example": "master[0].libvirtConfig.gateway"
+                }
+            }
+        },
+        "proto.FileResult": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "msg": {
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                },
+                "violations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/proto.FieldViolation"
+                    }
+                }
+            }
+        },
+        "proto.KubeMateClusterStatus": {
+            "type": "object",
+            "properties": {
+                "conditions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/v1.Condition"
+                    }
+                },
+                "jobId": {
+                    "description": "执行中的nkd任务",
+                    "type": "string"
+                },
+                "lastOperation": {
+                    "$ref": "#/definitions/proto.OperationResult"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "observedGeneration": {
+                    "description": "最近一次提交任务时spec的generation",
+                    "type": "integer"
+                },
+                "phase": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/proto.ClusterPhase"
+                        }
+                    ]
+                },
+                "targetWorkers": {
+                    "description": "执行中的任务成功后的worker节点数",
+                    "type": "integer"
+                },
+                "verb": {
+                    "description": "最近一次提交的nkd子命令",
+                    "type": "string"
+                },
+                "workers": {
+                    "description": "已部署的worker节点数",
+                    "type": "integer"
+                }
+            }
+        },
+        "proto.KubeMateClusterStatusResult": {
+            "type": "object",
             "properties": {
                 "code": {
                     "type": "integer"
                 },
+                "data": {
+                    "$ref": "#/definitions/proto.KubeMateClusterStatus"
+                },
                 "msg": {
                     "type": "string"
                 },
@@ -471,12 +1859,224 @@ const docTemplate = `{
                 }
             }
         },
+        "proto.NKDHistoryList": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/proto.NKDHistoryRecord"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "proto.NKDHistoryRecord": {
+            "type": "object",
+            "properties": {
+                "cluster_id": {
+                    "type": "string",
+                    "example": "cluster"
+                },
+                "end_time": {
+                    "type": "string"
+                },
+                "exit_code": {
+                    "type": "integer"
+                },
+                "job_id": {
+                    "type": "string",
+                    "example": "nkd-1722047933000000000-1024"
+                },
+                "labels": {
+                    "type": "string",
+                    "example": "{\"version\":\"v0.1\"}"
+                },
+                "nodes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "num": {
+                    "type": "integer"
+                },
+                "output": {
+                    "type": "string"
+                },
+                "phase": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/proto.NKDJobPhase"
+                        }
+                    ],
+                    "example": "running"
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "timeout after 2h0m0s"
+                },
+                "request_id": {
+                    "type": "string"
+                },
+                "requester": {
+                    "type": "string",
+                    "example": "10.0.0.1"
+                },
+                "start_time": {
+                    "type": "string"
+                },
+                "verb": {
+                    "type": "string",
+                    "example": "deploy"
+                },
+                "version": {
+                    "type": "string"
+                }
+            }
+        },
+        "proto.NKDHistoryResult": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "data": {
+                    "$ref": "#/definitions/proto.NKDHistoryList"
+                },
+                "msg": {
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "proto.NKDJobInfo": {
+            "type": "object",
+            "properties": {
+                "cluster_id": {
+                    "type": "string",
+                    "example": "cluster"
+                },
+                "end_time": {
+                    "type": "string"
+                },
+                "exit_code": {
+                    "type": "integer"
+                },
+                "job_id": {
+                    "type": "string",
+                    "example": "nkd-1722047933000000000-1024"
+                },
+                "output": {
+                    "type": "string"
+                },
+                "phase": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/proto.NKDJobPhase"
+                        }
+                    ],
+                    "example": "running"
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "timeout after 2h0m0s"
+                },
+                "start_time": {
+                    "type": "string"
+                },
+                "verb": {
+                    "type": "string",
+                    "example": "deploy"
+                }
+            }
+        },
+        "proto.NKDJobLog": {
+            "type": "object",
+            "properties": {
+                "finished": {
+                    "type": "boolean"
+                },
+                "job_id": {
+                    "type": "string",
+                    "example": "nkd-1722047933000000000-1024"
+                },
+                "lines": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "offset": {
+                    "type": "integer",
+                    "example": 20
+                }
+            }
+        },
+        "proto.NKDJobLogResult": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "data": {
+                    "$ref": "#/definitions/proto.NKDJobLog"
+                },
+                "msg": {
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "proto.NKDJobPhase": {
+            "type": "string",
+            "enum": [
+                "pending",
+                "running",
+                "succeeded",
+                "failed",
+                "cancelled"
+            ],
+            "x-enum-varnames": [
+                "NKDJobPending",
+                "NKDJobRunning",
+                "NKDJobSucceeded",
+                "NKDJobFailed",
+                "NKDJobCancelled"
+            ]
+        },
+        "proto.NKDJobResult": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "integer"
+                },
+                "data": {
+                    "$ref": "#/definitions/proto.NKDJobInfo"
+                },
+                "msg": {
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                }
+            }
+        },
         "proto.NKDResult": {
             "type": "object",
             "properties": {
                 "code": {
                     "type": "integer"
                 },
+                "job_id": {
+                    "type": "string"
+                },
                 "msg": {
                     "type": "string"
                 },
@@ -484,6 +2084,148 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "proto.NKDShrinkParam": {
+            "type": "object",
+            "required": [
+                "cluster_id",
+                "nodes"
+            ],
+            "properties": {
+                "cluster_id": {
+                    "type": "string",
+                    "example": "cluster"
+                },
+                "nodes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "worker1",
+                        "worker2"
+                    ]
+                }
+            }
+        },
+        "proto.NKDUpgradeParam": {
+            "type": "object",
+            "required": [
+                "cluster_id",
+                "version"
+            ],
+            "properties": {
+                "cluster_id": {
+                    "type": "string",
+                    "example": "cluster"
+                },
+                "labels": {
+                    "type": "string",
+                    "example": "{\"version\":\"v0.1\"}"
+                },
+                "version": {
+                    "type": "string",
+                    "example": "v1.29.1"
+                }
+            }
+        },
+        "proto.OperationResult": {
+            "type": "object",
+            "properties": {
+                "jobId": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "operation": {
+                    "description": "nkd子命令、upgrade或upload",
+                    "type": "string"
+                },
+                "result": {
+                    "type": "string"
+                },
+                "time": {
+                    "type": "string"
+                }
+            }
+        },
+        "proto.WatchEvent": {
+            "type": "object",
+            "properties": {
+                "cluster_id": {
+                    "type": "string",
+                    "example": "k8s-001"
+                },
+                "kind": {
+                    "type": "string",
+                    "example": "clusterconfig"
+                },
+                "labels": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "message": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "k8s-001-clusterconfig"
+                },
+                "phase": {
+                    "type": "string",
+                    "example": "Ready"
+                },
+                "resource_version": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "updated"
+                }
+            }
+        },
+        "v1.Condition": {
+            "type": "object",
+            "properties": {
+                "lastTransitionTime": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "observedGeneration": {
+                    "type": "integer"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "status": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/v1.ConditionStatus"
+                        }
+                    ]
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "v1.ConditionStatus": {
+            "type": "string",
+            "enum": [
+                "True",
+                "False",
+                "Unknown"
+            ],
+            "x-enum-varnames": [
+                "ConditionTrue",
+                "ConditionFalse",
+                "ConditionUnknown"
+            ]
         }
     }
 }`
@@ -0,0 +1,54 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Executor nkd命令的执行器，命令输出实时写入output，ctx结束时终止执行
+type Executor interface {
+	Deploy(ctx context.Context, configFile string, output io.Writer) error
+	Destroy(ctx context.Context, clusterID string, output io.Writer) error
+	Extend(ctx context.Context, clusterID string, num int, output io.Writer) error
+	Upgrade(ctx context.Context, clusterID string, version string, output io.Writer) error
+	Shrink(ctx context.Context, clusterID string, nodes []string, output io.Writer) error
+}
+
+// ExitError 命令执行完成但退出码非0
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+// ExitCode 获取执行结果的退出码，成功返回0，命令未能启动或被中断时返回-1
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
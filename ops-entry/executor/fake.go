@@ -0,0 +1,107 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"ops-entry/constValue"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeScript 模拟一次nkd执行：逐行输出Lines，每行之前等待Interval，最后以ExitCode退出
+type FakeScript struct {
+	Lines    []string
+	Interval time.Duration
+	ExitCode int
+}
+
+// FakeCall 记录一次调用
+type FakeCall struct {
+	Verb string
+	Args []string
+}
+
+/**
+* @Description: 测试用的执行器，不依赖nkd二进制
+* Scripts按子命令配置执行过程，未配置的子命令立即成功
+*
+ */
+
+type FakeExecutor struct {
+	mu      sync.Mutex
+	Scripts map[string]FakeScript
+	calls   []FakeCall
+}
+
+func NewFakeExecutor() *FakeExecutor {
+	return &FakeExecutor{Scripts: make(map[string]FakeScript)}
+}
+
+// SetScript 设置子命令的执行过程
+func (f *FakeExecutor) SetScript(verb string, script FakeScript) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Scripts[verb] = script
+}
+
+// Calls 返回已经发生的调用
+func (f *FakeExecutor) Calls() []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeCall(nil), f.calls...)
+}
+
+func (f *FakeExecutor) Deploy(ctx context.Context, configFile string, output io.Writer) error {
+	return f.run(ctx, output, constValue.NkdVerbDeploy, configFile)
+}
+
+func (f *FakeExecutor) Destroy(ctx context.Context, clusterID string, output io.Writer) error {
+	return f.run(ctx, output, constValue.NkdVerbDestroy, clusterID)
+}
+
+func (f *FakeExecutor) Extend(ctx context.Context, clusterID string, num int, output io.Writer) error {
+	return f.run(ctx, output, constValue.NkdVerbExtend, clusterID, strconv.Itoa(num))
+}
+
+func (f *FakeExecutor) Upgrade(ctx context.Context, clusterID string, version string, output io.Writer) error {
+	return f.run(ctx, output, constValue.NkdVerbUpgrade, clusterID, version)
+}
+
+func (f *FakeExecutor) Shrink(ctx context.Context, clusterID string, nodes []string, output io.Writer) error {
+	return f.run(ctx, output, constValue.NkdVerbShrink, clusterID, strings.Join(nodes, ","))
+}
+
+func (f *FakeExecutor) run(ctx context.Context, output io.Writer, verb string, args ...string) error {
+	f.mu.Lock()
+	f.calls = append(f.calls, FakeCall{Verb: verb, Args: args})
+	script := f.Scripts[verb]
+	f.mu.Unlock()
+
+	for _, line := range script.Lines {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(script.Interval):
+		}
+		fmt.Fprintln(output, line)
+	}
+	if script.ExitCode != 0 {
+		return &ExitError{Code: script.ExitCode}
+	}
+	return nil
+}
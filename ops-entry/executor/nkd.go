@@ -0,0 +1,136 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"ops-entry/constValue"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrUnsupportedVerb 当前nkd版本不支持的子命令或参数
+var ErrUnsupportedVerb = errors.New("unsupported by nkd")
+
+// NkdExecutor 调用nkd二进制执行
+type NkdExecutor struct {
+	BinPath string
+}
+
+// NewNkdExecutor binPath为空时依次使用环境变量KUBEMATE_NKD_PATH和默认路径/usr/bin/nkd
+func NewNkdExecutor(binPath string) *NkdExecutor {
+	if len(binPath) == 0 {
+		binPath = os.Getenv(constValue.NkdPathEnv)
+	}
+	if len(binPath) == 0 {
+		binPath = constValue.NkdPath
+	}
+	return &NkdExecutor{BinPath: binPath}
+}
+
+func (e *NkdExecutor) Deploy(ctx context.Context, configFile string, output io.Writer) error {
+	return e.run(ctx, output, constValue.NkdVerbDeploy, "-f", configFile)
+}
+
+func (e *NkdExecutor) Destroy(ctx context.Context, clusterID string, output io.Writer) error {
+	return e.run(ctx, output, constValue.NkdVerbDestroy, "--cluster-id", clusterID)
+}
+
+func (e *NkdExecutor) Extend(ctx context.Context, clusterID string, num int, output io.Writer) error {
+	return e.run(ctx, output, constValue.NkdVerbExtend, "--cluster-id", clusterID, "-n", strconv.Itoa(num))
+}
+
+// Upgrade 升级集群的kubernetes版本，nkd先升级控制面节点再逐个升级worker节点
+// nkd upgrade --cluster-id <id> --kube-version <version>，upgrade子命令由NestOS-kubernetes-deployer提供，
+// 旧版本nkd没有该子命令，执行前先检查
+func (e *NkdExecutor) Upgrade(ctx context.Context, clusterID string, version string, output io.Writer) error {
+	if err := e.checkVerb(ctx, constValue.NkdVerbUpgrade, "--kube-version"); err != nil {
+		fmt.Fprintln(output, err.Error())
+		return err
+	}
+	return e.run(ctx, output, constValue.NkdVerbUpgrade, "--cluster-id", clusterID, "--kube-version", version)
+}
+
+// Shrink 释放已从集群中删除的worker节点对应的libvirt/OpenStack虚拟机
+// nkd shrink --cluster-id <id> --nodes <name1,name2>，旧版本nkd没有该子命令，执行前先检查
+func (e *NkdExecutor) Shrink(ctx context.Context, clusterID string, nodes []string, output io.Writer) error {
+	if err := e.checkVerb(ctx, constValue.NkdVerbShrink, "--nodes"); err != nil {
+		fmt.Fprintln(output, err.Error())
+		return err
+	}
+	return e.run(ctx, output, constValue.NkdVerbShrink, "--cluster-id", clusterID, "--nodes", strings.Join(nodes, ","))
+}
+
+// checkVerb 通过nkd <verb> --help检查nkd是否支持子命令及参数
+func (e *NkdExecutor) checkVerb(ctx context.Context, verb string, flags ...string) error {
+	help, err := exec.CommandContext(ctx, e.BinPath, verb, "--help").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s %s", ErrUnsupportedVerb, e.BinPath, verb)
+	}
+	for _, flag := range flags {
+		if !bytes.Contains(help, []byte(flag)) {
+			return fmt.Errorf("%w: %s %s %s", ErrUnsupportedVerb, e.BinPath, verb, flag)
+		}
+	}
+	return nil
+}
+
+func (e *NkdExecutor) run(ctx context.Context, output io.Writer, args ...string) error {
+	cmd := exec.Command(e.BinPath, args...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// nkd会拉起子进程，放到独立的进程组中，取消时整组终止
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return runProcessGroup(ctx, cmd)
+}
+
+/**
+* @Description: 执行命令，ctx结束时先向进程组发送SIGTERM，
+* 超过NkdKillGracePeriod仍未退出再发送SIGKILL
+*
+ */
+
+func runProcessGroup(ctx context.Context, cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	pgid := -cmd.Process.Pid
+	_ = syscall.Kill(pgid, syscall.SIGTERM)
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(constValue.NkdKillGracePeriod):
+		_ = syscall.Kill(pgid, syscall.SIGKILL)
+		return <-done
+	}
+}
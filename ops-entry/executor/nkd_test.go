@@ -0,0 +1,78 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeNkd 生成一个模拟nkd的脚本
+func fakeNkd(t *testing.T, script string) string {
+	path := filepath.Join(t.TempDir(), "nkd")
+	err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755)
+	assert.Nil(t, err)
+	return path
+}
+
+func TestNkdExecutor(t *testing.T) {
+	t.Run("bin path", func(t *testing.T) {
+		t.Setenv("KUBEMATE_NKD_PATH", "/opt/nkd/bin/nkd")
+		assert.Equal(t, "/opt/nkd/bin/nkd", NewNkdExecutor("").BinPath)
+		assert.Equal(t, "/tmp/nkd", NewNkdExecutor("/tmp/nkd").BinPath)
+	})
+
+	t.Run("output", func(t *testing.T) {
+		e := NewNkdExecutor(fakeNkd(t, `echo "$@"; echo failed >&2; exit 2`))
+		var output bytes.Buffer
+		err := e.Extend(context.Background(), "cluster", 3, &output)
+		assert.Equal(t, 2, ExitCode(err))
+		assert.Equal(t, "extend --cluster-id cluster -n 3\nfailed\n", output.String())
+	})
+
+	t.Run("process group", func(t *testing.T) {
+		e := NewNkdExecutor(fakeNkd(t, "sleep 10 & sleep 10"))
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		err := e.Destroy(ctx, "cluster", &bytes.Buffer{})
+		assert.Equal(t, -1, ExitCode(err))
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("upgrade", func(t *testing.T) {
+		e := NewNkdExecutor(fakeNkd(t, `if [ "$2" = "--help" ]; then echo "--cluster-id --kube-version"; exit 0; fi; echo "$@"`))
+		var output bytes.Buffer
+		assert.Nil(t, e.Upgrade(context.Background(), "cluster", "v1.29.1", &output))
+		assert.Equal(t, "upgrade --cluster-id cluster --kube-version v1.29.1\n", output.String())
+
+		e = NewNkdExecutor(fakeNkd(t, `if [ "$2" = "--help" ]; then echo "unknown command \"$1\""; exit 1; fi; echo "$@"`))
+		output.Reset()
+		err := e.Upgrade(context.Background(), "cluster", "v1.29.1", &output)
+		assert.True(t, errors.Is(err, ErrUnsupportedVerb))
+		assert.NotContains(t, output.String(), "--cluster-id")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		err := NewNkdExecutor("/not/exist/nkd").Deploy(context.Background(), "cluster.yaml", &bytes.Buffer{})
+		assert.Equal(t, -1, ExitCode(err))
+	})
+}
@@ -12,14 +12,25 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"ops-entry/common/util"
 	"ops-entry/constValue"
 	"ops-entry/db"
+	"ops-entry/db/configManager"
+	"ops-entry/db/configManager/config"
+	"ops-entry/executor"
 	"ops-entry/log"
 	router2 "ops-entry/router"
+	"ops-entry/service"
+	"os"
+	"path/filepath"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 //	@title			统一运维入口
@@ -37,12 +48,78 @@ import (
 //	@host		0.0.0.0:9090
 
 func main() {
+	rotateKey := flag.Bool("rotate-encryption-key", false, "re-encrypt all kubeconfigs and cluster configs with the current encryption key and exit")
+	operator := flag.Bool("operator", false, "reconcile KubeMateCluster and OSUpgrade resources")
+	flag.Parse()
+
 	log.InitLog()
 	err := db.InitDb()
 	if err != nil {
 		logrus.Errorf("init db failed: %s", err.Error())
 		return
 	}
+	clusterStore, kubeStore, err := configStores()
+	if err != nil {
+		logrus.Errorf("init config stores failed: %s", err.Error())
+		return
+	}
+	service.SetConfigStores(clusterStore, kubeStore)
+	if err := service.InitConfigEncryption(); err != nil {
+		logrus.Errorf("init config encryption failed: %s", err.Error())
+		return
+	}
+	if *rotateKey {
+		count, err := service.RotateConfigEncryptionKey(util.CreateContext(""))
+		if err != nil {
+			logrus.Errorf("rotate encryption key failed after %d configs: %s", count, err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("%d configs re-encrypted\n", count)
+		return
+	}
+
+	if db.StorageBackend() == constValue.StorageBackendKubernetes {
+		if count, err := config.AddNameLabels(context.Background(), constValue.NameSpace, service.KubeMateClusterGVR); err != nil {
+			logrus.Errorf("add name labels failed after %d objects: %s", count, err.Error())
+		} else if count > 0 {
+			logrus.Infof("name labels added to %d objects", count)
+		}
+		if count, err := service.MigrateClusterConfigs(util.CreateContext("")); err != nil {
+			logrus.Errorf("migrate cluster configs failed after %d configs: %s", count, err.Error())
+		} else if count > 0 {
+			logrus.Infof("%d cluster configs migrated", count)
+		}
+		var cached []schema.GroupVersionResource
+		if err := service.InstallKubeMateClusterCRD(context.Background()); err != nil {
+			logrus.Errorf("crfile cluster configs are unavailable: %s", err.Error())
+		} else {
+			cached = append(cached, service.KubeMateClusterGVR)
+		}
+		if err := service.InstallOSUpgradeCRD(context.Background()); err != nil {
+			logrus.Errorf("os upgrades are unavailable: %s", err.Error())
+		} else {
+			cached = append(cached, service.OSUpgradeGVR)
+		}
+		if clients, err := configManager.StartCache(context.Background(), configManager.KCS, constValue.NameSpace, cached...); err != nil {
+			logrus.Errorf("informer cache is unavailable, read from api server: %s", err.Error())
+		} else {
+			configManager.KCS = clients
+		}
+		config.StartRevisionGC(context.Background(), constValue.NameSpace, constValue.RetentionGCInterval, service.KubeMateClusterGVR)
+	}
+	service.StartNKDWorkers(executor.NewNkdExecutor(""), constValue.NkdWorkerNum)
+	if *operator {
+		if db.StorageBackend() != constValue.StorageBackendKubernetes {
+			logrus.Errorf("operator mode requires the %s storage backend", constValue.StorageBackendKubernetes)
+		} else {
+			if err := service.StartClusterOperator(context.Background(), configManager.KCS, constValue.NameSpace, constValue.OperatorWorkers); err != nil {
+				logrus.Errorf("start cluster operator failed: %s", err.Error())
+			}
+			if err := service.StartOSUpgradeController(context.Background(), configManager.KCS, constValue.NameSpace, constValue.OperatorWorkers); err != nil {
+				logrus.Errorf("start os upgrade controller failed: %s", err.Error())
+			}
+		}
+	}
 
 	router := router2.NewRouter()
 	listen := fmt.Sprintf("%s:%d", constValue.ListenIP, constValue.ListenPort)
@@ -53,3 +130,20 @@ func main() {
 		logrus.Errorf("listen err: %s", err.Error())
 	}
 }
+
+// configStores 根据启动时选择的存储后端创建集群配置和kubeconfig的存储，kubernetes后端使用secret存储
+func configStores() (configManager.RevisionStore, configManager.RevisionStore, error) {
+	if db.StorageBackend() != constValue.StorageBackendLocal {
+		return config.NewSecretImpl(nil, constValue.NameSpace, ""),
+			config.NewSecretImpl(nil, constValue.NameSpace, corev1.ServiceAccountKubeconfigKey), nil
+	}
+	clusterStore, err := config.NewFileStore(filepath.Join(db.StorageDir(), constValue.ClusterConfigType))
+	if err != nil {
+		return nil, nil, err
+	}
+	kubeStore, err := config.NewFileStore(filepath.Join(db.StorageDir(), constValue.KubeconfigType))
+	if err != nil {
+		return nil, nil, err
+	}
+	return clusterStore, kubeStore, nil
+}
@@ -0,0 +1,33 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package models
+
+// ClusterConfig 表示nkd部署集群使用的集群配置文件
+type ClusterConfig struct {
+	// ClusterID 是集群的名称
+	ClusterID string `json:"cluster_id,omitempty"`
+	// Master 包含了全部master节点
+	Master []KubernetesMasterNode `json:"master,omitempty"`
+	// Worker 包含了全部worker节点
+	Worker []KubernetesWorkerNode `json:"worker,omitempty"`
+	// Kubernetes 是集群级别的Kubernetes配置
+	Kubernetes KubernetesConfig `json:"kubernetes,omitempty"`
+	Os         Os               `json:"os,omitempty"`
+}
+
+// KubeMateClusterSpec 表示KubeMateCluster CR的spec，密码不保存在spec中
+type KubeMateClusterSpec struct {
+	ClusterConfig `json:",inline"`
+	// CredentialsSecret 是保存OpenStack密码的secret名称，secret与CR在同一命名空间
+	CredentialsSecret string `json:"credentials_secret,omitempty"`
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package models
+
+import (
+	"path"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// OSUpgradeSpec 表示OSUpgrade CR的spec，在被管理集群的节点上执行universal_os_upgrader
+type OSUpgradeSpec struct {
+	// ClusterID 是被管理集群的名称，集群的kubeconfig需要已上传
+	ClusterID string `json:"cluster_id,omitempty"`
+	// NodeSelector 选择需要升级的节点，为空时升级全部节点
+	NodeSelector map[string]string `json:"node_selector,omitempty"`
+	// Repo 是升级使用的yum repo文件内容
+	Repo string `json:"repo,omitempty"`
+	// Backup 是升级前备份系统的目标
+	Backup OSBackupTarget `json:"backup,omitempty"`
+	// Concurrency 是同时升级的节点数，默认为1
+	Concurrency int64 `json:"concurrency,omitempty"`
+	// Image 是执行升级的Job使用的镜像，需要包含sh和nsenter
+	Image string `json:"image,omitempty"`
+}
+
+// OSBackupTarget 与universal_os_upgrader的backup.yaml一致
+type OSBackupTarget struct {
+	NfsServer string `json:"nfs_server,omitempty"`
+	NfsPath   string `json:"nfs_path,omitempty"`
+}
+
+/**
+* @Description: OSUpgrade spec的语义校验
+* return
+*   @resp 全部不合法的字段
+*
+ */
+
+func (s *OSUpgradeSpec) Validate() field.ErrorList {
+	var allErrs field.ErrorList
+	if len(s.ClusterID) == 0 {
+		allErrs = append(allErrs, field.Required(field.NewPath("cluster_id"), "cluster id is required"))
+	}
+	if len(s.Repo) == 0 {
+		allErrs = append(allErrs, field.Required(field.NewPath("repo"), "repo is required"))
+	}
+
+	backupPath := field.NewPath("backup")
+	if len(s.Backup.NfsServer) == 0 {
+		allErrs = append(allErrs, field.Required(backupPath.Child("nfs_server"), "nfs server is required"))
+	}
+	if !path.IsAbs(s.Backup.NfsPath) {
+		allErrs = append(allErrs, field.Invalid(backupPath.Child("nfs_path"), s.Backup.NfsPath, "must be an absolute path"))
+	}
+	if s.Concurrency < 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("concurrency"), s.Concurrency, "must not be negative"))
+	}
+	return allErrs
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package models
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+/**
+* @Description: 集群配置的语义校验
+* return
+*   @resp 全部不合法的字段，字段路径与配置文件中的字段名一致，如master[0].libvirtConfig.gateway
+*
+ */
+
+func (c *ClusterConfig) Validate() field.ErrorList {
+	var allErrs field.ErrorList
+
+	kubernetesPath := field.NewPath("kubernetes")
+	if len(c.Kubernetes.ImageRegistry) == 0 {
+		allErrs = append(allErrs, field.Required(kubernetesPath.Child("image_registry"), "image registry is required"))
+	}
+	allErrs = append(allErrs, c.Kubernetes.validate(kubernetesPath)...)
+
+	names := make(map[string]bool)
+	ips := make(map[string]bool)
+	for i := range c.Master {
+		node := &c.Master[i]
+		path := field.NewPath("master").Index(i)
+		allErrs = append(allErrs, validateNode(path, node.Name, node.IP, names, ips)...)
+		if node.Port < 1 || node.Port > 65535 {
+			allErrs = append(allErrs, field.Invalid(path.Child("port"), node.Port, "must be between 1 and 65535"))
+		}
+		allErrs = append(allErrs, node.LibvirtConfig.validate(path.Child("libvirtConfig"))...)
+		allErrs = append(allErrs, node.OpenStack.validate(path.Child("open_stack"))...)
+		allErrs = append(allErrs, node.KubernetesConfig.validate(path.Child("kubernetesConfig"))...)
+	}
+	for i := range c.Worker {
+		node := &c.Worker[i]
+		path := field.NewPath("worker").Index(i)
+		allErrs = append(allErrs, validateNode(path, node.Name, node.IP, names, ips)...)
+		allErrs = append(allErrs, node.LibvirtConfig.validate(path.Child("libvirtConfig"))...)
+		allErrs = append(allErrs, node.OpenStack.validate(path.Child("open_stack"))...)
+		allErrs = append(allErrs, node.KubernetesConfig.validate(path.Child("kubernetesConfig"))...)
+	}
+	return allErrs
+}
+
+// validateNode 校验节点名称和IP，master和worker之间也不能重复
+func validateNode(path *field.Path, name, ip string, names, ips map[string]bool) field.ErrorList {
+	var allErrs field.ErrorList
+	if len(name) > 0 {
+		if names[name] {
+			allErrs = append(allErrs, field.Duplicate(path.Child("name"), name))
+		}
+		names[name] = true
+	}
+	if len(ip) > 0 {
+		if net.ParseIP(ip) == nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("ip"), ip, "must be a valid IP address"))
+		} else if ips[ip] {
+			allErrs = append(allErrs, field.Duplicate(path.Child("ip"), ip))
+		}
+		ips[ip] = true
+	}
+	return allErrs
+}
+
+func (k *KubernetesConfig) validate(path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if len(k.ApiserverEndpoint) > 0 {
+		allErrs = append(allErrs, validateEndpoint(path.Child("apiserver_endpoint"), k.ApiserverEndpoint)...)
+	}
+
+	networkPath := path.Child("network")
+	podSubnet := parseCIDR(networkPath.Child("pod_subnet"), k.Network.PodSubnet, &allErrs)
+	serviceSubnet := parseCIDR(networkPath.Child("service_subnet"), k.Network.ServiceSubnet, &allErrs)
+	if podSubnet != nil && serviceSubnet != nil &&
+		(podSubnet.Contains(serviceSubnet.IP) || serviceSubnet.Contains(podSubnet.IP)) {
+		allErrs = append(allErrs, field.Invalid(networkPath.Child("service_subnet"), k.Network.ServiceSubnet,
+			fmt.Sprintf("overlaps with pod_subnet %s", k.Network.PodSubnet)))
+	}
+	return allErrs
+}
+
+func (l *LibvirtConfig) validate(path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	cidr := parseCIDR(path.Child("cidr"), l.Cidr, &allErrs)
+	if len(l.Gateway) == 0 {
+		return allErrs
+	}
+
+	gateway := net.ParseIP(l.Gateway)
+	if gateway == nil {
+		allErrs = append(allErrs, field.Invalid(path.Child("gateway"), l.Gateway, "must be a valid IP address"))
+	} else if cidr != nil && !cidr.Contains(gateway) {
+		allErrs = append(allErrs, field.Invalid(path.Child("gateway"), l.Gateway, fmt.Sprintf("must be within cidr %s", l.Cidr)))
+	}
+	return allErrs
+}
+
+// validate 未使用OpenStack时不校验，使用时除availability_none外均为必填，auth_url需为http(s)地址
+func (o *OpenStackConfig) validate(path *field.Path) field.ErrorList {
+	if *o == (OpenStackConfig{}) {
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	required := []struct {
+		name  string
+		value string
+	}{
+		{"user_name", o.UserName},
+		{"password", o.Password},
+		{"tenant_name", o.TenantName},
+		{"auth_url", o.AuthURL},
+		{"region", o.Region},
+		{"internal_network", o.InternalNetwork},
+		{"external_network", o.ExternalNetwork},
+		{"glance_name", o.GlanceName},
+	}
+	for _, r := range required {
+		if len(r.value) == 0 {
+			allErrs = append(allErrs, field.Required(path.Child(r.name), r.name+" is required"))
+		}
+	}
+	if len(o.AuthURL) > 0 {
+		allErrs = append(allErrs, validateURL(path.Child("auth_url"), o.AuthURL)...)
+	}
+	return allErrs
+}
+
+// validateURL 校验http(s)地址，主机为IP时需为合法的IP，指定端口时端口需合法
+func validateURL(path *field.Path, value string) field.ErrorList {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Hostname()) == 0 {
+		return field.ErrorList{field.Invalid(path, value, "must be a http or https URL")}
+	}
+	if strings.Trim(u.Hostname(), "0123456789.") == "" && net.ParseIP(u.Hostname()) == nil {
+		return field.ErrorList{field.Invalid(path, value, "must be a valid IP address or host name")}
+	}
+	if port := u.Port(); len(port) > 0 {
+		if num, err := strconv.Atoi(port); err != nil || num < 1 || num > 65535 {
+			return field.ErrorList{field.Invalid(path, value, "port must be between 1 and 65535")}
+		}
+	}
+	return nil
+}
+
+// validateEndpoint 校验host:port形式的地址
+func validateEndpoint(path *field.Path, endpoint string) field.ErrorList {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil || len(host) == 0 {
+		return field.ErrorList{field.Invalid(path, endpoint, "must be in the form of host:port")}
+	}
+	if num, err := strconv.Atoi(port); err != nil || num < 1 || num > 65535 {
+		return field.ErrorList{field.Invalid(path, endpoint, "port must be between 1 and 65535")}
+	}
+	return nil
+}
+
+// parseCIDR 解析可选的CIDR，不合法时记录错误并返回nil
+func parseCIDR(path *field.Path, value string, allErrs *field.ErrorList) *net.IPNet {
+	if len(value) == 0 {
+		return nil
+	}
+	_, ipNet, err := net.ParseCIDR(value)
+	if err != nil {
+		*allErrs = append(*allErrs, field.Invalid(path, value, "must be a valid CIDR"))
+		return nil
+	}
+	return ipNet
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestClusterConfigValidate(t *testing.T) {
+	valid := func() *ClusterConfig {
+		return &ClusterConfig{
+			ClusterID: "cluster",
+			Master: []KubernetesMasterNode{{
+				Name: "k8s-master01",
+				IP:   "192.168.1.10",
+				Port: 6443,
+				LibvirtConfig: LibvirtConfig{
+					Cidr:    "192.168.1.0/24",
+					Gateway: "192.168.1.1",
+				},
+			}},
+			Worker: []KubernetesWorkerNode{{Name: "k8s-worker01", IP: "192.168.1.20"}},
+			Kubernetes: KubernetesConfig{
+				Version:           "v1.29.1",
+				ApiserverEndpoint: "192.168.1.10:6443",
+				ImageRegistry:     "k8s.gcr.io",
+				Network: KubernetesNetwork{
+					ServiceSubnet: "10.96.0.0/16",
+					PodSubnet:     "10.244.0.0/16",
+				},
+			},
+		}
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		assert.Empty(t, valid().Validate())
+
+		conf := valid()
+		conf.Worker[0].OpenStack = OpenStackConfig{
+			UserName:        "admin",
+			Password:        "secret",
+			TenantName:      "admin",
+			AuthURL:         "http://192.168.1.5:5000/v3",
+			Region:          "RegionOne",
+			InternalNetwork: "internal",
+			ExternalNetwork: "external",
+			GlanceName:      "nestos",
+		}
+		assert.Empty(t, conf.Validate())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		conf := valid()
+		conf.Kubernetes.ImageRegistry = ""
+		conf.Kubernetes.ApiserverEndpoint = "192.168.1.10:70000"
+		conf.Kubernetes.Network.ServiceSubnet = "10.244.128.0/20"
+		conf.Master[0].Port = 0
+		conf.Master[0].OpenStack = OpenStackConfig{UserName: "admin", AuthURL: "http://192.168.1.300:5000/v3"}
+		conf.Master[0].LibvirtConfig.Gateway = "10.0.1.1"
+		conf.Worker = append(conf.Worker,
+			KubernetesWorkerNode{Name: "k8s-master01", IP: "192.168.1.10"},
+			KubernetesWorkerNode{Name: "k8s-worker02", IP: "192.168.1.300"})
+
+		var fields []string
+		for _, err := range conf.Validate() {
+			fields = append(fields, err.Field+" "+string(err.Type))
+		}
+		assert.ElementsMatch(t, []string{
+			"kubernetes.image_registry " + string(field.ErrorTypeRequired),
+			"kubernetes.apiserver_endpoint " + string(field.ErrorTypeInvalid),
+			"kubernetes.network.service_subnet " + string(field.ErrorTypeInvalid),
+			"master[0].port " + string(field.ErrorTypeInvalid),
+			"master[0].libvirtConfig.gateway " + string(field.ErrorTypeInvalid),
+			"master[0].open_stack.password " + string(field.ErrorTypeRequired),
+			"master[0].open_stack.tenant_name " + string(field.ErrorTypeRequired),
+			"master[0].open_stack.region " + string(field.ErrorTypeRequired),
+			"master[0].open_stack.internal_network " + string(field.ErrorTypeRequired),
+			"master[0].open_stack.external_network " + string(field.ErrorTypeRequired),
+			"master[0].open_stack.glance_name " + string(field.ErrorTypeRequired),
+			"master[0].open_stack.auth_url " + string(field.ErrorTypeInvalid),
+			"worker[1].name " + string(field.ErrorTypeDuplicate),
+			"worker[1].ip " + string(field.ErrorTypeDuplicate),
+			"worker[2].ip " + string(field.ErrorTypeInvalid),
+		}, fields)
+	})
+}
@@ -0,0 +1,45 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package proto
+
+import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+// ClusterPhase KubeMateCluster所处的阶段
+type ClusterPhase string
+
+const (
+	ClusterDeploying  ClusterPhase = "Deploying"
+	ClusterExtending  ClusterPhase = "Extending"
+	ClusterReady      ClusterPhase = "Ready"
+	ClusterDestroying ClusterPhase = "Destroying"
+	ClusterFailed     ClusterPhase = "Failed"
+	ClusterStored     ClusterPhase = "Stored" // 上传集群配置时生成的记录，不会被operator处理
+)
+
+// KubeMateClusterStatus KubeMateCluster的status，operator模式下记录部署进度
+type KubeMateClusterStatus struct {
+	Phase              ClusterPhase       `json:"phase,omitempty"`
+	Verb               string             `json:"verb,omitempty"`               // 最近一次提交的nkd子命令
+	JobId              string             `json:"jobId,omitempty"`              // 执行中的nkd任务
+	Workers            int64              `json:"workers,omitempty"`            // 已部署的worker节点数
+	TargetWorkers      int64              `json:"targetWorkers,omitempty"`      // 执行中的任务成功后的worker节点数
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"` // 最近一次提交任务时spec的generation
+	Message            string             `json:"message,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+	LastOperation      *OperationResult   `json:"lastOperation,omitempty"`
+}
+
+type KubeMateClusterStatusResult struct {
+	BaseResult
+	Data *KubeMateClusterStatus `json:"data"`
+}
@@ -9,11 +9,20 @@ package proto
 
 import (
 	"mime/multipart"
+	"ops-entry/models"
+	"time"
 )
 
 // FileResult 接口响应数据
 type FileResult struct {
 	BaseResult
+	Violations []FieldViolation `json:"violations,omitempty"`
+}
+
+// FieldViolation 集群配置中不合法的字段
+type FieldViolation struct {
+	Field  string `json:"field" example:"master[0].libvirtConfig.gateway"`
+	Detail string `json:"detail" example:"Invalid value: \"10.0.1.1\": must be within cidr 192.168.1.0/24"`
 }
 
 // KubeConfigResult kubeconfig文件数据
@@ -50,4 +59,109 @@ type FileUpdateParam struct {
 	ClusterId string                `json:"cluster_id" form:"cluster_id" example:"k8s-001" description:"The name of k8s"`
 	Labels    string                `json:"labels" form:"labels" example:"{\"version\":\"v0.1\",\"environment\":\"prod\"}" description:"A JSON string representing labels for the update file"`
 	File      *multipart.FileHeader `json:"-" form:"file" swagger:"file" description:"The file to update"`
+	IfMatch   string                `json:"-" form:"-"` // If-Match请求头中ETag对应的resourceVersion
+}
+
+// swagger:proto ClusterConfigGenerateParam
+type ClusterConfigGenerateParam struct {
+	ClusterId string               `json:"cluster_id" binding:"required" example:"k8s-001" description:"The name of k8s"`
+	Labels    string               `json:"labels" example:"{\"version\":\"v0.1\"}" description:"A JSON string representing labels for the generated file"`
+	Config    models.ClusterConfig `json:"config" description:"The cluster config, unset fields are filled with defaults"`
+}
+
+// ClusterConfigGenerateResult 生成的集群配置
+type ClusterConfigGenerateResult struct {
+	BaseResult
+	Violations []FieldViolation `json:"violations,omitempty"`
+	Data       string           `json:"data" description:"The generated NKD cluster config in YAML"`
+}
+
+// ConfigRevision clusterconfig/kubeconfig的一个历史版本
+type ConfigRevision struct {
+	Revision     int       `json:"revision" example:"3"`
+	Name         string    `json:"name" example:"k8s-001-clusterconfig"`
+	Cause        string    `json:"cause" example:"rollback to 1"`
+	CreationTime time.Time `json:"creation_time"`
+	Data         string    `json:"data,omitempty" description:"Base64 encoded content of the revision"`
+}
+
+type ConfigRevisionListResult struct {
+	BaseResult
+	Data []ConfigRevision `json:"data"`
+}
+
+type ConfigRevisionResult struct {
+	BaseResult
+	Data *ConfigRevision `json:"data"`
+}
+
+// swagger:proto ConfigRollbackParam
+type ConfigRollbackParam struct {
+	Labels   string `json:"labels" example:"{\"version\":\"v0.1\"}" description:"A JSON string representing labels of the cluster config, ignored for kubeconfig"`
+	Revision int    `json:"revision" binding:"required" example:"1" description:"The revision to roll back to"`
+}
+
+type ConfigRollbackResult struct {
+	BaseResult
+	Revision int `json:"revision" example:"4" description:"The new revision recording the rollback"`
+}
+
+// ConfigDiffType 字段的变化类型
+type ConfigDiffType string
+
+const (
+	ConfigDiffAdded   ConfigDiffType = "added"
+	ConfigDiffRemoved ConfigDiffType = "removed"
+	ConfigDiffChanged ConfigDiffType = "changed"
+)
+
+// ConfigDiffEntry 一个字段的变化，凭据字段的值为******
+type ConfigDiffEntry struct {
+	Path string         `json:"path" example:"master[0].ip"`
+	Type ConfigDiffType `json:"type" example:"changed"`
+	From interface{}    `json:"from,omitempty"`
+	To   interface{}    `json:"to,omitempty"`
+}
+
+// ConfigDiff 两个集群配置版本之间的差异
+type ConfigDiff struct {
+	From    int               `json:"from" example:"1"`
+	To      int               `json:"to" example:"2" description:"0 means the uploaded candidate"`
+	Changes []ConfigDiffEntry `json:"changes"`
+}
+
+type ConfigDiffResult struct {
+	BaseResult
+	Data *ConfigDiff `json:"data"`
+}
+
+// swagger:proto ConfigListParam
+type ConfigListParam struct {
+	LabelSelector string `form:"label_selector" example:"environment=prod,version in (v0.1,v0.2)" description:"Kubernetes label selector on the labels of the uploaded file"`
+	SortBy        string `form:"sort_by" example:"update_time" description:"Sort by name, creation_time or update_time, default name"`
+	Order         string `form:"order" example:"desc" description:"asc or desc, default asc"`
+	Limit         int    `form:"limit" example:"20" description:"Max number of items to return"`
+	Continue      string `form:"continue" description:"The continue token returned by the previous page"`
+}
+
+// ConfigSummary 一个集群的kubeconfig或集群配置
+type ConfigSummary struct {
+	ClusterId    string            `json:"cluster_id" example:"k8s-001"`
+	Name         string            `json:"name" example:"k8s-001-clusterconfig"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	CreationTime time.Time         `json:"creation_time"`
+	UpdateTime   time.Time         `json:"update_time"`
+	Revisions    int               `json:"revisions" example:"3"`
+	Size         int               `json:"size" example:"2048" description:"Size of the file in bytes"`
+}
+
+type ConfigList struct {
+	Total    int             `json:"total"`
+	Items    []ConfigSummary `json:"items"`
+	Continue string          `json:"continue,omitempty" description:"Pass as continue to get the next page, empty on the last page"`
+}
+
+type ConfigListResult struct {
+	BaseResult
+	Data *ConfigList `json:"data"`
 }
@@ -12,8 +12,11 @@
 
 package proto
 
+import "time"
+
 type NKDResult struct {
 	BaseResult
+	JobId string `json:"job_id"`
 }
 
 type NKDParam struct {
@@ -33,3 +36,88 @@ type NKDExtendParam struct {
 	NKDParam
 	Num string `json:"num" binding:"required" form:"num" example:"1" description:"Number of nodes to extend"`
 }
+
+type NKDShrinkParam struct {
+	NKDParam
+	Nodes []string `json:"nodes" binding:"required" example:"worker1,worker2" description:"Names of the worker nodes to remove"`
+}
+
+type NKDUpgradeParam struct {
+	NKDParam
+	Labels  string `json:"labels" form:"labels" example:"{\"version\":\"v0.1\"}" description:"A JSON string representing labels of the cluster config"`
+	Version string `json:"version" binding:"required" example:"v1.29.1" description:"Target kubernetes version"`
+}
+
+// NKDJobPhase nkd任务所处的阶段
+type NKDJobPhase string
+
+const (
+	NKDJobPending   NKDJobPhase = "pending"
+	NKDJobRunning   NKDJobPhase = "running"
+	NKDJobSucceeded NKDJobPhase = "succeeded"
+	NKDJobFailed    NKDJobPhase = "failed"
+	NKDJobCancelled NKDJobPhase = "cancelled"
+)
+
+// NKDJobInfo nkd任务的执行情况
+type NKDJobInfo struct {
+	JobId     string      `json:"job_id" example:"nkd-1722047933000000000-1024"`
+	ClusterID string      `json:"cluster_id" example:"cluster"`
+	Verb      string      `json:"verb" example:"deploy"`
+	Phase     NKDJobPhase `json:"phase" example:"running"`
+	StartTime *time.Time  `json:"start_time,omitempty"`
+	EndTime   *time.Time  `json:"end_time,omitempty"`
+	ExitCode  int         `json:"exit_code"`
+	Reason    string      `json:"reason,omitempty" example:"timeout after 2h0m0s"`
+	Output    string      `json:"output"`
+}
+
+type NKDJobResult struct {
+	BaseResult
+	Data *NKDJobInfo `json:"data"`
+}
+
+// NKDJobLog nkd任务的输出日志，Offset为下一次读取的起始行号
+type NKDJobLog struct {
+	JobId    string   `json:"job_id" example:"nkd-1722047933000000000-1024"`
+	Lines    []string `json:"lines"`
+	Offset   int      `json:"offset" example:"20"`
+	Finished bool     `json:"finished"`
+}
+
+type NKDJobLogResult struct {
+	BaseResult
+	Data *NKDJobLog `json:"data"`
+}
+
+type NKDJobLogParam struct {
+	Follow bool `form:"follow" example:"true" description:"Keep the connection open and stream new lines as server-sent events"`
+	Offset int  `form:"offset" example:"0" description:"Number of lines already read, streaming resumes after it"`
+}
+
+// NKDHistoryRecord nkd任务的执行记录
+type NKDHistoryRecord struct {
+	NKDJobInfo
+	Num       int      `json:"num,omitempty"`
+	Nodes     []string `json:"nodes,omitempty"`
+	Version   string   `json:"version,omitempty"`
+	Labels    string   `json:"labels,omitempty" example:"{\"version\":\"v0.1\"}"`
+	RequestId string   `json:"request_id"`
+	Requester string   `json:"requester" example:"10.0.0.1"`
+}
+
+type NKDHistoryList struct {
+	Total int                `json:"total"`
+	Items []NKDHistoryRecord `json:"items"`
+}
+
+type NKDHistoryResult struct {
+	BaseResult
+	Data *NKDHistoryList `json:"data"`
+}
+
+type NKDHistoryParam struct {
+	ClusterID string `form:"cluster_id" example:"cluster" description:"Only return records of the kubernetes cluster"`
+	Limit     int    `form:"limit" example:"20" description:"Max number of records to return"`
+	Offset    int    `form:"offset" example:"0" description:"Number of records to skip"`
+}
@@ -0,0 +1,45 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package proto
+
+import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+// OSUpgradePhase OSUpgrade及其中每个节点所处的阶段
+type OSUpgradePhase string
+
+const (
+	OSUpgradePending   OSUpgradePhase = "Pending"
+	OSUpgradeRunning   OSUpgradePhase = "Running"
+	OSUpgradeSucceeded OSUpgradePhase = "Succeeded"
+	OSUpgradeFailed    OSUpgradePhase = "Failed"
+)
+
+// OSUpgradeStatus OSUpgrade的status，Nodes在开始升级时根据node_selector确定
+type OSUpgradeStatus struct {
+	Phase              OSUpgradePhase        `json:"phase,omitempty"`
+	Nodes              []OSUpgradeNodeStatus `json:"nodes,omitempty"`
+	Succeeded          int64                 `json:"succeeded,omitempty"` // 升级成功的节点数
+	Failed             int64                 `json:"failed,omitempty"`    // 升级失败的节点数
+	ObservedGeneration int64                 `json:"observedGeneration,omitempty"`
+	Message            string                `json:"message,omitempty"`
+	Conditions         []metav1.Condition    `json:"conditions,omitempty"`
+	LastOperation      *OperationResult      `json:"lastOperation,omitempty"`
+}
+
+// OSUpgradeNodeStatus 一个节点的升级结果
+type OSUpgradeNodeStatus struct {
+	Node    string         `json:"node"`
+	Phase   OSUpgradePhase `json:"phase"`
+	Job     string         `json:"job,omitempty"` // 被管理集群中执行升级的Job
+	Message string         `json:"message,omitempty"`
+}
@@ -0,0 +1,37 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package proto
+
+import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+// KubeMate CR的status中通用的conditions
+const (
+	ConditionReady       = "Ready"       // 资源处于可用状态
+	ConditionProgressing = "Progressing" // 有执行中的操作
+	ConditionDegraded    = "Degraded"    // 最近一次操作失败或spec不合法
+)
+
+// OperationSucceeded、OperationFailed 最近一次操作的结果
+const (
+	OperationSucceeded = "Succeeded"
+	OperationFailed    = "Failed"
+)
+
+// OperationResult KubeMate CR最近一次结束的操作
+type OperationResult struct {
+	Operation string      `json:"operation"` // nkd子命令、upgrade或upload
+	Result    string      `json:"result"`
+	JobId     string      `json:"jobId,omitempty"`
+	Message   string      `json:"message,omitempty"`
+	Time      metav1.Time `json:"time"`
+}
@@ -0,0 +1,42 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package proto
+
+// /watch/clusters推送的事件类型
+const (
+	WatchEventAdded    = "added"
+	WatchEventUpdated  = "updated"
+	WatchEventDeleted  = "deleted"
+	WatchEventBookmark = "bookmark" // 没有变化，只更新resource_version
+	WatchEventReset    = "reset"    // 之后的added事件为全部对象，客户端需要丢弃之前的状态
+	WatchEventError    = "error"    // 推送结束
+)
+
+// WatchKindCluster KubeMateCluster CR，kubeconfig和集群配置的Kind为对应的类型
+const WatchKindCluster = "cluster"
+
+type WatchParam struct {
+	ResourceVersion string `form:"resource_version" description:"The resource_version of the last received event, streaming resumes after it"`
+}
+
+// WatchEvent kubeconfig、集群配置或KubeMateCluster CR的变化，不包含配置内容
+type WatchEvent struct {
+	Type            string            `json:"type" example:"updated"`
+	Kind            string            `json:"kind,omitempty" example:"clusterconfig"`
+	ClusterId       string            `json:"cluster_id,omitempty" example:"k8s-001"`
+	Name            string            `json:"name,omitempty" example:"k8s-001-clusterconfig"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Phase           string            `json:"phase,omitempty" example:"Ready" description:"Phase of a cluster"`
+	Message         string            `json:"message,omitempty"`
+	ResourceVersion string            `json:"resource_version" description:"Pass as resource_version or Last-Event-ID to resume after this event"`
+}
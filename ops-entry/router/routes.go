@@ -41,18 +41,30 @@ func NewRouter() *gin.Engine {
 	// api for file
 	kubeconfigRouter := router.Group("/kubeconfig")
 	{
+		kubeconfigRouter.GET("", controllers.KubeconfigListHandler)
 		kubeconfigRouter.POST("/upload", controllers.KubeconfigFileUploadHandler)
 		kubeconfigRouter.DELETE("/:cluster_id", controllers.KubeconfigFileDeleteHandler)
 		kubeconfigRouter.GET("/:cluster_id", controllers.KubeconfigFileQueryHandler)
 		kubeconfigRouter.PUT("/update", controllers.KubeconfigFileUpdateHandler)
+		kubeconfigRouter.GET("/:cluster_id/revisions", controllers.KubeconfigRevisionListHandler)
+		kubeconfigRouter.GET("/:cluster_id/revisions/:revision", controllers.KubeconfigRevisionQueryHandler)
+		kubeconfigRouter.POST("/:cluster_id/rollback", controllers.KubeconfigRollbackHandler)
 	}
 
 	clusterConfigRouter := router.Group("/clusterconfig")
 	{
+		clusterConfigRouter.GET("", controllers.ClusterconfigListHandler)
 		clusterConfigRouter.POST("/upload", controllers.ClusterconfigFileUploadHandler)
+		clusterConfigRouter.POST("/generate", controllers.ClusterconfigFileGenerateHandler)
 		clusterConfigRouter.DELETE("/:cluster_id", controllers.ClusterconfigFileDeleteHandler)
 		clusterConfigRouter.GET("/:cluster_id", controllers.ClusterconfigFileQueryHandler)
 		clusterConfigRouter.PUT("/update", controllers.ClusterconfigFileUpdateHandler)
+		clusterConfigRouter.GET("/:cluster_id/revisions", controllers.ClusterconfigRevisionListHandler)
+		clusterConfigRouter.GET("/:cluster_id/revisions/:revision", controllers.ClusterconfigRevisionQueryHandler)
+		clusterConfigRouter.POST("/:cluster_id/rollback", controllers.ClusterconfigRollbackHandler)
+		clusterConfigRouter.GET("/:cluster_id/diff", controllers.ClusterconfigDiffHandler)
+		clusterConfigRouter.POST("/:cluster_id/diff", controllers.ClusterconfigCandidateDiffHandler)
+		clusterConfigRouter.GET("/:cluster_id/status", controllers.ClusterconfigStatusHandler)
 	}
 
 	// api for nkd
@@ -61,6 +73,18 @@ func NewRouter() *gin.Engine {
 		nkdRouter.POST("/deploy", controllers.NKDDeployHandler)
 		nkdRouter.DELETE("/destroy", controllers.NKDDeleteHandler)
 		nkdRouter.POST("/extend", controllers.NKDExtendHandler)
+		nkdRouter.POST("/shrink", controllers.NKDShrinkHandler)
+		nkdRouter.POST("/upgrade", controllers.NKDUpgradeHandler)
+		nkdRouter.GET("/jobs/:id", controllers.NKDJobQueryHandler)
+		nkdRouter.DELETE("/jobs/:id", controllers.NKDJobCancelHandler)
+		nkdRouter.GET("/jobs/:id/logs", controllers.NKDJobLogHandler)
+		nkdRouter.GET("/history", controllers.NKDHistoryHandler)
+	}
+
+	// api for watching changes
+	watchRouter := router.Group("/watch")
+	{
+		watchRouter.GET("/clusters", controllers.WatchClustersHandler)
 	}
 
 	return router
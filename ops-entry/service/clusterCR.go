@@ -0,0 +1,243 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+package service
+
+import (
+	"context"
+	"errors"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"ops-entry/db/configManager/config"
+	"ops-entry/models"
+	"ops-entry/proto"
+	"reflect"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// KubeMateClusterGVR KubeMateCluster CR的资源
+var KubeMateClusterGVR = schema.GroupVersionResource{
+	Group:    constValue.KubeMateGroup,
+	Version:  constValue.KubeMateVersion,
+	Resource: constValue.KubeMateClusterResource,
+}
+
+// kubeMateClusterCRD KubeMateCluster的CRD，spec的schema由models.KubeMateClusterSpec生成
+func kubeMateClusterCRD() *unstructured.Unstructured {
+	names := map[string]interface{}{
+		"kind":       constValue.KubeMateClusterKind,
+		"listKind":   constValue.KubeMateClusterListKind,
+		"plural":     constValue.KubeMateClusterResource,
+		"singular":   constValue.KubeMateClusterSingular,
+		"shortNames": []interface{}{constValue.KubeMateClusterShortName},
+	}
+	return kubeMateCRD(constValue.KubeMateClusterCRDName, names, reflect.TypeOf(models.KubeMateClusterSpec{}),
+		map[string]interface{}{"name": "Cluster", "type": "string", "jsonPath": ".spec.cluster_id"},
+		map[string]interface{}{"name": "Version", "type": "string", "jsonPath": ".spec.kubernetes.version"},
+		map[string]interface{}{"name": "Phase", "type": "string", "jsonPath": ".status.phase"},
+		map[string]interface{}{"name": "Age", "type": "date", "jsonPath": ".metadata.creationTimestamp"},
+	)
+}
+
+// kubeMateCRD kubemate.openeuler.org组下的CRD，spec的schema由specType生成，status为子资源且不做校验
+// schema中不包含凭据字段，写入spec的凭据会被API server丢弃
+func kubeMateCRD(name string, names map[string]interface{}, specType reflect.Type, printerColumns ...interface{}) *unstructured.Unstructured {
+	crd := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"group": constValue.KubeMateGroup,
+			"scope": "Namespaced",
+			"names": names,
+			"versions": []interface{}{
+				map[string]interface{}{
+					"name":    constValue.KubeMateVersion,
+					"served":  true,
+					"storage": true,
+					"schema": map[string]interface{}{
+						"openAPIV3Schema": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"spec":   stripCredentials(models.OpenAPISchema(specType)),
+								"status": map[string]interface{}{"type": "object", "x-kubernetes-preserve-unknown-fields": true},
+							},
+						},
+					},
+					"subresources":             map[string]interface{}{"status": map[string]interface{}{}},
+					"additionalPrinterColumns": printerColumns,
+				},
+			},
+		},
+	}}
+	crd.SetAPIVersion("apiextensions.k8s.io/v1")
+	crd.SetKind("CustomResourceDefinition")
+	crd.SetName(name)
+	return crd
+}
+
+// InstallKubeMateClusterCRD 启动时安装或更新KubeMateCluster CRD，并等待其可用
+func InstallKubeMateClusterCRD(ctx context.Context) error {
+	return installCRD(ctx, kubeMateClusterCRD())
+}
+
+// installCRD 安装或更新CRD，并等待其可用
+func installCRD(ctx context.Context, crd *unstructured.Unstructured) error {
+	if err := config.EnsureCRD(ctx, nil, crd); err != nil {
+		logrus.Errorf("install crd %s failed: %v", crd.GetName(), err)
+		return err
+	}
+	if err := config.WaitCRDEstablished(ctx, nil, crd.GetName()); err != nil {
+		logrus.Errorf("wait for crd %s established failed: %v", crd.GetName(), err)
+		return err
+	}
+	return nil
+}
+
+/**
+* @Description: 将集群配置转换为KubeMateCluster的spec
+* 凭据字段不保存在CR中，完整的集群配置仍以加密的secret保存
+*
+ */
+
+func kubeMateClusterSpec(clusterConfig *models.ClusterConfig) (map[string]interface{}, error) {
+	spec, err := runtime.DefaultUnstructuredConverter.ToUnstructured(clusterConfig)
+	if err != nil {
+		return nil, err
+	}
+	return stripCredentials(spec).(map[string]interface{}), nil
+}
+
+// stripCredentials 删除credentialFields中的字段
+func stripCredentials(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			if credentialFields[strings.ToLower(k)] {
+				continue
+			}
+			result[k] = stripCredentials(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = stripCredentials(item)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+// kubeMateClusterCrs 上传集群配置时记录的多版本KubeMateCluster CR
+func kubeMateClusterCrs() *config.CrImpl {
+	return config.NewCrImpl(
+		nil,
+		constValue.KubeMateGroup,
+		constValue.KubeMateVersion,
+		constValue.KubeMateClusterKind,
+		constValue.KubeMateClusterResource,
+		constValue.NameSpace,
+		constValue.DefaultCrUpdateField,
+	)
+}
+
+/**
+* @Description: 将集群配置转换为KubeMateCluster CR并创建，每次创建一个新版本
+* <kubemate-cr-><集群配置secret名称>-v-N，同一集群、同一组labels的CR为同一名称的不同版本
+*
+ */
+
+func applyCRResource(clusterConfig *models.ClusterConfig, secretName string, labelData map[string]string) error {
+	if configManager.KCS == nil {
+		return errors.New("failed to apply CR resource: a management cluster is required")
+	}
+	spec, err := kubeMateClusterSpec(clusterConfig)
+	if err != nil {
+		return errors.New("failed to apply CR resource:" + err.Error())
+	}
+
+	cr := kubeMateClusterCrs()
+	err = cr.CreateFields(context.TODO(), &configManager.ConfigObject{Name: secretName, Labels: labelData}, spec)
+	if err != nil {
+		logrus.Errorf("failed to apply CR resource: %v", err)
+		return errors.New("failed to apply CR resource:" + err.Error())
+	}
+
+	// 记录CR只保存集群配置，status在创建后即为最终状态
+	status := &proto.KubeMateClusterStatus{
+		Phase:              proto.ClusterStored,
+		ObservedGeneration: 1,
+		LastOperation:      newOperationResult("upload", "", false, ""),
+	}
+	setClusterConditions(status, status.ObservedGeneration)
+	if err := cr.UpdateStatus(context.TODO(), secretName, status); err != nil {
+		logrus.Errorf("failed to update CR status: %v", err)
+	}
+	return nil
+}
+
+/**
+* @Description: 查询集群配置对应的KubeMateCluster的status
+* operator保存的集群配置返回operator处理的CR的status，其他集群配置返回上传时生成的CR最新版本的status
+* @param labels JSON字符串，为空时查询没有labels的集群配置
+*
+ */
+
+func QueryClusterStatus(c util.Context, clusterID string, labels string) (*proto.KubeMateClusterStatus, error) {
+	if configManager.KCS == nil {
+		return nil, errors.New("failed to query cluster status: a management cluster is required")
+	}
+	labelData, err := parseClusterConfigLabels(labels)
+	if err != nil {
+		return nil, err
+	}
+	secretName, err := clusterConfigName(clusterID, labelData)
+	if err != nil {
+		return nil, err
+	}
+	if labelData[constValue.LabelManagedBy] == constValue.ManagedByOperator {
+		return operatorClusterStatus(clusterID)
+	}
+
+	content, err := kubeMateClusterCrs().GetStatus(context.TODO(), secretName)
+	if err != nil {
+		logrus.Errorf(c.P()+"get cluster status failed [name:%s],[err:%v]", secretName, err)
+		return nil, err
+	}
+	status := &proto.KubeMateClusterStatus{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// operatorClusterStatus operator处理的、集群id为clusterID的KubeMateCluster的status
+func operatorClusterStatus(clusterID string) (*proto.KubeMateClusterStatus, error) {
+	list, err := configManager.KCS.DynamicClientSet.Resource(KubeMateClusterGVR).Namespace(constValue.NameSpace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		cr := &list.Items[i]
+		if cr.GetLabels()[constValue.LabelType] != constValue.ClusterConfigType && kubeMateClusterID(cr) == clusterID {
+			return clusterStatusOf(cr)
+		}
+	}
+	return nil, k8serrors.NewNotFound(KubeMateClusterGVR.GroupResource(), clusterID)
+}
@@ -0,0 +1,427 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"ops-entry/models"
+	"ops-entry/proto"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// operatorConfigLabels operator保存的集群配置的labels，与上传的集群配置使用不同的secret，互不覆盖
+const operatorConfigLabels = `{"` + constValue.LabelManagedBy + `":"` + constValue.ManagedByOperator + `"}`
+
+// errClusterCredentials 无法从credentials_secret读取密码，secret创建或更新后重试
+var errClusterCredentials = errors.New("cluster credentials unavailable")
+
+// storeOperatorClusterConfig 保存operator部署使用的集群配置，deploy任务执行时从中读取，测试时可替换
+var storeOperatorClusterConfig = func(c util.Context, clusterID string, content []byte) error {
+	labels, err := parseClusterConfigLabels(operatorConfigLabels)
+	if err != nil {
+		return err
+	}
+	return saveClusterConfig2Secret(c, clusterID, content, labels)
+}
+
+// clusterOperator 监听KubeMateCluster，调用nkd使集群与spec一致
+type clusterOperator struct {
+	client dynamic.ResourceInterface
+}
+
+/**
+* @Description: 启动operator，监听nameSpace下的KubeMateCluster：
+* 新建时部署集群，worker数量增加时扩容，删除时通过finalizer先销毁集群；
+* 上传集群配置时生成的KubeMateCluster只作为记录，不会被部署
+* @param workers 同时处理的CR数量
+*
+ */
+
+func StartClusterOperator(ctx context.Context, clients *configManager.K8sClientSet, nameSpace string, workers int) error {
+	ctrl, err := newCRController(clients, KubeMateClusterGVR, nameSpace)
+	if err != nil {
+		return err
+	}
+	op := &clusterOperator{client: ctrl.client}
+	if err := ctrl.run(ctx, workers, op.reconcile); err != nil {
+		return err
+	}
+	logrus.Infof("cluster operator started, namespace[%s] workers[%d]", nameSpace, workers)
+	return nil
+}
+
+/**
+* @Description: 处理一个KubeMateCluster，每次最多提交一个nkd任务，任务状态记录在status中
+* return
+*   @resp requeue 大于0时在该时间后再次处理，用于等待执行中的任务
+*   @resp 返回错误时按退避策略重试
+*
+ */
+
+func (op *clusterOperator) reconcile(ctx context.Context, cr *unstructured.Unstructured) (time.Duration, error) {
+	// 上传集群配置时生成的CR
+	if cr.GetLabels()[constValue.LabelType] == constValue.ClusterConfigType {
+		return 0, nil
+	}
+	c := util.CreateContext("")
+	status, err := clusterStatusOf(cr)
+	if err != nil {
+		return 0, err
+	}
+
+	if status.JobId != "" {
+		info := nkdJobInfo(c, status.JobId)
+		if info != nil && (info.Phase == proto.NKDJobPending || info.Phase == proto.NKDJobRunning) {
+			return constValue.OperatorRequeueInterval, nil
+		}
+		finishClusterJob(status, info)
+		return 0, op.updateStatus(ctx, cr, status)
+	}
+
+	if cr.GetDeletionTimestamp() != nil {
+		return 0, op.finalize(ctx, c, cr, status)
+	}
+	if !hasFinalizer(cr, constValue.KubeMateClusterFinalizer) {
+		cr.SetFinalizers(append(cr.GetFinalizers(), constValue.KubeMateClusterFinalizer))
+		_, err := op.client.Update(ctx, cr, metav1.UpdateOptions{})
+		return 0, err
+	}
+
+	clusterConfig, err := kubeMateClusterConfig(ctx, cr)
+	if errors.Is(err, errClusterCredentials) {
+		// 不改变phase，secret就绪后继续处理
+		if status.Message != err.Error() {
+			status.Message = err.Error()
+			return constValue.OperatorRequeueInterval, op.updateStatus(ctx, cr, status)
+		}
+		return constValue.OperatorRequeueInterval, nil
+	}
+	if err != nil {
+		if status.Phase != proto.ClusterFailed || status.Message != err.Error() {
+			status.Phase, status.Message = proto.ClusterFailed, err.Error()
+			status.ObservedGeneration = cr.GetGeneration()
+			return 0, op.updateStatus(ctx, cr, status)
+		}
+		return 0, nil
+	}
+	desired := int64(len(clusterConfig.Worker))
+	retry := status.Phase == proto.ClusterFailed && status.ObservedGeneration != cr.GetGeneration()
+
+	switch {
+	case status.Phase == "" || retry && (status.Verb == "" || status.Verb == constValue.NkdVerbDeploy):
+		return 0, op.deploy(ctx, c, cr, status, clusterConfig)
+	case status.Phase == proto.ClusterReady || retry && status.Verb == constValue.NkdVerbExtend:
+		if desired > status.Workers {
+			return 0, op.submit(ctx, c, cr, status, NKDJobParam{
+				Verb:      constValue.NkdVerbExtend,
+				ClusterID: clusterConfig.ClusterID,
+				Num:       int(desired - status.Workers),
+			}, desired)
+		}
+		if desired < status.Workers && status.Message == "" {
+			status.Message = fmt.Sprintf("worker count decreased from %d to %d, remove nodes with /nkd/shrink", status.Workers, desired)
+			return 0, op.updateStatus(ctx, cr, status)
+		}
+	}
+	return 0, nil
+}
+
+// deploy 保存集群配置后提交deploy任务
+func (op *clusterOperator) deploy(ctx context.Context, c util.Context, cr *unstructured.Unstructured, status *proto.KubeMateClusterStatus, clusterConfig *models.ClusterConfig) error {
+	content, err := sigsyaml.Marshal(clusterConfig)
+	if err != nil {
+		return err
+	}
+	if err := storeOperatorClusterConfig(c, clusterConfig.ClusterID, content); err != nil {
+		return err
+	}
+	return op.submit(ctx, c, cr, status, NKDJobParam{
+		Verb:      constValue.NkdVerbDeploy,
+		ClusterID: clusterConfig.ClusterID,
+		Labels:    operatorConfigLabels,
+	}, int64(len(clusterConfig.Worker)))
+}
+
+// finalize 删除CR前销毁已部署的集群，销毁成功或从未部署时移除finalizer
+func (op *clusterOperator) finalize(ctx context.Context, c util.Context, cr *unstructured.Unstructured, status *proto.KubeMateClusterStatus) error {
+	if !hasFinalizer(cr, constValue.KubeMateClusterFinalizer) {
+		return nil
+	}
+	deployed := status.Verb != "" && !(status.Verb == constValue.NkdVerbDeploy && status.Phase == proto.ClusterFailed)
+	if deployed && !(status.Verb == constValue.NkdVerbDestroy && status.Phase == proto.ClusterReady) {
+		// 销毁失败后与部署失败相同，修改spec后重试
+		if status.Verb == constValue.NkdVerbDestroy && status.Phase == proto.ClusterFailed && status.ObservedGeneration == cr.GetGeneration() {
+			return nil
+		}
+		return op.submit(ctx, c, cr, status, NKDJobParam{
+			Verb:      constValue.NkdVerbDestroy,
+			ClusterID: kubeMateClusterID(cr),
+		}, 0)
+	}
+
+	removeFinalizer(cr, constValue.KubeMateClusterFinalizer)
+	_, err := op.client.Update(ctx, cr, metav1.UpdateOptions{})
+	return err
+}
+
+/**
+* @Description: 提交nkd任务并记录到status，targetWorkers为任务成功后的worker节点数
+* Request-Id由CR的UID、generation和verb组成，任务已提交但status更新失败时，
+* 再次处理得到相同的Request-Id，提交返回原任务而不会重复执行
+*
+ */
+
+func (op *clusterOperator) submit(ctx context.Context, c util.Context, cr *unstructured.Unstructured, status *proto.KubeMateClusterStatus, param NKDJobParam, targetWorkers int64) error {
+	c = util.CreateContext(operatorRequestId(cr, param.Verb))
+	param.Requester = "operator/" + cr.GetName()
+	var jobId string
+	job, err := SubmitNKDJob(c, param)
+	var repeated *RepeatRequestError
+	switch {
+	case errors.As(err, &repeated) && len(repeated.JobId) > 0:
+		jobId = repeated.JobId
+		logrus.Infof(c.P()+"KubeMateCluster %s nkd job has been submitted [job:%s],[verb:%s]", cr.GetName(), jobId, param.Verb)
+	case err != nil:
+		return err
+	default:
+		jobId = job.Id
+		logrus.Infof(c.P()+"KubeMateCluster %s submitted nkd job [job:%s],[verb:%s]", cr.GetName(), jobId, param.Verb)
+	}
+
+	status.Verb = param.Verb
+	status.JobId = jobId
+	status.TargetWorkers = targetWorkers
+	status.ObservedGeneration = cr.GetGeneration()
+	status.Message = ""
+	switch param.Verb {
+	case constValue.NkdVerbDeploy:
+		status.Phase = proto.ClusterDeploying
+	case constValue.NkdVerbExtend:
+		status.Phase = proto.ClusterExtending
+	case constValue.NkdVerbDestroy:
+		status.Phase = proto.ClusterDestroying
+	}
+	return op.updateStatus(ctx, cr, status)
+}
+
+// operatorRequestId CR的同一generation对同一verb只提交一次任务
+func operatorRequestId(cr *unstructured.Unstructured, verb string) string {
+	return fmt.Sprintf("operator-%s-%d-%s", cr.GetUID(), cr.GetGeneration(), verb)
+}
+
+// updateStatus 根据phase设置conditions后通过status子资源更新
+func (op *clusterOperator) updateStatus(ctx context.Context, cr *unstructured.Unstructured, status *proto.KubeMateClusterStatus) error {
+	setClusterConditions(status, cr.GetGeneration())
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(status)
+	if err != nil {
+		return err
+	}
+	cr.Object["status"] = content
+	_, err = op.client.UpdateStatus(ctx, cr, metav1.UpdateOptions{})
+	return err
+}
+
+/**
+* @Description: 根据phase设置Ready、Progressing和Degraded
+* 扩容失败时集群仍可用，Ready保持不变
+*
+ */
+
+func setClusterConditions(status *proto.KubeMateClusterStatus, generation int64) {
+	conditions := &status.Conditions
+	reason := string(status.Phase)
+	switch status.Phase {
+	case proto.ClusterDeploying, proto.ClusterExtending, proto.ClusterDestroying:
+		setCondition(conditions, generation, proto.ConditionProgressing, true, reason, fmt.Sprintf("nkd %s job %s is running", status.Verb, status.JobId))
+		setCondition(conditions, generation, proto.ConditionDegraded, false, reason, "")
+		if status.Phase != proto.ClusterExtending {
+			setCondition(conditions, generation, proto.ConditionReady, false, reason, "")
+		}
+	case proto.ClusterReady:
+		if status.Verb == constValue.NkdVerbDestroy {
+			reason = "Destroyed"
+		}
+		setCondition(conditions, generation, proto.ConditionReady, status.Verb != constValue.NkdVerbDestroy, reason, status.Message)
+		setCondition(conditions, generation, proto.ConditionProgressing, false, reason, "")
+		setCondition(conditions, generation, proto.ConditionDegraded, false, reason, "")
+	case proto.ClusterFailed:
+		reason = "InvalidSpec"
+		if status.Verb != "" {
+			reason = strings.ToUpper(status.Verb[:1]) + status.Verb[1:] + "Failed"
+		}
+		setCondition(conditions, generation, proto.ConditionDegraded, true, reason, status.Message)
+		setCondition(conditions, generation, proto.ConditionProgressing, false, reason, "")
+		if status.Verb != constValue.NkdVerbExtend {
+			setCondition(conditions, generation, proto.ConditionReady, false, reason, status.Message)
+		}
+	case proto.ClusterStored:
+		setCondition(conditions, generation, proto.ConditionReady, true, reason, "cluster config is stored")
+		setCondition(conditions, generation, proto.ConditionProgressing, false, reason, "")
+		setCondition(conditions, generation, proto.ConditionDegraded, false, reason, "")
+	}
+}
+
+// finishClusterJob 根据已结束的任务更新status，info为nil表示任务记录已丢失
+func finishClusterJob(status *proto.KubeMateClusterStatus, info *proto.NKDJobInfo) {
+	switch {
+	case info == nil:
+		status.Phase = proto.ClusterFailed
+		status.Message = fmt.Sprintf("nkd job %s is lost", status.JobId)
+	case info.Phase == proto.NKDJobSucceeded:
+		// destroy成功后同样为Ready，finalize据此移除finalizer
+		status.Phase = proto.ClusterReady
+		status.Workers = status.TargetWorkers
+		status.Message = ""
+	default:
+		status.Phase = proto.ClusterFailed
+		status.Message = fmt.Sprintf("nkd %s job %s %s, exit code %d", info.Verb, info.JobId, info.Phase, info.ExitCode)
+		if info.Reason != "" {
+			status.Message += ": " + info.Reason
+		}
+	}
+	status.LastOperation = newOperationResult(status.Verb, status.JobId, status.Phase == proto.ClusterFailed, status.Message)
+	status.JobId = ""
+	status.TargetWorkers = 0
+}
+
+// nkdJobInfo 查询任务状态，ops-entry重启后从执行记录中查询
+func nkdJobInfo(c util.Context, jobId string) *proto.NKDJobInfo {
+	if job, ok := GetNKDJob(jobId); ok {
+		return job.Info()
+	}
+	record, err := GetNKDHistory(c, jobId)
+	if err != nil || record == nil {
+		return nil
+	}
+	return &record.NKDJobInfo
+}
+
+func clusterStatusOf(cr *unstructured.Unstructured) (*proto.KubeMateClusterStatus, error) {
+	status := &proto.KubeMateClusterStatus{}
+	content, ok, err := unstructured.NestedMap(cr.Object, "status")
+	if err != nil || !ok {
+		return status, err
+	}
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(content, status)
+	return status, err
+}
+
+/**
+* @Description: 将spec转换为集群配置并校验，未指定cluster_id时使用CR的名称
+* OpenStack密码从credentials_secret读取，spec中的密码不使用
+* return
+*   @resp 读取secret失败时返回errClusterCredentials
+*
+ */
+
+func kubeMateClusterConfig(ctx context.Context, cr *unstructured.Unstructured) (*models.ClusterConfig, error) {
+	spec, _, err := unstructured.NestedMap(cr.Object, "spec")
+	if err != nil {
+		return nil, err
+	}
+	var clusterSpec models.KubeMateClusterSpec
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(spec, &clusterSpec); err != nil {
+		return nil, fmt.Errorf("invalid spec: %v", err)
+	}
+	clusterConfig := clusterSpec.ClusterConfig
+	clusterConfig.ClusterID = kubeMateClusterID(cr)
+	if !util.IsValidResourceName(clusterConfig.ClusterID) {
+		return nil, fmt.Errorf("invalid cluster id %q", clusterConfig.ClusterID)
+	}
+	if err := setOpenStackPassword(ctx, &clusterConfig, clusterSpec.CredentialsSecret); err != nil {
+		return nil, err
+	}
+	if errs := clusterConfig.Validate(); len(errs) > 0 {
+		return nil, errors.New("invalid spec: " + errs.ToAggregate().Error())
+	}
+	return &clusterConfig, nil
+}
+
+// setOpenStackPassword 为使用OpenStack的节点设置secretName中保存的密码，未使用OpenStack时不读取secret
+func setOpenStackPassword(ctx context.Context, clusterConfig *models.ClusterConfig, secretName string) error {
+	var configs []*models.OpenStackConfig
+	for i := range clusterConfig.Master {
+		configs = append(configs, &clusterConfig.Master[i].OpenStack)
+	}
+	for i := range clusterConfig.Worker {
+		configs = append(configs, &clusterConfig.Worker[i].OpenStack)
+	}
+	var used []*models.OpenStackConfig
+	for _, config := range configs {
+		config.Password = ""
+		if *config != (models.OpenStackConfig{}) {
+			used = append(used, config)
+		}
+	}
+	if len(used) == 0 {
+		return nil
+	}
+	if secretName == "" {
+		return errors.New("invalid spec: credentials_secret is required when open_stack is configured")
+	}
+
+	if configManager.KCS == nil {
+		return fmt.Errorf("%w: a management cluster is required", errClusterCredentials)
+	}
+	secret, err := configManager.KCS.ClientSet.CoreV1().Secrets(constValue.NameSpace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("%w: get secret %s failed: %v", errClusterCredentials, secretName, err)
+	}
+	password := string(secret.Data[constValue.OpenStackPasswordKey])
+	if password == "" {
+		return fmt.Errorf("%w: secret %s has no %s", errClusterCredentials, secretName, constValue.OpenStackPasswordKey)
+	}
+	for _, config := range used {
+		config.Password = password
+	}
+	return nil
+}
+
+func kubeMateClusterID(cr *unstructured.Unstructured) string {
+	if clusterID, _, _ := unstructured.NestedString(cr.Object, "spec", "cluster_id"); clusterID != "" {
+		return clusterID
+	}
+	return cr.GetName()
+}
+
+func hasFinalizer(cr *unstructured.Unstructured, name string) bool {
+	for _, finalizer := range cr.GetFinalizers() {
+		if finalizer == name {
+			return true
+		}
+	}
+	return false
+}
+
+func removeFinalizer(cr *unstructured.Unstructured, name string) {
+	var finalizers []string
+	for _, finalizer := range cr.GetFinalizers() {
+		if finalizer != name {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	cr.SetFinalizers(finalizers)
+}
@@ -0,0 +1,281 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"context"
+	"errors"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"ops-entry/executor"
+	"ops-entry/proto"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestClusterOperator(t *testing.T) {
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{KubeMateClusterGVR: constValue.KubeMateClusterListKind})
+	clientSet := k8sfake.NewSimpleClientset()
+	origin := configManager.KCS
+	configManager.KCS = &configManager.K8sClientSet{ClientSet: clientSet, DynamicClientSet: dynamicClient}
+	defer func() { configManager.KCS = origin }()
+
+	var stored []byte
+	originStore := storeOperatorClusterConfig
+	storeOperatorClusterConfig = func(c util.Context, clusterID string, content []byte) error {
+		stored = content
+		return nil
+	}
+	originLoad := loadClusterConfig
+	loadClusterConfig = func(c util.Context, clusterID, labels string) ([]byte, error) {
+		assert.Equal(t, operatorConfigLabels, labels)
+		return stored, nil
+	}
+	defer func() { storeOperatorClusterConfig, loadClusterConfig = originStore, originLoad }()
+
+	fake := executor.NewFakeExecutor()
+	StartNKDWorkers(fake, 1)
+
+	ctx := context.TODO()
+	client := dynamicClient.Resource(KubeMateClusterGVR).Namespace(constValue.NameSpace)
+	op := &clusterOperator{client: client}
+	openStack := map[string]interface{}{
+		"user_name": "admin", "password": "in-spec", "tenant_name": "admin", "auth_url": "http://192.168.1.2:5000/v3",
+		"region": "RegionOne", "internal_network": "internal", "external_network": "external", "glance_name": "nestos",
+	}
+	cr := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"kubernetes":         map[string]interface{}{"version": "v1.29.1", "image_registry": "k8s.gcr.io"},
+			"master":             []interface{}{map[string]interface{}{"name": "master01", "ip": "192.168.1.10", "port": int64(6443), "open_stack": openStack}},
+			"worker":             []interface{}{map[string]interface{}{"name": "worker01", "ip": "192.168.1.11"}},
+			"credentials_secret": "operator-cluster-credentials",
+		},
+	}}
+	cr.SetAPIVersion(KubeMateClusterGVR.GroupVersion().String())
+	cr.SetKind(constValue.KubeMateClusterKind)
+	cr.SetName("operator-cluster")
+	cr.SetNamespace(constValue.NameSpace)
+	cr.SetGeneration(1)
+	_, err := client.Create(ctx, cr, metav1.CreateOptions{})
+	assert.Nil(t, err)
+
+	// 处理一次CR，返回处理后的CR和status
+	reconcile := func() (*unstructured.Unstructured, *proto.KubeMateClusterStatus) {
+		cr, err := client.Get(ctx, "operator-cluster", metav1.GetOptions{})
+		assert.Nil(t, err)
+		_, err = op.reconcile(ctx, cr)
+		assert.Nil(t, err)
+		cr, err = client.Get(ctx, "operator-cluster", metav1.GetOptions{})
+		assert.Nil(t, err)
+		status, err := clusterStatusOf(cr)
+		assert.Nil(t, err)
+		return cr, status
+	}
+	// 等待status中记录的任务结束
+	waitJob := func(status *proto.KubeMateClusterStatus) {
+		job, ok := GetNKDJob(status.JobId)
+		assert.True(t, ok)
+		waitNKDJob(t, job)
+	}
+
+	t.Run("deploy", func(t *testing.T) {
+		cr, status := reconcile()
+		assert.Equal(t, []string{constValue.KubeMateClusterFinalizer}, cr.GetFinalizers())
+		assert.Equal(t, proto.ClusterPhase(""), status.Phase)
+
+		// secret不存在时等待创建，不改变phase
+		requeue, err := op.reconcile(ctx, cr)
+		assert.Nil(t, err)
+		assert.Equal(t, constValue.OperatorRequeueInterval, requeue)
+		cr, err = client.Get(ctx, "operator-cluster", metav1.GetOptions{})
+		assert.Nil(t, err)
+		status, err = clusterStatusOf(cr)
+		assert.Nil(t, err)
+		assert.Equal(t, proto.ClusterPhase(""), status.Phase)
+		assert.Contains(t, status.Message, "operator-cluster-credentials")
+		_, err = clientSet.CoreV1().Secrets(constValue.NameSpace).Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "operator-cluster-credentials", Namespace: constValue.NameSpace},
+			Data:       map[string][]byte{constValue.OpenStackPasswordKey: []byte("from-secret")},
+		}, metav1.CreateOptions{})
+		assert.Nil(t, err)
+
+		_, status = reconcile()
+		assert.Equal(t, proto.ClusterDeploying, status.Phase)
+		assert.Equal(t, int64(1), status.TargetWorkers)
+		assert.True(t, meta.IsStatusConditionTrue(status.Conditions, proto.ConditionProgressing))
+		assert.True(t, meta.IsStatusConditionFalse(status.Conditions, proto.ConditionReady))
+		assert.Contains(t, string(stored), "cluster_id: operator-cluster")
+		assert.Contains(t, string(stored), "password: from-secret")
+		assert.NotContains(t, string(stored), "in-spec")
+		assert.Empty(t, status.Message)
+		waitJob(status)
+		calls := fake.Calls()
+		assert.Len(t, calls, 1)
+		assert.Equal(t, constValue.NkdVerbDeploy, calls[0].Verb)
+		assert.True(t, strings.HasPrefix(filepath.Base(calls[0].Args[0]), "operator-cluster-"))
+
+		_, status = reconcile()
+		assert.Equal(t, proto.ClusterReady, status.Phase)
+		assert.Equal(t, int64(1), status.Workers)
+		assert.Empty(t, status.JobId)
+		assert.True(t, meta.IsStatusConditionTrue(status.Conditions, proto.ConditionReady))
+		assert.True(t, meta.IsStatusConditionFalse(status.Conditions, proto.ConditionProgressing))
+		assert.Equal(t, constValue.NkdVerbDeploy, status.LastOperation.Operation)
+		assert.Equal(t, proto.OperationSucceeded, status.LastOperation.Result)
+
+		queried, err := QueryClusterStatus(util.CreateContext(""), "operator-cluster", operatorConfigLabels)
+		assert.Nil(t, err)
+		assert.Equal(t, status, queried)
+	})
+
+	t.Run("extend", func(t *testing.T) {
+		cr, err := client.Get(ctx, "operator-cluster", metav1.GetOptions{})
+		assert.Nil(t, err)
+		workers, _, _ := unstructured.NestedSlice(cr.Object, "spec", "worker")
+		workers = append(workers,
+			map[string]interface{}{"name": "worker02", "ip": "192.168.1.12"},
+			map[string]interface{}{"name": "worker03", "ip": "192.168.1.13"})
+		assert.Nil(t, unstructured.SetNestedSlice(cr.Object, workers, "spec", "worker"))
+		cr.SetGeneration(2)
+		_, err = client.Update(ctx, cr, metav1.UpdateOptions{})
+		assert.Nil(t, err)
+
+		_, status := reconcile()
+		assert.Equal(t, proto.ClusterExtending, status.Phase)
+		assert.Equal(t, int64(2), status.ObservedGeneration)
+		waitJob(status)
+		assert.Contains(t, fake.Calls(), executor.FakeCall{Verb: constValue.NkdVerbExtend, Args: []string{"operator-cluster", "2"}})
+
+		_, status = reconcile()
+		assert.Equal(t, proto.ClusterReady, status.Phase)
+		assert.Equal(t, int64(3), status.Workers)
+	})
+
+	t.Run("destroy", func(t *testing.T) {
+		fake.SetScript(constValue.NkdVerbDestroy, executor.FakeScript{Lines: []string{"destroy failed"}, ExitCode: 1})
+		cr, err := client.Get(ctx, "operator-cluster", metav1.GetOptions{})
+		assert.Nil(t, err)
+		now := metav1.NewTime(time.Now())
+		cr.SetDeletionTimestamp(&now)
+		_, err = client.Update(ctx, cr, metav1.UpdateOptions{})
+		assert.Nil(t, err)
+
+		_, status := reconcile()
+		assert.Equal(t, proto.ClusterDestroying, status.Phase)
+		waitJob(status)
+		cr, status = reconcile()
+		assert.Equal(t, proto.ClusterFailed, status.Phase)
+		assert.Contains(t, status.Message, "exit code 1")
+		degraded := meta.FindStatusCondition(status.Conditions, proto.ConditionDegraded)
+		assert.Equal(t, metav1.ConditionTrue, degraded.Status)
+		assert.Equal(t, "DestroyFailed", degraded.Reason)
+		assert.Equal(t, proto.OperationFailed, status.LastOperation.Result)
+		// 失败后不会自动重试，finalizer保留
+		cr, status = reconcile()
+		assert.Equal(t, proto.ClusterFailed, status.Phase)
+		assert.Equal(t, []string{constValue.KubeMateClusterFinalizer}, cr.GetFinalizers())
+
+		fake.SetScript(constValue.NkdVerbDestroy, executor.FakeScript{})
+		cr.SetGeneration(3)
+		_, err = client.Update(ctx, cr, metav1.UpdateOptions{})
+		assert.Nil(t, err)
+		_, status = reconcile()
+		assert.Equal(t, proto.ClusterDestroying, status.Phase)
+		waitJob(status)
+		reconcile()
+		cr, _ = reconcile()
+		assert.Empty(t, cr.GetFinalizers())
+	})
+
+	t.Run("status update failed", func(t *testing.T) {
+		spec, _, _ := unstructured.NestedMap(cr.Object, "spec")
+		retry := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+		retry.SetAPIVersion(KubeMateClusterGVR.GroupVersion().String())
+		retry.SetKind(constValue.KubeMateClusterKind)
+		retry.SetName("retry-cluster")
+		retry.SetUID("retry-cluster-uid")
+		retry.SetGeneration(1)
+		retry.SetFinalizers([]string{constValue.KubeMateClusterFinalizer})
+		_, err := client.Create(ctx, retry, metav1.CreateOptions{})
+		assert.Nil(t, err)
+
+		// 任务提交后第一次更新status失败
+		failed := false
+		dynamicClient.PrependReactor("update", KubeMateClusterGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			update := action.(k8stesting.UpdateAction)
+			if failed || update.GetSubresource() != "status" || update.GetObject().(*unstructured.Unstructured).GetName() != "retry-cluster" {
+				return false, nil, nil
+			}
+			failed = true
+			return true, nil, errors.New("update status failed")
+		})
+		created, err := client.Get(ctx, "retry-cluster", metav1.GetOptions{})
+		assert.Nil(t, err)
+		_, err = op.reconcile(ctx, created)
+		assert.NotNil(t, err)
+
+		// 再次处理时返回原任务，不会重复部署
+		created, err = client.Get(ctx, "retry-cluster", metav1.GetOptions{})
+		assert.Nil(t, err)
+		_, err = op.reconcile(ctx, created)
+		assert.Nil(t, err)
+		created, err = client.Get(ctx, "retry-cluster", metav1.GetOptions{})
+		assert.Nil(t, err)
+		status, err := clusterStatusOf(created)
+		assert.Nil(t, err)
+		assert.Equal(t, proto.ClusterDeploying, status.Phase)
+		waitJob(status)
+		deploys := 0
+		for _, call := range fake.Calls() {
+			if call.Verb == constValue.NkdVerbDeploy && strings.HasPrefix(filepath.Base(call.Args[0]), "retry-cluster-") {
+				deploys++
+			}
+		}
+		assert.Equal(t, 1, deploys)
+	})
+
+	t.Run("invalid spec", func(t *testing.T) {
+		cr := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{"cluster_id": "invalid-cluster"},
+		}}
+		cr.SetAPIVersion(KubeMateClusterGVR.GroupVersion().String())
+		cr.SetKind(constValue.KubeMateClusterKind)
+		cr.SetName("invalid")
+		cr.SetFinalizers([]string{constValue.KubeMateClusterFinalizer})
+		created, err := client.Create(ctx, cr, metav1.CreateOptions{})
+		assert.Nil(t, err)
+		_, err = op.reconcile(ctx, created)
+		assert.Nil(t, err)
+		cr, err = client.Get(ctx, "invalid", metav1.GetOptions{})
+		assert.Nil(t, err)
+		status, _ := clusterStatusOf(cr)
+		assert.Equal(t, proto.ClusterFailed, status.Phase)
+		assert.Contains(t, status.Message, "kubernetes.image_registry")
+		assert.Equal(t, "InvalidSpec", meta.FindStatusCondition(status.Conditions, proto.ConditionDegraded).Reason)
+	})
+}
@@ -12,29 +12,27 @@
 package service
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
 	"errors"
-	"fmt"
 	"io"
 	"ops-entry/common/util"
 	"ops-entry/constValue"
-	"ops-entry/db/configManager/config"
+	"ops-entry/db/configManager"
+	"ops-entry/models"
 	"ops-entry/proto"
 	"os"
-	"os/user"
 	"path/filepath"
 
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	sigsyaml "sigs.k8s.io/yaml"
 )
 
 func UploadClusterConfigFile(c util.Context, param *proto.FileUploadParam) error {
-	var labels map[string]string
-
 	allowedExts := []string{constValue.YamlExt, constValue.YmlExt}
 	ext := filepath.Ext(param.File.Filename)
 
@@ -62,95 +60,87 @@ func UploadClusterConfigFile(c util.Context, param *proto.FileUploadParam) error
 		return errors.New("error: invalid yaml config file")
 	}
 
-	if param.Labels != "" {
-		labels, err = util.ParseLabels(param.Labels)
-		if err != nil {
+	if param.Type == proto.FileTypeFile {
+		if _, err := ParseClusterConfig(c, content); err != nil {
 			return err
 		}
 	}
 
-	dst, err := util.GetSaveFilename(param.Labels, param.ClusterId)
-	if err != nil {
-		return err
-	}
+	return storeClusterConfig(c, param.ClusterId, param.Labels, param.Type, content)
+}
 
-	outFile, err := os.Create(dst)
+// storeClusterConfig 保存集群配置到secret，上传和生成的集群配置都通过该方法保存
+func storeClusterConfig(c util.Context, clusterID string, labelStr string, fileType proto.FileType, content []byte) error {
+	labels, err := userClusterConfigLabels(labelStr)
 	if err != nil {
-		logrus.Errorf(c.P()+"Error creating file:%v", err)
-		return errors.New("Error creating file:" + err.Error())
-	}
-	defer outFile.Close()
-
-	if _, err := io.Copy(outFile, bytes.NewReader(content)); err != nil {
-		logrus.Errorf(c.P()+"Error copying file:%v", err)
-		return errors.New("Error copying file:" + err.Error())
+		return err
 	}
 
-	if param.Type == proto.FileTypeCR {
-		if err := applyCRResource(content, labels); err != nil {
+	if fileType == proto.FileTypeCR {
+		clusterConfig, err := ParseClusterConfig(c, content)
+		if err != nil {
 			return err
 		}
-	}
-
-	return saveClusterConfig2Secret(c, param.ClusterId, content, labels)
-}
-
-func DeleteClusterConfigFile(c util.Context, clusterID string, labels string) error {
-	str := clusterID
-	secretName := clusterID + constValue.ClusterconfigPrefix
-	if labels != "" {
-		deleteLabels, err := util.ParseLabels(labels)
+		secretName, err := clusterConfigName(clusterID, labels)
 		if err != nil {
 			return err
 		}
-
-		for key, value := range deleteLabels {
-			secretName = secretName + "-" + key + "-" + value
-			str = str + "-" + key + "-" + value
+		if err := applyCRResource(clusterConfig, secretName, clusterConfigLabels(clusterID, labels)); err != nil {
+			return err
 		}
 	}
-	currentUser, err := user.Current()
+
+	return saveClusterConfig2Secret(c, clusterID, content, labels)
+}
+
+func DeleteClusterConfigFile(c util.Context, clusterID string, labels string) error {
+	secretName, err := clusterConfigSecretName(clusterID, labels)
 	if err != nil {
-		logrus.Errorf(c.P()+"Error getting current user:%v", err)
+		logrus.Errorf(c.P()+"invalid secret name: %v\n", err)
 		return err
 	}
 
-	clusterConfigSavePath := filepath.Join(currentUser.HomeDir, constValue.ClusterConfigSavePath, clusterID)
-	dst := filepath.Join(clusterConfigSavePath, fmt.Sprintf("%s%s", str, ".yaml"))
+	dst, err := util.GetSaveFilename(labels, clusterID)
+	if err != nil {
+		return err
+	}
 	if _, err := os.Stat(dst); !os.IsNotExist(err) {
-		// 如果文件存在，则尝试删除
+		// 删除旧版本保存在本地的明文配置文件
 		if err := os.Remove(dst); err != nil {
 			logrus.Errorf(c.P()+"Error deleting file:%s\n", err)
 		}
 	}
 
-	if !util.IsValidResourceName(secretName) {
-		logrus.Errorf(c.P()+"invalid secret name: %s\n", secretName)
-		return errors.New("invalid secret name")
-	}
-	sr := config.NewSecretImpl(constValue.NameSpace, secretName, nil, "")
-	return sr.Delete(context.TODO(), metav1.DeleteOptions{})
+	return clusterConfigStore.Delete(context.TODO(), secretName)
 }
 
-func QueryClusterConfigFile(c util.Context, clusterID string, labels string) (*corev1.Secret, error) {
-	secretName := clusterID + constValue.ClusterconfigPrefix
-	if labels != "" {
-		deleteLabels, err := util.ParseLabels(labels)
-		if err != nil {
-			return nil, err
-		}
+/**
+* @Description: 根据集群id和labels查询集群配置，使用label selector匹配上传时保存的labels
+* @param labels JSON字符串，为空时查询没有labels的集群配置
+*
+ */
 
-		for key, value := range deleteLabels {
-			secretName = secretName + "-" + key + "-" + value
-		}
+func QueryClusterConfigFile(c util.Context, clusterID string, labels string) (*configManager.ConfigObject, error) {
+	labelData, err := parseClusterConfigLabels(labels)
+	if err != nil {
+		logrus.Errorf(c.P()+"invalid labels: %v\n", err)
+		return nil, err
+	}
+	secretName, err := clusterConfigName(clusterID, labelData)
+	if err != nil {
+		logrus.Errorf(c.P()+"invalid secret name: %v\n", err)
+		return nil, err
 	}
 
-	if !util.IsValidResourceName(secretName) {
-		logrus.Errorf(c.P()+"invalid secret name: %s\n", secretName)
-		return nil, errors.New("invalid secret name")
+	objects, err := clusterConfigStore.List(context.TODO(), clusterConfigLabels(clusterID, labelData))
+	if err != nil {
+		logrus.Errorf(c.P()+"list cluster config failed: %v", err)
+		return nil, err
+	}
+	if len(objects) < 1 {
+		return nil, k8serrors.NewNotFound(corev1.Resource("secrets"), secretName)
 	}
-	sr := config.NewSecretImpl(constValue.NameSpace, secretName, nil, "")
-	return sr.Get(context.TODO(), metav1.GetOptions{})
+	return &objects[0], nil
 }
 
 func UpdateClusterConfigFile(c util.Context, param *proto.FileUpdateParam) error {
@@ -181,31 +171,16 @@ func UpdateClusterConfigFile(c util.Context, param *proto.FileUpdateParam) error
 		return errors.New("error: invalid yaml config file")
 	}
 
-	if param.Labels != "" {
-		updateLabels, err = util.ParseLabels(param.Labels)
-		if err != nil {
-			return err
-		}
-	}
-
-	dst, err := util.GetSaveFilename(param.Labels, param.ClusterId)
-	if err != nil {
+	if _, err := ParseClusterConfig(c, content); err != nil {
 		return err
 	}
 
-	outFile, err := os.Create(dst)
+	updateLabels, err = userClusterConfigLabels(param.Labels)
 	if err != nil {
-		logrus.Errorf(c.P()+"Error creating file:%v", err)
-		return errors.New("Error creating file:" + err.Error())
-	}
-	defer outFile.Close()
-
-	if _, err := io.Copy(outFile, bytes.NewReader(content)); err != nil {
-		logrus.Errorf(c.P()+"Error copying file:%v", err)
-		return errors.New("Error copying file:" + err.Error())
+		return err
 	}
 
-	return updateClusterConfig2Secret(c, param.ClusterId, content, updateLabels)
+	return updateClusterConfig2Secret(c, param.ClusterId, content, updateLabels, param.IfMatch)
 }
 
 // validYamlConfig 检查给定的内容是否是有效的 YAML 配置文件
@@ -219,63 +194,68 @@ func validYamlConfig(c util.Context, content []byte) bool {
 	return true
 }
 
-func saveClusterConfig2Secret(c util.Context, clusterID string, configBytes []byte, labelData map[string]string) error {
-	var secretName string
-	encodedConfig := base64.StdEncoding.EncodeToString(configBytes)
-	clusterConfigData := map[string]string{
-		constValue.Clusterconfig: string(encodedConfig),
+// ClusterConfigError 集群配置语义校验失败，包含全部不合法的字段
+type ClusterConfigError struct {
+	Errors field.ErrorList
+}
+
+func (e *ClusterConfigError) Error() string {
+	return "error: invalid cluster config: " + e.Errors.ToAggregate().Error()
+}
+
+// Violations 转换为接口返回的字段列表
+func (e *ClusterConfigError) Violations() []proto.FieldViolation {
+	violations := make([]proto.FieldViolation, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		violations = append(violations, proto.FieldViolation{Field: err.Field, Detail: err.ErrorBody()})
 	}
-	secretName = clusterID + constValue.ClusterconfigPrefix
-	if len(labelData) > 0 {
-		for key, value := range labelData {
-			secretName = secretName + "-" + key + "-" + value
-		}
+	return violations
+}
+
+/**
+* @Description: 将集群配置解析为models.ClusterConfig并做语义校验
+* return
+*   @resp 字段类型错误时返回解析错误，语义错误时返回*ClusterConfigError
+*
+ */
+
+func ParseClusterConfig(c util.Context, content []byte) (*models.ClusterConfig, error) {
+	var clusterConfig models.ClusterConfig
+	if err := sigsyaml.Unmarshal(content, &clusterConfig); err != nil {
+		logrus.Errorf(c.P()+"invalid cluster config: %v", err)
+		return nil, errors.New("error: invalid cluster config: " + err.Error())
 	}
-	if !util.IsValidResourceName(secretName) {
-		logrus.Errorf(c.P()+"invalid secret name: %s\n", secretName)
-		return errors.New("invalid secret name")
+
+	if errs := clusterConfig.Validate(); len(errs) > 0 {
+		logrus.Errorf(c.P()+"invalid cluster config: %v", errs.ToAggregate())
+		return nil, &ClusterConfigError{Errors: errs}
 	}
-	sr := config.NewSecretImpl(constValue.NameSpace, secretName, labelData, "")
-	return sr.Create(context.TODO(), metav1.CreateOptions{}, clusterConfigData)
+	return &clusterConfig, nil
 }
 
-func updateClusterConfig2Secret(c util.Context, clusterID string, configBytes []byte, labelData map[string]string) error {
-	var secretName string
+func saveClusterConfig2Secret(c util.Context, clusterID string, configBytes []byte, labelData map[string]string) error {
 	encodedConfig := base64.StdEncoding.EncodeToString(configBytes)
 	clusterConfigData := map[string]string{
 		constValue.Clusterconfig: string(encodedConfig),
 	}
-	secretName = clusterID + constValue.ClusterconfigPrefix
-	if len(labelData) > 0 {
-		for key, value := range labelData {
-			secretName = secretName + "-" + key + "-" + value
-		}
-	}
-	if !util.IsValidResourceName(secretName) {
-		logrus.Errorf(c.P()+"invalid secret name: %s\n", secretName)
-		return errors.New("invalid secret name")
+	secretName, err := clusterConfigName(clusterID, labelData)
+	if err != nil {
+		logrus.Errorf(c.P()+"invalid secret name: %v\n", err)
+		return err
 	}
-	sr := config.NewSecretImpl(constValue.NameSpace, secretName, labelData, "")
-	return sr.Update(context.TODO(), metav1.UpdateOptions{}, clusterConfigData)
+	return saveConfig(c, clusterConfigStore, secretName, clusterConfigData, clusterConfigLabels(clusterID, labelData))
 }
 
-// 应用Cr资源
-func applyCRResource(clusterconfigBytes []byte, labelData map[string]string) error {
-	cr := config.NewCrImpl(
-		"group",
-		constValue.DefaultCrVersion,
-		"kind",
-		"resource",
-		constValue.NameSpace,
-		"crName",
-		constValue.DefaultCrUpdateField,
-		labelData,
-	)
-	err := cr.Create(context.TODO(), metav1.CreateOptions{}, clusterconfigBytes)
+// updateClusterConfig2Secret 更新集群配置secret，resourceVersion不为空时作为更新的前置条件
+func updateClusterConfig2Secret(c util.Context, clusterID string, configBytes []byte, labelData map[string]string, resourceVersion string) error {
+	encodedConfig := base64.StdEncoding.EncodeToString(configBytes)
+	clusterConfigData := map[string]string{
+		constValue.Clusterconfig: string(encodedConfig),
+	}
+	secretName, err := clusterConfigName(clusterID, labelData)
 	if err != nil {
-		logrus.Errorf("failed to apply CR resource: %v", err)
-		return errors.New("failed to apply CR resource:" + err.Error())
+		logrus.Errorf(c.P()+"invalid secret name: %v\n", err)
+		return err
 	}
-
-	return nil
+	return updateConfig(c, clusterConfigStore, secretName, clusterConfigData, clusterConfigLabels(clusterID, labelData), resourceVersion)
 }
@@ -0,0 +1,204 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/proto"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+const redactedValue = "******"
+
+// credentialFields 需要脱敏的字段，如Node.Password、OpenStackConfig.Password
+var credentialFields = map[string]bool{
+	"password":       true,
+	"token":          true,
+	"certificatekey": true,
+}
+
+/**
+* @Description: 比较集群配置的两个历史版本
+* @param from 旧版本号
+* @param to 新版本号，为0时与最新版本比较
+* return
+*   @resp 新增、删除、修改的字段，凭据字段的值已脱敏
+*
+ */
+
+func DiffClusterConfigRevisions(c util.Context, clusterID string, labels string, from, to int) (*proto.ConfigDiff, error) {
+	secretName, err := clusterConfigSecretName(clusterID, labels)
+	if err != nil {
+		return nil, err
+	}
+	if to == 0 {
+		revisions, err := clusterConfigStore.ListRevisions(context.TODO(), secretName)
+		if err != nil {
+			return nil, err
+		}
+		if len(revisions) == 0 {
+			return nil, fmt.Errorf("cluster config %s has no revision", clusterID)
+		}
+		to = revisions[len(revisions)-1].Revision
+	}
+
+	oldContent, err := clusterConfigRevisionContent(secretName, from)
+	if err != nil {
+		return nil, err
+	}
+	newContent, err := clusterConfigRevisionContent(secretName, to)
+	if err != nil {
+		return nil, err
+	}
+	return diffClusterConfig(c, from, to, oldContent, newContent)
+}
+
+// DiffClusterConfigCandidate 比较集群配置的历史版本与待上传的集群配置，to为0
+func DiffClusterConfigCandidate(c util.Context, clusterID string, labels string, from int, candidate []byte) (*proto.ConfigDiff, error) {
+	secretName, err := clusterConfigSecretName(clusterID, labels)
+	if err != nil {
+		return nil, err
+	}
+	oldContent, err := clusterConfigRevisionContent(secretName, from)
+	if err != nil {
+		return nil, err
+	}
+	return diffClusterConfig(c, from, 0, oldContent, candidate)
+}
+
+func clusterConfigRevisionContent(secretName string, revision int) ([]byte, error) {
+	obj, err := clusterConfigStore.GetRevision(context.TODO(), secretName, revision)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(obj.Data[constValue.Clusterconfig])
+}
+
+func diffClusterConfig(c util.Context, from, to int, oldContent, newContent []byte) (*proto.ConfigDiff, error) {
+	var oldConfig, newConfig interface{}
+	if err := sigsyaml.Unmarshal(oldContent, &oldConfig); err != nil {
+		logrus.Errorf(c.P()+"invalid cluster config revision %d: %v", from, err)
+		return nil, fmt.Errorf("invalid cluster config revision %d: %v", from, err)
+	}
+	if err := sigsyaml.Unmarshal(newContent, &newConfig); err != nil {
+		logrus.Errorf(c.P()+"invalid cluster config: %v", err)
+		return nil, fmt.Errorf("invalid cluster config: %v", err)
+	}
+
+	diff := &proto.ConfigDiff{From: from, To: to, Changes: []proto.ConfigDiffEntry{}}
+	diffValue("", "", oldConfig, newConfig, &diff.Changes)
+	return diff, nil
+}
+
+// diffValue 递归比较两个yaml节点，字段路径格式与校验错误一致，如master[0].ip
+func diffValue(path string, key string, oldValue, newValue interface{}, changes *[]proto.ConfigDiffEntry) {
+	if reflect.DeepEqual(oldValue, newValue) {
+		return
+	}
+
+	oldMap, oldIsMap := oldValue.(map[string]interface{})
+	newMap, newIsMap := newValue.(map[string]interface{})
+	if oldIsMap && newIsMap {
+		keys := make([]string, 0, len(oldMap)+len(newMap))
+		for k := range oldMap {
+			keys = append(keys, k)
+		}
+		for k := range newMap {
+			if _, ok := oldMap[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			childPath := k
+			if path != "" {
+				childPath = path + "." + k
+			}
+			oldChild, inOld := oldMap[k]
+			newChild, inNew := newMap[k]
+			switch {
+			case !inOld:
+				addDiffEntry(changes, proto.ConfigDiffAdded, childPath, k, nil, newChild)
+			case !inNew:
+				addDiffEntry(changes, proto.ConfigDiffRemoved, childPath, k, oldChild, nil)
+			default:
+				diffValue(childPath, k, oldChild, newChild, changes)
+			}
+		}
+		return
+	}
+
+	oldList, oldIsList := oldValue.([]interface{})
+	newList, newIsList := newValue.([]interface{})
+	if oldIsList && newIsList {
+		for i := 0; i < len(oldList) || i < len(newList); i++ {
+			childPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(oldList):
+				addDiffEntry(changes, proto.ConfigDiffAdded, childPath, key, nil, newList[i])
+			case i >= len(newList):
+				addDiffEntry(changes, proto.ConfigDiffRemoved, childPath, key, oldList[i], nil)
+			default:
+				diffValue(childPath, key, oldList[i], newList[i], changes)
+			}
+		}
+		return
+	}
+
+	addDiffEntry(changes, proto.ConfigDiffChanged, path, key, oldValue, newValue)
+}
+
+func addDiffEntry(changes *[]proto.ConfigDiffEntry, diffType proto.ConfigDiffType, path string, key string, oldValue, newValue interface{}) {
+	*changes = append(*changes, proto.ConfigDiffEntry{
+		Path: path,
+		Type: diffType,
+		From: redact(key, oldValue),
+		To:   redact(key, newValue),
+	})
+}
+
+// redact 凭据字段的值替换为******，新增或删除的对象中包含的凭据字段同样脱敏
+func redact(key string, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	if credentialFields[strings.ToLower(key)] {
+		return redactedValue
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			result[k] = redact(k, item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = redact("", item)
+		}
+		return result
+	default:
+		return value
+	}
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/models"
+	"ops-entry/proto"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+/**
+* @Description: 根据结构化的集群配置生成nkd集群配置文件，补全默认值并校验后，
+* 与上传的集群配置文件相同的方式保存
+* return
+*   @resp 生成的集群配置yaml，校验失败时返回*ClusterConfigError
+*
+ */
+
+func GenerateClusterConfigFile(c util.Context, param *proto.ClusterConfigGenerateParam) ([]byte, error) {
+	clusterConfig := param.Config
+	clusterConfig.ClusterID = param.ClusterId
+	setClusterConfigDefaults(&clusterConfig)
+
+	if errs := clusterConfig.Validate(); len(errs) > 0 {
+		logrus.Errorf(c.P()+"invalid cluster config: %v", errs.ToAggregate())
+		return nil, &ClusterConfigError{Errors: errs}
+	}
+
+	content, err := renderClusterConfig(&clusterConfig)
+	if err != nil {
+		logrus.Errorf(c.P()+"render cluster config failed: %v", err)
+		return nil, err
+	}
+
+	if err := storeClusterConfig(c, param.ClusterId, param.Labels, proto.FileTypeFile, content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// setClusterConfigDefaults 补全未设置的字段
+func setClusterConfigDefaults(conf *models.ClusterConfig) {
+	k := &conf.Kubernetes
+	if len(k.Version) == 0 {
+		k.Version = constValue.DefaultKubernetesVersion
+	}
+	if len(k.ApiVersion) == 0 {
+		k.ApiVersion = constValue.DefaultKubernetesApiVersion
+	}
+	if len(k.ImageRegistry) == 0 {
+		k.ImageRegistry = constValue.DefaultImageRegistry
+	}
+	if len(k.PauseImage) == 0 {
+		k.PauseImage = constValue.DefaultPauseImage
+	}
+	if len(k.Network.ServiceSubnet) == 0 {
+		k.Network.ServiceSubnet = constValue.DefaultServiceSubnet
+	}
+	if len(k.Network.PodSubnet) == 0 {
+		k.Network.PodSubnet = constValue.DefaultPodSubnet
+	}
+	if len(k.Network.Plugin) == 0 {
+		k.Network.Plugin = constValue.DefaultNetworkPlugin
+	}
+
+	for i := range conf.Master {
+		if len(conf.Master[i].Name) == 0 {
+			conf.Master[i].Name = fmt.Sprintf(constValue.DefaultMasterNameFormat, i+1)
+		}
+		if conf.Master[i].Port == 0 {
+			conf.Master[i].Port = constValue.DefaultApiserverPort
+		}
+	}
+	for i := range conf.Worker {
+		if len(conf.Worker[i].Name) == 0 {
+			conf.Worker[i].Name = fmt.Sprintf(constValue.DefaultWorkerNameFormat, i+1)
+		}
+	}
+
+	// 默认使用第一个master节点作为apiserver地址
+	if len(k.ApiserverEndpoint) == 0 && len(conf.Master) > 0 && len(conf.Master[0].IP) > 0 {
+		k.ApiserverEndpoint = net.JoinHostPort(conf.Master[0].IP, strconv.Itoa(conf.Master[0].Port))
+	}
+}
+
+// renderClusterConfig 生成yaml，去掉未设置的空对象
+func renderClusterConfig(conf *models.ClusterConfig) ([]byte, error) {
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	var object interface{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	return sigsyaml.Marshal(pruneEmpty(object))
+}
+
+func pruneEmpty(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			item = pruneEmpty(item)
+			if m, ok := item.(map[string]interface{}); ok && len(m) == 0 {
+				delete(v, key)
+				continue
+			}
+			v[key] = item
+		}
+		return v
+	case []interface{}:
+		for i := range v {
+			v[i] = pruneEmpty(v[i])
+		}
+		return v
+	default:
+		return value
+	}
+}
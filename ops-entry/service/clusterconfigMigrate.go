@@ -0,0 +1,127 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"context"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+/**
+* @Description: 迁移旧版本保存的集群配置secret，旧名称为<cluster_id>-clusterconfig[-<key>-<value>...]，
+* 只带有用户labels，迁移为按labels哈希命名、带有类型和集群id标签的secret，否则按标签查询不到
+* return
+*   @resp 迁移的集群配置数，部分迁移失败时返回最后一个错误
+*
+ */
+
+func MigrateClusterConfigs(c util.Context) (int, error) {
+	if configManager.KCS == nil {
+		return 0, nil
+	}
+	list, err := configManager.KCS.ClientSet.CoreV1().Secrets(constValue.NameSpace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		logrus.Errorf(c.P()+"list secrets failed: %v", err)
+		return 0, err
+	}
+
+	count := 0
+	var lastErr error
+	for i := range list.Items {
+		clusterID, ok := legacyClusterConfigID(&list.Items[i])
+		if !ok {
+			continue
+		}
+		if err := migrateClusterConfig(c, &list.Items[i], clusterID); err != nil {
+			logrus.Errorf(c.P()+"migrate cluster config failed [name:%s],[err:%v]", list.Items[i].Name, err)
+			lastErr = err
+			continue
+		}
+		count++
+	}
+	return count, lastErr
+}
+
+// legacyClusterConfigID 旧版本集群配置secret的集群id，不是旧版本集群配置时返回false
+func legacyClusterConfigID(secret *corev1.Secret) (string, bool) {
+	if len(secret.Labels[constValue.LabelType]) > 0 || len(secret.Annotations[constValue.AnnotationRevisionOf]) > 0 {
+		return "", false
+	}
+	if _, ok := secret.Data[constValue.Clusterconfig]; !ok {
+		return "", false
+	}
+	name, ok := strings.CutPrefix(secret.Name, constValue.Prefix+constValue.SECRET)
+	if !ok {
+		return "", false
+	}
+	idx := strings.Index(name, constValue.ClusterconfigPrefix)
+	if idx <= 0 {
+		return "", false
+	}
+	if suffix := name[idx+len(constValue.ClusterconfigPrefix):]; len(suffix) > 0 && !strings.HasPrefix(suffix, "-") {
+		return "", false
+	}
+	return name[:idx], true
+}
+
+// migrateClusterConfig 没有labels时名称不变，只补充标签；有labels时以新名称保存后删除旧secret
+func migrateClusterConfig(c util.Context, secret *corev1.Secret, clusterID string) error {
+	oldName := strings.TrimPrefix(secret.Name, constValue.Prefix+constValue.SECRET)
+	userLabels := make(map[string]string, len(secret.Labels))
+	for key, value := range secret.Labels {
+		userLabels[key] = value
+	}
+	newName, err := clusterConfigName(clusterID, userLabels)
+	if err != nil {
+		return err
+	}
+	labels := clusterConfigLabels(clusterID, userLabels)
+
+	if newName == oldName {
+		if err := clusterConfigStore.AddLabels(context.TODO(), oldName, labels); err != nil {
+			return err
+		}
+		logrus.Infof(c.P()+"cluster config migrated [name:%s]", oldName)
+		return recordRevision(c, clusterConfigStore, newName, constValue.ChangeCauseMigrate)
+	}
+
+	obj, err := clusterConfigStore.Get(context.TODO(), oldName)
+	if err != nil {
+		return err
+	}
+	err = clusterConfigStore.Create(context.TODO(), &configManager.ConfigObject{Name: newName, Labels: labels, Data: obj.Data})
+	switch {
+	case k8serrors.IsAlreadyExists(err):
+		// 升级后已重新上传，保留新上传的集群配置
+		logrus.Warnf(c.P()+"cluster config %s already exists, drop the legacy one [name:%s]", newName, oldName)
+	case err != nil:
+		return err
+	default:
+		if err := recordRevision(c, clusterConfigStore, newName, constValue.ChangeCauseMigrate); err != nil {
+			return err
+		}
+	}
+	if err := clusterConfigStore.Delete(context.TODO(), oldName); err != nil {
+		return err
+	}
+	logrus.Infof(c.P()+"cluster config migrated [name:%s],[new:%s]", oldName, newName)
+	return nil
+}
@@ -0,0 +1,471 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"ops-entry/db/configManager/config"
+	"ops-entry/db/configManager/encryption"
+	"ops-entry/models"
+	"ops-entry/proto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseClusterConfig(t *testing.T) {
+	c := util.CreateContext("")
+
+	conf, err := ParseClusterConfig(c, []byte("cluster_id: cluster\nkubernetes:\n  version: v1.29.1\n  image_registry: k8s.gcr.io\nmaster:\n- name: master01\n  ip: 192.168.1.10\n  port: 6443\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "master01", conf.Master[0].Name)
+	assert.Equal(t, 6443, conf.Master[0].Port)
+
+	_, err = ParseClusterConfig(c, []byte("kubernetes:\n  image_registry: k8s.gcr.io\nmaster:\n- port: abc\n"))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "port")
+
+	_, err = ParseClusterConfig(c, []byte("master:\n- name: master01\n  ip: 192.168.1.1000\n"))
+	var configErr *ClusterConfigError
+	assert.True(t, errors.As(err, &configErr))
+	violations := configErr.Violations()
+	assert.Len(t, violations, 3)
+	assert.Equal(t, "kubernetes.image_registry", violations[0].Field)
+	assert.Equal(t, "master[0].ip", violations[1].Field)
+	assert.Equal(t, "master[0].port", violations[2].Field)
+}
+
+func TestRenderClusterConfig(t *testing.T) {
+	conf := models.ClusterConfig{
+		ClusterID: "cluster",
+		Master:    []models.KubernetesMasterNode{{IP: "192.168.1.10"}},
+		Worker:    []models.KubernetesWorkerNode{{IP: "192.168.1.20"}, {Name: "gpu", IP: "192.168.1.21"}},
+	}
+	setClusterConfigDefaults(&conf)
+	assert.Equal(t, "k8s-master01", conf.Master[0].Name)
+	assert.Equal(t, constValue.DefaultApiserverPort, conf.Master[0].Port)
+	assert.Equal(t, "k8s-worker01", conf.Worker[0].Name)
+	assert.Equal(t, "gpu", conf.Worker[1].Name)
+	assert.Equal(t, "192.168.1.10:6443", conf.Kubernetes.ApiserverEndpoint)
+	assert.Empty(t, conf.Validate())
+
+	content, err := renderClusterConfig(&conf)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(content), "{}")
+
+	parsed, err := ParseClusterConfig(util.CreateContext(""), content)
+	assert.Nil(t, err)
+	assert.Equal(t, conf, *parsed)
+}
+
+func TestDiffClusterConfig(t *testing.T) {
+	oldContent := []byte(`
+kubernetes:
+  version: v1.28.3
+  token: old-token
+master:
+- name: master01
+  ip: 192.168.1.10
+  password: old
+worker:
+- name: worker01
+  ip: 192.168.1.20
+`)
+	newContent := []byte(`
+kubernetes:
+  version: v1.29.1
+  token: new-token
+  image_registry: k8s.gcr.io
+master:
+- name: master01
+  ip: 192.168.1.11
+  password: new
+worker:
+- name: worker01
+  ip: 192.168.1.20
+- name: worker02
+  ip: 192.168.1.21
+  open_stack:
+    password: secret
+`)
+	diff, err := diffClusterConfig(util.CreateContext(""), 1, 2, oldContent, newContent)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, diff.From)
+	assert.Equal(t, 2, diff.To)
+	assert.Equal(t, []proto.ConfigDiffEntry{
+		{Path: "kubernetes.image_registry", Type: proto.ConfigDiffAdded, To: "k8s.gcr.io"},
+		{Path: "kubernetes.token", Type: proto.ConfigDiffChanged, From: "******", To: "******"},
+		{Path: "kubernetes.version", Type: proto.ConfigDiffChanged, From: "v1.28.3", To: "v1.29.1"},
+		{Path: "master[0].ip", Type: proto.ConfigDiffChanged, From: "192.168.1.10", To: "192.168.1.11"},
+		{Path: "master[0].password", Type: proto.ConfigDiffChanged, From: "******", To: "******"},
+		{Path: "worker[1]", Type: proto.ConfigDiffAdded, To: map[string]interface{}{
+			"name":       "worker02",
+			"ip":         "192.168.1.21",
+			"open_stack": map[string]interface{}{"password": "******"},
+		}},
+	}, diff.Changes)
+
+	diff, err = diffClusterConfig(util.CreateContext(""), 1, 0, oldContent, oldContent)
+	assert.Nil(t, err)
+	assert.Empty(t, diff.Changes)
+}
+
+func TestClusterConfigStore(t *testing.T) {
+	originCluster, originKube := clusterConfigStore, kubeconfigStore
+	clusterConfigStore, kubeconfigStore = config.NewMemoryStore(), config.NewMemoryStore()
+	defer func() { clusterConfigStore, kubeconfigStore = originCluster, originKube }()
+
+	c := util.CreateContext("")
+	_, err := QueryClusterConfigFile(c, "k8s-001", "")
+	assert.True(t, k8serrors.IsNotFound(err))
+
+	v1 := []byte("kubernetes:\n  version: v1.28.3\n")
+	v2 := []byte("kubernetes:\n  version: v1.29.1\n")
+	assert.Nil(t, saveClusterConfig2Secret(c, "k8s-001", v1, nil))
+	assert.Nil(t, updateClusterConfig2Secret(c, "k8s-001", v2, nil, ""))
+
+	obj, err := QueryClusterConfigFile(c, "k8s-001", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "k8s-001-clusterconfig", obj.Name)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(v2), obj.Data[constValue.Clusterconfig])
+
+	revisions, err := ListClusterConfigRevisions(c, "k8s-001", "")
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 2)
+	assert.Equal(t, constValue.ChangeCauseUpdate, revisions[1].Cause)
+
+	diff, err := DiffClusterConfigRevisions(c, "k8s-001", "", 1, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, diff.To)
+	assert.Equal(t, []proto.ConfigDiffEntry{
+		{Path: "kubernetes.version", Type: proto.ConfigDiffChanged, From: "v1.28.3", To: "v1.29.1"},
+	}, diff.Changes)
+
+	// 集群配置与kubeconfig分别保存
+	_, err = QueryKubeconfigFile(c, "k8s-001")
+	assert.True(t, k8serrors.IsNotFound(err))
+
+	assert.Nil(t, DeleteClusterConfigFile(c, "k8s-001", ""))
+	_, err = QueryClusterConfigFile(c, "k8s-001", "")
+	assert.True(t, k8serrors.IsNotFound(err))
+}
+
+func TestClusterConfigLabels(t *testing.T) {
+	origin := clusterConfigStore
+	clusterConfigStore = config.NewMemoryStore()
+	defer func() { clusterConfigStore = origin }()
+
+	c := util.CreateContext("")
+	labels := map[string]string{"env": "prod", "region": "north", "zone": "a"}
+	name, err := clusterConfigName("k8s-001", labels)
+	assert.Nil(t, err)
+	// 名称与map的遍历顺序无关
+	for i := 0; i < 20; i++ {
+		again, err := clusterConfigSecretName("k8s-001", `{"zone":"a","region":"north","env":"prod"}`)
+		assert.Nil(t, err)
+		assert.Equal(t, name, again)
+	}
+	assert.Equal(t, "k8s-001-clusterconfig-"+util.LabelsHash(labels), name)
+
+	assert.Nil(t, saveClusterConfig2Secret(c, "k8s-001", []byte("cluster_id: k8s-001\n"), labels))
+	assert.Nil(t, saveClusterConfig2Secret(c, "k8s-001", []byte("cluster_id: k8s-001\n"), nil))
+
+	obj, err := QueryClusterConfigFile(c, "k8s-001", `{"region":"north","zone":"a","env":"prod"}`)
+	assert.Nil(t, err)
+	assert.Equal(t, name, obj.Name)
+	assert.Equal(t, "prod", obj.Labels["env"])
+	assert.Equal(t, "k8s-001", obj.Labels[constValue.LabelClusterId])
+
+	obj, err = QueryClusterConfigFile(c, "k8s-001", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "k8s-001-clusterconfig", obj.Name)
+
+	// labels不完全一致时不匹配
+	_, err = QueryClusterConfigFile(c, "k8s-001", `{"env":"prod"}`)
+	assert.True(t, k8serrors.IsNotFound(err))
+	_, err = QueryClusterConfigFile(c, "k8s-002", `{"region":"north","zone":"a","env":"prod"}`)
+	assert.True(t, k8serrors.IsNotFound(err))
+
+	_, err = QueryClusterConfigFile(c, "k8s-001", `{"env":"prod/1"}`)
+	assert.NotNil(t, err)
+
+	// operator保存的集群配置使用单独的secret，不覆盖上传的集群配置
+	assert.Nil(t, storeOperatorClusterConfig(c, "k8s-001", []byte("cluster_id: k8s-001\nworker: []\n")))
+	obj, err = QueryClusterConfigFile(c, "k8s-001", "")
+	assert.Nil(t, err)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("cluster_id: k8s-001\n")), obj.Data[constValue.Clusterconfig])
+	obj, err = QueryClusterConfigFile(c, "k8s-001", operatorConfigLabels)
+	assert.Nil(t, err)
+	assert.Equal(t, constValue.ManagedByOperator, obj.Labels[constValue.LabelManagedBy])
+	assert.NotEqual(t, "k8s-001-clusterconfig", obj.Name)
+
+	// 上传时不能使用保留的label key
+	err = storeClusterConfig(c, "k8s-001", operatorConfigLabels, proto.FileTypeFile, []byte("cluster_id: k8s-001\n"))
+	assert.ErrorContains(t, err, "is reserved")
+}
+
+func TestMigrateClusterConfigs(t *testing.T) {
+	legacy := func(name string, labels map[string]string, content string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: constValue.Prefix + constValue.SECRET + name, Namespace: constValue.NameSpace, Labels: labels},
+			Data:       map[string][]byte{constValue.Clusterconfig: []byte(base64.StdEncoding.EncodeToString([]byte(content)))},
+		}
+	}
+	clientSet := k8sfake.NewSimpleClientset(
+		legacy("k8s-001-clusterconfig", nil, "cluster_id: k8s-001\n"),
+		legacy("k8s-001-clusterconfig-env-prod-zone-a", map[string]string{"env": "prod", "zone": "a"}, "cluster_id: k8s-001\nzone: a\n"),
+		legacy("k8s-001-kubeconfig", nil, "kubeconfig"),
+	)
+	originKCS, originStore := configManager.KCS, clusterConfigStore
+	configManager.KCS = &configManager.K8sClientSet{ClientSet: clientSet}
+	clusterConfigStore = config.NewSecretImpl(nil, constValue.NameSpace, "")
+	defer func() { configManager.KCS, clusterConfigStore = originKCS, originStore }()
+
+	c := util.CreateContext("")
+	// 迁移前按标签查询不到
+	_, err := QueryClusterConfigFile(c, "k8s-001", `{"zone":"a","env":"prod"}`)
+	assert.True(t, k8serrors.IsNotFound(err))
+
+	count, err := MigrateClusterConfigs(c)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	obj, err := QueryClusterConfigFile(c, "k8s-001", `{"zone":"a","env":"prod"}`)
+	assert.Nil(t, err)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("cluster_id: k8s-001\nzone: a\n")), obj.Data[constValue.Clusterconfig])
+	obj, err = QueryClusterConfigFile(c, "k8s-001", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "k8s-001-clusterconfig", obj.Name)
+
+	revisions, err := ListClusterConfigRevisions(c, "k8s-001", `{"env":"prod","zone":"a"}`)
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 1)
+	assert.Equal(t, constValue.ChangeCauseMigrate, revisions[0].Cause)
+
+	_, err = clientSet.CoreV1().Secrets(constValue.NameSpace).Get(context.TODO(), constValue.Prefix+constValue.SECRET+"k8s-001-clusterconfig-env-prod-zone-a", metav1.GetOptions{})
+	assert.True(t, k8serrors.IsNotFound(err))
+	_, err = clientSet.CoreV1().Secrets(constValue.NameSpace).Get(context.TODO(), constValue.Prefix+constValue.SECRET+"k8s-001-kubeconfig", metav1.GetOptions{})
+	assert.Nil(t, err)
+
+	// 再次执行时没有需要迁移的集群配置
+	count, err = MigrateClusterConfigs(c)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestListClusterConfigs(t *testing.T) {
+	originCluster, originKube := clusterConfigStore, kubeconfigStore
+	clusterConfigStore, kubeconfigStore = config.NewMemoryStore(), config.NewMemoryStore()
+	defer func() { clusterConfigStore, kubeconfigStore = originCluster, originKube }()
+
+	c := util.CreateContext("")
+	content := []byte("cluster_id: k8s\n")
+	assert.Nil(t, saveClusterConfig2Secret(c, "k8s-001", content, map[string]string{"env": "prod"}))
+	assert.Nil(t, updateClusterConfig2Secret(c, "k8s-001", content, map[string]string{"env": "prod"}, ""))
+	assert.Nil(t, saveClusterConfig2Secret(c, "k8s-002", content, map[string]string{"env": "test"}))
+	assert.Nil(t, saveClusterConfig2Secret(c, "k8s-003", content, nil))
+	assert.Nil(t, saveKubeConfig2Secret(c, "k8s-001", []byte("apiVersion: v1\n"), nil))
+
+	list, err := ListClusterConfigs(c, &proto.ConfigListParam{LabelSelector: "env in (prod,test)", Order: "desc"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, list.Total)
+	assert.Equal(t, "k8s-002", list.Items[0].ClusterId)
+	assert.Equal(t, map[string]string{"env": "test"}, list.Items[0].Labels)
+	assert.Equal(t, "k8s-001", list.Items[1].ClusterId)
+	assert.Equal(t, 2, list.Items[1].Revisions)
+	assert.Equal(t, len(content), list.Items[1].Size)
+
+	// 分页
+	list, err = ListClusterConfigs(c, &proto.ConfigListParam{Limit: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, list.Total)
+	assert.Len(t, list.Items, 2)
+	assert.NotEmpty(t, list.Continue)
+	// 翻页期间删除已返回的配置，下一页不会遗漏
+	name, err := clusterConfigSecretName("k8s-001", `{"env":"prod"}`)
+	assert.Nil(t, err)
+	assert.Nil(t, clusterConfigStore.Delete(context.TODO(), name))
+	list, err = ListClusterConfigs(c, &proto.ConfigListParam{Limit: 2, Continue: list.Continue})
+	assert.Nil(t, err)
+	assert.Len(t, list.Items, 1)
+	assert.Equal(t, "k8s-003", list.Items[0].ClusterId)
+	assert.Empty(t, list.Continue)
+
+	list, err = ListClusterConfigs(c, &proto.ConfigListParam{SortBy: "update_time", Order: "desc", Limit: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, "k8s-003", list.Items[0].ClusterId)
+	list, err = ListClusterConfigs(c, &proto.ConfigListParam{SortBy: "update_time", Order: "desc", Limit: 1, Continue: list.Continue})
+	assert.Nil(t, err)
+	assert.Equal(t, "k8s-002", list.Items[0].ClusterId)
+
+	list, err = ListKubeconfigs(c, &proto.ConfigListParam{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, list.Total)
+	assert.Equal(t, "k8s-001", list.Items[0].ClusterId)
+
+	for _, param := range []proto.ConfigListParam{
+		{LabelSelector: "env in prod"},
+		{SortBy: "size"},
+		{Order: "up"},
+		{Continue: "invalid!"},
+	} {
+		_, err = ListClusterConfigs(c, &param)
+		assert.True(t, errors.Is(err, ErrInvalidListParam), param)
+	}
+}
+
+func TestClusterConfigIfMatch(t *testing.T) {
+	origin := clusterConfigStore
+	clusterConfigStore = config.NewMemoryStore()
+	defer func() { clusterConfigStore = origin }()
+
+	c := util.CreateContext("")
+	assert.Nil(t, saveClusterConfig2Secret(c, "k8s-001", []byte("cluster_id: k8s-001\n"), nil))
+	obj, err := QueryClusterConfigFile(c, "k8s-001", "")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, obj.ResourceVersion)
+
+	assert.Nil(t, updateClusterConfig2Secret(c, "k8s-001", []byte("cluster_id: k8s-002\n"), nil, obj.ResourceVersion))
+	// 使用已过期的版本更新被拒绝，配置保持不变
+	err = updateClusterConfig2Secret(c, "k8s-001", []byte("cluster_id: k8s-003\n"), nil, obj.ResourceVersion)
+	assert.True(t, k8serrors.IsConflict(err))
+
+	latest, err := QueryClusterConfigFile(c, "k8s-001", "")
+	assert.Nil(t, err)
+	assert.NotEqual(t, obj.ResourceVersion, latest.ResourceVersion)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("cluster_id: k8s-002\n")), latest.Data[constValue.Clusterconfig])
+}
+
+func TestConfigEncryption(t *testing.T) {
+	originCluster, originKube := clusterConfigStore, kubeconfigStore
+	defer func() { clusterConfigStore, kubeconfigStore = originCluster, originKube }()
+	clusterStore, kubeStore := config.NewMemoryStore(), config.NewMemoryStore()
+	clusterConfigStore, kubeconfigStore = clusterStore, kubeStore
+
+	c := util.CreateContext("")
+	_, err := RotateConfigEncryptionKey(c)
+	assert.Equal(t, ErrEncryptionDisabled, err)
+
+	// 启用加密前保存的集群配置
+	content := []byte("openstack:\n  password: secret\n")
+	assert.Nil(t, saveClusterConfig2Secret(c, "k8s-001", content, nil))
+
+	provider, err := encryption.ParseKeyFile([]byte("k1:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="))
+	assert.Nil(t, err)
+	enableConfigEncryption(encryption.NewEnvelope(provider))
+	assert.Nil(t, saveKubeConfig2Secret(c, "k8s-001", []byte("apiVersion: v1\n"), nil))
+
+	raw, err := kubeStore.Get(context.TODO(), "k8s-001-kubeconfig")
+	assert.Nil(t, err)
+	assert.True(t, encryption.IsEncrypted(raw.Data[constValue.Kubeconfig]))
+	raw, err = clusterStore.Get(context.TODO(), "k8s-001-clusterconfig")
+	assert.Nil(t, err)
+	assert.False(t, encryption.IsEncrypted(raw.Data[constValue.Clusterconfig]))
+
+	// 集群配置和历史版本各一个
+	count, err := RotateConfigEncryptionKey(c)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	raw, err = clusterStore.Get(context.TODO(), "k8s-001-clusterconfig")
+	assert.Nil(t, err)
+	assert.True(t, encryption.IsEncrypted(raw.Data[constValue.Clusterconfig]))
+
+	obj, err := QueryClusterConfigFile(c, "k8s-001", "")
+	assert.Nil(t, err)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(content), obj.Data[constValue.Clusterconfig])
+	obj, err = QueryKubeconfigFile(c, "k8s-001")
+	assert.Nil(t, err)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("apiVersion: v1\n")), obj.Data[constValue.Kubeconfig])
+}
+
+func TestKubeMateClusterCR(t *testing.T) {
+	gvrToListKind := map[schema.GroupVersionResource]string{
+		KubeMateClusterGVR: constValue.KubeMateClusterListKind,
+		{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}: "CustomResourceDefinitionList",
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), gvrToListKind)
+	origin := configManager.KCS
+	configManager.KCS = &configManager.K8sClientSet{DynamicClientSet: dynamicClient}
+	defer func() { configManager.KCS = origin }()
+
+	ctx := context.TODO()
+	crd := kubeMateClusterCRD()
+	versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	specSchema, _, _ := unstructured.NestedMap(versions[0].(map[string]interface{}), "schema", "openAPIV3Schema", "properties", "spec")
+	ipType, _, _ := unstructured.NestedString(specSchema, "properties", "master", "items", "properties", "ip", "type")
+	assert.Equal(t, "string", ipType)
+	portFormat, _, _ := unstructured.NestedString(specSchema, "properties", "master", "items", "properties", "port", "format")
+	assert.Equal(t, "int64", portFormat)
+	_, found, _ := unstructured.NestedMap(specSchema, "properties", "master", "items", "properties", "open_stack", "properties", "password")
+	assert.False(t, found)
+	_, found, _ = unstructured.NestedMap(specSchema, "properties", "credentials_secret")
+	assert.True(t, found)
+	_, found, _ = unstructured.NestedMap(versions[0].(map[string]interface{}), "subresources", "status")
+	assert.True(t, found)
+	// 创建后再次安装为更新
+	assert.Nil(t, config.EnsureCRD(ctx, nil, crd))
+	assert.Nil(t, config.EnsureCRD(ctx, nil, crd))
+
+	clusterConfig := &models.ClusterConfig{
+		ClusterID: "k8s-001",
+		Master: []models.KubernetesMasterNode{
+			{Name: "k8s-master01", IP: "192.168.1.10", Port: 6443, OpenStack: models.OpenStackConfig{Password: "secret"}},
+		},
+		Kubernetes: models.KubernetesConfig{Version: "v1.29.1"},
+	}
+	labels := clusterConfigLabels("k8s-001", map[string]string{"env": "prod"})
+	name, err := clusterConfigName("k8s-001", map[string]string{"env": "prod"})
+	assert.Nil(t, err)
+	assert.Nil(t, applyCRResource(clusterConfig, name, labels))
+	assert.Nil(t, applyCRResource(clusterConfig, name, labels))
+
+	list, err := dynamicClient.Resource(KubeMateClusterGVR).Namespace(constValue.NameSpace).List(ctx, metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, list.Items, 2)
+	cr, err := dynamicClient.Resource(KubeMateClusterGVR).Namespace(constValue.NameSpace).Get(ctx, constValue.Prefix+constValue.Cr+name+"-"+constValue.VersionMark+"2", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, constValue.KubeMateClusterKind, cr.GetKind())
+	assert.Equal(t, "prod", cr.GetLabels()["env"])
+	version, _, _ := unstructured.NestedString(cr.Object, "spec", "kubernetes", "version")
+	assert.Equal(t, "v1.29.1", version)
+	masters, _, _ := unstructured.NestedSlice(cr.Object, "spec", "master")
+	port, _, _ := unstructured.NestedInt64(masters[0].(map[string]interface{}), "port")
+	assert.Equal(t, int64(6443), port)
+	_, found, _ = unstructured.NestedString(masters[0].(map[string]interface{}), "open_stack", "password")
+	assert.False(t, found)
+	phase, _, _ := unstructured.NestedString(cr.Object, "status", "phase")
+	assert.Equal(t, string(proto.ClusterStored), phase)
+	operation, _, _ := unstructured.NestedString(cr.Object, "status", "lastOperation", "operation")
+	assert.Equal(t, "upload", operation)
+
+	status, err := QueryClusterStatus(util.CreateContext(""), "k8s-001", `{"env":"prod"}`)
+	assert.Nil(t, err)
+	assert.Equal(t, proto.ClusterStored, status.Phase)
+	assert.True(t, meta.IsStatusConditionTrue(status.Conditions, proto.ConditionReady))
+	_, err = QueryClusterStatus(util.CreateContext(""), "k8s-001", "")
+	assert.True(t, k8serrors.IsNotFound(err))
+}
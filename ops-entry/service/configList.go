@@ -0,0 +1,263 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"ops-entry/proto"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var ErrInvalidListParam = errors.New("invalid list param")
+
+// configKind 列表查询的一类配置
+type configKind struct {
+	store   configManager.RevisionStore
+	typ     string // LabelType的值
+	suffix  string // secret名称中cluster_id之后的部分
+	dataKey string
+}
+
+var kubeconfigKind = configKind{
+	typ:     constValue.KubeconfigType,
+	suffix:  constValue.KubeconfigPrefix,
+	dataKey: constValue.Kubeconfig,
+}
+
+var clusterConfigKind = configKind{
+	typ:     constValue.ClusterConfigType,
+	suffix:  constValue.ClusterconfigPrefix,
+	dataKey: constValue.Clusterconfig,
+}
+
+// kubeconfigLabels 保存在kubeconfig secret上的labels
+func kubeconfigLabels(clusterID string) map[string]string {
+	return map[string]string{
+		constValue.LabelType:      constValue.KubeconfigType,
+		constValue.LabelClusterId: clusterID,
+	}
+}
+
+// ListKubeconfigs 查询全部集群的kubeconfig
+func ListKubeconfigs(c util.Context, param *proto.ConfigListParam) (*proto.ConfigList, error) {
+	kind := kubeconfigKind
+	kind.store = kubeconfigStore
+	return listConfigs(c, kind, param)
+}
+
+// ListClusterConfigs 查询全部集群配置，同一集群不同labels的集群配置分别返回
+func ListClusterConfigs(c util.Context, param *proto.ConfigListParam) (*proto.ConfigList, error) {
+	kind := clusterConfigKind
+	kind.store = clusterConfigStore
+	return listConfigs(c, kind, param)
+}
+
+/**
+* @Description: 按label selector过滤、排序后分页返回配置列表
+* 历史版本数和更新时间一次查询全部历史版本统计得到，大小只对当前页计算；
+* continue为上一页最后一项的排序字段，下一页从排序在其之后的项开始，期间增删配置不会导致重复或遗漏
+* return
+*   @resp 参数不合法时返回ErrInvalidListParam
+*
+ */
+
+func listConfigs(c util.Context, kind configKind, param *proto.ConfigListParam) (*proto.ConfigList, error) {
+	selector, err := labels.Parse(param.LabelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("%w: label_selector: %v", ErrInvalidListParam, err)
+	}
+	less, err := configLess(param.SortBy, param.Order)
+	if err != nil {
+		return nil, err
+	}
+	after, err := decodeContinue(param.Continue)
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := kind.store.List(context.TODO(), nil)
+	if err != nil {
+		logrus.Errorf(c.P()+"list %s failed: %v", kind.typ, err)
+		return nil, err
+	}
+	stats, err := kind.store.RevisionStats(context.TODO())
+	if err != nil {
+		logrus.Errorf(c.P()+"list revisions of %s failed: %v", kind.typ, err)
+		return nil, err
+	}
+
+	items := make([]proto.ConfigSummary, 0, len(objects))
+	data := make(map[string]string, len(objects))
+	for i := range objects {
+		obj := &objects[i]
+		if !kind.match(obj) {
+			continue
+		}
+		userLabels := userLabels(obj.Labels)
+		if !selector.Matches(labels.Set(userLabels)) {
+			continue
+		}
+		items = append(items, kind.summary(obj, userLabels, stats[obj.Name]))
+		data[obj.Name] = obj.Data[kind.dataKey]
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return less(&items[i], &items[j])
+	})
+
+	list := &proto.ConfigList{Total: len(items), Items: []proto.ConfigSummary{}}
+	offset := 0
+	if after != nil {
+		offset = sort.Search(len(items), func(i int) bool {
+			return less(after, &items[i])
+		})
+	}
+	if offset >= len(items) {
+		return list, nil
+	}
+	end := offset + param.Limit
+	if param.Limit <= 0 || end > len(items) {
+		end = len(items)
+	}
+	list.Items = items[offset:end]
+	for i := range list.Items {
+		list.Items[i].Size = dataSize(data[list.Items[i].Name])
+	}
+	if end < len(items) {
+		list.Continue = encodeContinue(&items[end-1], param.SortBy)
+	}
+	return list, nil
+}
+
+// match 旧版本保存的secret没有类型label，按名称判断
+func (k configKind) match(obj *configManager.ConfigObject) bool {
+	if typ, ok := obj.Labels[constValue.LabelType]; ok {
+		return typ == k.typ
+	}
+	return strings.HasSuffix(obj.Name, k.suffix) || strings.Contains(obj.Name, k.suffix+"-")
+}
+
+// summary 每次保存都会记录一个历史版本，最新历史版本的创建时间即更新时间
+func (k configKind) summary(obj *configManager.ConfigObject, userLabels map[string]string, stat configManager.RevisionStat) proto.ConfigSummary {
+	summary := proto.ConfigSummary{
+		ClusterId:    obj.Labels[constValue.LabelClusterId],
+		Name:         obj.Name,
+		Labels:       userLabels,
+		CreationTime: obj.CreationTimestamp,
+		UpdateTime:   obj.CreationTimestamp,
+		Revisions:    stat.Count,
+	}
+	if idx := strings.LastIndex(obj.Name, k.suffix); summary.ClusterId == "" && idx >= 0 {
+		summary.ClusterId = obj.Name[:idx]
+	}
+	if stat.Latest.After(summary.UpdateTime) {
+		summary.UpdateTime = stat.Latest
+	}
+	return summary
+}
+
+// dataSize 解码后的文件大小，不是base64编码时为原始长度
+func dataSize(value string) int {
+	if content, err := base64.StdEncoding.DecodeString(value); err == nil {
+		return len(content)
+	}
+	return len(value)
+}
+
+// userLabels 去掉kubemate内部使用的labels
+func userLabels(objLabels map[string]string) map[string]string {
+	result := make(map[string]string, len(objLabels))
+	for key, value := range objLabels {
+		switch key {
+		case constValue.LabelType, constValue.LabelClusterId, constValue.LabelHash:
+			continue
+		}
+		result[key] = value
+	}
+	return result
+}
+
+func configLess(sortBy, order string) (func(a, b *proto.ConfigSummary) bool, error) {
+	var less func(a, b *proto.ConfigSummary) bool
+	switch sortBy {
+	case "", constValue.ConfigSortByName:
+		less = func(a, b *proto.ConfigSummary) bool { return a.Name < b.Name }
+	case constValue.ConfigSortByCreationTime:
+		less = func(a, b *proto.ConfigSummary) bool { return timeLess(a.CreationTime, b.CreationTime, a.Name, b.Name) }
+	case constValue.ConfigSortByUpdateTime:
+		less = func(a, b *proto.ConfigSummary) bool { return timeLess(a.UpdateTime, b.UpdateTime, a.Name, b.Name) }
+	default:
+		return nil, fmt.Errorf("%w: unknown sort_by %q", ErrInvalidListParam, sortBy)
+	}
+
+	switch order {
+	case "", constValue.ConfigOrderAsc:
+		return less, nil
+	case constValue.ConfigOrderDesc:
+		return func(a, b *proto.ConfigSummary) bool { return less(b, a) }, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown order %q", ErrInvalidListParam, order)
+	}
+}
+
+// timeLess 时间相同时按名称排序，保证分页结果稳定
+func timeLess(a, b time.Time, nameA, nameB string) bool {
+	if a.Equal(b) {
+		return nameA < nameB
+	}
+	return a.Before(b)
+}
+
+// continueToken 上一页最后一项的排序字段
+type continueToken struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+}
+
+func encodeContinue(last *proto.ConfigSummary, sortBy string) string {
+	token := continueToken{Name: last.Name}
+	switch sortBy {
+	case constValue.ConfigSortByCreationTime:
+		token.Time = last.CreationTime
+	case constValue.ConfigSortByUpdateTime:
+		token.Time = last.UpdateTime
+	}
+	value, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(value)
+}
+
+// decodeContinue 返回只包含排序字段的上一页最后一项，token为空时返回nil
+func decodeContinue(value string) (*proto.ConfigSummary, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var token continueToken
+	content, err := base64.RawURLEncoding.DecodeString(value)
+	if err == nil {
+		if err = json.Unmarshal(content, &token); err == nil && len(token.Name) > 0 {
+			return &proto.ConfigSummary{Name: token.Name, CreationTime: token.Time, UpdateTime: token.Time}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: invalid continue token", ErrInvalidListParam)
+}
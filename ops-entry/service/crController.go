@@ -0,0 +1,157 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"context"
+	"errors"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"ops-entry/proto"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// reconcileFunc 处理一个CR，requeue大于0时在该时间后再次处理，返回错误时按退避策略重试
+type reconcileFunc func(ctx context.Context, cr *unstructured.Unstructured) (requeue time.Duration, err error)
+
+// crController 监听一种CR，变化时交给reconcile处理
+type crController struct {
+	gvr      schema.GroupVersionResource
+	client   dynamic.ResourceInterface
+	factory  dynamicinformer.DynamicSharedInformerFactory
+	informer cache.SharedIndexInformer
+	queue    workqueue.RateLimitingInterface
+}
+
+func newCRController(clients *configManager.K8sClientSet, gvr schema.GroupVersionResource, nameSpace string) (*crController, error) {
+	if clients == nil {
+		return nil, errors.New("operator mode requires a management cluster")
+	}
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(clients.DynamicClientSet, constValue.OperatorResyncPeriod, nameSpace, nil)
+	return &crController{
+		gvr:      gvr,
+		client:   clients.DynamicClientSet.Resource(gvr).Namespace(nameSpace),
+		factory:  factory,
+		informer: factory.ForResource(gvr).Informer(),
+		queue:    workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}, nil
+}
+
+/**
+* @Description: 等待缓存同步后启动workers个worker，ctx结束时停止
+* @param reconcile 同一个CR不会被并发处理
+*
+ */
+
+func (ctrl *crController) run(ctx context.Context, workers int, reconcile reconcileFunc) error {
+	_, err := ctrl.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.enqueue,
+		UpdateFunc: func(old, new interface{}) { ctrl.enqueue(new) },
+		DeleteFunc: ctrl.enqueue,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctrl.factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), ctrl.informer.HasSynced) {
+		return errors.New("wait for " + ctrl.gvr.Resource + " cache sync failed")
+	}
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, func(ctx context.Context) {
+			for ctrl.processNextItem(ctx, reconcile) {
+			}
+		}, time.Second)
+	}
+	go func() {
+		<-ctx.Done()
+		ctrl.queue.ShutDown()
+	}()
+	return nil
+}
+
+func (ctrl *crController) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		logrus.Errorf("get %s key failed: %v", ctrl.gvr.Resource, err)
+		return
+	}
+	ctrl.queue.Add(key)
+}
+
+func (ctrl *crController) processNextItem(ctx context.Context, reconcile reconcileFunc) bool {
+	item, quit := ctrl.queue.Get()
+	if quit {
+		return false
+	}
+	defer ctrl.queue.Done(item)
+
+	key := item.(string)
+	obj, exists, err := ctrl.informer.GetIndexer().GetByKey(key)
+	if err != nil || !exists {
+		ctrl.queue.Forget(item)
+		return true
+	}
+	requeue, err := reconcile(ctx, obj.(*unstructured.Unstructured).DeepCopy())
+	if err != nil {
+		logrus.Errorf("reconcile %s %s failed: %v", ctrl.gvr.Resource, key, err)
+		ctrl.queue.AddRateLimited(item)
+		return true
+	}
+	ctrl.queue.Forget(item)
+	if requeue > 0 {
+		ctrl.queue.AddAfter(item, requeue)
+	}
+	return true
+}
+
+// setCondition 设置conditionType的condition，状态变化时更新lastTransitionTime
+func setCondition(conditions *[]metav1.Condition, generation int64, conditionType string, status bool, reason, message string) {
+	condition := metav1.Condition{
+		Type:               conditionType,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            message,
+	}
+	if status {
+		condition.Status = metav1.ConditionTrue
+	}
+	meta.SetStatusCondition(conditions, condition)
+}
+
+// newOperationResult 最近一次结束的操作，failed为false时结果为成功
+func newOperationResult(operation, jobId string, failed bool, message string) *proto.OperationResult {
+	result := &proto.OperationResult{
+		Operation: operation,
+		Result:    proto.OperationSucceeded,
+		JobId:     jobId,
+		Message:   message,
+		Time:      metav1.Now(),
+	}
+	if failed {
+		result.Result = proto.OperationFailed
+	}
+	return result
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"context"
+	"errors"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"ops-entry/db/configManager/config"
+	"ops-entry/db/configManager/encryption"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+var ErrEncryptionDisabled = errors.New("config encryption is not enabled")
+
+/**
+* @Description: 根据环境变量加载主密钥，为kubeconfig和集群配置的存储启用信封加密
+* 未配置提供者且默认密钥文件不存在时不加密，兼容未配置密钥的部署
+*
+ */
+
+func InitConfigEncryption() error {
+	name := os.Getenv(constValue.EncryptionProviderEnv)
+	providerConfig := os.Getenv(constValue.EncryptionConfigEnv)
+	if len(name) == 0 {
+		name = constValue.EncryptionProviderFile
+	}
+	provider, err := encryption.NewProvider(name, providerConfig)
+	if err != nil {
+		if os.IsNotExist(err) && len(os.Getenv(constValue.EncryptionProviderEnv)) == 0 && len(providerConfig) == 0 {
+			logrus.Warnf("encryption key file %s not found, kubeconfigs and cluster configs are stored unencrypted", constValue.EncryptionKeyFile)
+			return nil
+		}
+		logrus.Errorf("load encryption provider %s failed: %v", name, err)
+		return err
+	}
+	enableConfigEncryption(encryption.NewEnvelope(provider))
+	logrus.Infof("config encryption enabled, provider[%s] key[%s]", name, provider.KeyID())
+	return nil
+}
+
+func enableConfigEncryption(envelope *encryption.Envelope) {
+	clusterConfigStore = config.NewEncryptedStore(clusterConfigStore, envelope, constValue.Clusterconfig)
+	kubeconfigStore = config.NewEncryptedStore(kubeconfigStore, envelope, constValue.Kubeconfig)
+}
+
+/**
+* @Description: 使用当前主密钥重新加密全部kubeconfig和集群配置及其历史版本，轮换主密钥时使用
+* return
+*   @resp 重新加密的对象数，未启用加密时返回ErrEncryptionDisabled
+*
+ */
+
+func RotateConfigEncryptionKey(c util.Context) (int, error) {
+	total := 0
+	for _, store := range []configManager.RevisionStore{clusterConfigStore, kubeconfigStore} {
+		encrypted, ok := store.(*config.EncryptedStore)
+		if !ok {
+			return total, ErrEncryptionDisabled
+		}
+		count, err := encrypted.ReEncrypt(context.TODO())
+		total += count
+		if err != nil {
+			logrus.Errorf(c.P()+"re-encrypt configs failed: %v", err)
+			return total, err
+		}
+	}
+	logrus.Infof(c.P()+"%d configs re-encrypted", total)
+	return total, nil
+}
@@ -12,24 +12,17 @@
 package service
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
 	"errors"
-	"fmt"
 	"io"
 	"ops-entry/common/util"
 	"ops-entry/constValue"
 	"ops-entry/db/configManager"
-	"ops-entry/db/configManager/config"
 	"ops-entry/proto"
-	"os"
-	"os/user"
 	"path/filepath"
 
 	"github.com/sirupsen/logrus"
-	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -59,33 +52,6 @@ func UploadKubeconfigFile(c util.Context, param *proto.FileUploadParam) error {
 		return errors.New("Invalid KubeConfig file")
 	}
 
-	currentUser, err := user.Current()
-	if err != nil {
-		logrus.Errorf(c.P()+"Error getting current user:%v", err)
-		return err
-	}
-
-	KubeConfigSavePath := filepath.Join(currentUser.HomeDir, constValue.KubeconfigSavePath)
-	err = util.CreatePath(KubeConfigSavePath)
-	if err != nil {
-		logrus.Errorf(c.P()+"Error creating path:%v", err)
-		return err
-	}
-
-	dst := filepath.Join(KubeConfigSavePath, fmt.Sprintf("%s-%s", param.ClusterId, param.File.Filename))
-
-	outFile, err := os.Create(dst)
-	if err != nil {
-		logrus.Errorf(c.P()+"Error creating file:%v", err)
-		return errors.New("Error creating file:" + err.Error())
-	}
-	defer outFile.Close()
-
-	if _, err := io.Copy(outFile, open); err != nil {
-		logrus.Errorf(c.P()+"Error copying file:%v", err)
-		return errors.New("Error copying file:" + err.Error())
-	}
-
 	return saveKubeConfig2Secret(c, param.ClusterId, content, nil)
 }
 
@@ -106,23 +72,21 @@ func validKubeConfig(c util.Context, content []byte) bool {
 }
 
 func DeleteKubeconfigFile(c util.Context, clusterID string) error {
-	secretName := clusterID + constValue.KubeconfigPrefix
-	if !util.IsValidResourceName(secretName) {
-		logrus.Errorf(c.P()+"invalid secret name: %s\n", secretName)
-		return errors.New("invalid secret name")
+	secretName, err := kubeconfigSecretName(clusterID)
+	if err != nil {
+		logrus.Errorf(c.P()+"invalid secret name: %v\n", err)
+		return err
 	}
-	sr := config.NewSecretImpl(constValue.NameSpace, secretName, nil, "")
-	return sr.Delete(context.TODO(), metav1.DeleteOptions{})
+	return kubeconfigStore.Delete(context.TODO(), secretName)
 }
 
-func QueryKubeconfigFile(c util.Context, clusterID string) (*corev1.Secret, error) {
-	secretName := clusterID + constValue.KubeconfigPrefix
-	if !util.IsValidResourceName(secretName) {
-		logrus.Errorf(c.P()+"invalid secret name: %s\n", secretName)
-		return nil, errors.New("invalid secret name")
+func QueryKubeconfigFile(c util.Context, clusterID string) (*configManager.ConfigObject, error) {
+	secretName, err := kubeconfigSecretName(clusterID)
+	if err != nil {
+		logrus.Errorf(c.P()+"invalid secret name: %v\n", err)
+		return nil, err
 	}
-	sr := config.NewSecretImpl(constValue.NameSpace, secretName, nil, "")
-	return sr.Get(context.TODO(), metav1.GetOptions{})
+	return kubeconfigStore.Get(context.TODO(), secretName)
 }
 
 func UpdateKubeconfigFile(c util.Context, param *proto.FileUpdateParam) error {
@@ -151,34 +115,7 @@ func UpdateKubeconfigFile(c util.Context, param *proto.FileUpdateParam) error {
 		return errors.New("invalid KubeConfig file")
 	}
 
-	currentUser, err := user.Current()
-	if err != nil {
-		logrus.Errorf(c.P()+"Error getting current user:%v", err)
-		return err
-	}
-
-	KubeConfigSavePath := filepath.Join(currentUser.HomeDir, constValue.KubeconfigSavePath)
-	err = util.CreatePath(KubeConfigSavePath)
-	if err != nil {
-		logrus.Errorf(c.P()+"Error creating path:%v", err)
-		return err
-	}
-
-	dst := filepath.Join(KubeConfigSavePath, fmt.Sprintf("%s-%s", param.ClusterId, param.File.Filename))
-
-	outFile, err := os.Create(dst)
-	if err != nil {
-		logrus.Errorf(c.P()+"Error creating file:%v", err)
-		return errors.New("Error creating file:" + err.Error())
-	}
-	defer outFile.Close()
-
-	if _, err := io.Copy(outFile, bytes.NewReader(content)); err != nil {
-		logrus.Errorf(c.P()+"Error copying file:%v", err)
-		return errors.New("Error copying file:" + err.Error())
-	}
-
-	return updateKubeConfig2Secret(c, param.ClusterId, content, nil)
+	return updateKubeConfig2Secret(c, param.ClusterId, content, nil, param.IfMatch)
 }
 
 // saveKubeConfig2Secret 保存kubeconfig到secret
@@ -192,12 +129,11 @@ func saveKubeConfig2Secret(c util.Context, clusterID string, kubeconfigBytes []b
 		logrus.Errorf(c.P()+"invalid secret name: %s\n", secretName)
 		return errors.New("invalid secret name")
 	}
-	sr := config.NewSecretImpl(constValue.NameSpace, secretName, labelData, corev1.ServiceAccountKubeconfigKey)
-	return sr.Create(context.TODO(), metav1.CreateOptions{}, kubeconfigData)
+	return saveConfig(c, kubeconfigStore, secretName, kubeconfigData, util.MergeMap(labelData, kubeconfigLabels(clusterID)))
 }
 
-// updateKubeConfig2Secret 保存kubeconfig到secret
-func updateKubeConfig2Secret(c util.Context, clusterID string, kubeconfigBytes []byte, labelData map[string]string) error {
+// updateKubeConfig2Secret 更新kubeconfig secret，resourceVersion不为空时作为更新的前置条件
+func updateKubeConfig2Secret(c util.Context, clusterID string, kubeconfigBytes []byte, labelData map[string]string, resourceVersion string) error {
 	encodedConfig := base64.StdEncoding.EncodeToString(kubeconfigBytes)
 	kubeconfigData := map[string]string{
 		constValue.Kubeconfig: string(encodedConfig),
@@ -207,6 +143,5 @@ func updateKubeConfig2Secret(c util.Context, clusterID string, kubeconfigBytes [
 		logrus.Errorf(c.P()+"invalid secret name: %s\n", secretName)
 		return errors.New("invalid secret name")
 	}
-	sr := config.NewSecretImpl(constValue.NameSpace, secretName, labelData, corev1.ServiceAccountKubeconfigKey)
-	return sr.Update(context.TODO(), metav1.UpdateOptions{}, kubeconfigData)
+	return updateConfig(c, kubeconfigStore, secretName, kubeconfigData, util.MergeMap(labelData, kubeconfigLabels(clusterID)), resourceVersion)
 }
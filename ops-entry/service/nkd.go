@@ -0,0 +1,378 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager/config"
+	"ops-entry/executor"
+	"ops-entry/proto"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NKDJobParam 提交nkd任务的参数
+type NKDJobParam struct {
+	Verb      string   // nkd子命令，deploy/destroy/extend/shrink/upgrade
+	ClusterID string   // 集群名称
+	Labels    string   // 集群配置的labels，JSON字符串
+	Requester string   // 发起请求的客户端
+	Num       int      // extend增加的节点数
+	Nodes     []string // shrink删除的节点
+	Version   string   // upgrade的目标kubernetes版本
+}
+
+// NKDJob 一次nkd命令的异步执行任务
+type NKDJob struct {
+	NKDJobParam
+	Id        string
+	RequestId string
+	ctx       util.Context
+
+	lease     *config.LeaseImpl
+	stopRenew chan struct{}
+
+	mu        sync.Mutex
+	phase     proto.NKDJobPhase
+	startTime *time.Time
+	endTime   *time.Time
+	exitCode  int
+	reason    string
+	log       *nkdJobLog
+	cancelled bool
+	cancel    context.CancelFunc
+}
+
+type nkdJobManager struct {
+	mu       sync.RWMutex
+	executor executor.Executor
+	jobs     map[string]*NKDJob
+	// requests Request-Id到提交结果的映射，用于识别重放的请求
+	requests map[string]*nkdSubmission
+	// clusters 集群到正在执行的任务id的映射
+	clusters map[string]string
+	queue    chan *NKDJob
+}
+
+// nkdSubmission 一个Request-Id的提交，done关闭后job和err为提交的结果
+type nkdSubmission struct {
+	done chan struct{}
+	job  *NKDJob
+	err  error
+}
+
+var jobManager = &nkdJobManager{
+	jobs:     make(map[string]*NKDJob),
+	requests: make(map[string]*nkdSubmission),
+	clusters: make(map[string]string),
+	queue:    make(chan *NKDJob, constValue.NkdJobQueueSize),
+}
+
+// StartNKDWorkers 启动执行nkd任务的worker，任务通过e执行
+func StartNKDWorkers(e executor.Executor, num int) {
+	jobManager.mu.Lock()
+	jobManager.executor = e
+	jobManager.mu.Unlock()
+	for i := 0; i < num; i++ {
+		go jobManager.worker()
+	}
+	logrus.Infof("%d nkd workers started", num)
+}
+
+/**
+* @Description: 提交nkd任务，任务进入队列后立即返回，由后台worker执行
+* Request-Id在获取集群操作锁之前登记，并发重放的请求等待第一个请求的结果
+* @param param 任务参数
+* return
+*   @resp 相同Request-Id的请求已被受理时直接返回原任务；
+*   已被其他副本受理或已执行结束时返回RepeatRequestError，其中包含原任务id；
+*   集群正在执行其他任务或队列已满时返回错误
+*
+ */
+
+func SubmitNKDJob(c util.Context, param NKDJobParam) (*NKDJob, error) {
+	jobManager.mu.Lock()
+	if origin, ok := jobManager.requests[c.RequestId]; ok {
+		jobManager.mu.Unlock()
+		<-origin.done
+		if origin.err != nil {
+			return nil, origin.err
+		}
+		logrus.Infof(c.P()+"repeated request, return the original job [job:%s]", origin.job.Id)
+		return origin.job, nil
+	}
+	submission := &nkdSubmission{done: make(chan struct{})}
+	jobManager.requests[c.RequestId] = submission
+	jobManager.mu.Unlock()
+
+	job, err := jobManager.submit(c, param)
+	jobManager.mu.Lock()
+	submission.job, submission.err = job, err
+	// 提交失败时允许以相同Request-Id重试
+	if err != nil {
+		delete(jobManager.requests, c.RequestId)
+	}
+	jobManager.mu.Unlock()
+	close(submission.done)
+	return job, err
+}
+
+func (m *nkdJobManager) submit(c util.Context, param NKDJobParam) (*NKDJob, error) {
+	// 重启或其他副本执行结束的任务只能从执行记录中识别
+	if jobId := nkdHistoryJobId(c, param.ClusterID, c.RequestId); len(jobId) > 0 {
+		logrus.Infof(c.P()+"repeated request, the original job has finished [job:%s]", jobId)
+		return nil, &RepeatRequestError{RequestId: c.RequestId, JobId: jobId}
+	}
+
+	job := &NKDJob{
+		NKDJobParam: param,
+		Id:          newJobId(),
+		RequestId:   c.RequestId,
+		ctx:         c,
+		phase:       proto.NKDJobPending,
+		log:         newNKDJobLog(),
+	}
+
+	if err := m.lockCluster(job); err != nil {
+		logrus.Errorf(c.P()+"lock cluster failed [verb:%s],[cluster:%s],[err:%v]", job.Verb, job.ClusterID, err)
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.jobs[job.Id] = job
+	m.mu.Unlock()
+
+	select {
+	case m.queue <- job:
+	default:
+		m.mu.Lock()
+		delete(m.jobs, job.Id)
+		m.mu.Unlock()
+		m.unlockCluster(job)
+		logrus.Errorf(c.P()+"nkd job queue is full, drop [verb:%s],[cluster:%s]", job.Verb, job.ClusterID)
+		return nil, errors.New("too many nkd jobs are waiting, try again later")
+	}
+
+	logrus.Infof(c.P()+"nkd job submitted [job:%s],[verb:%s],[cluster:%s]", job.Id, job.Verb, job.ClusterID)
+	return job, nil
+}
+
+// GetNKDJob 根据任务id查询nkd任务
+func GetNKDJob(jobId string) (*NKDJob, bool) {
+	jobManager.mu.RLock()
+	defer jobManager.mu.RUnlock()
+	job, ok := jobManager.jobs[jobId]
+	return job, ok
+}
+
+// Info 返回任务当前状态的快照
+func (job *NKDJob) Info() *proto.NKDJobInfo {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return &proto.NKDJobInfo{
+		JobId:     job.Id,
+		ClusterID: job.ClusterID,
+		Verb:      job.Verb,
+		Phase:     job.phase,
+		StartTime: job.startTime,
+		EndTime:   job.endTime,
+		ExitCode:  job.exitCode,
+		Reason:    job.reason,
+		Output:    job.log.String(),
+	}
+}
+
+// ReadLog 读取第offset行之后的输出，参见nkdJobLog.Read
+func (job *NKDJob) ReadLog(offset int) ([]string, <-chan struct{}, bool) {
+	return job.log.Read(offset)
+}
+
+func (m *nkdJobManager) worker() {
+	for job := range m.queue {
+		m.mu.RLock()
+		e := m.executor
+		m.mu.RUnlock()
+		job.run(e)
+	}
+}
+
+func (job *NKDJob) run(e executor.Executor) {
+	c := job.ctx
+	timeout := nkdTimeout(job.Verb)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	now := time.Now()
+	job.mu.Lock()
+	// 排队期间已被取消
+	if job.phase != proto.NKDJobPending {
+		job.mu.Unlock()
+		return
+	}
+	job.phase = proto.NKDJobRunning
+	job.startTime = &now
+	job.cancel = cancel
+	job.mu.Unlock()
+
+	logrus.Infof(c.P()+"nkd job running [job:%s],[verb:%s],[cluster:%s],[timeout:%s]", job.Id, job.Verb, job.ClusterID, timeout)
+	var err error
+	switch job.Verb {
+	case constValue.NkdVerbDeploy:
+		err = job.deploy(ctx, e)
+	case constValue.NkdVerbDestroy:
+		err = e.Destroy(ctx, job.ClusterID, job.log)
+	case constValue.NkdVerbExtend:
+		err = e.Extend(ctx, job.ClusterID, job.Num, job.log)
+	case constValue.NkdVerbShrink:
+		err = job.shrink(ctx, e)
+	case constValue.NkdVerbUpgrade:
+		err = job.upgrade(ctx, e)
+	default:
+		err = fmt.Errorf("unsupported nkd verb: %s", job.Verb)
+	}
+
+	end := time.Now()
+	job.mu.Lock()
+	job.endTime = &end
+	job.cancel = nil
+	switch {
+	case job.cancelled:
+		job.phase = proto.NKDJobCancelled
+		job.exitCode = executor.ExitCode(err)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		job.phase = proto.NKDJobFailed
+		job.exitCode = executor.ExitCode(err)
+		job.reason = fmt.Sprintf("timeout after %s", timeout)
+	case err != nil:
+		job.phase = proto.NKDJobFailed
+		job.exitCode = executor.ExitCode(err)
+	default:
+		job.phase = proto.NKDJobSucceeded
+	}
+	phase, reason := job.phase, job.reason
+	job.mu.Unlock()
+	job.finish()
+
+	if err != nil {
+		logrus.Errorf(c.P()+"nkd job %s [job:%s],[verb:%s],[err:%s],[reason:%s], output: %s", phase, job.Id, job.Verb, err.Error(), reason, job.log.String())
+		return
+	}
+	logrus.Infof(c.P()+"nkd job succeeded [job:%s],[verb:%s], output: %s", job.Id, job.Verb, job.log.String())
+}
+
+// finishedJobTTL 已结束的任务在内存中保留的时间，saved表示执行记录是否已保存
+var finishedJobTTL = func(saved bool) time.Duration {
+	if saved {
+		return constValue.NkdJobTTL
+	}
+	return constValue.NkdUnsavedJobTTL
+}
+
+// finish 任务结束后调用，任务状态需已更新，读取方看到日志关闭时即可拿到最终状态
+func (job *NKDJob) finish() {
+	job.log.close()
+	jobManager.unlockCluster(job)
+	saved := saveNKDHistory(job)
+	time.AfterFunc(finishedJobTTL(saved), func() {
+		jobManager.evict(job)
+	})
+}
+
+// evict 从内存中删除已结束的任务及其Request-Id
+func (m *nkdJobManager) evict(job *NKDJob) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.jobs[job.Id] == job {
+		delete(m.jobs, job.Id)
+	}
+	if submission, ok := m.requests[job.RequestId]; ok && submission.job == job {
+		delete(m.requests, job.RequestId)
+	}
+}
+
+/**
+* @Description: 取消nkd任务，排队中的任务直接取消，
+* 执行中的任务通过ctx通知执行器终止
+* return
+*   @resp 任务已经结束时返回错误
+*
+ */
+
+func (job *NKDJob) Cancel(c util.Context) error {
+	job.mu.Lock()
+	switch job.phase {
+	case proto.NKDJobPending:
+		now := time.Now()
+		job.phase = proto.NKDJobCancelled
+		job.cancelled = true
+		job.endTime = &now
+		job.reason = "cancelled by request " + c.RequestId
+		job.mu.Unlock()
+		job.finish()
+	case proto.NKDJobRunning:
+		job.cancelled = true
+		job.reason = "cancelled by request " + c.RequestId
+		cancel := job.cancel
+		job.mu.Unlock()
+		if cancel != nil {
+			cancel()
+		}
+	default:
+		phase := job.phase
+		job.mu.Unlock()
+		return fmt.Errorf("nkd job %s is already %s", job.Id, phase)
+	}
+
+	logrus.Infof(c.P()+"nkd job cancelled [job:%s],[verb:%s],[cluster:%s]", job.Id, job.Verb, job.ClusterID)
+	return nil
+}
+
+// nkdTimeout 获取nkd子命令的超时时间，环境变量配置优先
+func nkdTimeout(verb string) time.Duration {
+	env := fmt.Sprintf(constValue.NkdTimeoutEnvFormat, strings.ToUpper(verb))
+	if value := os.Getenv(env); len(value) > 0 {
+		timeout, err := time.ParseDuration(value)
+		if err == nil && timeout > 0 {
+			return timeout
+		}
+		logrus.Errorf("invalid nkd timeout %s=%s, use the default value", env, value)
+	}
+
+	switch verb {
+	case constValue.NkdVerbDeploy:
+		return constValue.NkdDeployTimeout
+	case constValue.NkdVerbDestroy:
+		return constValue.NkdDestroyTimeout
+	case constValue.NkdVerbExtend:
+		return constValue.NkdExtendTimeout
+	case constValue.NkdVerbShrink:
+		return constValue.NkdShrinkTimeout
+	case constValue.NkdVerbUpgrade:
+		return constValue.NkdUpgradeTimeout
+	default:
+		return constValue.NkdDefaultTimeout
+	}
+}
+
+func newJobId() string {
+	return fmt.Sprintf("nkd-%d-%d", time.Now().UnixNano(), rand.Intn(31415926))
+}
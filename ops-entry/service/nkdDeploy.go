@@ -0,0 +1,49 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"ops-entry/common/util"
+	"ops-entry/executor"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+/**
+* @Description: 从集群配置secret中读取解密后的集群配置，写入临时文件后调用nkd部署集群，
+* 明文配置只在nkd执行期间落盘，nkd退出后即删除临时文件
+*
+ */
+
+func (job *NKDJob) deploy(ctx context.Context, e executor.Executor) error {
+	c := job.ctx
+	content, err := loadClusterConfig(c, job.ClusterID, job.Labels)
+	if err != nil {
+		fmt.Fprintln(job.log, err.Error())
+		return err
+	}
+	configFile, err := util.WriteTempFile(job.ClusterID+"-*.yaml", content)
+	if err != nil {
+		fmt.Fprintf(job.log, "write cluster config failed: %v\n", err)
+		return err
+	}
+	defer func() {
+		if err := os.Remove(configFile); err != nil {
+			logrus.Errorf(c.P()+"remove cluster config file failed [job:%s],[file:%s],[err:%v]", job.Id, configFile, err)
+		}
+	}()
+	return e.Deploy(ctx, configFile, job.log)
+}
@@ -0,0 +1,305 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"ops-entry/db/configManager/config"
+	"ops-entry/proto"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// nkdHistoryStore nkd执行记录的存储，每个集群的记录为同一名称的不同版本
+var nkdHistoryStore = func() configManager.ConfigStore {
+	return config.NewMapImpl(nil, constValue.NameSpace)
+}
+
+// historyRecord 生成任务的执行记录，输出超过NkdHistoryOutputLimit时只保留末尾
+func (job *NKDJob) historyRecord() *proto.NKDHistoryRecord {
+	info := job.Info()
+	if len(info.Output) > constValue.NkdHistoryOutputLimit {
+		info.Output = "...(truncated)\n" + info.Output[len(info.Output)-constValue.NkdHistoryOutputLimit:]
+	}
+	return &proto.NKDHistoryRecord{
+		NKDJobInfo: *info,
+		Num:        job.Num,
+		Nodes:      job.Nodes,
+		Version:    job.Version,
+		Labels:     job.Labels,
+		RequestId:  job.RequestId,
+		Requester:  job.Requester,
+	}
+}
+
+/**
+* @Description: 保存nkd任务的执行记录，每个集群的记录保存为一组多版本configMap
+* kubemate-configmap-nkd-history-<cluster_id>-v-N
+* 未连接管理集群时只记录日志
+* return
+*   @resp 是否已保存
+*
+ */
+
+func saveNKDHistory(job *NKDJob) bool {
+	c := job.ctx
+	if configManager.KCS == nil {
+		logrus.Infof(c.P()+"management cluster is not connected, skip saving nkd history [job:%s]", job.Id)
+		return false
+	}
+
+	if err := saveNKDLog(job); err != nil {
+		logrus.Errorf(c.P()+"save nkd log failed [job:%s],[err:%v]", job.Id, err)
+	}
+	record, err := json.Marshal(job.historyRecord())
+	if err != nil {
+		logrus.Errorf(c.P()+"marshal nkd history failed [job:%s],[err:%v]", job.Id, err)
+		return false
+	}
+	labels := map[string]string{
+		constValue.LabelType:      constValue.NkdHistoryType,
+		constValue.LabelClusterId: job.ClusterID,
+	}
+	err = nkdHistoryStore().Create(context.TODO(), &configManager.ConfigObject{
+		Name:   constValue.NkdHistoryName + job.ClusterID,
+		Labels: labels,
+		Data:   map[string]string{constValue.NkdHistoryKey: string(record)},
+	})
+	if err != nil {
+		logrus.Errorf(c.P()+"save nkd history failed [job:%s],[err:%v]", job.Id, err)
+		return false
+	}
+	logrus.Infof(c.P()+"nkd history saved [job:%s],[cluster:%s]", job.Id, job.ClusterID)
+	pruneNKDLogs(c, job.ClusterID)
+	return true
+}
+
+/**
+* @Description: 保存任务的完整日志，按NkdLogChunkSize分块保存为nkd-log-<job_id>的各个版本，
+* 超过分块大小的一行只保留开头；保存失败时删除已保存的分块，只保留执行记录中的输出
+*
+ */
+
+func saveNKDLog(job *NKDJob) error {
+	lines, _, _ := job.log.Read(0)
+	if len(lines) == 0 {
+		return nil
+	}
+	ctx := context.TODO()
+	store := nkdHistoryStore()
+	name := constValue.NkdLogName + job.Id
+	labels := map[string]string{
+		constValue.LabelType:      constValue.NkdLogType,
+		constValue.LabelClusterId: job.ClusterID,
+		constValue.LabelJobId:     job.Id,
+	}
+
+	var chunk []string
+	size := 0
+	flush := func() error {
+		err := store.Create(ctx, &configManager.ConfigObject{
+			Name:   name,
+			Labels: labels,
+			Data:   map[string]string{constValue.NkdLogKey: strings.Join(chunk, "\n")},
+		})
+		chunk, size = nil, 0
+		return err
+	}
+	for _, line := range lines {
+		if len(line) > constValue.NkdLogChunkSize {
+			line = line[:constValue.NkdLogChunkSize]
+		}
+		if len(chunk) > 0 && size+len(line) > constValue.NkdLogChunkSize {
+			if err := flush(); err != nil {
+				deleteNKDLog(store, name)
+				return err
+			}
+		}
+		chunk = append(chunk, line)
+		size += len(line) + 1
+	}
+	if err := flush(); err != nil {
+		deleteNKDLog(store, name)
+		return err
+	}
+	return nil
+}
+
+func deleteNKDLog(store configManager.ConfigStore, name string) {
+	if err := store.Delete(context.TODO(), name); err != nil && !k8serrors.IsNotFound(err) {
+		logrus.Errorf("delete nkd log %s failed: %v", name, err)
+	}
+}
+
+// pruneNKDLogs 删除集群中执行记录已被删除的任务的日志
+func pruneNKDLogs(c util.Context, clusterID string) {
+	records, err := listNKDHistory(c, clusterID)
+	if err != nil {
+		return
+	}
+	jobs := make(map[string]bool, len(records))
+	for i := range records {
+		jobs[records[i].JobId] = true
+	}
+	store := nkdHistoryStore()
+	items, err := store.List(context.TODO(), map[string]string{
+		constValue.LabelType:      constValue.NkdLogType,
+		constValue.LabelClusterId: clusterID,
+	})
+	if err != nil {
+		logrus.Errorf(c.P()+"list nkd logs failed: %v", err)
+		return
+	}
+	deleted := make(map[string]bool)
+	for _, item := range items {
+		if jobs[item.Labels[constValue.LabelJobId]] || deleted[item.Name] {
+			continue
+		}
+		deleted[item.Name] = true
+		deleteNKDLog(store, item.Name)
+	}
+}
+
+/**
+* @Description: 查询已从内存中删除的任务的执行记录和完整日志，
+* 保存完整日志之前的执行记录只有截断后的输出
+* return
+*   @resp lines 日志行
+*   @resp record 执行记录，任务不存在时为nil
+*
+ */
+
+func FinishedNKDJobLog(c util.Context, jobId string) ([]string, *proto.NKDHistoryRecord, error) {
+	record, err := GetNKDHistory(c, jobId)
+	if err != nil || record == nil {
+		return nil, nil, err
+	}
+	items, err := nkdHistoryStore().List(context.TODO(), map[string]string{
+		constValue.LabelType:  constValue.NkdLogType,
+		constValue.LabelJobId: jobId,
+	})
+	if err != nil {
+		logrus.Errorf(c.P()+"list nkd log failed [job:%s],[err:%v]", jobId, err)
+		return nil, nil, err
+	}
+	if len(items) == 0 {
+		if len(record.Output) == 0 {
+			return []string{}, record, nil
+		}
+		return strings.Split(record.Output, "\n"), record, nil
+	}
+
+	// List按版本号升序返回，即分块的顺序
+	var lines []string
+	for _, item := range items {
+		lines = append(lines, strings.Split(item.Data[constValue.NkdLogKey], "\n")...)
+	}
+	return lines, record, nil
+}
+
+/**
+* @Description: 查询nkd执行记录，按创建时间倒序
+* @param clusterID 为空时查询全部集群
+* @param limit 每页数量
+* @param offset 跳过的记录数
+* return
+*   @resp
+*
+ */
+
+func ListNKDHistory(c util.Context, clusterID string, limit, offset int) (*proto.NKDHistoryList, error) {
+	records, err := listNKDHistory(c, clusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &proto.NKDHistoryList{Total: len(records), Items: []proto.NKDHistoryRecord{}}
+	if offset >= len(records) {
+		return list, nil
+	}
+	end := offset + limit
+	if end > len(records) {
+		end = len(records)
+	}
+	list.Items = records[offset:end]
+	return list, nil
+}
+
+// GetNKDHistory 根据任务id查询执行记录，用于ops-entry重启后查询已结束的任务
+func GetNKDHistory(c util.Context, jobId string) (*proto.NKDHistoryRecord, error) {
+	records, err := listNKDHistory(c, "")
+	if err != nil {
+		return nil, err
+	}
+	for i := range records {
+		if records[i].JobId == jobId {
+			return &records[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// nkdHistoryJobId 查询集群的执行记录中requestId对应的任务，未连接管理集群或查询失败时返回空
+func nkdHistoryJobId(c util.Context, clusterID, requestId string) string {
+	if configManager.KCS == nil || len(requestId) == 0 {
+		return ""
+	}
+	records, err := listNKDHistory(c, clusterID)
+	if err != nil {
+		return ""
+	}
+	for i := range records {
+		if records[i].RequestId == requestId {
+			return records[i].JobId
+		}
+	}
+	return ""
+}
+
+func listNKDHistory(c util.Context, clusterID string) ([]proto.NKDHistoryRecord, error) {
+	if configManager.KCS == nil {
+		return nil, errors.New("nkd history is unavailable without a management cluster")
+	}
+
+	labels := map[string]string{constValue.LabelType: constValue.NkdHistoryType}
+	if len(clusterID) > 0 {
+		labels[constValue.LabelClusterId] = clusterID
+	}
+	items, err := nkdHistoryStore().List(context.TODO(), labels)
+	if err != nil {
+		logrus.Errorf(c.P()+"list nkd history failed: %v", err)
+		return nil, err
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[j].CreationTimestamp.Before(items[i].CreationTimestamp)
+	})
+
+	records := make([]proto.NKDHistoryRecord, 0, len(items))
+	for _, item := range items {
+		var record proto.NKDHistoryRecord
+		if err := json.Unmarshal([]byte(item.Data[constValue.NkdHistoryKey]), &record); err != nil {
+			logrus.Errorf(c.P()+"invalid nkd history [name:%s],[revision:%d],[err:%v]", item.Name, item.Revision, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"ops-entry/db/configManager/config"
+	"ops-entry/proto"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	ErrRepeatRequest = errors.New("repeated request")
+	ErrClusterBusy   = errors.New("cluster is busy")
+)
+
+// RepeatRequestError 相同Request-Id的请求已被其他副本受理或已执行结束，JobId为原任务
+type RepeatRequestError struct {
+	RequestId string
+	JobId     string
+}
+
+func (e *RepeatRequestError) Error() string {
+	return fmt.Sprintf("%s: request %s has been accepted as job %s", ErrRepeatRequest, e.RequestId, e.JobId)
+}
+
+func (e *RepeatRequestError) Unwrap() error {
+	return ErrRepeatRequest
+}
+
+/**
+* @Description: 获取集群操作锁，同一集群同一时刻只允许一个nkd任务
+* 进程内用jobManager.clusters互斥，多副本之间用kubemate命名空间下的Lease互斥
+* return
+*   @resp 集群被其他任务占用时返回ErrClusterBusy，
+*   被其他副本以相同Request-Id受理时返回RepeatRequestError
+*
+ */
+
+func (m *nkdJobManager) lockCluster(job *NKDJob) error {
+	m.mu.Lock()
+	if jobId, ok := m.clusters[job.ClusterID]; ok {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: %s is running job %s", ErrClusterBusy, job.ClusterID, jobId)
+	}
+	m.clusters[job.ClusterID] = job.Id
+	m.mu.Unlock()
+
+	// 未连接管理集群时只做进程内互斥
+	if configManager.KCS == nil {
+		return nil
+	}
+
+	lease := config.NewLeaseImpl(constValue.NameSpace, job.ClusterID, job.Id, constValue.NkdLeaseDuration)
+	annotations := map[string]string{
+		constValue.AnnotationRequestId: job.RequestId,
+		constValue.AnnotationJobId:     job.Id,
+		constValue.AnnotationVerb:      job.Verb,
+	}
+	current, acquired, err := lease.Acquire(context.TODO(), annotations)
+	if err != nil || !acquired {
+		m.unlockCluster(job)
+	}
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		if current.Annotations[constValue.AnnotationRequestId] == job.RequestId {
+			return &RepeatRequestError{RequestId: job.RequestId, JobId: current.Annotations[constValue.AnnotationJobId]}
+		}
+		return fmt.Errorf("%w: %s is running job %s", ErrClusterBusy, job.ClusterID, current.Annotations[constValue.AnnotationJobId])
+	}
+
+	job.lease = lease
+	job.stopRenew = make(chan struct{})
+	go job.renewLease(lease, job.stopRenew)
+	return nil
+}
+
+// unlockCluster 释放集群操作锁
+func (m *nkdJobManager) unlockCluster(job *NKDJob) {
+	if job.lease != nil {
+		close(job.stopRenew)
+		if err := job.lease.Release(context.TODO()); err != nil {
+			logrus.Errorf(job.ctx.P()+"release lease failed [job:%s],[err:%v]", job.Id, err)
+		}
+		job.lease = nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.clusters[job.ClusterID] == job.Id {
+		delete(m.clusters, job.ClusterID)
+	}
+}
+
+func (job *NKDJob) renewLease(lease *config.LeaseImpl, stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Duration(constValue.NkdLeaseDuration) * time.Second / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := lease.Renew(context.TODO()); err != nil {
+				logrus.Errorf(job.ctx.P()+"renew lease failed [job:%s],[err:%v]", job.Id, err)
+			}
+		}
+	}
+}
+
+/**
+* @Description: 查询其他副本已受理、尚未结束的任务，根据集群操作锁上记录的任务信息
+* return
+*   @resp 只包含集群、子命令和受理时间，阶段记为running，没有持有lease的任务时返回false
+*
+ */
+
+func GetLeasedNKDJob(c util.Context, jobId string) (*proto.NKDJobInfo, bool) {
+	if configManager.KCS == nil {
+		return nil, false
+	}
+	list, err := configManager.KCS.ClientSet.CoordinationV1().Leases(constValue.NameSpace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		logrus.Errorf(c.P()+"list leases failed: %v", err)
+		return nil, false
+	}
+	for i := range list.Items {
+		lease := &list.Items[i]
+		if lease.Annotations[constValue.AnnotationJobId] != jobId || config.LeaseExpired(lease) {
+			continue
+		}
+		info := &proto.NKDJobInfo{
+			JobId:     jobId,
+			ClusterID: strings.TrimPrefix(lease.Name, constValue.Prefix+constValue.LEASE),
+			Verb:      lease.Annotations[constValue.AnnotationVerb],
+			Phase:     proto.NKDJobRunning,
+		}
+		if lease.Spec.AcquireTime != nil {
+			info.StartTime = &lease.Spec.AcquireTime.Time
+		}
+		return info, true
+	}
+	return nil, false
+}
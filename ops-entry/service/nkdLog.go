@@ -0,0 +1,115 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+/**
+* @Description: nkd进程的输出日志，stdout和stderr按行追加，
+* 任务结束后完整保留，读取方通过行号偏移量续读
+*
+ */
+
+type nkdJobLog struct {
+	mu      sync.Mutex
+	lines   []string
+	partial bytes.Buffer
+	closed  bool
+	// notify 每追加一次内容就关闭并替换，用于唤醒等待中的读取方
+	notify chan struct{}
+}
+
+func newNKDJobLog() *nkdJobLog {
+	return &nkdJobLog{notify: make(chan struct{})}
+}
+
+// Write 实现io.Writer，直接作为exec.Cmd的Stdout和Stderr
+func (l *nkdJobLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.partial.Write(p)
+	appended := false
+	for {
+		idx := bytes.IndexByte(l.partial.Bytes(), '\n')
+		if idx < 0 {
+			break
+		}
+		line := string(l.partial.Next(idx + 1))
+		l.lines = append(l.lines, strings.TrimRight(line, "\r\n"))
+		appended = true
+	}
+	if appended {
+		l.wakeup()
+	}
+	return len(p), nil
+}
+
+// close 进程退出后调用，输出最后不完整的一行并唤醒全部读取方
+func (l *nkdJobLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	if l.partial.Len() > 0 {
+		l.lines = append(l.lines, strings.TrimRight(l.partial.String(), "\r"))
+		l.partial.Reset()
+	}
+	l.closed = true
+	l.wakeup()
+}
+
+func (l *nkdJobLog) wakeup() {
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+/**
+* @Description: 读取offset之后的日志行
+* @param offset 已经读取的行数
+* return
+*   @resp lines offset之后的日志行
+*   @resp wait 有新内容或日志关闭时被关闭的channel
+*   @resp closed 日志是否已经关闭，关闭后不会再有新内容
+*
+ */
+
+func (l *nkdJobLog) Read(offset int) (lines []string, wait <-chan struct{}, closed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if offset < 0 {
+		offset = 0
+	}
+	if offset < len(l.lines) {
+		lines = append(lines, l.lines[offset:]...)
+	}
+	return lines, l.notify, l.closed
+}
+
+func (l *nkdJobLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	output := strings.Join(l.lines, "\n")
+	if l.partial.Len() > 0 {
+		if len(output) > 0 {
+			output += "\n"
+		}
+		output += l.partial.String()
+	}
+	return output
+}
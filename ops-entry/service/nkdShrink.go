@@ -0,0 +1,249 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"ops-entry/executor"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+var controlPlaneLabels = []string{
+	"node-role.kubernetes.io/control-plane",
+	"node-role.kubernetes.io/master",
+}
+
+// clusterClientSet 根据集群已上传的kubeconfig创建clientSet，测试时可替换
+var clusterClientSet = func(c util.Context, clusterID string) (kubernetes.Interface, error) {
+	secret, err := QueryKubeconfigFile(c, clusterID)
+	if err != nil {
+		return nil, fmt.Errorf("get kubeconfig of %s failed: %w", clusterID, err)
+	}
+	kubeconfig, err := base64.StdEncoding.DecodeString(secret.Data[constValue.Kubeconfig])
+	if err != nil || len(kubeconfig) == 0 {
+		return nil, fmt.Errorf("invalid kubeconfig of %s", clusterID)
+	}
+	return configManager.GetOuterClientSet(kubeconfig)
+}
+
+// nodeDrainPollInterval 等待pod驱逐完成和重试被拒绝的驱逐的间隔，测试时可替换
+var nodeDrainPollInterval = constValue.NodeDrainPollInterval
+
+/**
+* @Description: 缩容集群：驱逐并删除节点，通过nkd释放节点对应的libvirt/OpenStack虚拟机，
+* 最后从集群配置中删除这些worker节点，保存为集群配置的新版本
+*
+ */
+
+func (job *NKDJob) shrink(ctx context.Context, e executor.Executor) error {
+	c := job.ctx
+	clientSet, err := clusterClientSet(c, job.ClusterID)
+	if err != nil {
+		fmt.Fprintln(job.log, err.Error())
+		return err
+	}
+
+	for _, name := range job.Nodes {
+		if err := drainNode(ctx, clientSet, name, job.log); err != nil {
+			fmt.Fprintf(job.log, "drain node %s failed: %v\n", name, err)
+			return err
+		}
+	}
+	fmt.Fprintf(job.log, "nodes %s removed from cluster\n", strings.Join(job.Nodes, ","))
+
+	if err := e.Shrink(ctx, job.ClusterID, job.Nodes, job.log); err != nil {
+		return err
+	}
+
+	// 重新读取，避免覆盖缩容期间对集群配置的其他修改
+	content, err := loadClusterConfig(c, job.ClusterID, job.Labels)
+	removed := false
+	if err == nil {
+		content, removed, err = removeClusterConfigWorkers(content, job.Nodes)
+	}
+	if err == nil && removed {
+		err = saveClusterConfig(c, job.ClusterID, job.Labels, content)
+	}
+	if err != nil {
+		fmt.Fprintf(job.log, "cluster shrunk but update cluster config failed: %v\n", err)
+		logrus.Errorf(c.P()+"remove workers from cluster config failed [job:%s],[err:%v]", job.Id, err)
+		return err
+	}
+	if removed {
+		fmt.Fprintf(job.log, "workers %s removed from cluster config\n", strings.Join(job.Nodes, ","))
+	}
+	return nil
+}
+
+// removeClusterConfigWorkers 从集群配置的worker列表中删除指定名称的节点，保留其余字段、顺序和注释
+func removeClusterConfigWorkers(content []byte, nodes []string) ([]byte, bool, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, false, err
+	}
+	workers := yamlMappingValue(yamlDocument(&doc), "worker")
+	if workers == nil || workers.Kind != yaml.SequenceNode {
+		return content, false, nil
+	}
+
+	remain := workers.Content[:0]
+	for _, worker := range workers.Content {
+		name := yamlMappingValue(worker, "name")
+		if name != nil && slices.Contains(nodes, name.Value) {
+			continue
+		}
+		remain = append(remain, worker)
+	}
+	if len(remain) == len(workers.Content) {
+		return content, false, nil
+	}
+	workers.Content = remain
+	updated, err := encodeClusterConfig(&doc)
+	return updated, err == nil, err
+}
+
+/**
+* @Description: 驱逐节点上的pod并删除节点，等同于kubectl drain --ignore-daemonsets 后 kubectl delete node
+* 节点不存在时视为已删除；控制面节点不允许删除；被PodDisruptionBudget拒绝的驱逐在NodeDrainTimeout内重试
+* @param output 执行过程输出
+* return
+*   @resp
+*
+ */
+
+func drainNode(ctx context.Context, clientSet kubernetes.Interface, name string, output io.Writer) error {
+	node, err := clientSet.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			fmt.Fprintf(output, "node %s not found, skip drain\n", name)
+			return nil
+		}
+		return err
+	}
+	for _, label := range controlPlaneLabels {
+		if _, ok := node.Labels[label]; ok {
+			return errors.New("refuse to remove control plane node " + name)
+		}
+	}
+
+	// cordon
+	if !node.Spec.Unschedulable {
+		node.Spec.Unschedulable = true
+		if _, err := clientSet.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(output, "node %s cordoned\n", name)
+
+	// 驱逐被拒绝时的重试和等待驱逐完成共用超时时间
+	drainCtx, cancel := context.WithTimeout(ctx, constValue.NodeDrainTimeout)
+	defer cancel()
+	pods, err := podsToEvict(drainCtx, clientSet, name)
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		if err := evictPod(drainCtx, clientSet, pod, output); err != nil {
+			return fmt.Errorf("evict pod %s/%s failed: %v", pod.Namespace, pod.Name, err)
+		}
+		fmt.Fprintf(output, "evicting pod %s/%s\n", pod.Namespace, pod.Name)
+	}
+
+	err = wait.PollUntilContextCancel(drainCtx, nodeDrainPollInterval, true, func(ctx context.Context) (bool, error) {
+		remain, err := podsToEvict(ctx, clientSet, name)
+		if err != nil {
+			return false, err
+		}
+		return len(remain) == 0, nil
+	})
+	if err != nil {
+		return fmt.Errorf("wait for pods evicted failed: %v", err)
+	}
+	fmt.Fprintf(output, "node %s drained\n", name)
+
+	err = clientSet.CoreV1().Nodes().Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	fmt.Fprintf(output, "node %s deleted\n", name)
+	return nil
+}
+
+// evictPod 驱逐pod，PodDisruptionBudget不允许驱逐时apiserver返回429，按间隔重试直到ctx超时
+func evictPod(ctx context.Context, clientSet kubernetes.Interface, pod corev1.Pod, output io.Writer) error {
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+	}
+	refused := false
+	return wait.PollUntilContextCancel(ctx, nodeDrainPollInterval, true, func(ctx context.Context) (bool, error) {
+		err := clientSet.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction)
+		if k8serrors.IsTooManyRequests(err) {
+			if !refused {
+				fmt.Fprintf(output, "eviction of pod %s/%s refused by PodDisruptionBudget, retrying\n", pod.Namespace, pod.Name)
+				refused = true
+			}
+			return false, nil
+		}
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return false, err
+		}
+		return true, nil
+	})
+}
+
+// podsToEvict 节点上需要驱逐的pod，跳过DaemonSet管理的pod、静态pod和已结束的pod
+func podsToEvict(ctx context.Context, clientSet kubernetes.Interface, nodeName string) ([]corev1.Pod, error) {
+	podList, err := clientSet.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var pods []corev1.Pod
+	for _, pod := range podList.Items {
+		if pod.Spec.NodeName != nodeName {
+			continue
+		}
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+			continue
+		}
+		controller := metav1.GetControllerOf(&pod)
+		if controller != nil && controller.Kind == "DaemonSet" {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/executor"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+var ErrInvalidUpgradeVersion = errors.New("invalid upgrade version")
+
+// loadClusterConfig 读取集群配置secret中保存的集群配置，测试时可替换
+var loadClusterConfig = func(c util.Context, clusterID, labels string) ([]byte, error) {
+	secret, err := QueryClusterConfigFile(c, clusterID, labels)
+	if err != nil {
+		return nil, fmt.Errorf("get cluster config of %s failed: %v", clusterID, err)
+	}
+	content, err := base64.StdEncoding.DecodeString(secret.Data[constValue.Clusterconfig])
+	if err != nil || len(content) == 0 {
+		return nil, fmt.Errorf("invalid cluster config of %s", clusterID)
+	}
+	return content, nil
+}
+
+// saveClusterConfig 将集群配置写回集群配置secret，测试时可替换
+var saveClusterConfig = func(c util.Context, clusterID, labels string, content []byte) error {
+	labelData, err := parseClusterConfigLabels(labels)
+	if err != nil {
+		return err
+	}
+	return updateClusterConfig2Secret(c, clusterID, content, labelData, "")
+}
+
+/**
+* @Description: 检查集群能否升级到目标版本
+* @param labels 集群配置的labels，JSON字符串
+* @param target 目标kubernetes版本
+* return
+*   @resp current 集群配置中当前的kubernetes版本
+*   @resp 目标版本不合法时返回ErrInvalidUpgradeVersion
+*
+ */
+
+func CheckNKDUpgrade(c util.Context, clusterID, labels, target string) (string, error) {
+	content, err := loadClusterConfig(c, clusterID, labels)
+	if err != nil {
+		return "", err
+	}
+	current, err := clusterConfigVersion(content)
+	if err != nil {
+		return "", err
+	}
+	if err := validUpgradeVersion(current, target); err != nil {
+		return current, err
+	}
+	return current, nil
+}
+
+// upgrade 校验目标版本后调用nkd升级集群，成功后将新版本写回集群配置
+func (job *NKDJob) upgrade(ctx context.Context, e executor.Executor) error {
+	c := job.ctx
+	current, err := CheckNKDUpgrade(c, job.ClusterID, job.Labels, job.Version)
+	if err != nil {
+		fmt.Fprintln(job.log, err.Error())
+		return err
+	}
+	fmt.Fprintf(job.log, "upgrade kubernetes from %s to %s\n", current, job.Version)
+
+	if err := e.Upgrade(ctx, job.ClusterID, job.Version, job.log); err != nil {
+		return err
+	}
+
+	// 重新读取，避免覆盖升级期间对集群配置的其他修改
+	content, err := loadClusterConfig(c, job.ClusterID, job.Labels)
+	if err == nil {
+		content, err = setClusterConfigVersion(content, job.Version)
+	}
+	if err == nil {
+		err = saveClusterConfig(c, job.ClusterID, job.Labels, content)
+	}
+	if err != nil {
+		fmt.Fprintf(job.log, "cluster upgraded but update cluster config failed: %v\n", err)
+		logrus.Errorf(c.P()+"update cluster config version failed [job:%s],[err:%v]", job.Id, err)
+		return err
+	}
+	fmt.Fprintf(job.log, "cluster config updated to kubernetes %s\n", job.Version)
+	return nil
+}
+
+/**
+* @Description: 校验升级版本，目标版本必须高于当前版本，
+* 且与kubernetes的版本偏差策略一致，不能跨大版本，一次最多升级一个次版本
+*
+ */
+
+func validUpgradeVersion(current, target string) error {
+	cur, err := version.ParseGeneric(current)
+	if err != nil {
+		return fmt.Errorf("%w: invalid current version %q: %v", ErrInvalidUpgradeVersion, current, err)
+	}
+	tgt, err := version.ParseGeneric(target)
+	if err != nil {
+		return fmt.Errorf("%w: %q: %v", ErrInvalidUpgradeVersion, target, err)
+	}
+	if !cur.LessThan(tgt) {
+		return fmt.Errorf("%w: %s is not newer than current version %s", ErrInvalidUpgradeVersion, target, current)
+	}
+	if tgt.Major() != cur.Major() || tgt.Minor() > cur.Minor()+1 {
+		return fmt.Errorf("%w: can not upgrade from %s to %s, upgrade one minor version at a time", ErrInvalidUpgradeVersion, current, target)
+	}
+	return nil
+}
+
+// clusterConfigVersion 读取集群配置中的kubernetes.version
+func clusterConfigVersion(content []byte) (string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return "", err
+	}
+	node := yamlMappingValue(yamlMappingValue(yamlDocument(&doc), "kubernetes"), "version")
+	if node == nil || node.Kind != yaml.ScalarNode || node.Value == "" {
+		return "", errors.New("kubernetes.version is not set in cluster config")
+	}
+	return node.Value, nil
+}
+
+// setClusterConfigVersion 修改集群配置中的kubernetes.version，保留其余字段、顺序和注释
+func setClusterConfigVersion(content []byte, v string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	kubernetes := yamlMappingValue(yamlDocument(&doc), "kubernetes")
+	if kubernetes == nil || kubernetes.Kind != yaml.MappingNode {
+		return nil, errors.New("kubernetes is not set in cluster config")
+	}
+
+	node := yamlMappingValue(kubernetes, "version")
+	if node == nil {
+		node = &yaml.Node{Kind: yaml.ScalarNode}
+		kubernetes.Content = append(kubernetes.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "version"}, node)
+	}
+	node.Kind = yaml.ScalarNode
+	node.Tag = "!!str"
+	node.Value = v
+	return encodeClusterConfig(&doc)
+}
+
+// encodeClusterConfig 按集群配置的缩进格式输出修改后的yaml
+func encodeClusterConfig(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func yamlDocument(doc *yaml.Node) *yaml.Node {
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		return doc.Content[0]
+	}
+	return doc
+}
+
+func yamlMappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
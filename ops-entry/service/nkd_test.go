@@ -0,0 +1,558 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"ops-entry/db/configManager/config"
+	"ops-entry/executor"
+	"ops-entry/proto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func waitNKDJob(t *testing.T, job *NKDJob) *proto.NKDJobInfo {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		info := job.Info()
+		if info.Phase != proto.NKDJobPending && info.Phase != proto.NKDJobRunning {
+			return info
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("nkd job %s not finished in time", job.Id)
+	return nil
+}
+
+func TestNKDJob(t *testing.T) {
+	originLoad := loadClusterConfig
+	loadClusterConfig = func(c util.Context, clusterID, labels string) ([]byte, error) {
+		return []byte("cluster_id: " + clusterID + "\n"), nil
+	}
+	defer func() { loadClusterConfig = originLoad }()
+
+	fake := executor.NewFakeExecutor()
+	StartNKDWorkers(fake, 2)
+
+	t.Run("succeeded", func(t *testing.T) {
+		fake.SetScript(constValue.NkdVerbExtend, executor.FakeScript{Lines: []string{"extend node1", "extend node2"}})
+		job, err := SubmitNKDJob(util.CreateContext(""), NKDJobParam{
+			Verb:      constValue.NkdVerbExtend,
+			ClusterID: "succeeded-cluster",
+			Num:       2,
+		})
+		assert.Nil(t, err)
+
+		found, ok := GetNKDJob(job.Id)
+		assert.True(t, ok)
+		assert.Equal(t, job, found)
+
+		info := waitNKDJob(t, job)
+		assert.Equal(t, proto.NKDJobSucceeded, info.Phase)
+		assert.Equal(t, "extend node1\nextend node2", info.Output)
+		assert.NotNil(t, info.StartTime)
+		assert.NotNil(t, info.EndTime)
+		assert.Contains(t, fake.Calls(), executor.FakeCall{Verb: constValue.NkdVerbExtend, Args: []string{"succeeded-cluster", "2"}})
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		fake.SetScript(constValue.NkdVerbDestroy, executor.FakeScript{Lines: []string{"no such cluster"}, ExitCode: 3})
+		job, err := SubmitNKDJob(util.CreateContext(""), NKDJobParam{Verb: constValue.NkdVerbDestroy, ClusterID: "failed-cluster"})
+		assert.Nil(t, err)
+
+		info := waitNKDJob(t, job)
+		assert.Equal(t, proto.NKDJobFailed, info.Phase)
+		assert.Equal(t, 3, info.ExitCode)
+		assert.Equal(t, "no such cluster", info.Output)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Setenv("KUBEMATE_NKD_DEPLOY_TIMEOUT", "50ms")
+		fake.SetScript(constValue.NkdVerbDeploy, executor.FakeScript{Lines: []string{"never"}, Interval: time.Second})
+		job, err := SubmitNKDJob(util.CreateContext(""), NKDJobParam{Verb: constValue.NkdVerbDeploy, ClusterID: "timeout-cluster"})
+		assert.Nil(t, err)
+
+		info := waitNKDJob(t, job)
+		assert.Equal(t, proto.NKDJobFailed, info.Phase)
+		assert.Equal(t, -1, info.ExitCode)
+		assert.Contains(t, info.Reason, "timeout")
+	})
+
+	t.Run("cancel running", func(t *testing.T) {
+		fake.SetScript(constValue.NkdVerbExtend, executor.FakeScript{Lines: []string{"slow", "never"}, Interval: 200 * time.Millisecond})
+		job, err := SubmitNKDJob(util.CreateContext(""), NKDJobParam{Verb: constValue.NkdVerbExtend, ClusterID: "cancel-cluster", Num: 1})
+		assert.Nil(t, err)
+
+		_, wait, _ := job.ReadLog(0)
+		<-wait
+		assert.Nil(t, job.Cancel(util.CreateContext("")))
+		info := waitNKDJob(t, job)
+		assert.Equal(t, proto.NKDJobCancelled, info.Phase)
+		assert.Equal(t, "slow", info.Output)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, ok := GetNKDJob("nkd-not-exist")
+		assert.False(t, ok)
+	})
+
+	t.Run("evicted", func(t *testing.T) {
+		origin := finishedJobTTL
+		finishedJobTTL = func(saved bool) time.Duration {
+			assert.False(t, saved)
+			return 10 * time.Millisecond
+		}
+		defer func() { finishedJobTTL = origin }()
+
+		fake.SetScript(constValue.NkdVerbDestroy, executor.FakeScript{Lines: []string{"destroyed"}})
+		c := util.CreateContext("")
+		job, err := SubmitNKDJob(c, NKDJobParam{Verb: constValue.NkdVerbDestroy, ClusterID: "evicted-cluster"})
+		assert.Nil(t, err)
+		waitNKDJob(t, job)
+		assert.Eventually(t, func() bool {
+			_, ok := GetNKDJob(job.Id)
+			return !ok
+		}, 5*time.Second, 10*time.Millisecond)
+
+		// Request-Id同时被删除，重放的请求作为新任务执行
+		replay, err := SubmitNKDJob(c, NKDJobParam{Verb: constValue.NkdVerbDestroy, ClusterID: "evicted-cluster"})
+		assert.Nil(t, err)
+		assert.NotEqual(t, job.Id, replay.Id)
+		waitNKDJob(t, replay)
+	})
+}
+
+func TestNKDJobLog(t *testing.T) {
+	log := newNKDJobLog()
+
+	log.Write([]byte("line1\nli"))
+	lines, wait, closed := log.Read(0)
+	assert.Equal(t, []string{"line1"}, lines)
+	assert.False(t, closed)
+
+	log.Write([]byte("ne2\r\nline3"))
+	select {
+	case <-wait:
+	default:
+		t.Fatal("reader is not woken up by new lines")
+	}
+	lines, _, _ = log.Read(1)
+	assert.Equal(t, []string{"line2"}, lines)
+
+	log.close()
+	lines, _, closed = log.Read(1)
+	assert.Equal(t, []string{"line2", "line3"}, lines)
+	assert.True(t, closed)
+	assert.Equal(t, "line1\nline2\nline3", log.String())
+
+	lines, _, _ = log.Read(10)
+	assert.Empty(t, lines)
+}
+
+func TestNKDClusterLock(t *testing.T) {
+	jobA := &NKDJob{NKDJobParam: NKDJobParam{ClusterID: "lock-cluster"}, Id: "nkd-a", RequestId: "req-a", ctx: util.CreateContext("req-a")}
+	jobB := &NKDJob{NKDJobParam: NKDJobParam{ClusterID: "lock-cluster"}, Id: "nkd-b", RequestId: "req-b", ctx: util.CreateContext("req-b")}
+
+	assert.Nil(t, jobManager.lockCluster(jobA))
+	err := jobManager.lockCluster(jobB)
+	assert.ErrorIs(t, err, ErrClusterBusy)
+
+	jobManager.unlockCluster(jobA)
+	assert.Nil(t, jobManager.lockCluster(jobB))
+	jobManager.unlockCluster(jobB)
+
+	t.Run("repeat request", func(t *testing.T) {
+		StartNKDWorkers(executor.NewFakeExecutor(), 1)
+		c := util.CreateContext("req-repeat")
+		param := NKDJobParam{Verb: constValue.NkdVerbDestroy, ClusterID: "repeat-cluster"}
+		job, err := SubmitNKDJob(c, param)
+		assert.Nil(t, err)
+		replayed, err := SubmitNKDJob(c, param)
+		assert.Nil(t, err)
+		assert.Equal(t, job.Id, replayed.Id)
+	})
+
+	t.Run("concurrent repeat request", func(t *testing.T) {
+		fake := executor.NewFakeExecutor()
+		fake.SetScript(constValue.NkdVerbExtend, executor.FakeScript{Lines: []string{"slow"}, Interval: 100 * time.Millisecond})
+		StartNKDWorkers(fake, 1)
+		c := util.CreateContext("req-concurrent")
+		param := NKDJobParam{Verb: constValue.NkdVerbExtend, ClusterID: "concurrent-cluster", Num: 1}
+
+		var wg sync.WaitGroup
+		jobIds := make([]string, 10)
+		for i := range jobIds {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				job, err := SubmitNKDJob(c, param)
+				if assert.Nil(t, err) {
+					jobIds[i] = job.Id
+				}
+			}(i)
+		}
+		wg.Wait()
+		for _, jobId := range jobIds {
+			assert.Equal(t, jobIds[0], jobId)
+		}
+		job, _ := GetNKDJob(jobIds[0])
+		waitNKDJob(t, job)
+	})
+
+	t.Run("accepted by other replica", func(t *testing.T) {
+		now := metav1.NewMicroTime(time.Now())
+		holder, duration := "nkd-remote", int32(constValue.NkdLeaseDuration)
+		lease := &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      constValue.Prefix + constValue.LEASE + "remote-cluster",
+				Namespace: constValue.NameSpace,
+				Annotations: map[string]string{
+					constValue.AnnotationRequestId: "req-remote",
+					constValue.AnnotationJobId:     "nkd-remote",
+					constValue.AnnotationVerb:      constValue.NkdVerbDeploy,
+				},
+			},
+			Spec: coordinationv1.LeaseSpec{HolderIdentity: &holder, LeaseDurationSeconds: &duration, AcquireTime: &now, RenewTime: &now},
+		}
+		origin := configManager.KCS
+		configManager.KCS = &configManager.K8sClientSet{ClientSet: k8sfake.NewSimpleClientset(lease)}
+		defer func() { configManager.KCS = origin }()
+
+		_, err := SubmitNKDJob(util.CreateContext("req-remote"), NKDJobParam{Verb: constValue.NkdVerbDeploy, ClusterID: "remote-cluster"})
+		var repeated *RepeatRequestError
+		assert.True(t, errors.As(err, &repeated))
+		assert.ErrorIs(t, err, ErrRepeatRequest)
+		assert.Equal(t, "nkd-remote", repeated.JobId)
+
+		info, ok := GetLeasedNKDJob(util.CreateContext(""), "nkd-remote")
+		assert.True(t, ok)
+		assert.Equal(t, "remote-cluster", info.ClusterID)
+		assert.Equal(t, proto.NKDJobRunning, info.Phase)
+
+		// 原任务结束后从执行记录中识别
+		finished := &NKDJob{
+			NKDJobParam: NKDJobParam{Verb: constValue.NkdVerbDestroy, ClusterID: "finished-cluster"},
+			Id:          "nkd-finished",
+			RequestId:   "req-finished",
+			ctx:         util.CreateContext("req-finished"),
+			phase:       proto.NKDJobSucceeded,
+			log:         newNKDJobLog(),
+		}
+		assert.True(t, saveNKDHistory(finished))
+		_, err = SubmitNKDJob(util.CreateContext("req-finished"), NKDJobParam{Verb: constValue.NkdVerbDestroy, ClusterID: "finished-cluster"})
+		assert.True(t, errors.As(err, &repeated))
+		assert.Equal(t, "nkd-finished", repeated.JobId)
+	})
+}
+
+func TestNKDJobCancel(t *testing.T) {
+	t.Run("timeout", func(t *testing.T) {
+		t.Setenv("KUBEMATE_NKD_EXTEND_TIMEOUT", "90m")
+		assert.Equal(t, 90*time.Minute, nkdTimeout(constValue.NkdVerbExtend))
+		assert.Equal(t, constValue.NkdDeployTimeout, nkdTimeout(constValue.NkdVerbDeploy))
+	})
+
+	t.Run("pending", func(t *testing.T) {
+		job := &NKDJob{NKDJobParam: NKDJobParam{ClusterID: "pending-cluster"}, Id: "nkd-pending", phase: proto.NKDJobPending, log: newNKDJobLog()}
+		assert.Nil(t, job.Cancel(util.CreateContext("")))
+		assert.Equal(t, proto.NKDJobCancelled, job.Info().Phase)
+		assert.NotNil(t, job.Cancel(util.CreateContext("")))
+	})
+}
+
+func TestNKDHistoryRecord(t *testing.T) {
+	job := &NKDJob{
+		NKDJobParam: NKDJobParam{
+			Verb:      constValue.NkdVerbExtend,
+			ClusterID: "history-cluster",
+			Labels:    `{"version":"v0.1"}`,
+			Requester: "10.0.0.1",
+			Num:       2,
+		},
+		Id:        "nkd-history",
+		RequestId: "req-history",
+		phase:     proto.NKDJobSucceeded,
+		log:       newNKDJobLog(),
+	}
+	job.log.Write([]byte(strings.Repeat("x", constValue.NkdHistoryOutputLimit) + "\nlast line\n"))
+	job.log.close()
+
+	record := job.historyRecord()
+	assert.Equal(t, "req-history", record.RequestId)
+	assert.Equal(t, 2, record.Num)
+	assert.True(t, strings.HasPrefix(record.Output, "...(truncated)\n"))
+	assert.True(t, strings.HasSuffix(record.Output, "last line"))
+	assert.LessOrEqual(t, len(record.Output), constValue.NkdHistoryOutputLimit+len("...(truncated)\n"))
+}
+
+func TestNKDJobFullLog(t *testing.T) {
+	origin := configManager.KCS
+	configManager.KCS = &configManager.K8sClientSet{ClientSet: k8sfake.NewSimpleClientset()}
+	originRetention := config.TypeRetention[constValue.NkdHistoryType]
+	config.TypeRetention[constValue.NkdHistoryType] = config.RetentionPolicy{MaxRevisions: 1}
+	defer func() {
+		configManager.KCS = origin
+		config.TypeRetention[constValue.NkdHistoryType] = originRetention
+	}()
+
+	finished := func(id string, lines []string) *NKDJob {
+		job := &NKDJob{
+			NKDJobParam: NKDJobParam{Verb: constValue.NkdVerbDeploy, ClusterID: "log-cluster"},
+			Id:          id,
+			ctx:         util.CreateContext(""),
+			phase:       proto.NKDJobSucceeded,
+			log:         newNKDJobLog(),
+		}
+		for _, line := range lines {
+			job.log.Write([]byte(line + "\n"))
+		}
+		job.log.close()
+		return job
+	}
+
+	// 超过执行记录输出长度的日志分块完整保存
+	long := strings.Repeat("x", constValue.NkdLogChunkSize/2)
+	lines := []string{"first line", long, long, "", long, "last line"}
+	assert.True(t, saveNKDHistory(finished("nkd-log-1", lines)))
+	saved, record, err := FinishedNKDJobLog(util.CreateContext(""), "nkd-log-1")
+	assert.Nil(t, err)
+	assert.Equal(t, proto.NKDJobSucceeded, record.Phase)
+	assert.Equal(t, lines, saved)
+	assert.True(t, strings.HasPrefix(record.Output, "...(truncated)\n"))
+	chunks, err := nkdHistoryStore().List(context.TODO(), map[string]string{constValue.LabelJobId: "nkd-log-1"})
+	assert.Nil(t, err)
+	assert.Len(t, chunks, 3)
+
+	saved, record, err = FinishedNKDJobLog(util.CreateContext(""), "nkd-missing")
+	assert.Nil(t, err)
+	assert.Nil(t, record)
+	assert.Nil(t, saved)
+
+	// 执行记录被保留策略删除后，日志随之删除
+	assert.True(t, saveNKDHistory(finished("nkd-log-2", []string{"second job"})))
+	chunks, err = nkdHistoryStore().List(context.TODO(), map[string]string{constValue.LabelType: constValue.NkdLogType})
+	assert.Nil(t, err)
+	assert.Len(t, chunks, 1)
+	assert.Equal(t, "nkd-log-2", chunks[0].Labels[constValue.LabelJobId])
+}
+
+// fileExecutor 执行deploy时读取传入的集群配置文件
+type fileExecutor struct {
+	*executor.FakeExecutor
+	content []byte
+	mode    os.FileMode
+}
+
+func (e *fileExecutor) Deploy(ctx context.Context, configFile string, output io.Writer) error {
+	if info, err := os.Stat(configFile); err == nil {
+		e.mode = info.Mode().Perm()
+	}
+	e.content, _ = os.ReadFile(configFile)
+	return e.FakeExecutor.Deploy(ctx, configFile, output)
+}
+
+func TestNKDDeploy(t *testing.T) {
+	content := []byte("cluster_id: deploy-cluster\n")
+	originLoad := loadClusterConfig
+	loadClusterConfig = func(c util.Context, clusterID, labels string) ([]byte, error) {
+		if labels != `{"env":"prod"}` {
+			return nil, errors.New("cluster config not found")
+		}
+		return content, nil
+	}
+	defer func() { loadClusterConfig = originLoad }()
+
+	e := &fileExecutor{FakeExecutor: executor.NewFakeExecutor()}
+	StartNKDWorkers(e, 1)
+
+	t.Run("temporary config file", func(t *testing.T) {
+		job, err := SubmitNKDJob(util.CreateContext(""), NKDJobParam{Verb: constValue.NkdVerbDeploy, ClusterID: "deploy-cluster", Labels: `{"env":"prod"}`})
+		assert.Nil(t, err)
+		info := waitNKDJob(t, job)
+		assert.Equal(t, proto.NKDJobSucceeded, info.Phase)
+		assert.Equal(t, content, e.content)
+		assert.Equal(t, os.FileMode(constValue.PrivateFileMode), e.mode)
+
+		calls := e.Calls()
+		assert.Len(t, calls, 1)
+		configFile := calls[0].Args[0]
+		assert.True(t, strings.HasPrefix(filepath.Base(configFile), "deploy-cluster-"))
+		_, err = os.Stat(configFile)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("config not found", func(t *testing.T) {
+		job, err := SubmitNKDJob(util.CreateContext(""), NKDJobParam{Verb: constValue.NkdVerbDeploy, ClusterID: "deploy-cluster"})
+		assert.Nil(t, err)
+		info := waitNKDJob(t, job)
+		assert.Equal(t, proto.NKDJobFailed, info.Phase)
+		assert.Equal(t, "cluster config not found", info.Output)
+		assert.Len(t, e.Calls(), 1)
+	})
+}
+
+func TestNKDShrink(t *testing.T) {
+	node := func(name string, labels map[string]string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	pod := func(name, nodeName string, owner *metav1.OwnerReference) *corev1.Pod {
+		p := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       corev1.PodSpec{NodeName: nodeName},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+		if owner != nil {
+			p.OwnerReferences = []metav1.OwnerReference{*owner}
+		}
+		return p
+	}
+	isController := true
+	daemonSet := &metav1.OwnerReference{Kind: "DaemonSet", Name: "ds", Controller: &isController}
+
+	clientSet := k8sfake.NewSimpleClientset(
+		node("master1", map[string]string{"node-role.kubernetes.io/control-plane": ""}),
+		node("worker1", nil),
+		pod("app", "worker1", nil),
+		pod("agent", "worker1", daemonSet),
+		pod("other", "worker2", nil),
+	)
+	// 模拟驱逐成功后pod被删除，app第一次驱逐被PodDisruptionBudget拒绝
+	refusals := map[string]int{"app": 1}
+	clientSet.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+		if refusals[eviction.Name] > 0 {
+			refusals[eviction.Name]--
+			return true, nil, k8serrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+		}
+		return true, nil, clientSet.Tracker().Delete(corev1.SchemeGroupVersion.WithResource("pods"), eviction.Namespace, eviction.Name)
+	})
+	content := []byte("cluster_id: shrink-cluster\nworker:\n# first worker\n- name: worker1\n  ip: 192.168.1.20\n- name: worker2\n  ip: 192.168.1.21\n")
+	var saved []byte
+	origin, originInterval := clusterClientSet, nodeDrainPollInterval
+	originLoad, originSave := loadClusterConfig, saveClusterConfig
+	clusterClientSet = func(c util.Context, clusterID string) (kubernetes.Interface, error) { return clientSet, nil }
+	nodeDrainPollInterval = 10 * time.Millisecond
+	loadClusterConfig = func(c util.Context, clusterID, labels string) ([]byte, error) { return content, nil }
+	saveClusterConfig = func(c util.Context, clusterID, labels string, data []byte) error {
+		saved = data
+		return nil
+	}
+	defer func() {
+		clusterClientSet, nodeDrainPollInterval = origin, originInterval
+		loadClusterConfig, saveClusterConfig = originLoad, originSave
+	}()
+
+	fake := executor.NewFakeExecutor()
+	StartNKDWorkers(fake, 1)
+
+	t.Run("control plane", func(t *testing.T) {
+		job, err := SubmitNKDJob(util.CreateContext(""), NKDJobParam{Verb: constValue.NkdVerbShrink, ClusterID: "shrink-master", Nodes: []string{"master1"}})
+		assert.Nil(t, err)
+		info := waitNKDJob(t, job)
+		assert.Equal(t, proto.NKDJobFailed, info.Phase)
+		assert.Contains(t, info.Output, "refuse to remove control plane node master1")
+		_, err = clientSet.CoreV1().Nodes().Get(context.TODO(), "master1", metav1.GetOptions{})
+		assert.Nil(t, err)
+	})
+
+	t.Run("drain and remove", func(t *testing.T) {
+		job, err := SubmitNKDJob(util.CreateContext(""), NKDJobParam{Verb: constValue.NkdVerbShrink, ClusterID: "shrink-cluster", Nodes: []string{"worker1", "worker-gone"}})
+		assert.Nil(t, err)
+		info := waitNKDJob(t, job)
+		assert.Equal(t, proto.NKDJobSucceeded, info.Phase)
+		assert.Contains(t, info.Output, "evicting pod default/app")
+		assert.NotContains(t, info.Output, "default/agent")
+		assert.Contains(t, info.Output, "node worker1 deleted")
+		assert.Contains(t, info.Output, "node worker-gone not found")
+		assert.Contains(t, info.Output, "eviction of pod default/app refused by PodDisruptionBudget, retrying")
+		assert.Contains(t, info.Output, "nodes worker1,worker-gone removed from cluster")
+		assert.Equal(t, []executor.FakeCall{{Verb: constValue.NkdVerbShrink, Args: []string{"shrink-cluster", "worker1,worker-gone"}}}, fake.Calls())
+		assert.Equal(t, "cluster_id: shrink-cluster\nworker:\n  - name: worker2\n    ip: 192.168.1.21\n", string(saved))
+
+		_, err = clientSet.CoreV1().Nodes().Get(context.TODO(), "worker1", metav1.GetOptions{})
+		assert.True(t, k8serrors.IsNotFound(err))
+		_, err = clientSet.CoreV1().Pods("default").Get(context.TODO(), "other", metav1.GetOptions{})
+		assert.Nil(t, err)
+	})
+}
+
+func TestNKDUpgrade(t *testing.T) {
+	t.Run("version", func(t *testing.T) {
+		assert.Nil(t, validUpgradeVersion("v1.28.3", "v1.29.1"))
+		assert.Nil(t, validUpgradeVersion("v1.29.0", "v1.29.1"))
+		assert.ErrorIs(t, validUpgradeVersion("v1.29.1", "v1.29.1"), ErrInvalidUpgradeVersion)
+		assert.ErrorIs(t, validUpgradeVersion("v1.29.1", "v1.28.0"), ErrInvalidUpgradeVersion)
+		assert.ErrorIs(t, validUpgradeVersion("v1.27.1", "v1.29.0"), ErrInvalidUpgradeVersion)
+		assert.ErrorIs(t, validUpgradeVersion("v1.29.1", "latest"), ErrInvalidUpgradeVersion)
+	})
+
+	t.Run("cluster config", func(t *testing.T) {
+		content := []byte("cluster_id: cluster\n# kubernetes settings\nkubernetes:\n  version: v1.28.3\n  network:\n    plugin: calico\n")
+		current, err := clusterConfigVersion(content)
+		assert.Nil(t, err)
+		assert.Equal(t, "v1.28.3", current)
+
+		updated, err := setClusterConfigVersion(content, "v1.29.1")
+		assert.Nil(t, err)
+		assert.Equal(t, "cluster_id: cluster\n# kubernetes settings\nkubernetes:\n  version: v1.29.1\n  network:\n    plugin: calico\n", string(updated))
+
+		_, err = clusterConfigVersion([]byte("cluster_id: cluster\n"))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("job", func(t *testing.T) {
+		content := []byte("kubernetes:\n  version: v1.28.3\n")
+		var saved []byte
+		originLoad, originSave := loadClusterConfig, saveClusterConfig
+		loadClusterConfig = func(c util.Context, clusterID, labels string) ([]byte, error) { return content, nil }
+		saveClusterConfig = func(c util.Context, clusterID, labels string, data []byte) error {
+			saved = data
+			return nil
+		}
+		defer func() { loadClusterConfig, saveClusterConfig = originLoad, originSave }()
+
+		fake := executor.NewFakeExecutor()
+		StartNKDWorkers(fake, 1)
+		job, err := SubmitNKDJob(util.CreateContext(""), NKDJobParam{Verb: constValue.NkdVerbUpgrade, ClusterID: "upgrade-cluster", Version: "v1.29.1"})
+		assert.Nil(t, err)
+		info := waitNKDJob(t, job)
+		assert.Equal(t, proto.NKDJobSucceeded, info.Phase)
+		assert.Contains(t, info.Output, "upgrade kubernetes from v1.28.3 to v1.29.1")
+		assert.Contains(t, fake.Calls(), executor.FakeCall{Verb: constValue.NkdVerbUpgrade, Args: []string{"upgrade-cluster", "v1.29.1"}})
+		assert.Equal(t, "kubernetes:\n  version: v1.29.1\n", string(saved))
+		assert.Equal(t, "v1.29.1", job.historyRecord().Version)
+	})
+}
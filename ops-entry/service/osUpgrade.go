@@ -0,0 +1,547 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"ops-entry/models"
+	"ops-entry/proto"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// OSUpgradeGVR OSUpgrade CR的资源
+var OSUpgradeGVR = schema.GroupVersionResource{
+	Group:    constValue.KubeMateGroup,
+	Version:  constValue.KubeMateVersion,
+	Resource: constValue.OSUpgradeResource,
+}
+
+// osUpgradeCRD OSUpgrade的CRD，spec的schema由models.OSUpgradeSpec生成
+func osUpgradeCRD() *unstructured.Unstructured {
+	names := map[string]interface{}{
+		"kind":       constValue.OSUpgradeKind,
+		"listKind":   constValue.OSUpgradeListKind,
+		"plural":     constValue.OSUpgradeResource,
+		"singular":   constValue.OSUpgradeSingular,
+		"shortNames": []interface{}{constValue.OSUpgradeShortName},
+	}
+	return kubeMateCRD(constValue.OSUpgradeCRDName, names, reflect.TypeOf(models.OSUpgradeSpec{}),
+		map[string]interface{}{"name": "Cluster", "type": "string", "jsonPath": ".spec.cluster_id"},
+		map[string]interface{}{"name": "Phase", "type": "string", "jsonPath": ".status.phase"},
+		map[string]interface{}{"name": "Succeeded", "type": "integer", "jsonPath": ".status.succeeded"},
+		map[string]interface{}{"name": "Failed", "type": "integer", "jsonPath": ".status.failed"},
+		map[string]interface{}{"name": "Age", "type": "date", "jsonPath": ".metadata.creationTimestamp"},
+	)
+}
+
+// InstallOSUpgradeCRD 启动时安装或更新OSUpgrade CRD，并等待其可用
+func InstallOSUpgradeCRD(ctx context.Context) error {
+	return installCRD(ctx, osUpgradeCRD())
+}
+
+// osUpgradeController 监听OSUpgrade，在被管理集群中为每个节点创建执行升级的Job
+type osUpgradeController struct {
+	client dynamic.ResourceInterface
+}
+
+/**
+* @Description: 启动OSUpgrade controller，监听nameSpace下的OSUpgrade：
+* 开始时根据node_selector确定需要升级的节点，每次最多同时升级concurrency个节点，
+* 有节点失败后不再升级新的节点；开始后修改spec不会生效
+* @param workers 同时处理的CR数量
+*
+ */
+
+func StartOSUpgradeController(ctx context.Context, clients *configManager.K8sClientSet, nameSpace string, workers int) error {
+	ctrl, err := newCRController(clients, OSUpgradeGVR, nameSpace)
+	if err != nil {
+		return err
+	}
+	u := &osUpgradeController{client: ctrl.client}
+	if err := ctrl.run(ctx, workers, u.reconcile); err != nil {
+		return err
+	}
+	logrus.Infof("os upgrade controller started, namespace[%s] workers[%d]", nameSpace, workers)
+	return nil
+}
+
+/**
+* @Description: 处理一个OSUpgrade，检查执行中的Job并按并发数创建新的Job
+* 被管理集群中Job的变化不会触发处理，执行期间每隔OperatorRequeueInterval检查一次；
+* Job在被管理集群中，不能以CR作为OwnerReference，删除CR时通过finalizer删除Job
+*
+ */
+
+func (u *osUpgradeController) reconcile(ctx context.Context, cr *unstructured.Unstructured) (time.Duration, error) {
+	if cr.GetDeletionTimestamp() != nil {
+		return 0, u.finalize(ctx, cr)
+	}
+	if !hasFinalizer(cr, constValue.OSUpgradeFinalizer) {
+		cr.SetFinalizers(append(cr.GetFinalizers(), constValue.OSUpgradeFinalizer))
+		updated, err := u.client.Update(ctx, cr, metav1.UpdateOptions{})
+		if err != nil {
+			return 0, err
+		}
+		cr = updated
+	}
+	status, err := osUpgradeStatusOf(cr)
+	if err != nil {
+		return 0, err
+	}
+	if status.Phase == proto.OSUpgradeSucceeded || status.Phase == proto.OSUpgradeFailed {
+		return 0, nil
+	}
+	origin := *status
+	origin.Nodes = append([]proto.OSUpgradeNodeStatus(nil), status.Nodes...)
+
+	spec, err := osUpgradeSpecOf(cr)
+	if err != nil {
+		status.Phase, status.Message = proto.OSUpgradeFailed, err.Error()
+		return 0, u.updateStatus(ctx, cr, status)
+	}
+	c := util.CreateContext("")
+	clientSet, err := clusterClientSet(c, spec.ClusterID)
+	if err != nil {
+		return 0, err
+	}
+
+	if status.Phase == "" {
+		if err := u.start(ctx, clientSet, cr, spec, status); err != nil {
+			return 0, err
+		}
+		if status.Phase == proto.OSUpgradeFailed {
+			return 0, u.updateStatus(ctx, cr, status)
+		}
+	}
+
+	if err := u.checkJobs(ctx, clientSet, status); err != nil {
+		return 0, err
+	}
+	if status.Failed == 0 {
+		if err := u.createJobs(ctx, clientSet, cr, spec, status); err != nil {
+			return 0, err
+		}
+	}
+
+	running := countOSUpgradeNodes(status, proto.OSUpgradeRunning)
+	if running == 0 {
+		finishOSUpgrade(status)
+		err := clientSet.CoreV1().ConfigMaps(constValue.OSUpgradeJobNameSpace).Delete(ctx, osUpgradeName(cr.GetName()), metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			logrus.Warnf("delete os upgrade config of %s failed: %v", cr.GetName(), err)
+		}
+	}
+	if !reflect.DeepEqual(&origin, status) {
+		if err := u.updateStatus(ctx, cr, status); err != nil {
+			return 0, err
+		}
+	}
+	if running > 0 {
+		return constValue.OperatorRequeueInterval, nil
+	}
+	return 0, nil
+}
+
+// start 确定需要升级的节点，并在被管理集群中保存升级使用的配置文件
+func (u *osUpgradeController) start(ctx context.Context, clientSet kubernetes.Interface, cr *unstructured.Unstructured, spec *models.OSUpgradeSpec, status *proto.OSUpgradeStatus) error {
+	nodes, err := clientSet.CoreV1().Nodes().List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(spec.NodeSelector).String(),
+	})
+	if err != nil {
+		return err
+	}
+	status.ObservedGeneration = cr.GetGeneration()
+	if len(nodes.Items) == 0 {
+		status.Phase, status.Message = proto.OSUpgradeFailed, "no node matches node_selector"
+		return nil
+	}
+
+	configMap, err := osUpgradeConfigMap(cr, spec)
+	if err != nil {
+		return err
+	}
+	configMaps := clientSet.CoreV1().ConfigMaps(constValue.OSUpgradeJobNameSpace)
+	if _, err := configMaps.Create(ctx, configMap, metav1.CreateOptions{}); k8serrors.IsAlreadyExists(err) {
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(nodes.Items))
+	for _, node := range nodes.Items {
+		names = append(names, node.Name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		status.Nodes = append(status.Nodes, proto.OSUpgradeNodeStatus{Node: name, Phase: proto.OSUpgradePending})
+	}
+	status.Phase = proto.OSUpgradeRunning
+	logrus.Infof("os upgrade %s started on %d nodes of cluster %s", cr.GetName(), len(names), spec.ClusterID)
+	return nil
+}
+
+// checkJobs 根据Job的状态更新执行中的节点
+func (u *osUpgradeController) checkJobs(ctx context.Context, clientSet kubernetes.Interface, status *proto.OSUpgradeStatus) error {
+	for i := range status.Nodes {
+		node := &status.Nodes[i]
+		if node.Phase != proto.OSUpgradeRunning {
+			continue
+		}
+		job, err := clientSet.BatchV1().Jobs(constValue.OSUpgradeJobNameSpace).Get(ctx, node.Job, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			node.Phase, node.Message = proto.OSUpgradeFailed, "job "+node.Job+" not found"
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, condition := range job.Status.Conditions {
+			if condition.Status != corev1.ConditionTrue {
+				continue
+			}
+			switch condition.Type {
+			case batchv1.JobComplete:
+				node.Phase, node.Message = proto.OSUpgradeSucceeded, ""
+			case batchv1.JobFailed:
+				node.Phase, node.Message = proto.OSUpgradeFailed, condition.Message
+			}
+		}
+	}
+	status.Succeeded = countOSUpgradeNodes(status, proto.OSUpgradeSucceeded)
+	status.Failed = countOSUpgradeNodes(status, proto.OSUpgradeFailed)
+	return nil
+}
+
+// createJobs 为等待中的节点创建Job，执行中的节点数不超过concurrency
+func (u *osUpgradeController) createJobs(ctx context.Context, clientSet kubernetes.Interface, cr *unstructured.Unstructured, spec *models.OSUpgradeSpec, status *proto.OSUpgradeStatus) error {
+	concurrency := spec.Concurrency
+	if concurrency == 0 {
+		concurrency = 1
+	}
+	running := countOSUpgradeNodes(status, proto.OSUpgradeRunning)
+	for i := range status.Nodes {
+		node := &status.Nodes[i]
+		if running >= concurrency {
+			break
+		}
+		if node.Phase != proto.OSUpgradePending {
+			continue
+		}
+		job := osUpgradeJob(cr, spec, node.Node)
+		_, err := clientSet.BatchV1().Jobs(constValue.OSUpgradeJobNameSpace).Create(ctx, job, metav1.CreateOptions{})
+		if k8serrors.IsAlreadyExists(err) {
+			err = adoptOSUpgradeJob(ctx, clientSet, cr, job.Name)
+		}
+		if err != nil {
+			return err
+		}
+		logrus.Infof("os upgrade %s created job %s on node %s", cr.GetName(), job.Name, node.Node)
+		node.Phase, node.Job = proto.OSUpgradeRunning, job.Name
+		running++
+	}
+	return nil
+}
+
+/**
+* @Description: Job已存在时，只有该CR创建的Job继续使用，
+* 同名CR删除后重新创建时，之前的CR创建的Job可能仍在保留期内，删除后返回错误，重试时重新创建
+*
+ */
+
+func adoptOSUpgradeJob(ctx context.Context, clientSet kubernetes.Interface, cr *unstructured.Unstructured, name string) error {
+	jobs := clientSet.BatchV1().Jobs(constValue.OSUpgradeJobNameSpace)
+	job, err := jobs.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if job.Labels[constValue.LabelOSUpgradeUID] == string(cr.GetUID()) {
+		return nil
+	}
+	propagation := metav1.DeletePropagationBackground
+	err = jobs.Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	return fmt.Errorf("job %s was created by a previous %s %s, deleted and will be recreated", name, constValue.OSUpgradeKind, cr.GetName())
+}
+
+// finalize 删除CR前删除被管理集群中该CR创建的Job和配置文件，集群已不存在时直接移除finalizer
+func (u *osUpgradeController) finalize(ctx context.Context, cr *unstructured.Unstructured) error {
+	if !hasFinalizer(cr, constValue.OSUpgradeFinalizer) {
+		return nil
+	}
+	clusterID, _, _ := unstructured.NestedString(cr.Object, "spec", "cluster_id")
+	clientSet, err := clusterClientSet(util.CreateContext(""), clusterID)
+	switch {
+	case k8serrors.IsNotFound(err):
+		logrus.Warnf("cluster %s of os upgrade %s not found, skip deleting jobs", clusterID, cr.GetName())
+	case err != nil:
+		return err
+	default:
+		if err := deleteOSUpgradeJobs(ctx, clientSet, cr); err != nil {
+			return err
+		}
+	}
+	removeFinalizer(cr, constValue.OSUpgradeFinalizer)
+	_, err = u.client.Update(ctx, cr, metav1.UpdateOptions{})
+	return err
+}
+
+// deleteOSUpgradeJobs 删除该CR创建的Job和保存配置文件的ConfigMap
+func deleteOSUpgradeJobs(ctx context.Context, clientSet kubernetes.Interface, cr *unstructured.Unstructured) error {
+	selector := labels.SelectorFromSet(map[string]string{
+		constValue.LabelOSUpgrade:    cr.GetName(),
+		constValue.LabelOSUpgradeUID: string(cr.GetUID()),
+	}).String()
+	jobs := clientSet.BatchV1().Jobs(constValue.OSUpgradeJobNameSpace)
+	list, err := jobs.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+	propagation := metav1.DeletePropagationBackground
+	for _, job := range list.Items {
+		err := jobs.Delete(ctx, job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+		logrus.Infof("os upgrade %s deleted job %s", cr.GetName(), job.Name)
+	}
+
+	configMaps := clientSet.CoreV1().ConfigMaps(constValue.OSUpgradeJobNameSpace)
+	configMap, err := configMaps.Get(ctx, osUpgradeName(cr.GetName()), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if configMap.Labels[constValue.LabelOSUpgradeUID] != string(cr.GetUID()) {
+		return nil
+	}
+	err = configMaps.Delete(ctx, configMap.Name, metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// osUpgradeLabels Job和ConfigMap的labels，记录创建该对象的CR的名称和UID
+func osUpgradeLabels(cr *unstructured.Unstructured) map[string]string {
+	return map[string]string{
+		constValue.LabelOSUpgrade:    cr.GetName(),
+		constValue.LabelOSUpgradeUID: string(cr.GetUID()),
+	}
+}
+
+// updateStatus 根据phase设置conditions和结束时的lastOperation后通过status子资源更新
+func (u *osUpgradeController) updateStatus(ctx context.Context, cr *unstructured.Unstructured, status *proto.OSUpgradeStatus) error {
+	generation := cr.GetGeneration()
+	conditions := &status.Conditions
+	switch status.Phase {
+	case proto.OSUpgradeRunning:
+		message := fmt.Sprintf("%d of %d nodes upgraded", status.Succeeded, len(status.Nodes))
+		setCondition(conditions, generation, proto.ConditionProgressing, true, "Upgrading", message)
+		setCondition(conditions, generation, proto.ConditionReady, false, "Upgrading", message)
+		setCondition(conditions, generation, proto.ConditionDegraded, false, "Upgrading", "")
+	case proto.OSUpgradeSucceeded:
+		setCondition(conditions, generation, proto.ConditionReady, true, "Upgraded", "")
+		setCondition(conditions, generation, proto.ConditionProgressing, false, "Upgraded", "")
+		setCondition(conditions, generation, proto.ConditionDegraded, false, "Upgraded", "")
+	case proto.OSUpgradeFailed:
+		setCondition(conditions, generation, proto.ConditionDegraded, true, "UpgradeFailed", status.Message)
+		setCondition(conditions, generation, proto.ConditionReady, false, "UpgradeFailed", status.Message)
+		setCondition(conditions, generation, proto.ConditionProgressing, false, "UpgradeFailed", "")
+	}
+	if status.Phase == proto.OSUpgradeSucceeded || status.Phase == proto.OSUpgradeFailed {
+		status.LastOperation = newOperationResult("upgrade", "", status.Phase == proto.OSUpgradeFailed, status.Message)
+	}
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(status)
+	if err != nil {
+		return err
+	}
+	cr.Object["status"] = content
+	_, err = u.client.UpdateStatus(ctx, cr, metav1.UpdateOptions{})
+	return err
+}
+
+// finishOSUpgrade 没有执行中的节点时结束升级，有节点失败时未升级的节点保持Pending
+func finishOSUpgrade(status *proto.OSUpgradeStatus) {
+	if status.Failed == 0 {
+		status.Phase, status.Message = proto.OSUpgradeSucceeded, ""
+		return
+	}
+	status.Phase = proto.OSUpgradeFailed
+	status.Message = fmt.Sprintf("%d of %d nodes failed", status.Failed, len(status.Nodes))
+	if pending := countOSUpgradeNodes(status, proto.OSUpgradePending); pending > 0 {
+		status.Message += fmt.Sprintf(", %d nodes not upgraded", pending)
+	}
+}
+
+func countOSUpgradeNodes(status *proto.OSUpgradeStatus, phase proto.OSUpgradePhase) int64 {
+	var count int64
+	for _, node := range status.Nodes {
+		if node.Phase == phase {
+			count++
+		}
+	}
+	return count
+}
+
+// osUpgradeName 被管理集群中保存配置文件的ConfigMap名称，也是Job名称的前缀
+func osUpgradeName(name string) string {
+	return constValue.Prefix + constValue.OSUpgradeSingular + "-" + name
+}
+
+// osUpgradeJobName Job名称，节点名称以哈希表示以满足长度限制
+func osUpgradeJobName(name, node string) string {
+	prefix := osUpgradeName(name)
+	// Job名称会作为pod的label，最长63个字符
+	if maxLength := 63 - 1 - constValue.LabelsHashLength; len(prefix) > maxLength {
+		prefix = prefix[:maxLength]
+	}
+	return prefix + "-" + util.LabelsHash(map[string]string{constValue.AnnotationOSUpgradeNode: node})
+}
+
+// osUpgradeConfigMap universal_os_upgrader使用的backup.yaml和upgrade.yaml
+func osUpgradeConfigMap(cr *unstructured.Unstructured, spec *models.OSUpgradeSpec) (*corev1.ConfigMap, error) {
+	backup, err := sigsyaml.Marshal(spec.Backup)
+	if err != nil {
+		return nil, err
+	}
+	upgrade, err := sigsyaml.Marshal(map[string]string{"repo": spec.Repo})
+	if err != nil {
+		return nil, err
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      osUpgradeName(cr.GetName()),
+			Namespace: constValue.OSUpgradeJobNameSpace,
+			Labels:    osUpgradeLabels(cr),
+		},
+		Data: map[string]string{
+			constValue.OSUpgradeBackupFile:  string(backup),
+			constValue.OSUpgradeUpgradeFile: string(upgrade),
+		},
+	}, nil
+}
+
+/**
+* @Description: 在节点上执行升级的Job，只执行一次
+* 容器将配置文件复制到节点的OSUpgradeConfigDir，再通过nsenter进入节点的命名空间执行UniversalOS，
+* 因此节点上需要已安装universal_os_upgrader
+*
+ */
+
+func osUpgradeJob(cr *unstructured.Unstructured, spec *models.OSUpgradeSpec, node string) *batchv1.Job {
+	image := spec.Image
+	if image == "" {
+		image = constValue.OSUpgradeImage
+	}
+	privileged := true
+	backoffLimit := int32(0)
+	ttl := constValue.OSUpgradeJobTTL
+	script := fmt.Sprintf("set -e\nmkdir -p /host%[1]s\ncp /config/%[2]s /config/%[3]s /host%[1]s/\nexec nsenter -t 1 -m -u -i -n -p -- %[4]s\n",
+		constValue.OSUpgradeConfigDir, constValue.OSUpgradeBackupFile, constValue.OSUpgradeUpgradeFile, constValue.OSUpgradeCommand)
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        osUpgradeJobName(cr.GetName(), node),
+			Namespace:   constValue.OSUpgradeJobNameSpace,
+			Labels:      osUpgradeLabels(cr),
+			Annotations: map[string]string{constValue.AnnotationOSUpgradeNode: node},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{constValue.LabelOSUpgrade: cr.GetName()},
+				},
+				Spec: corev1.PodSpec{
+					NodeName:      node,
+					RestartPolicy: corev1.RestartPolicyNever,
+					HostPID:       true,
+					HostNetwork:   true,
+					Tolerations:   []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+					Containers: []corev1.Container{{
+						Name:            "upgrade",
+						Image:           image,
+						Command:         []string{"sh", "-c", script},
+						SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "config", MountPath: "/config", ReadOnly: true},
+							{Name: "host", MountPath: "/host"},
+						},
+					}},
+					Volumes: []corev1.Volume{
+						{Name: "config", VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: osUpgradeName(cr.GetName())}},
+						}},
+						{Name: "host", VolumeSource: corev1.VolumeSource{
+							HostPath: &corev1.HostPathVolumeSource{Path: "/"},
+						}},
+					},
+				},
+			},
+		},
+	}
+}
+
+func osUpgradeStatusOf(cr *unstructured.Unstructured) (*proto.OSUpgradeStatus, error) {
+	status := &proto.OSUpgradeStatus{}
+	content, ok, err := unstructured.NestedMap(cr.Object, "status")
+	if err != nil || !ok {
+		return status, err
+	}
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(content, status)
+	return status, err
+}
+
+func osUpgradeSpecOf(cr *unstructured.Unstructured) (*models.OSUpgradeSpec, error) {
+	content, _, err := unstructured.NestedMap(cr.Object, "spec")
+	if err != nil {
+		return nil, err
+	}
+	var spec models.OSUpgradeSpec
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, &spec); err != nil {
+		return nil, fmt.Errorf("invalid spec: %v", err)
+	}
+	if errs := spec.Validate(); len(errs) > 0 {
+		return nil, errors.New("invalid spec: " + errs.ToAggregate().Error())
+	}
+	return &spec, nil
+}
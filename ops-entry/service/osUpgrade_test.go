@@ -0,0 +1,225 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"context"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/proto"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestOSUpgrade(t *testing.T) {
+	var nodes []runtime.Object
+	for _, name := range []string{"node3", "node1", "node2"} {
+		nodes = append(nodes, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"upgrade": "true"}}})
+	}
+	nodes = append(nodes, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "master1"}})
+	clientSet := k8sfake.NewSimpleClientset(nodes...)
+	origin := clusterClientSet
+	clusterClientSet = func(c util.Context, clusterID string) (kubernetes.Interface, error) { return clientSet, nil }
+	defer func() { clusterClientSet = origin }()
+
+	ctx := context.TODO()
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{OSUpgradeGVR: constValue.OSUpgradeListKind})
+	client := dynamicClient.Resource(OSUpgradeGVR).Namespace(constValue.NameSpace)
+	u := &osUpgradeController{client: client}
+	jobs := clientSet.BatchV1().Jobs(constValue.OSUpgradeJobNameSpace)
+
+	create := func(name string, spec map[string]interface{}) {
+		cr := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+		cr.SetAPIVersion(OSUpgradeGVR.GroupVersion().String())
+		cr.SetKind(constValue.OSUpgradeKind)
+		cr.SetName(name)
+		_, err := client.Create(ctx, cr, metav1.CreateOptions{})
+		assert.Nil(t, err)
+	}
+	reconcile := func(name string) *proto.OSUpgradeStatus {
+		cr, err := client.Get(ctx, name, metav1.GetOptions{})
+		assert.Nil(t, err)
+		_, err = u.reconcile(ctx, cr)
+		assert.Nil(t, err)
+		cr, err = client.Get(ctx, name, metav1.GetOptions{})
+		assert.Nil(t, err)
+		status, err := osUpgradeStatusOf(cr)
+		assert.Nil(t, err)
+		return status
+	}
+	finishJob := func(name string, conditionType batchv1.JobConditionType) {
+		job, err := jobs.Get(ctx, name, metav1.GetOptions{})
+		assert.Nil(t, err)
+		job.Status.Conditions = append(job.Status.Conditions, batchv1.JobCondition{Type: conditionType, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"})
+		_, err = jobs.UpdateStatus(ctx, job, metav1.UpdateOptions{})
+		assert.Nil(t, err)
+	}
+	spec := func(concurrency int64) map[string]interface{} {
+		return map[string]interface{}{
+			"cluster_id":    "managed",
+			"node_selector": map[string]interface{}{"upgrade": "true"},
+			"repo":          "[everything]\nbaseurl=http://repo.openeuler.org/everything\n",
+			"backup":        map[string]interface{}{"nfs_server": "192.168.1.2", "nfs_path": "/backup"},
+			"concurrency":   concurrency,
+		}
+	}
+
+	t.Run("succeeded", func(t *testing.T) {
+		create("upgrade", spec(2))
+		status := reconcile("upgrade")
+		assert.Equal(t, proto.OSUpgradeRunning, status.Phase)
+		assert.Len(t, status.Nodes, 3)
+		assert.Equal(t, "0 of 3 nodes upgraded", meta.FindStatusCondition(status.Conditions, proto.ConditionProgressing).Message)
+		assert.Equal(t, proto.OSUpgradeNodeStatus{Node: "node1", Phase: proto.OSUpgradeRunning, Job: osUpgradeJobName("upgrade", "node1")}, status.Nodes[0])
+		assert.Equal(t, proto.OSUpgradeRunning, status.Nodes[1].Phase)
+		assert.Equal(t, proto.OSUpgradePending, status.Nodes[2].Phase)
+
+		configMap, err := clientSet.CoreV1().ConfigMaps(constValue.OSUpgradeJobNameSpace).Get(ctx, osUpgradeName("upgrade"), metav1.GetOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, "nfs_path: /backup\nnfs_server: 192.168.1.2\n", configMap.Data[constValue.OSUpgradeBackupFile])
+		assert.Contains(t, configMap.Data[constValue.OSUpgradeUpgradeFile], "baseurl=http://repo.openeuler.org/everything")
+		job, err := jobs.Get(ctx, status.Nodes[0].Job, metav1.GetOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, "node1", job.Spec.Template.Spec.NodeName)
+		assert.True(t, *job.Spec.Template.Spec.Containers[0].SecurityContext.Privileged)
+		assert.Contains(t, job.Spec.Template.Spec.Containers[0].Command[2], "nsenter -t 1 -m -u -i -n -p -- UniversalOS Upgrade")
+
+		// 没有Job结束时status不变
+		assert.Equal(t, status, reconcile("upgrade"))
+
+		finishJob(status.Nodes[0].Job, batchv1.JobComplete)
+		status = reconcile("upgrade")
+		assert.Equal(t, proto.OSUpgradeSucceeded, status.Nodes[0].Phase)
+		assert.Equal(t, proto.OSUpgradeRunning, status.Nodes[2].Phase)
+		assert.Equal(t, int64(1), status.Succeeded)
+
+		finishJob(status.Nodes[1].Job, batchv1.JobComplete)
+		finishJob(status.Nodes[2].Job, batchv1.JobComplete)
+		status = reconcile("upgrade")
+		assert.Equal(t, proto.OSUpgradeSucceeded, status.Phase)
+		assert.Equal(t, int64(3), status.Succeeded)
+		assert.True(t, meta.IsStatusConditionTrue(status.Conditions, proto.ConditionReady))
+		assert.True(t, meta.IsStatusConditionFalse(status.Conditions, proto.ConditionProgressing))
+		assert.Equal(t, proto.OperationSucceeded, status.LastOperation.Result)
+		_, err = clientSet.CoreV1().ConfigMaps(constValue.OSUpgradeJobNameSpace).Get(ctx, osUpgradeName("upgrade"), metav1.GetOptions{})
+		assert.True(t, k8serrors.IsNotFound(err))
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		create("upgrade-failed", spec(0))
+		status := reconcile("upgrade-failed")
+		assert.Equal(t, proto.OSUpgradeRunning, status.Nodes[0].Phase)
+		assert.Equal(t, proto.OSUpgradePending, status.Nodes[1].Phase)
+
+		finishJob(status.Nodes[0].Job, batchv1.JobFailed)
+		status = reconcile("upgrade-failed")
+		assert.Equal(t, proto.OSUpgradeFailed, status.Phase)
+		assert.Equal(t, "BackoffLimitExceeded", status.Nodes[0].Message)
+		assert.Equal(t, proto.OSUpgradePending, status.Nodes[1].Phase)
+		assert.Equal(t, "1 of 3 nodes failed, 2 nodes not upgraded", status.Message)
+		assert.True(t, meta.IsStatusConditionTrue(status.Conditions, proto.ConditionDegraded))
+		assert.Equal(t, proto.OperationFailed, status.LastOperation.Result)
+	})
+
+	t.Run("recreated", func(t *testing.T) {
+		createWithUID := func(uid string) {
+			cr := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec(1)}}
+			cr.SetAPIVersion(OSUpgradeGVR.GroupVersion().String())
+			cr.SetKind(constValue.OSUpgradeKind)
+			cr.SetName("upgrade-recreated")
+			cr.SetUID(types.UID(uid))
+			_, err := client.Create(ctx, cr, metav1.CreateOptions{})
+			assert.Nil(t, err)
+		}
+		createWithUID("uid-1")
+		status := reconcile("upgrade-recreated")
+		job, err := jobs.Get(ctx, status.Nodes[0].Job, metav1.GetOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, "uid-1", job.Labels[constValue.LabelOSUpgradeUID])
+		finishJob(status.Nodes[0].Job, batchv1.JobComplete)
+
+		// 没有经过finalizer删除CR，Job保留在被管理集群中
+		assert.Nil(t, client.Delete(ctx, "upgrade-recreated", metav1.DeleteOptions{}))
+		createWithUID("uid-2")
+		cr, err := client.Get(ctx, "upgrade-recreated", metav1.GetOptions{})
+		assert.Nil(t, err)
+		_, err = u.reconcile(ctx, cr)
+		assert.NotNil(t, err)
+		_, err = jobs.Get(ctx, status.Nodes[0].Job, metav1.GetOptions{})
+		assert.True(t, k8serrors.IsNotFound(err))
+
+		status = reconcile("upgrade-recreated")
+		assert.Equal(t, proto.OSUpgradeRunning, status.Nodes[0].Phase)
+		job, err = jobs.Get(ctx, status.Nodes[0].Job, metav1.GetOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, "uid-2", job.Labels[constValue.LabelOSUpgradeUID])
+		assert.Empty(t, job.Status.Conditions)
+	})
+
+	t.Run("deleted", func(t *testing.T) {
+		status := reconcile("upgrade-recreated")
+		cr, err := client.Get(ctx, "upgrade-recreated", metav1.GetOptions{})
+		assert.Nil(t, err)
+		assert.Contains(t, cr.GetFinalizers(), constValue.OSUpgradeFinalizer)
+		now := metav1.NewTime(time.Now())
+		cr.SetDeletionTimestamp(&now)
+		_, err = client.Update(ctx, cr, metav1.UpdateOptions{})
+		assert.Nil(t, err)
+
+		reconcile("upgrade-recreated")
+		cr, err = client.Get(ctx, "upgrade-recreated", metav1.GetOptions{})
+		assert.Nil(t, err)
+		assert.NotContains(t, cr.GetFinalizers(), constValue.OSUpgradeFinalizer)
+		_, err = jobs.Get(ctx, status.Nodes[0].Job, metav1.GetOptions{})
+		assert.True(t, k8serrors.IsNotFound(err))
+		_, err = clientSet.CoreV1().ConfigMaps(constValue.OSUpgradeJobNameSpace).Get(ctx, osUpgradeName("upgrade-recreated"), metav1.GetOptions{})
+		assert.True(t, k8serrors.IsNotFound(err))
+		// 其他CR创建的Job不受影响
+		list, err := jobs.List(ctx, metav1.ListOptions{})
+		assert.Nil(t, err)
+		assert.NotEmpty(t, list.Items)
+	})
+
+	t.Run("invalid spec", func(t *testing.T) {
+		create("upgrade-invalid", map[string]interface{}{"cluster_id": "managed", "backup": map[string]interface{}{"nfs_path": "backup"}})
+		status := reconcile("upgrade-invalid")
+		assert.Equal(t, proto.OSUpgradeFailed, status.Phase)
+		assert.Contains(t, status.Message, "repo")
+		assert.Contains(t, status.Message, "backup.nfs_server")
+		assert.Contains(t, status.Message, "backup.nfs_path")
+	})
+
+	t.Run("no node", func(t *testing.T) {
+		noNode := spec(1)
+		noNode["node_selector"] = map[string]interface{}{"upgrade": "never"}
+		create("upgrade-no-node", noNode)
+		status := reconcile("upgrade-no-node")
+		assert.Equal(t, proto.OSUpgradeFailed, status.Phase)
+		assert.Equal(t, "no node matches node_selector", status.Message)
+	})
+}
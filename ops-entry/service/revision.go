@@ -0,0 +1,249 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"ops-entry/proto"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// clusterConfigStore、kubeconfigStore 集群配置和kubeconfig的存储，启动时通过SetConfigStores注入
+var (
+	clusterConfigStore configManager.RevisionStore
+	kubeconfigStore    configManager.RevisionStore
+)
+
+// SetConfigStores 注入集群配置和kubeconfig的存储，由main根据存储后端创建，需要在处理请求之前调用
+func SetConfigStores(clusterStore, kubeStore configManager.RevisionStore) {
+	clusterConfigStore, kubeconfigStore = clusterStore, kubeStore
+}
+
+// clusterConfigSecretName 集群配置secret的名称，labels为JSON字符串
+func clusterConfigSecretName(clusterID string, labels string) (string, error) {
+	labelData, err := parseClusterConfigLabels(labels)
+	if err != nil {
+		return "", err
+	}
+	return clusterConfigName(clusterID, labelData)
+}
+
+// parseClusterConfigLabels 解析并校验集群配置的labels
+func parseClusterConfigLabels(labels string) (map[string]string, error) {
+	if labels == "" {
+		return nil, nil
+	}
+	labelData, err := util.ParseLabels(labels)
+	if err != nil {
+		return nil, err
+	}
+	if err := util.ValidateLabels(labelData); err != nil {
+		return nil, err
+	}
+	return labelData, nil
+}
+
+// userClusterConfigLabels 解析上传的集群配置的labels，kubemate.openeuler.org/前缀的key由KubeMate保留
+func userClusterConfigLabels(labels string) (map[string]string, error) {
+	labelData, err := parseClusterConfigLabels(labels)
+	if err != nil {
+		return nil, err
+	}
+	for key := range labelData {
+		if strings.HasPrefix(key, constValue.KubeMateGroup+"/") {
+			return nil, fmt.Errorf("invalid label key %q: prefix %s/ is reserved", key, constValue.KubeMateGroup)
+		}
+	}
+	return labelData, nil
+}
+
+/**
+* @Description: 集群配置secret的名称，<cluster_id>-clusterconfig[-<labels哈希>]
+* 哈希按排序后的labels计算，同一组labels总是得到相同的名称
+*
+ */
+
+func clusterConfigName(clusterID string, labelData map[string]string) (string, error) {
+	secretName := clusterID + constValue.ClusterconfigPrefix
+	if hash := util.LabelsHash(labelData); hash != "" {
+		secretName = secretName + "-" + hash
+	}
+	if !util.IsValidResourceName(secretName) {
+		return "", errors.New("invalid secret name")
+	}
+	return secretName, nil
+}
+
+// clusterConfigLabels 保存在secret上的labels，在用户labels之外增加类型、集群id和labels哈希，用于label selector查询
+func clusterConfigLabels(clusterID string, labelData map[string]string) map[string]string {
+	labels := make(map[string]string, len(labelData)+3)
+	for key, value := range labelData {
+		labels[key] = value
+	}
+	labels[constValue.LabelType] = constValue.ClusterConfigType
+	labels[constValue.LabelClusterId] = clusterID
+	labels[constValue.LabelHash] = util.LabelsHash(labelData)
+	return labels
+}
+
+// kubeconfigSecretName kubeconfig secret的名称，<cluster_id>-kubeconfig
+func kubeconfigSecretName(clusterID string) (string, error) {
+	secretName := clusterID + constValue.KubeconfigPrefix
+	if !util.IsValidResourceName(secretName) {
+		return "", errors.New("invalid secret name")
+	}
+	return secretName, nil
+}
+
+// saveConfig 配置不存在时创建，已存在时覆盖，并记录一个历史版本
+func saveConfig(c util.Context, store configManager.RevisionStore, name string, data, labels map[string]string) error {
+	obj := &configManager.ConfigObject{Name: name, Labels: labels, Data: data}
+	err := store.Create(context.TODO(), obj)
+	if k8serrors.IsAlreadyExists(err) {
+		err = store.Update(context.TODO(), obj)
+	}
+	if err != nil {
+		logrus.Errorf(c.P()+"save config failed [name:%s],[err:%v]", name, err)
+		return err
+	}
+	return recordRevision(c, store, name, constValue.ChangeCauseCreate)
+}
+
+// updateConfig 更新已存在的配置，并记录一个历史版本，labels为nil时保持不变，resourceVersion为空时不检查版本
+func updateConfig(c util.Context, store configManager.RevisionStore, name string, data, labels map[string]string, resourceVersion string) error {
+	obj := &configManager.ConfigObject{Name: name, Labels: labels, Data: data, ResourceVersion: resourceVersion}
+	if err := store.Update(context.TODO(), obj); err != nil {
+		logrus.Errorf(c.P()+"update config failed [name:%s],[err:%v]", name, err)
+		return err
+	}
+	return recordRevision(c, store, name, constValue.ChangeCauseUpdate)
+}
+
+// recordRevision 保存或更新配置后记录一个历史版本
+func recordRevision(c util.Context, store configManager.RevisionStore, name string, cause string) error {
+	revision, err := store.CreateRevision(context.TODO(), name, cause)
+	if err != nil {
+		logrus.Errorf(c.P()+"record revision failed [name:%s],[err:%v]", name, err)
+		return err
+	}
+	logrus.Infof(c.P()+"revision recorded [name:%s],[revision:%d],[cause:%s]", name, revision, cause)
+	return nil
+}
+
+func ListClusterConfigRevisions(c util.Context, clusterID string, labels string) ([]proto.ConfigRevision, error) {
+	secretName, err := clusterConfigSecretName(clusterID, labels)
+	if err != nil {
+		return nil, err
+	}
+	return listRevisions(c, clusterConfigStore, secretName)
+}
+
+func GetClusterConfigRevision(c util.Context, clusterID string, labels string, revision int) (*proto.ConfigRevision, error) {
+	secretName, err := clusterConfigSecretName(clusterID, labels)
+	if err != nil {
+		return nil, err
+	}
+	return getRevision(c, clusterConfigStore, secretName, constValue.Clusterconfig, revision)
+}
+
+/**
+* @Description: 将集群配置回滚到指定版本
+* return
+*   @resp 记录本次回滚的新版本号
+*
+ */
+
+func RollbackClusterConfig(c util.Context, clusterID string, labels string, revision int) (int, error) {
+	secretName, err := clusterConfigSecretName(clusterID, labels)
+	if err != nil {
+		return 0, err
+	}
+	newRevision, err := clusterConfigStore.Rollback(context.TODO(), secretName, revision)
+	if err != nil {
+		logrus.Errorf(c.P()+"rollback cluster config failed [name:%s],[revision:%d],[err:%v]", secretName, revision, err)
+		return 0, err
+	}
+	return newRevision, nil
+}
+
+func ListKubeconfigRevisions(c util.Context, clusterID string) ([]proto.ConfigRevision, error) {
+	secretName, err := kubeconfigSecretName(clusterID)
+	if err != nil {
+		return nil, err
+	}
+	return listRevisions(c, kubeconfigStore, secretName)
+}
+
+func GetKubeconfigRevision(c util.Context, clusterID string, revision int) (*proto.ConfigRevision, error) {
+	secretName, err := kubeconfigSecretName(clusterID)
+	if err != nil {
+		return nil, err
+	}
+	return getRevision(c, kubeconfigStore, secretName, constValue.Kubeconfig, revision)
+}
+
+// RollbackKubeconfig 将kubeconfig回滚到指定版本
+func RollbackKubeconfig(c util.Context, clusterID string, revision int) (int, error) {
+	secretName, err := kubeconfigSecretName(clusterID)
+	if err != nil {
+		return 0, err
+	}
+	newRevision, err := kubeconfigStore.Rollback(context.TODO(), secretName, revision)
+	if err != nil {
+		logrus.Errorf(c.P()+"rollback kubeconfig failed [name:%s],[revision:%d],[err:%v]", secretName, revision, err)
+		return 0, err
+	}
+	return newRevision, nil
+}
+
+func listRevisions(c util.Context, store configManager.RevisionStore, secretName string) ([]proto.ConfigRevision, error) {
+	objects, err := store.ListRevisions(context.TODO(), secretName)
+	if err != nil {
+		logrus.Errorf(c.P()+"list revisions failed [name:%s],[err:%v]", secretName, err)
+		return nil, err
+	}
+
+	revisions := make([]proto.ConfigRevision, 0, len(objects))
+	for i := range objects {
+		revisions = append(revisions, toConfigRevision(&objects[i]))
+	}
+	return revisions, nil
+}
+
+func getRevision(c util.Context, store configManager.RevisionStore, secretName string, key string, revision int) (*proto.ConfigRevision, error) {
+	obj, err := store.GetRevision(context.TODO(), secretName, revision)
+	if err != nil {
+		logrus.Errorf(c.P()+"get revision failed [name:%s],[revision:%d],[err:%v]", secretName, revision, err)
+		return nil, err
+	}
+	result := toConfigRevision(obj)
+	result.Data = obj.Data[key]
+	return &result, nil
+}
+
+func toConfigRevision(obj *configManager.ConfigObject) proto.ConfigRevision {
+	return proto.ConfigRevision{
+		Revision:     obj.Revision,
+		Name:         obj.Name,
+		Cause:        obj.Annotations[constValue.AnnotationChangeCause],
+		CreationTime: obj.CreationTimestamp,
+	}
+}
@@ -0,0 +1,378 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/db"
+	"ops-entry/db/configManager"
+	"ops-entry/proto"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+var (
+	ErrWatchUnavailable       = errors.New("watch requires the kubernetes storage backend and a management cluster")
+	ErrInvalidResourceVersion = errors.New("invalid resource_version")
+)
+
+// watchSource 一类被监听的资源，resourceVersion为已推送的最新版本
+type watchSource struct {
+	resource        string
+	selector        labels.Selector
+	list            func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error)
+	watch           func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+	event           func(obj runtime.Object) proto.WatchEvent
+	optional        bool // 资源不存在(CRD未安装)时不监听
+	resourceVersion string
+	watcher         watch.Interface
+}
+
+// sourceEvent 某个watcher的一个事件，closed表示该watcher已结束
+type sourceEvent struct {
+	index   int
+	watcher watch.Interface
+	event   watch.Event
+	closed  bool
+}
+
+type clusterWatcher struct {
+	c       util.Context
+	sources []*watchSource
+	updates chan sourceEvent
+	events  chan proto.WatchEvent
+}
+
+// clusterWatchSources kubeconfig和集群配置secret、KubeMateCluster CR，顺序即resource_version中的顺序
+func clusterWatchSources(clients *configManager.K8sClientSet) []*watchSource {
+	secretSelector := labels.SelectorFromSet(nil)
+	requirement, _ := labels.NewRequirement(constValue.LabelType, "in", []string{constValue.KubeconfigType, constValue.ClusterConfigType})
+	secretSelector = secretSelector.Add(*requirement)
+	secrets := clients.ClientSet.CoreV1().Secrets(constValue.NameSpace)
+	clusters := clients.DynamicClientSet.Resource(KubeMateClusterGVR).Namespace(constValue.NameSpace)
+	return []*watchSource{
+		{
+			resource: "secrets",
+			selector: secretSelector,
+			list: func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return secrets.List(ctx, opts)
+			},
+			watch: secrets.Watch,
+			event: secretWatchEvent,
+		},
+		{
+			resource: constValue.KubeMateClusterResource,
+			selector: labels.Everything(),
+			list: func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return clusters.List(ctx, opts)
+			},
+			watch:    clusters.Watch,
+			event:    clusterWatchEvent,
+			optional: true,
+		},
+	}
+}
+
+/**
+* @Description: 监听kubeconfig、集群配置和KubeMateCluster CR的变化，ctx结束或出错时关闭返回的channel
+* @param resourceVersion 上次收到的事件的resource_version，为空时先以added事件推送全部对象
+* 已过期时先推送reset事件，再以added事件推送全部对象
+* return
+*   @resp 非kubernetes存储后端返回ErrWatchUnavailable，resourceVersion不合法返回ErrInvalidResourceVersion
+*
+ */
+
+func WatchClusters(ctx context.Context, c util.Context, resourceVersion string) (<-chan proto.WatchEvent, error) {
+	if db.StorageBackend() != constValue.StorageBackendKubernetes || configManager.KCS == nil {
+		return nil, ErrWatchUnavailable
+	}
+	w := &clusterWatcher{
+		c:       c,
+		sources: clusterWatchSources(configManager.KCS),
+		updates: make(chan sourceEvent),
+		events:  make(chan proto.WatchEvent),
+	}
+
+	var initial []proto.WatchEvent
+	var err error
+	if len(resourceVersion) == 0 {
+		initial, err = w.relist(ctx)
+	} else if err = w.decodeResourceVersion(resourceVersion); err == nil {
+		if err = w.start(ctx); isExpired(err) {
+			logrus.Infof(c.P()+"resource_version %s expired, relist", resourceVersion)
+			initial, err = w.relist(ctx)
+		}
+	}
+	if err != nil {
+		w.stop()
+		return nil, err
+	}
+	go w.run(ctx, initial)
+	return w.events, nil
+}
+
+func (w *clusterWatcher) run(ctx context.Context, initial []proto.WatchEvent) {
+	defer close(w.events)
+	defer w.stop()
+	for _, event := range initial {
+		if !w.send(ctx, event) {
+			return
+		}
+	}
+
+	for {
+		var update sourceEvent
+		select {
+		case update = <-w.updates:
+		case <-ctx.Done():
+			return
+		}
+		source := w.sources[update.index]
+		if update.watcher != source.watcher {
+			continue // relist前的watcher
+		}
+
+		var events []proto.WatchEvent
+		var err error
+		switch {
+		case update.closed:
+			// apiserver会定期关闭watch，从最新版本继续
+			source.watcher = nil
+			if err = w.watch(ctx, update.index); isExpired(err) {
+				events, err = w.relist(ctx)
+			}
+		case update.event.Type == watch.Error:
+			err = k8serrors.FromObject(update.event.Object)
+			if isExpired(err) {
+				events, err = w.relist(ctx)
+			}
+		default:
+			events = w.handle(source, update.event)
+		}
+		if err != nil {
+			logrus.Errorf(w.c.P()+"watch %s failed: %v", source.resource, err)
+			w.send(ctx, proto.WatchEvent{Type: proto.WatchEventError, Message: err.Error(), ResourceVersion: w.resourceVersion()})
+			return
+		}
+		for _, event := range events {
+			if !w.send(ctx, event) {
+				return
+			}
+		}
+	}
+}
+
+// handle 记录对象的版本并转换为推送的事件，不匹配selector的对象不推送
+func (w *clusterWatcher) handle(source *watchSource, event watch.Event) []proto.WatchEvent {
+	object, err := meta.Accessor(event.Object)
+	if err != nil {
+		return nil
+	}
+	source.resourceVersion = object.GetResourceVersion()
+	if event.Type == watch.Bookmark {
+		return []proto.WatchEvent{{Type: proto.WatchEventBookmark, ResourceVersion: w.resourceVersion()}}
+	}
+	if !source.selector.Matches(labels.Set(object.GetLabels())) {
+		return nil
+	}
+
+	result := source.event(event.Object)
+	switch event.Type {
+	case watch.Added:
+		result.Type = proto.WatchEventAdded
+	case watch.Modified:
+		result.Type = proto.WatchEventUpdated
+	case watch.Deleted:
+		result.Type = proto.WatchEventDeleted
+	default:
+		return nil
+	}
+	result.ResourceVersion = w.resourceVersion()
+	return []proto.WatchEvent{result}
+}
+
+/**
+* @Description: 重新查询全部对象并从查询时的版本开始监听
+* reset和added事件使用查询前的resource_version，中途断开时从头重新查询，最后的bookmark事件为查询后的resource_version
+*
+ */
+
+func (w *clusterWatcher) relist(ctx context.Context) ([]proto.WatchEvent, error) {
+	w.stop()
+	previous := w.resourceVersion()
+	events := []proto.WatchEvent{{Type: proto.WatchEventReset, ResourceVersion: previous}}
+	for _, source := range w.sources {
+		list, err := source.list(ctx, metav1.ListOptions{LabelSelector: source.selector.String()})
+		if source.optional && k8serrors.IsNotFound(err) {
+			logrus.Warnf(w.c.P()+"%s are not watched: %v", source.resource, err)
+			source.resourceVersion = ""
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		listMeta, err := meta.ListAccessor(list)
+		if err != nil {
+			return nil, err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			event := source.event(item)
+			event.Type = proto.WatchEventAdded
+			event.ResourceVersion = previous
+			events = append(events, event)
+		}
+		source.resourceVersion = listMeta.GetResourceVersion()
+	}
+	if err := w.start(ctx); err != nil {
+		return nil, err
+	}
+	return append(events, proto.WatchEvent{Type: proto.WatchEventBookmark, ResourceVersion: w.resourceVersion()}), nil
+}
+
+func (w *clusterWatcher) start(ctx context.Context) error {
+	for i := range w.sources {
+		if err := w.watch(ctx, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watch 从source的resourceVersion开始监听，事件转发到updates
+func (w *clusterWatcher) watch(ctx context.Context, index int) error {
+	source := w.sources[index]
+	watcher, err := source.watch(ctx, metav1.ListOptions{
+		LabelSelector:       source.selector.String(),
+		ResourceVersion:     source.resourceVersion,
+		AllowWatchBookmarks: true,
+	})
+	if source.optional && k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	source.watcher = watcher
+
+	go func() {
+		for event := range watcher.ResultChan() {
+			select {
+			case w.updates <- sourceEvent{index: index, watcher: watcher, event: event}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case w.updates <- sourceEvent{index: index, watcher: watcher, closed: true}:
+		case <-ctx.Done():
+		}
+	}()
+	return nil
+}
+
+func (w *clusterWatcher) stop() {
+	for _, source := range w.sources {
+		if source.watcher != nil {
+			source.watcher.Stop()
+			source.watcher = nil
+		}
+	}
+}
+
+func (w *clusterWatcher) send(ctx context.Context, event proto.WatchEvent) bool {
+	select {
+	case w.events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// resourceVersion 全部资源的版本，作为推送事件的resource_version，尚未查询过时为空
+func (w *clusterWatcher) resourceVersion() string {
+	versions := make([]string, 0, len(w.sources))
+	empty := true
+	for _, source := range w.sources {
+		versions = append(versions, source.resourceVersion)
+		empty = empty && len(source.resourceVersion) == 0
+	}
+	if empty {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(versions, ",")))
+}
+
+func (w *clusterWatcher) decodeResourceVersion(resourceVersion string) error {
+	value, err := base64.RawURLEncoding.DecodeString(resourceVersion)
+	versions := strings.Split(string(value), ",")
+	if err != nil || len(versions) != len(w.sources) {
+		return fmt.Errorf("%w: %q", ErrInvalidResourceVersion, resourceVersion)
+	}
+	for i, source := range w.sources {
+		source.resourceVersion = versions[i]
+	}
+	return nil
+}
+
+func isExpired(err error) bool {
+	return k8serrors.IsResourceExpired(err) || k8serrors.IsGone(err)
+}
+
+// secretWatchEvent kubeconfig或集群配置secret，不包含配置内容
+func secretWatchEvent(obj runtime.Object) proto.WatchEvent {
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		return proto.WatchEvent{}
+	}
+	return proto.WatchEvent{
+		Kind:      object.GetLabels()[constValue.LabelType],
+		ClusterId: object.GetLabels()[constValue.LabelClusterId],
+		Name:      strings.TrimPrefix(object.GetName(), constValue.Prefix+constValue.SECRET),
+		Labels:    userLabels(object.GetLabels()),
+	}
+}
+
+func clusterWatchEvent(obj runtime.Object) proto.WatchEvent {
+	cr, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return proto.WatchEvent{Kind: proto.WatchKindCluster}
+	}
+	event := proto.WatchEvent{
+		Kind:      proto.WatchKindCluster,
+		ClusterId: kubeMateClusterID(cr),
+		Name:      cr.GetName(),
+		Labels:    userLabels(cr.GetLabels()),
+	}
+	if status, err := clusterStatusOf(cr); err == nil {
+		event.Phase = string(status.Phase)
+		event.Message = status.Message
+	}
+	return event
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright 2024 KylinSoft  Co., Ltd.
+ * KubeMate is licensed under the Mulan PSL v2.
+ * You can use this software according to the terms and conditions of the Mulan PSL v2.
+ * You may obtain a copy of Mulan PSL v2 at:
+ *     http://license.coscl.org.cn/MulanPSL2
+ * THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+ * PURPOSE.
+ * See the Mulan PSL v2 for more details.
+ */
+
+package service
+
+import (
+	"context"
+	"errors"
+	"ops-entry/common/util"
+	"ops-entry/constValue"
+	"ops-entry/db/configManager"
+	"ops-entry/proto"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestWatchClusters(t *testing.T) {
+	secret := func(name, typ string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:      constValue.Prefix + constValue.SECRET + name,
+			Namespace: constValue.NameSpace,
+			Labels:    map[string]string{constValue.LabelType: typ, constValue.LabelClusterId: "k8s-001", "environment": "prod"},
+		}}
+	}
+	clientSet := k8sfake.NewSimpleClientset(secret("k8s-001-kubeconfig", constValue.KubeconfigType),
+		secret("k8s-001-clusterconfig-v-1", constValue.SecretRevisionType))
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{KubeMateClusterGVR: constValue.KubeMateClusterListKind})
+	origin := configManager.KCS
+	configManager.KCS = &configManager.K8sClientSet{ClientSet: clientSet, DynamicClientSet: dynamicClient}
+	defer func() { configManager.KCS = origin }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := util.CreateContext("")
+	next := func(events <-chan proto.WatchEvent) proto.WatchEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("no watch event")
+			return proto.WatchEvent{}
+		}
+	}
+
+	events, err := WatchClusters(ctx, c, "")
+	assert.Nil(t, err)
+	assert.Equal(t, proto.WatchEventReset, next(events).Type)
+	assert.Equal(t, proto.WatchEvent{
+		Type:      proto.WatchEventAdded,
+		Kind:      constValue.KubeconfigType,
+		ClusterId: "k8s-001",
+		Name:      "k8s-001-kubeconfig",
+		Labels:    map[string]string{"environment": "prod"},
+	}, next(events))
+	assert.Equal(t, proto.WatchEventBookmark, next(events).Type)
+
+	secrets := clientSet.CoreV1().Secrets(constValue.NameSpace)
+	_, err = secrets.Create(ctx, secret("k8s-001-clusterconfig-v-2", constValue.SecretRevisionType), metav1.CreateOptions{})
+	assert.Nil(t, err)
+	_, err = secrets.Create(ctx, secret("k8s-001-clusterconfig", constValue.ClusterConfigType), metav1.CreateOptions{})
+	assert.Nil(t, err)
+	event := next(events)
+	assert.Equal(t, proto.WatchEventAdded, event.Type)
+	assert.Equal(t, constValue.ClusterConfigType, event.Kind)
+	assert.Equal(t, "k8s-001-clusterconfig", event.Name)
+
+	crs := dynamicClient.Resource(KubeMateClusterGVR).Namespace(constValue.NameSpace)
+	cr := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{"cluster_id": "k8s-002"}}}
+	cr.SetAPIVersion(KubeMateClusterGVR.GroupVersion().String())
+	cr.SetKind(constValue.KubeMateClusterKind)
+	cr.SetName("cluster")
+	_, err = crs.Create(ctx, cr, metav1.CreateOptions{})
+	assert.Nil(t, err)
+	event = next(events)
+	assert.Equal(t, proto.WatchKindCluster, event.Kind)
+	assert.Equal(t, "k8s-002", event.ClusterId)
+
+	assert.Nil(t, unstructured.SetNestedField(cr.Object, string(proto.ClusterReady), "status", "phase"))
+	_, err = crs.UpdateStatus(ctx, cr, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	event = next(events)
+	assert.Equal(t, proto.WatchEventUpdated, event.Type)
+	assert.Equal(t, string(proto.ClusterReady), event.Phase)
+
+	assert.Nil(t, secrets.Delete(ctx, constValue.Prefix+constValue.SECRET+"k8s-001-kubeconfig", metav1.DeleteOptions{}))
+	event = next(events)
+	assert.Equal(t, proto.WatchEventDeleted, event.Type)
+	assert.Equal(t, "k8s-001-kubeconfig", event.Name)
+
+	t.Run("resume", func(t *testing.T) {
+		w := &clusterWatcher{sources: clusterWatchSources(configManager.KCS)}
+		w.sources[0].resourceVersion = "10"
+		w.sources[1].resourceVersion = "12"
+		token := w.resourceVersion()
+
+		var watched []string
+		clientSet.PrependWatchReactor("secrets", func(action k8stesting.Action) (bool, watch.Interface, error) {
+			watched = append(watched, action.(k8stesting.WatchActionImpl).WatchRestrictions.ResourceVersion)
+			return false, nil, nil
+		})
+		resumed, err := WatchClusters(ctx, c, token)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"10"}, watched)
+		_, err = secrets.Create(ctx, secret("k8s-003-kubeconfig", constValue.KubeconfigType), metav1.CreateOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, "k8s-003-kubeconfig", next(resumed).Name)
+
+		_, err = WatchClusters(ctx, c, "invalid")
+		assert.True(t, errors.Is(err, ErrInvalidResourceVersion))
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		w := &clusterWatcher{sources: clusterWatchSources(configManager.KCS)}
+		w.sources[0].resourceVersion = "1"
+		clientSet.PrependWatchReactor("secrets", func(action k8stesting.Action) (bool, watch.Interface, error) {
+			if action.(k8stesting.WatchActionImpl).WatchRestrictions.ResourceVersion == "1" {
+				return true, nil, k8serrors.NewResourceExpired("too old resource version: 1")
+			}
+			return false, nil, nil
+		})
+		relisted, err := WatchClusters(ctx, c, w.resourceVersion())
+		assert.Nil(t, err)
+		event := next(relisted)
+		assert.Equal(t, proto.WatchEventReset, event.Type)
+		assert.Equal(t, w.resourceVersion(), event.ResourceVersion)
+	})
+}